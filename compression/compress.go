@@ -3,23 +3,37 @@ package compression
 import (
 	"archive/tar"
 	"compress/gzip"
+	"context"
 	"github.com/2flow/gokies/storageabstraction"
 	"io"
 	"os"
 	"path/filepath"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
 )
 
 type Compressor struct {
 	fileStorage storageabstraction.IFileStorage
+
+	// Logger logs the errors of the compression
+	Logger log.Logger
 }
 
 func NewCompression(fileStorage storageabstraction.IFileStorage) *Compressor {
 	return &Compressor{
 		fileStorage: fileStorage,
+		Logger:      log.NewNopLogger(),
 	}
 }
 
 func (compressor *Compressor) CompressDir(path string, writer io.Writer) error {
+	return compressor.CompressDirContext(context.Background(), path, writer)
+}
+
+// CompressDirContext compresses the directory like CompressDir, but stops as soon as the context is done
+func (compressor *Compressor) CompressDirContext(ctx context.Context, path string, writer io.Writer) error {
+	fileStorage := storageabstraction.WithContext(compressor.fileStorage)
 
 	gzipWriter := gzip.NewWriter(writer)
 	defer gzipWriter.Close()
@@ -27,7 +41,10 @@ func (compressor *Compressor) CompressDir(path string, writer io.Writer) error {
 	tarWriter := tar.NewWriter(gzipWriter)
 	defer tarWriter.Close()
 
-	return compressor.fileStorage.Walk(path, func(filePath string, info os.FileInfo, err error) error {
+	err := fileStorage.WalkContext(ctx, path, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
 		header, err := tar.FileInfoHeader(info, "")
 		if err != nil {
 			return err
@@ -38,7 +55,7 @@ func (compressor *Compressor) CompressDir(path string, writer io.Writer) error {
 		}
 
 		if !info.IsDir() {
-			file, err := compressor.fileStorage.Read(compressor.fileStorage.Join(path, filePath))
+			file, err := fileStorage.ReadContext(ctx, fileStorage.Join(path, filePath))
 			if err != nil {
 				return err
 			}
@@ -51,4 +68,8 @@ func (compressor *Compressor) CompressDir(path string, writer io.Writer) error {
 
 		return nil
 	})
+	if err != nil {
+		_ = level.Error(compressor.Logger).Log("msg", "Unable to compress directory", "path", path, "err", err)
+	}
+	return err
 }
@@ -1,8 +1,18 @@
 package compression
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"github.com/2flow/gokies/storageabstraction"
 	"github.com/2flow/gokies/storageabstraction/localstorage"
+	"github.com/2flow/gokies/storageabstraction/memorystorage"
+	"io"
+	"io/fs"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -51,7 +61,7 @@ func TestCompression(t *testing.T) {
 	os.RemoveAll(testTempDir + "/compressDir")
 	extractor := NewGzipExtractor(storage)
 
-	err = extractor.ProcessCompression("extractDir", file)
+	_, err = extractor.ExtractFromStream("extractDir", file)
 	if err != nil {
 		t.Errorf("Error extracting file: %v", err)
 		return
@@ -72,6 +82,163 @@ func TestCompression(t *testing.T) {
 	file.Close()
 }
 
+func TestCompressionInMemory(t *testing.T) {
+	storage := memorystorage.NewMemoryStorage()
+	for _, fileName := range []string{"compressDir/test.txt", "compressDir/subDir/test3.txt"} {
+		if err := storage.Write(fileName, int64(len(fileName)), strings.NewReader(fileName)); err != nil {
+			t.Errorf("[TestError] Error writing test file: %v", err)
+			return
+		}
+	}
+
+	archive := bytes.Buffer{}
+	err := NewCompression(storage).CompressDir("compressDir", &archive)
+	if err != nil {
+		t.Errorf("Error compressing dir: %v", err)
+		return
+	}
+
+	extractedFiles, err := NewGzipExtractor(storage).ExtractFromStream("extractDir", &archive)
+	if err != nil {
+		t.Errorf("Error extracting file: %v", err)
+		return
+	}
+	if len(extractedFiles) != 2 {
+		t.Errorf("Expected 2 extracted files, actual: %v", extractedFiles)
+	}
+
+	reader, err := storage.Read("extractDir/subDir/test3.txt")
+	if err != nil {
+		t.Errorf("Error reading extracted file: %v", err)
+		return
+	}
+	defer reader.Close()
+
+	content, _ := io.ReadAll(reader)
+	if string(content) != "compressDir/subDir/test3.txt" {
+		t.Errorf("Unexpected content of extracted file: %s", content)
+	}
+}
+
+func TestExtractionSkipsUnchangedFiles(t *testing.T) {
+	storage := memorystorage.NewMemoryStorage()
+	if err := storage.Write("compressDir/test.txt", 4, strings.NewReader("test")); err != nil {
+		t.Errorf("[TestError] Error writing test file: %v", err)
+		return
+	}
+
+	archive := bytes.Buffer{}
+	if err := NewCompression(storage).CompressDir("compressDir", &archive); err != nil {
+		t.Errorf("Error compressing dir: %v", err)
+		return
+	}
+	before, _ := storageabstraction.Stat(storage, "compressDir/test.txt")
+
+	extractor := NewGzipExtractor(storage)
+	extractor.SkipUnchanged = true
+	extractedFiles, err := extractor.ExtractFromStream("compressDir", &archive)
+	if err != nil || len(extractedFiles) != 1 {
+		t.Errorf("Error extracting file %v: %v", extractedFiles, err)
+		return
+	}
+
+	after, _ := storageabstraction.Stat(storage, "compressDir/test.txt")
+	if !after.ModTime().Equal(before.ModTime()) {
+		t.Errorf("Unchanged file was written again")
+	}
+
+	// a changed content of the same size is written
+	if err = storage.Write("changedDir/test.txt", 8, strings.NewReader("changed!")); err != nil {
+		t.Errorf("[TestError] Error writing test file: %v", err)
+		return
+	}
+	changed, _ := archiveOf("test.txt")
+	if _, err = extractor.ExtractFromStream("changedDir", changed); err != nil {
+		t.Errorf("Error extracting file: %v", err)
+		return
+	}
+	reader, err := storage.Read("changedDir/test.txt")
+	if err != nil {
+		t.Errorf("Error reading extracted file: %v", err)
+		return
+	}
+	defer reader.Close()
+	if content, _ := io.ReadAll(reader); string(content) != "test.txt" {
+		t.Errorf("Expected the changed content to be written, actual: %q", content)
+	}
+}
+
+// archiveOf returns a tar.gz archive with a file for every name, the content of the file is its name
+func archiveOf(names ...string) (*bytes.Buffer, error) {
+	archive := &bytes.Buffer{}
+	gzipWriter := gzip.NewWriter(archive)
+	tarWriter := tar.NewWriter(gzipWriter)
+	for _, name := range names {
+		header := &tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(name))}
+		if err := tarWriter.WriteHeader(header); err != nil {
+			return nil, err
+		}
+		if _, err := tarWriter.Write([]byte(name)); err != nil {
+			return nil, err
+		}
+	}
+	if err := tarWriter.Close(); err != nil {
+		return nil, err
+	}
+	return archive, gzipWriter.Close()
+}
+
+func TestExtractionRejectsEscapingNames(t *testing.T) {
+	for _, name := range []string{"../outside.txt", "site/../../outside.txt", "/etc/outside.txt", "..\\outside.txt", "C:/outside.txt"} {
+		storage := memorystorage.NewMemoryStorage()
+		archive, err := archiveOf("index.html", name)
+		if err != nil {
+			t.Errorf("[TestError] Error creating archive: %v", err)
+			return
+		}
+
+		extractedFiles, err := NewGzipExtractor(storage).ExtractFromStream("extractDir", archive)
+		if !errors.Is(err, storageabstraction.ErrPathEscape) || len(extractedFiles) != 1 {
+			t.Errorf("Expected the extraction of %q to fail after %v, actual: %v", name, extractedFiles, err)
+		}
+		if exists, _ := storageabstraction.Exists(storage, "outside.txt"); exists {
+			t.Errorf("Expected %q not to be extracted outside of the directory", name)
+		}
+	}
+
+	// names are cleaned, the returned paths match the files in the directory
+	archive, _ := archiveOf("./site//index.html")
+	extractedFiles, err := NewGzipExtractor(memorystorage.NewMemoryStorage()).ExtractFromStream("extractDir", archive)
+	if err != nil || len(extractedFiles) != 1 || extractedFiles[0] != "site/index.html" {
+		t.Errorf("Expected the cleaned name, actual: %v, err: %v", extractedFiles, err)
+	}
+}
+
+func FuzzExtract(f *testing.F) {
+	for _, seed := range []string{"index.html", "../outside.txt", "site/../../outside.txt", "/etc/outside.txt",
+		"..\\outside.txt", "C:/outside.txt", "./a//b", ".", ""} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, name string) {
+		archive, err := archiveOf(name)
+		if err != nil {
+			t.Skip()
+		}
+
+		tempDir := t.TempDir()
+		root := filepath.Join(tempDir, "root")
+		_, _ = NewGzipExtractor(localstorage.NewLocalStorage(root)).ExtractFromStream("site", archive)
+
+		// every file has to be inside of the directory of the extraction
+		_ = filepath.WalkDir(tempDir, func(filePath string, entry fs.DirEntry, err error) error {
+			if err == nil && !entry.IsDir() && !strings.HasPrefix(filePath, filepath.Join(root, "site")+string(filepath.Separator)) {
+				t.Errorf("Expected %q to be extracted into the directory, actual: %s", name, filePath)
+			}
+			return nil
+		})
+	})
+}
+
 func createTestDir() error {
 	err := os.MkdirAll(testTempDir+"/compressDir", 0777)
 	if err != nil {
@@ -2,12 +2,17 @@ package compression
 
 import (
 	"archive/tar"
+	"bytes"
 	"compress/gzip"
-	"errors"
-	"fmt"
+	"context"
+	"crypto/md5"
 	"github.com/2flow/gokies/storageabstraction"
+	"github.com/2flow/gokies/storageabstraction/common"
 	"io"
-	"os"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
 )
 
 // ExtractFileCallback called if the current extraction is a file
@@ -21,12 +26,18 @@ type ExtractFolderCallback func(relativeDir string)
 //	Contains the callbacks
 type GzipExtractor struct {
 	storage storageabstraction.IFileStorage
+
+	// SkipUnchanged does not write files which already exist in the storage with the same content
+	SkipUnchanged bool
+	// Logger logs the extracted files and the errors of the extraction
+	Logger log.Logger
 }
 
 // NewGzipExtractor Creates a new GzipExtractor object
 func NewGzipExtractor(storage storageabstraction.IFileStorage) *GzipExtractor {
 	return &GzipExtractor{
 		storage: storage,
+		Logger:  log.NewNopLogger(),
 	}
 }
 
@@ -34,38 +45,53 @@ func NewGzipExtractor(storage storageabstraction.IFileStorage) *GzipExtractor {
 //
 //	Callbacks are called
 func (extractor *GzipExtractor) ExtractFromStream(directory string, gzipStream io.Reader) ([]string, error) {
+	return extractor.ExtractFromStreamContext(context.Background(), directory, gzipStream)
+}
+
+// ExtractFromStreamContext extracts the stream like ExtractFromStream, but stops as soon as the context is done
+func (extractor *GzipExtractor) ExtractFromStreamContext(ctx context.Context, directory string, gzipStream io.Reader) ([]string, error) {
 	uncompressedStream, err := gzip.NewReader(gzipStream)
-	defer uncompressedStream.Close()
 
 	var extractedFiles []string
 
 	if err != nil {
-		fmt.Println("Unable to get Reader from stream")
+		_ = level.Error(extractor.Logger).Log("msg", "Unable to get reader from stream", "path", directory, "err", err)
 		return extractedFiles, err
 	}
+	defer uncompressedStream.Close()
 
 	tarReader := tar.NewReader(uncompressedStream)
 
 	for header, err := tarReader.Next(); err != io.EOF; header, err = tarReader.Next() {
 		if err != nil {
-			fmt.Println("Extraction failed during Next()")
+			_ = level.Error(extractor.Logger).Log("msg", "Extraction failed during next", "path", directory, "err", err)
 			return extractedFiles, err
 		}
+		if err := ctx.Err(); err != nil {
+			return extractedFiles, err
+		}
+
 		switch header.Typeflag {
 		case tar.TypeReg:
-			path := extractor.storage.Join(directory, header.Name)
-			extractedFiles = append(extractedFiles, header.Name)
-
-			tempReader, err := newTempReaderSeeker(header.Size, tarReader)
+			// names which escape from the directory abort the extraction, instead of overwriting other files
+			name, err := common.CleanArchivePath(header.Name)
 			if err != nil {
+				_ = level.Error(extractor.Logger).Log("msg", "Invalid file name in archive", "path", directory, "err", err)
 				return extractedFiles, err
 			}
-			err = extractor.storage.Write(path, header.Size, tempReader)
+			path := extractor.storage.Join(directory, name)
+			extractedFiles = append(extractedFiles, name)
+
+			start := time.Now()
+			if extractor.SkipUnchanged {
+				err = extractor.writeIfChanged(ctx, path, header.Size, tarReader)
+			} else {
+				err = extractor.writeStream(ctx, path, tarReader)
+			}
+			common.LogOperation(extractor.Logger, "extract", path, header.Size, start, err)
 			if err != nil {
-				_ = tempReader.Close()
 				return extractedFiles, err
 			}
-			_ = tempReader.Close()
 
 		case tar.TypeDir:
 		}
@@ -74,50 +100,54 @@ func (extractor *GzipExtractor) ExtractFromStream(directory string, gzipStream i
 	return extractedFiles, nil
 }
 
-type tempReaderSeeker struct {
-	io.ReadSeekCloser
-	file *os.File
+// writeStream streams the content into the storage, the file is not modified if the content can not be read
+func (extractor *GzipExtractor) writeStream(ctx context.Context, path string, reader io.Reader) error {
+	return storageabstraction.WriteFromContext(ctx, extractor.storage, path, reader)
 }
 
-func newTempReaderSeeker(fileSize int64, reader io.Reader) (*tempReaderSeeker, error) {
-	file, err := os.CreateTemp("", "tempUploader")
-	if err != nil {
-		return nil, err
+// writeIfChanged streams the content into the storage, unless a file with the same size exists.
+// Then the content is hashed while it is streamed, and the writer is cancelled without commit if the hash matches
+func (extractor *GzipExtractor) writeIfChanged(ctx context.Context, path string, fileSize int64, reader io.Reader) error {
+	info, err := storageabstraction.StatContext(ctx, extractor.storage, path)
+	if err != nil || info.IsDir() || info.Size() != fileSize {
+		return extractor.writeStream(ctx, path, reader)
 	}
+	existingMD5 := extractor.contentMD5(ctx, path, info)
 
-	if written, err := io.Copy(file, reader); (err != nil) || (written != fileSize) {
-		if err != nil {
-			return nil, err
-		}
-		return nil, errors.New("unable to copy to temp file")
-	}
+	writerCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
-	if err := file.Sync(); err != nil {
-		return nil, err
-	}
-	if _, err := file.Seek(0, 0); err != nil {
-		return nil, err
+	writer, err := storageabstraction.OpenWriterContext(writerCtx, extractor.storage, path)
+	if err != nil {
+		return err
 	}
 
-	return &tempReaderSeeker{
-		file: file,
-	}, nil
-}
-
-func (reader *tempReaderSeeker) Read(p []byte) (n int, err error) {
-	return reader.file.Read(p)
+	hash := md5.New()
+	if _, err = io.Copy(io.MultiWriter(writer, hash), reader); err != nil || bytes.Equal(existingMD5, hash.Sum(nil)) {
+		// the storages do not commit the writer of a cancelled context
+		cancel()
+		_ = writer.Close()
+		return err
+	}
+	return writer.Close()
 }
 
-func (reader *tempReaderSeeker) Seek(offset int64, whence int) (int64, error) {
-	return reader.file.Seek(offset, whence)
-}
+// contentMD5 returns the content hash of the existing file, or nil if it is unknown.
+// If the storage does not know the hash of the file, the file is read to calculate it
+func (extractor *GzipExtractor) contentMD5(ctx context.Context, path string, info *storageabstraction.FileInfo) []byte {
+	if existingMD5 := info.ContentMD5(); existingMD5 != nil {
+		return existingMD5
+	}
 
-func (reader *tempReaderSeeker) Close() error {
-	err := reader.file.Close()
+	reader, err := storageabstraction.WithContext(extractor.storage).ReadContext(ctx, path)
 	if err != nil {
-		return err
+		return nil
 	}
+	defer reader.Close()
 
-	err = os.Remove(reader.file.Name())
-	return err
+	hash := md5.New()
+	if _, err = io.Copy(hash, reader); err != nil {
+		return nil
+	}
+	return hash.Sum(nil)
 }
@@ -1,9 +1,11 @@
 package filecontainer
 
 import (
+	"context"
 	"github.com/2flow/gokies/compression"
 	"github.com/2flow/gokies/storageabstraction"
 	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
 	"io"
 )
 
@@ -13,28 +15,33 @@ type FileManager struct {
 	uploader *Uploader
 }
 
-func CreateFileManager(storage storageabstraction.IFileStorage, logger log.Logger) *FileManager {
+// CreateFileManager creates a file manager for the storage, the options configure its uploader
+func CreateFileManager(storage storageabstraction.IFileStorage, logger log.Logger, options ...UploaderOption) *FileManager {
 	return &FileManager{storage: storage,
 		logger:   logger,
-		uploader: CreateUploader("./temps/", logger, storage)}
+		uploader: CreateUploader("./temps/", logger, storage, options...)}
 }
 
 // GetFile returns an error or a stream of data which represent the requested file
 func (fileManager FileManager) GetFile(path string) (io.ReadCloser, error) {
-	reader, err := fileManager.storage.Read(path)
+	return fileManager.GetFileContext(context.Background(), path)
+}
+
+// GetFileContext returns the requested file like GetFile, reading stops as soon as the context is done
+func (fileManager FileManager) GetFileContext(ctx context.Context, path string) (io.ReadCloser, error) {
+	reader, err := storageabstraction.WithContext(fileManager.storage).ReadContext(ctx, path)
 	if err != nil {
-		fileManager.logger.Log("msg", "Unable to read from storage", "error", err.Error())
+		_ = level.Error(fileManager.logger).Log("msg", "Unable to read from storage", "path", path, "err", err)
 		return nil, err
 	}
 
 	return reader, nil
 }
 
-/*
-func (fileManager FileManager) DoesFileExist(path string) {
-
+// FileExists checks if the file or directory exists in the storage
+func (fileManager FileManager) FileExists(path string) (bool, error) {
+	return storageabstraction.Exists(fileManager.storage, path)
 }
-*/
 
 func (fileManager FileManager) GetUploadWriter(path string, callbacks UploadCallBacks) (TarUploader, error) {
 	return fileManager.uploader.UploadTar(path, callbacks)
@@ -58,6 +65,17 @@ func (fileManager FileManager) UploadTar(path string, callbacks UploadCallBacks,
 }
 
 func (fileManager FileManager) BackupDirectory(path string, writer io.Writer) error {
+	return fileManager.BackupDirectoryContext(context.Background(), path, writer)
+}
+
+// BackupDirectoryContext writes the directory as tar.gz to the writer, until the context is done
+func (fileManager FileManager) BackupDirectoryContext(ctx context.Context, path string, writer io.Writer) error {
 	compressor := compression.NewCompression(fileManager.storage)
-	return compressor.CompressDir(path, writer)
+	compressor.Logger = fileManager.logger
+	return compressor.CompressDirContext(ctx, path, writer)
+}
+
+// Shutdown cancels all running uploads
+func (fileManager FileManager) Shutdown() {
+	fileManager.uploader.Shutdown()
 }
@@ -1,10 +1,19 @@
 package filecontainer
 
-import "io"
+import (
+	"context"
+	"io"
+	"time"
+)
 
 type IFileManager interface {
 	GetFile(path string) (io.ReadCloser, error)
+	GetFileContext(ctx context.Context, path string) (io.ReadCloser, error)
+	FileExists(path string) (bool, error)
 	GetUploadWriter(path string, callbacks UploadCallBacks) (TarUploader, error)
 	BackupDirectory(path string, writer io.Writer) error
+	BackupDirectoryContext(ctx context.Context, path string, writer io.Writer) error
 	UploadTar(path string, callbacks UploadCallBacks, reader io.Reader) error
+	Rollback(directory string, since time.Time) error
+	RollbackContext(ctx context.Context, directory string, since time.Time) error
 }
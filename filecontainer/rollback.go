@@ -0,0 +1,103 @@
+package filecontainer
+
+import (
+	"context"
+	"errors"
+	"github.com/2flow/gokies/storageabstraction"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log/level"
+)
+
+func (fileManager FileManager) Rollback(directory string, since time.Time) error {
+	return fileManager.RollbackContext(context.Background(), directory, since)
+}
+
+// RollbackContext restores the files of the directory to their state before since, e.g. the start of a bad deployment.
+// Files written after since are deleted again. The storage must keep the versions, e.g. the azure storage with
+// WithSnapshotBeforeOverwrite and soft delete enabled, otherwise an overwritten file is taken as new file
+func (fileManager FileManager) RollbackContext(ctx context.Context, directory string, since time.Time) error {
+	versions, err := storageabstraction.VersionsContext(ctx, fileManager.storage, directory)
+	if err != nil {
+		return err
+	}
+	current, err := fileManager.currentFiles(ctx, directory)
+	if err != nil {
+		return err
+	}
+
+	// the oldest version after since is the content the file had before since
+	restore := map[string]storageabstraction.FileVersion{}
+	for _, version := range versions {
+		if _, found := restore[version.Path]; !found && !version.Created.Before(since) {
+			restore[version.Path] = version
+		}
+	}
+
+	var deletePaths []string
+	for filePath, info := range current {
+		if _, found := restore[filePath]; !found && !info.ModTime().Before(since) {
+			deletePaths = append(deletePaths, filePath)
+		}
+	}
+
+	restored := 0
+	for filePath, version := range restore {
+		_, exists := current[filePath]
+		if !version.ModTime.Before(since) {
+			// the file did not exist before since
+			if exists {
+				deletePaths = append(deletePaths, filePath)
+			}
+			continue
+		}
+
+		if !exists {
+			if err = storageabstraction.UndeleteContext(ctx, fileManager.storage, filePath); err != nil {
+				return err
+			}
+		}
+		// a deleted file without version after since is restored by the undelete
+		if version.ID != "" {
+			if err = storageabstraction.RestoreVersionContext(ctx, fileManager.storage, filePath, version.ID); err != nil {
+				return err
+			}
+		}
+		restored++
+	}
+
+	sort.Strings(deletePaths)
+	for _, filePath := range deletePaths {
+		if err = storageabstraction.WithContext(fileManager.storage).DeleteFileContext(ctx, filePath); err != nil {
+			return err
+		}
+	}
+
+	_ = level.Info(fileManager.logger).Log("msg", "Rolled back directory", "path", directory, "since", since,
+		"restored", restored, "deleted", len(deletePaths))
+	return nil
+}
+
+// currentFiles returns the files of the directory by their path in the storage, like the paths of the versions
+func (fileManager FileManager) currentFiles(ctx context.Context, directory string) (map[string]os.FileInfo, error) {
+	files := map[string]os.FileInfo{}
+
+	err := storageabstraction.WithContext(fileManager.storage).WalkContext(ctx, directory, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info != nil && !info.IsDir() {
+			files[strings.TrimPrefix(path.Join(directory, filePath), "/")] = info
+		}
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return files, nil
+	}
+	return files, err
+}
@@ -0,0 +1,143 @@
+package filecontainer
+
+import (
+	"context"
+	"errors"
+	"github.com/2flow/gokies/storageabstraction"
+	"github.com/2flow/gokies/storageabstraction/cache"
+	"github.com/2flow/gokies/storageabstraction/memorystorage"
+	"github.com/2flow/gokies/storageabstraction/retry"
+	"github.com/go-kit/log"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// versionedStorage keeps the versions of the test in memory, like the azure storage with snapshots and soft delete
+type versionedStorage struct {
+	storageabstraction.IContextFileStorage
+	versions []storageabstraction.FileVersion
+	// contents are the contents of the versions by id and of the deleted files by path
+	contents map[string]string
+}
+
+func (storage *versionedStorage) Snapshot(fileName string) (string, error) {
+	return storage.SnapshotContext(context.Background(), fileName)
+}
+
+// SnapshotContext is not used by the rollback
+func (storage *versionedStorage) SnapshotContext(_ context.Context, _ string) (string, error) {
+	return "", errors.ErrUnsupported
+}
+
+func (storage *versionedStorage) Versions(path string) ([]storageabstraction.FileVersion, error) {
+	return storage.VersionsContext(context.Background(), path)
+}
+
+func (storage *versionedStorage) VersionsContext(_ context.Context, _ string) ([]storageabstraction.FileVersion, error) {
+	return storage.versions, nil
+}
+
+func (storage *versionedStorage) RestoreVersion(fileName string, versionID string) error {
+	return storage.RestoreVersionContext(context.Background(), fileName, versionID)
+}
+
+func (storage *versionedStorage) RestoreVersionContext(ctx context.Context, fileName string, versionID string) error {
+	content := storage.contents[versionID]
+	return storage.WriteContext(ctx, fileName, int64(len(content)), strings.NewReader(content))
+}
+
+func (storage *versionedStorage) Undelete(fileName string) error {
+	return storage.UndeleteContext(context.Background(), fileName)
+}
+
+func (storage *versionedStorage) UndeleteContext(ctx context.Context, fileName string) error {
+	content, ok := storage.contents[fileName]
+	if !ok {
+		return storageabstraction.ErrNotExist
+	}
+	return storage.WriteContext(ctx, fileName, int64(len(content)), strings.NewReader(content))
+}
+
+func TestFileManagerRollback(t *testing.T) {
+	// the versions are forwarded by the decorators and by the view of the directory
+	decorators := map[string]func(storage storageabstraction.IFileStorage) (storageabstraction.IFileStorage, string){
+		"storage": func(storage storageabstraction.IFileStorage) (storageabstraction.IFileStorage, string) {
+			return storage, "site"
+		},
+		"retry": func(storage storageabstraction.IFileStorage) (storageabstraction.IFileStorage, string) {
+			return retry.NewRetryStorage(storage), "site"
+		},
+		"sub": func(storage storageabstraction.IFileStorage) (storageabstraction.IFileStorage, string) {
+			subStorage, _ := storageabstraction.Sub(cache.NewCacheStorage(storage), "site")
+			return subStorage, ""
+		},
+	}
+	for name, decorate := range decorators {
+		t.Run(name, func(t *testing.T) {
+			testFileManagerRollback(t, decorate)
+		})
+	}
+
+	fileManager := FileManager{storage: memorystorage.NewMemoryStorage(), logger: log.NewNopLogger()}
+	if err := fileManager.Rollback("site", time.Now()); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("Expected unsupported error for a storage without versions, actual: %v", err)
+	}
+}
+
+func testFileManagerRollback(t *testing.T, decorate func(storage storageabstraction.IFileStorage) (storageabstraction.IFileStorage, string)) {
+	storage := &versionedStorage{IContextFileStorage: memorystorage.NewMemoryStorage()}
+	write := func(fileName string, content string) bool {
+		if err := storage.Write(fileName, int64(len(content)), strings.NewReader(content)); err != nil {
+			t.Errorf("[TestError] Error writing %s: %v", fileName, err)
+			return false
+		}
+		return true
+	}
+
+	if !write("site/keep.txt", "keep") {
+		return
+	}
+	time.Sleep(time.Millisecond)
+	since := time.Now()
+	if !write("site/index.html", "bad") || !write("site/new.js", "new") {
+		return
+	}
+
+	before, after := since.Add(-time.Hour), since.Add(time.Second)
+	storage.versions = []storageabstraction.FileVersion{
+		{Path: "site/index.html", ID: "1", Created: before, ModTime: before.Add(-time.Hour)},
+		{Path: "site/index.html", ID: "2", Created: after, ModTime: before},
+		{Path: "site/index.html", ID: "3", Created: after.Add(time.Second), ModTime: after},
+		{Path: "site/old.css", Created: after, ModTime: before, Deleted: true},
+		// created and deleted after since
+		{Path: "site/tmp.txt", Created: after, ModTime: after, Deleted: true},
+	}
+	storage.contents = map[string]string{"1": "oldest", "2": "good", "3": "intermediate", "site/old.css": "css", "site/tmp.txt": "tmp"}
+
+	decorated, directory := decorate(storage)
+	fileManager := FileManager{storage: decorated, logger: log.NewNopLogger()}
+	if err := fileManager.Rollback(directory, since); err != nil {
+		t.Errorf("Error rolling back: %v", err)
+		return
+	}
+
+	for fileName, expected := range map[string]string{"site/keep.txt": "keep", "site/index.html": "good", "site/old.css": "css"} {
+		reader, err := storage.Read(fileName)
+		if err != nil {
+			t.Errorf("Error reading %s: %v", fileName, err)
+			continue
+		}
+		content, _ := io.ReadAll(reader)
+		_ = reader.Close()
+		if string(content) != expected {
+			t.Errorf("Expected %s for %s, actual: %s", expected, fileName, content)
+		}
+	}
+	for _, fileName := range []string{"site/new.js", "site/tmp.txt"} {
+		if exists, _ := storageabstraction.Exists(storage, fileName); exists {
+			t.Errorf("Expected %s to be deleted", fileName)
+		}
+	}
+}
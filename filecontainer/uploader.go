@@ -1,9 +1,12 @@
 package filecontainer
 
 import (
+	"context"
 	compression3 "github.com/2flow/gokies/compression"
 	"github.com/2flow/gokies/storageabstraction"
+	storagesync "github.com/2flow/gokies/storageabstraction/sync"
 	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
 	"io"
 	"io/ioutil"
 	"os"
@@ -47,7 +50,13 @@ type Uploader struct {
 	uploaderTodos []*UploadLockObject
 	logger        log.Logger
 	todosLock     sync.Mutex
-	fileStorage   storageabstraction.IFileStorage
+	fileStorage   storageabstraction.IContextFileStorage
+	// skipUnchanged does not write the extracted files which exist with the same content already
+	skipUnchanged bool
+
+	// ctx is cancelled on Shutdown and stops all running extractions
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
 type TarUploader interface {
@@ -62,8 +71,35 @@ type tarUploadWriter struct {
 	file         io.WriteCloser
 }
 
-func CreateUploader(rootDir string, logger log.Logger, fileStorage storageabstraction.IFileStorage) *Uploader {
-	return &Uploader{logger: logger, rootDir: rootDir, fileStorage: fileStorage}
+// UploaderOption configures the uploader
+type UploaderOption func(uploader *Uploader)
+
+// WithSkipUnchanged does not write the extracted files which exist with the same content already. If the storage
+// does not know the MD5 of a file with the same size, the file is read to compare it
+func WithSkipUnchanged() UploaderOption {
+	return func(uploader *Uploader) {
+		uploader.skipUnchanged = true
+	}
+}
+
+func CreateUploader(rootDir string, logger log.Logger, fileStorage storageabstraction.IFileStorage, options ...UploaderOption) *Uploader {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	uploader := &Uploader{logger: logger,
+		rootDir:     rootDir,
+		fileStorage: storageabstraction.WithContext(fileStorage),
+		ctx:         ctx,
+		cancel:      cancel}
+	for _, option := range options {
+		option(uploader)
+	}
+	return uploader
+}
+
+// Shutdown stops all running and pending extractions, their OnExtractionFinished callback receives
+// the cancellation error
+func (uploader *Uploader) Shutdown() {
+	uploader.cancel()
 }
 
 func doesDirectoryExist(dir string) bool {
@@ -94,7 +130,7 @@ func (uploader *Uploader) createTempDir() string {
 
 	dir, err := os.MkdirTemp(uploader.rootDir, "uploaderDir")
 	if err != nil {
-		uploader.logger.Log("msg", "Unable to create temp dir")
+		_ = level.Error(uploader.logger).Log("msg", "Unable to create temp dir", "path", uploader.rootDir, "err", err)
 	}
 
 	return dir
@@ -122,8 +158,10 @@ func (uploader *Uploader) UploadTar(rootPath string, callbacks UploadCallBacks)
 
 func (uploader *Uploader) objectUploadFunction(uploadObject *UploadObject, lockObj *UploadLockObject) {
 
-	err := uploadObject.callbacks.OnReadyToExtract()
-	if err == nil {
+	err := uploader.ctx.Err()
+	if err != nil {
+		uploadObject.callbacks.OnExtractionFinished(err)
+	} else if err = uploadObject.callbacks.OnReadyToExtract(); err == nil {
 		err = uploader.extractTar(uploadObject)
 		uploadObject.callbacks.OnExtractionFinished(err)
 	}
@@ -186,9 +224,11 @@ func (tarUploadWriter tarUploadWriter) Error() {
 func (uploader *Uploader) uploadContentFromTar(tarPath string, destinationDir string, tempFile string) ([]string, error) {
 	var uploadedFiles []string
 
-	uploader.logger.Log("msg", "Start file extraction ...")
+	_ = level.Info(uploader.logger).Log("msg", "Start file extraction ...", "path", destinationDir)
 
 	compression2 := compression3.NewGzipExtractor(uploader.fileStorage)
+	compression2.SkipUnchanged = uploader.skipUnchanged
+	compression2.Logger = uploader.logger
 
 	/*compression := utils.Compression{
 		FolderCallback: func(relativeDir string) {
@@ -218,7 +258,7 @@ func (uploader *Uploader) uploadContentFromTar(tarPath string, destinationDir st
 
 	artifactReader, err := os.Open(tarPath)
 	if err != nil {
-		uploader.logger.Log("msg", "unable to open uploaded artifacts file "+tarPath)
+		_ = level.Error(uploader.logger).Log("msg", "unable to open uploaded artifacts file", "path", tarPath, "err", err)
 		return uploadedFiles, err
 	}
 	defer artifactReader.Close()
@@ -227,32 +267,19 @@ func (uploader *Uploader) uploadContentFromTar(tarPath string, destinationDir st
 			uploader.logger.Log("msg", "unable to process uploaded artifact")
 			return uploadedFiles
 		}*/
-	uploadedFiles, err = compression2.ExtractFromStream(destinationDir, artifactReader)
+	uploadedFiles, err = compression2.ExtractFromStreamContext(uploader.ctx, destinationDir, artifactReader)
 	if err != nil {
-		uploader.logger.Log("msg", "unable to process uploaded artifact: "+err.Error())
+		_ = level.Error(uploader.logger).Log("msg", "unable to process uploaded artifact", "path", destinationDir, "err", err)
 	} else {
-		uploader.logger.Log("msg", "Finished file extraction")
+		_ = level.Info(uploader.logger).Log("msg", "Finished file extraction", "path", destinationDir, "files", len(uploadedFiles))
 	}
 
 	return uploadedFiles, err
 }
 
 func (uploader *Uploader) removeOldFilesInStorage(uploadObject *UploadObject, uploadedFiles []string) error {
-	return uploader.fileStorage.Walk(uploadObject.destinationDir, func(filePath string, info os.FileInfo, err error) error {
-		if info != nil && !info.IsDir() {
-			exists := false
-			for _, fileInArtifact := range uploadedFiles {
-				if fileInArtifact == (filePath) {
-					exists = true
-					break
-				}
-			}
-			if !exists {
-				uploader.fileStorage.DeleteFile(uploadObject.destinationDir + "/" + filePath)
-			}
-		}
-		return nil
-	})
+	_, err := storagesync.RemoveOtherFilesContext(uploader.ctx, uploader.fileStorage, uploadObject.destinationDir, uploadedFiles)
+	return err
 }
 
 func (uploader *Uploader) extractTar(uploadObject *UploadObject) error {
@@ -264,5 +291,10 @@ func (uploader *Uploader) extractTar(uploadObject *UploadObject) error {
 		return err
 	}
 
-	return uploader.removeOldFilesInStorage(uploadObject, uploadedFiles)
+	if err = uploader.removeOldFilesInStorage(uploadObject, uploadedFiles); err != nil {
+		return err
+	}
+
+	// storages like the content addressable storage only publish the changes when they are flushed
+	return storageabstraction.FlushContext(uploader.ctx, uploader.fileStorage)
 }
@@ -0,0 +1,188 @@
+package filecontainer
+
+import (
+	"bytes"
+	"errors"
+	"github.com/2flow/gokies/compression"
+	"github.com/2flow/gokies/storageabstraction"
+	"github.com/2flow/gokies/storageabstraction/cas"
+	"github.com/2flow/gokies/storageabstraction/memorystorage"
+	"github.com/go-kit/log"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUploaderReplacesDirectoryContent(t *testing.T) {
+	artifactStorage := memorystorage.NewMemoryStorage()
+	for _, fileName := range []string{"artifact/index.html", "artifact/js/app.js"} {
+		if err := artifactStorage.Write(fileName, int64(len(fileName)), strings.NewReader(fileName)); err != nil {
+			t.Errorf("[TestError] Error writing artifact file: %v", err)
+			return
+		}
+	}
+	artifact := bytes.Buffer{}
+	if err := compression.NewCompression(artifactStorage).CompressDir("artifact", &artifact); err != nil {
+		t.Errorf("[TestError] Error creating artifact: %v", err)
+		return
+	}
+
+	storage := memorystorage.NewMemoryStorage()
+	if err := storage.Write("app/old.js", 3, strings.NewReader("old")); err != nil {
+		t.Errorf("[TestError] Error writing old file: %v", err)
+		return
+	}
+
+	uploader := CreateUploader(t.TempDir(), log.NewNopLogger(), storage)
+	finished := make(chan error, 1)
+
+	writer, err := uploader.UploadTar("app", UploadCallBacks{
+		OnReadyToExtract:     func() error { return nil },
+		OnExtractionFinished: func(err error) { finished <- err },
+	})
+	if err != nil {
+		t.Errorf("Error creating upload writer: %v", err)
+		return
+	}
+	if _, err = io.Copy(writer, &artifact); err != nil {
+		t.Errorf("Error writing artifact: %v", err)
+		return
+	}
+	writer.Done()
+
+	select {
+	case err = <-finished:
+		if err != nil {
+			t.Errorf("Extraction failed: %v", err)
+			return
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("Extraction did not finish")
+		return
+	}
+
+	if _, err := storage.FileSize("app/js/app.js"); err != nil {
+		t.Errorf("Uploaded file is missing: %v", err)
+	}
+
+	if _, err := storage.FileSize("app/old.js"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Old file was not removed: %v", err)
+	}
+}
+
+// uploadArtifact uploads a tar of the files into the directory and waits for the extraction
+func uploadArtifact(uploader *Uploader, directory string, files map[string]string) error {
+	artifactStorage := memorystorage.NewMemoryStorage()
+	for fileName, content := range files {
+		if err := artifactStorage.Write(path.Join("artifact", fileName), int64(len(content)), strings.NewReader(content)); err != nil {
+			return err
+		}
+	}
+	artifact := bytes.Buffer{}
+	if err := compression.NewCompression(artifactStorage).CompressDir("artifact", &artifact); err != nil {
+		return err
+	}
+
+	finished := make(chan error, 1)
+	writer, err := uploader.UploadTar(directory, UploadCallBacks{
+		OnReadyToExtract:     func() error { return nil },
+		OnExtractionFinished: func(err error) { finished <- err },
+	})
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(writer, &artifact); err != nil {
+		return err
+	}
+	writer.Done()
+
+	select {
+	case err = <-finished:
+		return err
+	case <-time.After(5 * time.Second):
+		return errors.New("extraction did not finish")
+	}
+}
+
+func countFiles(storage storageabstraction.IFileStorage, directory string) int {
+	count := 0
+	_ = storage.Walk(directory, func(path string, info fs.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			count++
+		}
+		return nil
+	})
+	return count
+}
+
+func TestUploaderContentAddressableStorage(t *testing.T) {
+	wrapped := memorystorage.NewMemoryStorage()
+	storage, err := cas.NewCASStorage(wrapped)
+	if err != nil {
+		t.Errorf("[TestError] Error creating storage: %v", err)
+		return
+	}
+	uploader := CreateUploader(t.TempDir(), log.NewNopLogger(), storage)
+
+	release := map[string]string{"index.html": "release 1", "js/app.js": "app", "js/vendor.js": "vendor"}
+	if err = uploadArtifact(uploader, "app", release); err != nil {
+		t.Errorf("Error uploading first release: %v", err)
+		return
+	}
+	blobs := countFiles(wrapped, "blobs")
+
+	release["index.html"] = "release 2"
+	delete(release, "js/vendor.js")
+	if err = uploadArtifact(uploader, "app", release); err != nil {
+		t.Errorf("Error uploading second release: %v", err)
+		return
+	}
+	if updated := countFiles(wrapped, "blobs"); updated != blobs+1 {
+		t.Errorf("Expected only the changed file to be written, blobs before: %d, after: %d", blobs, updated)
+	}
+
+	// the uploader flushes the manifest, so other instances see the release
+	other, err := cas.NewCASStorage(wrapped)
+	if err != nil {
+		t.Errorf("Error loading the manifest: %v", err)
+		return
+	}
+	if size, err := other.FileSize("app/index.html"); err != nil || size != int64(len("release 2")) {
+		t.Errorf("Expected the uploaded file in the manifest, actual: %d, err: %v", size, err)
+	}
+	if _, err = other.FileSize("app/js/vendor.js"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected the removed file not to be in the manifest: %v", err)
+	}
+}
+
+func TestUploaderSkipUnchanged(t *testing.T) {
+	for _, skipUnchanged := range []bool{false, true} {
+		storage := memorystorage.NewMemoryStorage()
+		var options []UploaderOption
+		if skipUnchanged {
+			options = append(options, WithSkipUnchanged())
+		}
+		uploader := CreateUploader(t.TempDir(), log.NewNopLogger(), storage, options...)
+
+		release := map[string]string{"index.html": "release", "js/app.js": "app"}
+		if err := uploadArtifact(uploader, "app", release); err != nil {
+			t.Errorf("[TestError] Error uploading first release: %v", err)
+			return
+		}
+		before, _ := storageabstraction.Stat(storage, "app/index.html")
+		time.Sleep(time.Millisecond)
+		if err := uploadArtifact(uploader, "app", release); err != nil {
+			t.Errorf("Error uploading the release again: %v", err)
+			return
+		}
+
+		// unchanged files are only skipped if it is enabled
+		after, _ := storageabstraction.Stat(storage, "app/index.html")
+		if after.ModTime().Equal(before.ModTime()) != skipUnchanged {
+			t.Errorf("Expected the unchanged file to be written: %v, actual modification: %v -> %v", !skipUnchanged, before.ModTime(), after.ModTime())
+		}
+	}
+}
@@ -1,16 +1,22 @@
 package httputils
 
 import (
+	"context"
+	"errors"
 	"github.com/2flow/gokies/filecontainer"
 	"github.com/2flow/gokies/storageabstraction"
 	"io"
 	"net/http"
+	"strconv"
 	"strings"
 )
 
 type HTTPFileContainer struct {
 	FileStorage storageabstraction.IFileStorage
 	RootDir     string
+	// ServeEncoded serves files which are stored encoded, e.g. compressed, as they are to the clients which accept
+	// their encoding. Other clients get the decoded files
+	ServeEncoded bool
 }
 
 func (container HTTPFileContainer) ProvideFileHandler() http.Handler {
@@ -31,21 +37,153 @@ func (container HTTPFileContainer) ProvideFileHandler() http.Handler {
 			routePath = "/index.html"
 		}
 
-		reader, err := container.FileStorage.Read(routePath)
+		var info *storageabstraction.FileInfo
+		var err error
+		if container.ServeEncoded {
+			info, err = storageabstraction.StatEncodedContext(request.Context(), container.FileStorage, routePath)
+			if err == nil && info.ContentEncoding() != "" {
+				responseWriter.Header().Add("Vary", "Accept-Encoding")
+				if AcceptsEncoding(request, info.ContentEncoding()) {
+					container.serveEncoded(responseWriter, request, routePath, info)
+					return
+				}
+				// the client gets the decoded file
+				info = nil
+			}
+		}
 
+		if info == nil && err == nil {
+			info, err = storageabstraction.StatContext(request.Context(), container.FileStorage, routePath)
+		}
 		if err != nil {
-			HTTPRoutingErrorHandler("Unable to read file", err).EncodeStatus(responseWriter, http.StatusInternalServerError)
+			HTTPRoutingErrorHandler("Unable to read file", err).EncodeStatus(responseWriter, StatusCodeFromError(err))
 			return
 		}
 
-		defer reader.Close()
-		SetContentType(responseWriter, reader, routePath)
-		responseWriter.WriteHeader(http.StatusOK)
-		io.Copy(responseWriter, reader)
+		if info.ETag() != "" {
+			responseWriter.Header().Set("ETag", info.ETag())
+		}
+
+		content := newStorageReadSeeker(request.Context(), container.FileStorage, routePath, info.Size(), false)
+		defer content.Close()
+
+		// ServeContent handles the conditional and Range requests, only the requested ranges are read from the storage
+		SetContentType(responseWriter, content, routePath)
+		http.ServeContent(responseWriter, request, routePath, info.ModTime(), content)
 	})
 }
 
-func UploadFileWithMultipart(request *http.Request, fileManager filecontainer.IFileManager, path string) error {
+// serveEncoded serves the file as it is stored with its content encoding. The encoded file gets its own ETag,
+// so caches do not mix it up with the decoded file
+func (container HTTPFileContainer) serveEncoded(responseWriter http.ResponseWriter, request *http.Request, routePath string, info *storageabstraction.FileInfo) {
+	if info.ETag() != "" {
+		responseWriter.Header().Set("ETag", strings.TrimSuffix(info.ETag(), "\"")+"-"+info.ContentEncoding()+"\"")
+	}
+	responseWriter.Header().Set("Content-Encoding", info.ContentEncoding())
+	if !SetContentTypeWithName(responseWriter, routePath) {
+		// the content type can not be sniffed from the encoded content
+		contentType := info.ContentType()
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		responseWriter.Header().Set("Content-Type", contentType)
+	}
+
+	content := newStorageReadSeeker(request.Context(), container.FileStorage, routePath, info.Size(), true)
+	defer content.Close()
+
+	http.ServeContent(responseWriter, request, routePath, info.ModTime(), content)
+}
+
+// AcceptsEncoding checks if the Accept-Encoding header of the request accepts the content encoding
+func AcceptsEncoding(request *http.Request, encoding string) bool {
+	wildcard := false
+	for _, accepted := range strings.Split(request.Header.Get("Accept-Encoding"), ",") {
+		name, parameters, _ := strings.Cut(accepted, ";")
+		name = strings.TrimSpace(name)
+
+		quality := 1.0
+		if value, found := strings.CutPrefix(strings.TrimSpace(parameters), "q="); found {
+			quality, _ = strconv.ParseFloat(value, 64)
+		}
+		if strings.EqualFold(name, encoding) {
+			return quality > 0
+		}
+		if name == "*" {
+			wildcard = quality > 0
+		}
+	}
+	return wildcard
+}
+
+// storageReadSeeker is a io.ReadSeeker on a file of a storage, a read after a seek starts a new ranged read
+type storageReadSeeker struct {
+	ctx      context.Context
+	storage  storageabstraction.IFileStorage
+	fileName string
+	size     int64
+	// encoded reads the file as it is stored
+	encoded bool
+
+	offset int64
+	reader io.ReadCloser
+}
+
+func newStorageReadSeeker(ctx context.Context, storage storageabstraction.IFileStorage, fileName string, size int64, encoded bool) *storageReadSeeker {
+	return &storageReadSeeker{ctx: ctx, storage: storage, fileName: fileName, size: size, encoded: encoded}
+}
+
+func (seeker *storageReadSeeker) Read(p []byte) (n int, err error) {
+	if seeker.offset >= seeker.size {
+		return 0, io.EOF
+	}
+
+	if seeker.reader == nil {
+		readRange := storageabstraction.ReadRangeContext
+		if seeker.encoded {
+			readRange = storageabstraction.ReadEncodedRangeContext
+		}
+		seeker.reader, err = readRange(seeker.ctx, seeker.storage, seeker.fileName, seeker.offset, storageabstraction.CountToEnd)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n, err = seeker.reader.Read(p)
+	seeker.offset += int64(n)
+	return n, err
+}
+
+func (seeker *storageReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += seeker.offset
+	case io.SeekEnd:
+		offset += seeker.size
+	}
+	if offset < 0 {
+		return 0, errors.New("seek before the start of the file")
+	}
+
+	if offset != seeker.offset {
+		_ = seeker.Close()
+		seeker.offset = offset
+	}
+	return offset, nil
+}
+
+func (seeker *storageReadSeeker) Close() error {
+	if seeker.reader == nil {
+		return nil
+	}
+
+	err := seeker.reader.Close()
+	seeker.reader = nil
+	return err
+}
+
+func UploadFileWithMultipart(request *http.Request, fileManager filecontainer.IFileManager, path string,
+	callbacks filecontainer.UploadCallBacks) error {
 	multipartFileName := "file"
 	reader, err := request.MultipartReader()
 
@@ -63,7 +201,7 @@ func UploadFileWithMultipart(request *http.Request, fileManager filecontainer.IF
 		}
 
 		if part.FormName() == multipartFileName {
-			err = fileManager.UploadTar(path, part)
+			err = fileManager.UploadTar(path, callbacks, part)
 			_ = part.Close()
 
 			if err != nil {
@@ -0,0 +1,121 @@
+package httputils
+
+import (
+	"compress/gzip"
+	"github.com/2flow/gokies/storageabstraction/compressed"
+	"github.com/2flow/gokies/storageabstraction/memorystorage"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newTestContainer(t *testing.T) http.Handler {
+	storage := memorystorage.NewMemoryStorage()
+	if err := storage.Write("video.mp4", 10, strings.NewReader("0123456789")); err != nil {
+		t.Fatalf("[TestError] Error writing test file: %v", err)
+	}
+
+	return HTTPFileContainer{FileStorage: storage}.ProvideFileHandler()
+}
+
+func serve(handler http.Handler, header map[string]string) *http.Response {
+	request := httptest.NewRequest(http.MethodGet, "/video.mp4", nil)
+	request.Header.Set("Sec-Fetch-Dest", "video")
+	for key, value := range header {
+		request.Header.Set(key, value)
+	}
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	return recorder.Result()
+}
+
+func TestProvideFileHandlerRange(t *testing.T) {
+	handler := newTestContainer(t)
+
+	response := serve(handler, nil)
+	body, _ := io.ReadAll(response.Body)
+	if response.StatusCode != http.StatusOK || string(body) != "0123456789" {
+		t.Errorf("Unexpected full response %d: %q", response.StatusCode, body)
+	}
+	etag := response.Header.Get("ETag")
+
+	response = serve(handler, map[string]string{"Range": "bytes=2-5"})
+	body, _ = io.ReadAll(response.Body)
+	if response.StatusCode != http.StatusPartialContent || string(body) != "2345" ||
+		response.Header.Get("Content-Range") != "bytes 2-5/10" {
+		t.Errorf("Unexpected range response %d: %q", response.StatusCode, body)
+	}
+
+	response = serve(handler, map[string]string{"Range": "bytes=7-", "If-Range": etag})
+	body, _ = io.ReadAll(response.Body)
+	if response.StatusCode != http.StatusPartialContent || string(body) != "789" {
+		t.Errorf("Unexpected If-Range response %d: %q", response.StatusCode, body)
+	}
+
+	response = serve(handler, map[string]string{"Range": "bytes=7-", "If-Range": "\"outdated\""})
+	if response.StatusCode != http.StatusOK {
+		t.Errorf("Outdated If-Range must return the whole file, actual status %d", response.StatusCode)
+	}
+
+	response = serve(handler, map[string]string{"If-None-Match": etag})
+	if response.StatusCode != http.StatusNotModified {
+		t.Errorf("Expected not modified, actual status %d", response.StatusCode)
+	}
+}
+
+func TestProvideFileHandlerMissingFile(t *testing.T) {
+	handler := newTestContainer(t)
+
+	request := httptest.NewRequest(http.MethodGet, "/missing.mp4", nil)
+	request.Header.Set("Sec-Fetch-Dest", "video")
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for a missing file, actual: %d", recorder.Code)
+	}
+}
+
+func TestProvideFileHandlerEncoded(t *testing.T) {
+	script := strings.Repeat("console.log('served');\n", 100)
+	storage := compressed.NewCompressedStorage(memorystorage.NewMemoryStorage())
+	if err := storage.Write("app.js", int64(len(script)), strings.NewReader(script)); err != nil {
+		t.Fatalf("[TestError] Error writing test file: %v", err)
+	}
+	handler := HTTPFileContainer{FileStorage: storage, ServeEncoded: true}.ProvideFileHandler()
+
+	serveScript := func(acceptEncoding string) *http.Response {
+		request := httptest.NewRequest(http.MethodGet, "/app.js", nil)
+		request.Header.Set("Sec-Fetch-Dest", "script")
+		request.Header.Set("Accept-Encoding", acceptEncoding)
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		return recorder.Result()
+	}
+
+	response := serveScript("br, gzip;q=0.8")
+	if response.Header.Get("Content-Encoding") != "gzip" || response.Header.Get("Vary") != "Accept-Encoding" ||
+		response.Header.Get("Content-Type") != "application/javascript" {
+		t.Errorf("Expected the compressed file, actual headers: %v", response.Header)
+	}
+	decoder, err := gzip.NewReader(response.Body)
+	if err != nil {
+		t.Errorf("Expected a gzip body: %v", err)
+		return
+	}
+	if body, _ := io.ReadAll(decoder); string(body) != script {
+		t.Errorf("Unexpected decompressed body: %d bytes", len(body))
+	}
+	encodedETag := response.Header.Get("ETag")
+
+	for _, acceptEncoding := range []string{"", "br", "gzip;q=0"} {
+		response = serveScript(acceptEncoding)
+		body, _ := io.ReadAll(response.Body)
+		if response.Header.Get("Content-Encoding") != "" || string(body) != script || response.Header.Get("ETag") == encodedETag {
+			t.Errorf("Expected the decompressed file for %q, actual headers: %v", acceptEncoding, response.Header)
+		}
+	}
+}
@@ -2,7 +2,9 @@ package httputils
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/2flow/gokies/storageabstraction"
 	"io"
 	"log"
 	"net/http"
@@ -64,6 +66,23 @@ func HTTPRoutingErrorHandler(logMsg string, err error) *TResponse {
 	return GetErrorResponse(logMsg, 1)
 }
 
+// StatusCodeFromError returns the http status code for the errors of the storages
+func StatusCodeFromError(err error) int {
+	switch {
+	case errors.Is(err, storageabstraction.ErrNotExist):
+		return http.StatusNotFound
+	case errors.Is(err, storageabstraction.ErrPermission):
+		return http.StatusForbidden
+	case errors.Is(err, storageabstraction.ErrPreconditionFailed):
+		return http.StatusPreconditionFailed
+	case errors.Is(err, storageabstraction.ErrAlreadyExists), errors.Is(err, storageabstraction.ErrConflict):
+		return http.StatusConflict
+	case errors.Is(err, storageabstraction.ErrUnavailable):
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
 // SetContentType Setst the content type to the responsewriter by the filename or the content
 func SetContentType(w http.ResponseWriter, file io.Reader, fileName string) {
 	SetContentTypeWithName(w, fileName)
@@ -8,186 +8,258 @@ import (
 	"github.com/2flow/gokies/storageabstraction"
 	"github.com/2flow/gokies/storageabstraction/common"
 	"io"
-	"log"
+	"net/http"
 	"net/url"
 	"os"
+	"path"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/Azure/azure-pipeline-go/pipeline"
 	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
 	//"github.com/Azure/azure-storage-file-go/azfile"
 )
 
-// tAzureFileStorage
-//
-//	if the storage URL is "" (Empty string) the default url is used
+// tAzureFileStorage stores the files as block blobs of a container,
+// the pipeline with the credential is created once and used for all requests
 type tAzureFileStorage struct {
-	accountName string
-	accountKey  string
-	storageURL  string
-	credential  *azblob.SharedKeyCredential
-	loginCount  int
-
-	loginLock sync.Mutex
+	pipeline     pipeline.Pipeline
+	serviceURL   azblob.ServiceURL
+	containerURL azblob.ContainerURL
 
 	containerName string // will be im-projects
+	logger        log.Logger
+	blockSize     int
+	concurrency   int
+
+	// createContainer creates the container with the public access before the first write
+	createContainer  bool
+	publicAccess     PublicAccess
+	containerLock    sync.Mutex
+	containerCreated bool
+
+	// snapshotOverwrites keeps the previous content of the overwritten blobs as snapshot
+	snapshotOverwrites bool
+	// retryOptions are the retries of the pipeline, the zero values are the defaults of azblob
+	retryOptions azblob.RetryOptions
+}
+
+// Option configures the azure storage
+type Option func(azureStorage *tAzureFileStorage)
+
+// WithLogger sets the logger for the operations of the storage, by default nothing is logged
+func WithLogger(logger log.Logger) Option {
+	return func(azureStorage *tAzureFileStorage) {
+		azureStorage.logger = logger
+	}
 }
 
-type tAzureReadCloser struct {
-	readCloser  io.ReadCloser
-	fileStorage *tAzureFileStorage
+// WithBlockSize sets the size of the blocks, larger blobs are uploaded and downloaded in blocks. The default is 4 MiB,
+// a blob can have up to 50000 blocks
+func WithBlockSize(blockSize int) Option {
+	return func(azureStorage *tAzureFileStorage) {
+		if blockSize > 0 {
+			azureStorage.blockSize = blockSize
+		}
+	}
 }
 
-func (azureReader *tAzureReadCloser) Read(p []byte) (n int, err error) {
-	return azureReader.readCloser.Read(p)
+// WithConcurrency sets the number of blocks which are uploaded or downloaded in parallel, the default is 4
+func WithConcurrency(concurrency int) Option {
+	return func(azureStorage *tAzureFileStorage) {
+		if concurrency > 0 {
+			azureStorage.concurrency = concurrency
+		}
+	}
 }
 
-func (azureReader *tAzureReadCloser) Close() error {
-	azureReader.fileStorage.LogOut()
-	return azureReader.readCloser.Close()
+// WithRetryOptions sets the retries of the requests by the azure pipeline. By default a request is tried 4 times
+// with an exponential backoff, MaxTries: 1 disables the retries, e.g. if the storage is wrapped by retry.NewRetryStorage
+func WithRetryOptions(retryOptions azblob.RetryOptions) Option {
+	return func(azureStorage *tAzureFileStorage) {
+		azureStorage.retryOptions = retryOptions
+	}
 }
 
 // NewAzureStorage instantiates a new azur storage connector
-func NewAzureStorage(accountName string, accountKey string, containerName string) storageabstraction.IFileStorage {
+func NewAzureStorage(accountName string, accountKey string, containerName string, options ...Option) storageabstraction.IFileStorage {
 
 	storageURL := fmt.Sprintf("https://%s.blob.core.windows.net", accountName)
 
-	return NewAzureStorageFromUrl(storageURL, containerName, accountName, accountKey)
+	return NewAzureStorageFromUrl(storageURL, containerName, accountName, accountKey, options...)
 }
 
-func NewAzureStorageFromUrl(storageURL, containerName, accountName, accountKey string) storageabstraction.IFileStorage {
-	storage := &tAzureFileStorage{accountName: accountName,
-		accountKey:    accountKey,
-		storageURL:    storageURL,
-		credential:    nil,
-		loginCount:    0,
-		containerName: containerName}
+// NewAzureStorageFromUrl uses the shared key of the account for the storage url, e.g. of Azurite.
+// If the url or the key is invalid, it is logged and all operations return the error.
+// NewAzureStorageWithCredential with SharedKeyCredential returns it when the storage is created
+func NewAzureStorageFromUrl(storageURL, containerName, accountName, accountKey string, options ...Option) storageabstraction.IFileStorage {
+	storage, err := NewAzureStorageWithCredential(storageURL, containerName, SharedKeyCredential(accountName, accountKey), options...)
+	if err != nil {
+		failedStorage := newAzureStorage(containerName, options)
+		_ = level.Error(failedStorage.logger).Log("msg", "Unable to login to azure", "account", accountName, "err", err)
+		failedStorage.setError(err)
+		return failedStorage
+	}
 
 	return storage
 }
 
-func (azureStorage *tAzureFileStorage) LogIn() error {
-	azureStorage.loginLock.Lock()
-	defer azureStorage.loginLock.Unlock()
+// NewAzureStorageWithCredential creates the storage for the container of the service url (including a SAS token, if any).
+// The credential is created once by the provider and is used for all requests
+func NewAzureStorageWithCredential(serviceURL string, containerName string, credential CredentialProvider, options ...Option) (storageabstraction.IFileStorage, error) {
+	storage := newAzureStorage(containerName, options)
 
-	// if currently no task is logged in
-	// do the login immediately
-	if azureStorage.loginCount == 0 {
-		credential, err := azblob.NewSharedKeyCredential(azureStorage.accountName, azureStorage.accountKey)
-		if err != nil {
-			fmt.Println("Unable to login to azure")
-			return err
-		}
+	parsedURL, err := url.Parse(serviceURL)
+	if err != nil {
+		return nil, err
+	}
+
+	blobCredential, err := credential(storage.logger)
+	if err != nil {
+		return nil, err
+	}
+
+	storage.setCredential(*parsedURL, blobCredential)
+	return storage, nil
+}
 
-		azureStorage.credential = credential
+func newAzureStorage(containerName string, options []Option) *tAzureFileStorage {
+	storage := &tAzureFileStorage{
+		containerName: containerName,
+		logger:        log.NewNopLogger(),
+		blockSize:     defaultBlockSize,
+		concurrency:   defaultConcurrency,
 	}
+	for _, option := range options {
+		option(storage)
+	}
+
+	return storage
+}
+
+// setError creates a pipeline which fails all requests with the error, instead of sending them without credential
+func (azureStorage *tAzureFileStorage) setError(err error) {
+	failed := pipeline.FactoryFunc(func(_ pipeline.Policy, _ *pipeline.PolicyOptions) pipeline.PolicyFunc {
+		return func(context.Context, pipeline.Request) (pipeline.Response, error) {
+			return nil, err
+		}
+	})
 
-	// increase login counter so we know during the logout if something else is using the connection
-	azureStorage.loginCount++
+	azureStorage.pipeline = pipeline.NewPipeline([]pipeline.Factory{pipeline.MethodFactoryMarker(), failed}, pipeline.Options{})
+	azureStorage.serviceURL = azblob.NewServiceURL(url.URL{}, azureStorage.pipeline)
+	azureStorage.containerURL = azureStorage.serviceURL.NewContainerURL(azureStorage.containerName)
+}
 
-	return nil
+// setCredential creates the pipeline used for all requests
+func (azureStorage *tAzureFileStorage) setCredential(serviceURL url.URL, credential azblob.Credential) {
+	azureStorage.pipeline = azblob.NewPipeline(credential, azblob.PipelineOptions{Retry: azureStorage.retryOptions})
+	azureStorage.serviceURL = azblob.NewServiceURL(serviceURL, azureStorage.pipeline)
+	azureStorage.containerURL = azureStorage.serviceURL.NewContainerURL(azureStorage.containerName)
 }
 
 func (azureStorage *tAzureFileStorage) DeleteDirectory(directory string) error {
-	azureStorage.LogIn()
-	defer azureStorage.LogOut()
+	return azureStorage.DeleteDirectoryContext(context.Background(), directory)
+}
 
-	_, containerURL := azureStorage.getContainerURL()
+func (azureStorage *tAzureFileStorage) DeleteDirectoryContext(ctx context.Context, directory string) (err error) {
+	defer func(start time.Time) {
+		common.LogOperation(azureStorage.logger, "deleteDirectory", directory, -1, start, err)
+	}(time.Now())
 
-	ctx := context.Background()
+	if err = common.ValidatePath(directory); err != nil {
+		return err
+	}
 
-	err := azureStorage.Walk(directory, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
+	_, containerURL := azureStorage.getContainerURL()
+
+	err = azureStorage.WalkContext(ctx, directory, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
 			return err
 		}
-		blobURL := containerURL.NewBlockBlobURL(directory + path)
+		blobName := path.Join(directory, filePath)
+		blobURL := containerURL.NewBlockBlobURL(blobName)
 		_, delErr := blobURL.Delete(ctx, azblob.DeleteSnapshotsOptionInclude, azblob.BlobAccessConditions{})
 
-		return delErr
+		return convertError("remove", blobName, delErr)
 	})
+	if errors.Is(err, storageabstraction.ErrNotExist) {
+		// a directory without blobs is already deleted
+		return nil
+	}
 
 	return err
 }
 
 func (azureStorage *tAzureFileStorage) DeleteFile(fileName string) error {
-	azureStorage.LogIn()
-	defer azureStorage.LogOut()
-
-	_, blobURL := azureStorage.getBlobURL(fileName)
-	ctx := context.Background()
-	_, delErr := blobURL.Delete(ctx, azblob.DeleteSnapshotsOptionInclude, azblob.BlobAccessConditions{})
-
-	return delErr
+	return azureStorage.DeleteFileContext(context.Background(), fileName)
 }
 
-func (azureStorage *tAzureFileStorage) Walk(directory string, walk storageabstraction.WalkFunc) error {
-	azureStorage.LogIn()
-	defer azureStorage.LogOut()
-
-	_, containerURL := azureStorage.getContainerURL()
-	ctx := context.Background()
-
-	var err error = nil
-
-	for marker := (azblob.Marker{}); marker.NotDone(); {
-		// Get a result segment starting with the blob indicated by the current Marker.
-		listBlob, err := containerURL.ListBlobsFlatSegment(ctx, marker, azblob.ListBlobsSegmentOptions{Prefix: directory})
-
-		if err != nil {
-			log.Printf("Unable to list content: %s\r\n", err.Error())
-			emptyModel := AzureFileInfo{}
-			err = walk("", &emptyModel, err)
-		} else {
-			// ListBlobs returns the start of the next segment; you MUST use this to get
-			// the next segment (after processing the current result segment).
-			marker = listBlob.NextMarker
-
-			// Process the blobs returned in this result segment (if the segment is empty, the loop body won't execute)
-			for _, blobInfo := range listBlob.Segment.BlobItems {
-				fileModel := AzureFileInfo{blobInfo: &blobInfo}
-				err = walk(strings.TrimPrefix(blobInfo.Name, directory), &fileModel, nil)
-
-				if err != nil {
-					break
-				}
-			}
-		}
+func (azureStorage *tAzureFileStorage) DeleteFileContext(ctx context.Context, fileName string) error {
+	return azureStorage.DeleteFileWithConditionsContext(ctx, fileName, storageabstraction.Conditions{})
+}
 
-		if err != nil {
-			break
-		}
+func (azureStorage *tAzureFileStorage) DeleteFileWithConditions(fileName string, conditions storageabstraction.Conditions) error {
+	return azureStorage.DeleteFileWithConditionsContext(context.Background(), fileName, conditions)
+}
 
+// DeleteFileWithConditionsContext deletes the blob with its snapshots, if the conditions are met.
+// With soft delete enabled for the account, they can be restored with Undelete
+func (azureStorage *tAzureFileStorage) DeleteFileWithConditionsContext(ctx context.Context, fileName string, conditions storageabstraction.Conditions) error {
+	if err := common.ValidatePath(fileName); err != nil {
+		return err
 	}
+	start := time.Now()
+	_, blobURL := azureStorage.getBlobURL(fileName)
+	_, delErr := blobURL.Delete(ctx, azblob.DeleteSnapshotsOptionInclude, accessConditions(conditions))
 
+	err := convertError("remove", fileName, delErr)
+	common.LogOperation(azureStorage.logger, "delete", fileName, -1, start, err)
 	return err
 }
 
 func (azureStorage *tAzureFileStorage) Read(fileName string) (io.ReadCloser, error) {
-	azureStorage.LogIn()
-	// do not logout at the end of this function, the logout is done when the reader is closed
+	return azureStorage.ReadContext(context.Background(), fileName)
+}
 
-	_, blobURL := azureStorage.getBlobURL(fileName)
+// ReadContext downloads the blob, large blobs are downloaded in parallel blocks
+func (azureStorage *tAzureFileStorage) ReadContext(ctx context.Context, fileName string) (io.ReadCloser, error) {
+	if err := common.ValidatePath(fileName); err != nil {
+		return nil, err
+	}
+	reader, err := azureStorage.download(ctx, fileName, 0, azblob.CountToEnd)
+	if err != nil {
+		err = convertError("read", fileName, err)
+		common.LogOperation(azureStorage.logger, "read", fileName, -1, time.Now(), err)
+		return nil, err
+	}
+
+	return common.LogReadCloser(azureStorage.logger, "read", fileName, reader), nil
+}
 
-	ctx := context.Background()
+func (azureStorage *tAzureFileStorage) ReadRange(fileName string, offset int64, length int64) (io.ReadCloser, error) {
+	return azureStorage.ReadRangeContext(context.Background(), fileName, offset, length)
+}
 
-	// Here's how to read the blob's data with progress reporting:
-	get, err := blobURL.Download(ctx, 0, 0, azblob.BlobAccessConditions{}, false, azblob.ClientProvidedKeyOptions{})
+// ReadRangeContext downloads only the range of the blob, azblob.CountToEnd is the same as storageabstraction.CountToEnd
+func (azureStorage *tAzureFileStorage) ReadRangeContext(ctx context.Context, fileName string, offset int64, length int64) (io.ReadCloser, error) {
+	if err := common.ValidatePath(fileName); err != nil {
+		return nil, err
+	}
+	reader, err := azureStorage.download(ctx, fileName, offset, length)
 	if err != nil {
-		fmt.Println(err.Error())
+		err = convertError("read", fileName, err)
+		common.LogOperation(azureStorage.logger, "readRange", fileName, -1, time.Now(), err)
 		return nil, err
 	}
 
-	return &tAzureReadCloser{get.Body(azblob.RetryReaderOptions{}), azureStorage}, nil
+	return common.LogReadCloser(azureStorage.logger, "readRange", fileName, reader), nil
 }
 
 func (azureStorage *tAzureFileStorage) getContainerURL() (pipeline.Pipeline, azblob.ContainerURL) {
-	p := azblob.NewPipeline(azureStorage.credential, azblob.PipelineOptions{})
-
-	cURL, _ := url.Parse(fmt.Sprintf("%s/%s", azureStorage.storageURL, azureStorage.containerName))
-
-	containerURL := azblob.NewContainerURL(*cURL, p)
-	return p, containerURL
+	return azureStorage.pipeline, azureStorage.containerURL
 }
 
 func (azureStorage *tAzureFileStorage) getBlobURL(fileName string) (pipeline.Pipeline, azblob.BlockBlobURL) {
@@ -198,66 +270,104 @@ func (azureStorage *tAzureFileStorage) getBlobURL(fileName string) (pipeline.Pip
 }
 
 func (azureStorage *tAzureFileStorage) FileSize(fileName string) (int64, error) {
-	azureStorage.LogIn()
-	defer azureStorage.LogOut()
+	return azureStorage.FileSizeContext(context.Background(), fileName)
+}
 
+func (azureStorage *tAzureFileStorage) FileSizeContext(ctx context.Context, fileName string) (int64, error) {
+	if err := common.ValidatePath(fileName); err != nil {
+		return 0, err
+	}
 	_, blobURL := azureStorage.getBlobURL(fileName)
-	ctx := context.Background()
 
 	property, err := blobURL.GetProperties(ctx, azblob.BlobAccessConditions{}, azblob.ClientProvidedKeyOptions{})
 
 	if err != nil {
-		fmt.Println("Unable to read blob property")
-		return 0, err
+		return 0, convertError("stat", fileName, err)
 	}
 	return property.ContentLength(), nil
 }
 
-func (azureStorage *tAzureFileStorage) Write(fileName string, fileSize int64, reader io.ReadSeeker) error {
-	azureStorage.LogIn()
-	defer azureStorage.LogOut()
+func (azureStorage *tAzureFileStorage) Stat(fileName string) (*storageabstraction.FileInfo, error) {
+	return azureStorage.StatContext(context.Background(), fileName)
+}
 
-	// p := azblob.NewPipeline(azureStorage.credential, azblob.PipelineOptions{})
+// StatContext returns the properties of the blob. If there is no blob with this name,
+// but blobs with the name as prefix, it is reported as directory
+func (azureStorage *tAzureFileStorage) StatContext(ctx context.Context, fileName string) (*storageabstraction.FileInfo, error) {
+	if err := common.ValidatePath(fileName); err != nil {
+		return nil, err
+	}
+	_, containerURL := azureStorage.getContainerURL()
+	blobURL := containerURL.NewBlockBlobURL(fileName)
 
-	// // From the Azure portal, get your Storage account blob service URL endpoint.
-	// cURL, _ := url.Parse(fmt.Sprintf("https://%s.blob.core.windows.net/%s", azureStorage.accountName, azureStorage.containerName))
+	property, err := blobURL.GetProperties(ctx, azblob.BlobAccessConditions{}, azblob.ClientProvidedKeyOptions{})
+	if err == nil {
+		return storageabstraction.NewFileInfoFromDetails(storageabstraction.FileDetails{
+			Name:            path.Base(fileName),
+			Size:            property.ContentLength(),
+			ModTime:         property.LastModified(),
+			ContentType:     property.ContentType(),
+			ContentEncoding: property.ContentEncoding(),
+			ETag:            string(property.ETag()),
+			ContentMD5:      property.ContentMD5(),
+		}), nil
+	} else if err = convertError("stat", fileName, err); !errors.Is(err, storageabstraction.ErrNotExist) {
+		return nil, err
+	}
 
-	// containerURL := azblob.NewContainerURL(*cURL, p)
-	// blobURL := containerURL.NewBlockBlobURL(fileName)
+	// virtual directory
+	listBlob, err := containerURL.ListBlobsFlatSegment(ctx, azblob.Marker{}, azblob.ListBlobsSegmentOptions{
+		Prefix:     strings.TrimSuffix(fileName, "/") + "/",
+		MaxResults: 1,
+	})
+	if err != nil {
+		return nil, convertError("stat", fileName, err)
+	}
+	if len(listBlob.Segment.BlobItems) == 0 {
+		return nil, storageabstraction.NewPathError("stat", fileName, storageabstraction.ErrNotExist, nil)
+	}
 
-	_, blobURL := azureStorage.getBlobURL(fileName)
+	return storageabstraction.NewFileInfoFromDetails(storageabstraction.FileDetails{
+		Name:  path.Base(fileName),
+		IsDir: true,
+	}), nil
+}
 
-	ctx := context.Background()
+// convertError wraps the storage errors with the matching storageabstraction error
+func convertError(op string, fileName string, err error) error {
+	var storageError azblob.StorageError
+	if !errors.As(err, &storageError) || storageError.Response() == nil {
+		return err
+	}
 
-	// Wrap the request body in a RequestBodyProgress and pass a callback function for progress reporting.
-	_, err := blobURL.Upload(ctx, reader,
-		azblob.BlobHTTPHeaders{
-			ContentType:        "text/html; charset=utf-8",
-			ContentDisposition: "attachment",
-		}, azblob.Metadata{
-			"createdby": "",
-		}, azblob.BlobAccessConditions{}, azblob.AccessTierHot, azblob.BlobTagsMap{}, azblob.ClientProvidedKeyOptions{}, azblob.ImmutabilityPolicyOptions{})
-	if err != nil {
-		log.Fatal(err)
+	switch storageError.Response().StatusCode {
+	case http.StatusNotFound:
+		return storageabstraction.NewPathError(op, fileName, storageabstraction.ErrNotExist, err)
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return storageabstraction.NewPathError(op, fileName, storageabstraction.ErrPermission, err)
+	case http.StatusConflict:
+		switch storageError.ServiceCode() {
+		case azblob.ServiceCodeBlobAlreadyExists, azblob.ServiceCodeContainerAlreadyExists:
+			return storageabstraction.NewPathError(op, fileName, storageabstraction.ErrAlreadyExists, err)
+		}
+		return storageabstraction.NewPathError(op, fileName, storageabstraction.ErrConflict, err)
+	case http.StatusPreconditionFailed:
+		return storageabstraction.NewPathError(op, fileName, storageabstraction.ErrPreconditionFailed, err)
+	case http.StatusRequestTimeout, http.StatusTooManyRequests, http.StatusInternalServerError,
+		http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return storageabstraction.NewPathError(op, fileName, storageabstraction.ErrUnavailable, err)
 	}
-	return nil
+	return err
 }
 
-func (azureStorage *tAzureFileStorage) Join(paths ...string) string {
-	return common.LinuxPathJoin(paths...)
+func (azureStorage *tAzureFileStorage) Write(fileName string, fileSize int64, reader io.ReadSeeker) error {
+	return azureStorage.WriteContext(context.Background(), fileName, fileSize, reader)
 }
 
-func (azureStorage *tAzureFileStorage) LogOut() error {
-	azureStorage.loginLock.Lock()
-	defer azureStorage.loginLock.Unlock()
-
-	azureStorage.loginCount--
-	if azureStorage.loginCount == 0 {
-		azureStorage.credential = nil
-	} else if azureStorage.loginCount < 0 {
-		azureStorage.loginCount = 0
-		return errors.New("there was an unexpectd logout somewhere. ")
-	}
+func (azureStorage *tAzureFileStorage) WriteContext(ctx context.Context, fileName string, fileSize int64, reader io.ReadSeeker) error {
+	return azureStorage.WriteWithOptionsContext(ctx, fileName, fileSize, reader, storageabstraction.WriteOptions{})
+}
 
-	return nil
+func (azureStorage *tAzureFileStorage) Join(paths ...string) string {
+	return common.LinuxPathJoin(paths...)
 }
@@ -0,0 +1,1050 @@
+package azureblobs
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"github.com/2flow/gokies/compression"
+	"github.com/2flow/gokies/storageabstraction"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-storage-blob-go/azblob"
+)
+
+func TestParseConnectionString(t *testing.T) {
+	tests := []struct {
+		connectionString string
+		expected         connectionSettings
+	}{
+		{
+			connectionString: "DefaultEndpointsProtocol=https;AccountName=name;AccountKey=a2V5==;EndpointSuffix=core.windows.net",
+			expected:         connectionSettings{blobEndpoint: "https://name.blob.core.windows.net", accountName: "name", accountKey: "a2V5=="},
+		},
+		{
+			connectionString: "AccountName=name;AccountKey=a2V5;EndpointSuffix=core.chinacloudapi.cn;",
+			expected:         connectionSettings{blobEndpoint: "https://name.blob.core.chinacloudapi.cn", accountName: "name", accountKey: "a2V5"},
+		},
+		{
+			connectionString: "BlobEndpoint=https://name.blob.core.windows.net/;SharedAccessSignature=?sv=2020-08-04&sig=abc%3D",
+			expected:         connectionSettings{blobEndpoint: "https://name.blob.core.windows.net/", sharedAccessSignature: "sv=2020-08-04&sig=abc%3D"},
+		},
+		{
+			connectionString: "UseDevelopmentStorage=true",
+			expected: connectionSettings{blobEndpoint: developmentBlobEndpoint, accountName: developmentAccountName,
+				accountKey: developmentAccountKey},
+		},
+	}
+
+	for _, test := range tests {
+		settings, err := parseConnectionString(test.connectionString)
+		if err != nil {
+			t.Errorf("Error parsing %q: %v", test.connectionString, err)
+			continue
+		}
+		if settings != test.expected {
+			t.Errorf("Unexpected settings of %q, expected: %+v, actual: %+v", test.connectionString, test.expected, settings)
+		}
+	}
+
+	for _, invalid := range []string{"", "AccountName=name", "BlobEndpoint=https://name.blob.core.windows.net", "AccountName"} {
+		if _, err := parseConnectionString(invalid); err == nil {
+			t.Errorf("Expected error for connection string %q", invalid)
+		}
+	}
+}
+
+// newTestServer answers all requests as the properties of a blob with 4 bytes and records the last request
+func newTestServer(t *testing.T) (*httptest.Server, func() *http.Request) {
+	var lock sync.Mutex
+	var lastRequest *http.Request
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		lastRequest = r
+		lock.Unlock()
+
+		w.Header().Set("Content-Length", "4")
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(server.Close)
+
+	return server, func() *http.Request {
+		lock.Lock()
+		defer lock.Unlock()
+		return lastRequest
+	}
+}
+
+func TestAzureStorageRetryOptions(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("x-ms-error-code", "ServerBusy")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(server.Close)
+
+	storage := NewAzureStorageFromUrl(server.URL+"/devstoreaccount1", "container", developmentAccountName, developmentAccountKey,
+		WithRetryOptions(azblob.RetryOptions{MaxTries: 2, RetryDelay: time.Millisecond, MaxRetryDelay: time.Millisecond}))
+	if _, err := storage.Read("test.txt"); !errors.Is(err, storageabstraction.ErrUnavailable) || requests.Load() != 2 {
+		t.Errorf("Expected unavailable error after 2 tries, actual: %v, requests: %d", err, requests.Load())
+	}
+}
+
+func TestAzureStorageCredentials(t *testing.T) {
+	server, lastRequest := newTestServer(t)
+
+	storage, err := NewAzureStorageFromConnectionString("BlobEndpoint="+server.URL+"/devstoreaccount1;AccountName=devstoreaccount1;AccountKey="+developmentAccountKey, "container")
+	if err != nil {
+		t.Errorf("[TestError] Error creating storage: %v", err)
+		return
+	}
+	if size, err := storage.FileSize("dir/test.txt"); err != nil || size != 4 {
+		t.Errorf("Unexpected file size: %v, %v", size, err)
+	}
+	request := lastRequest()
+	if request.URL.Path != "/devstoreaccount1/container/dir/test.txt" {
+		t.Errorf("Unexpected path: %s", request.URL.Path)
+	}
+	if !strings.HasPrefix(request.Header.Get("Authorization"), "SharedKey devstoreaccount1:") {
+		t.Errorf("Request is not signed with the shared key: %q", request.Header.Get("Authorization"))
+	}
+
+	storage, err = NewAzureStorageFromSASURL(server.URL+"/devstoreaccount1/container?sv=2020-08-04&sp=r&sig=abc%3D", "")
+	if err != nil {
+		t.Errorf("[TestError] Error creating storage: %v", err)
+		return
+	}
+	if _, err = storage.FileSize("test.txt"); err != nil {
+		t.Errorf("Error reading file size: %v", err)
+	}
+	request = lastRequest()
+	if request.URL.Path != "/devstoreaccount1/container/test.txt" {
+		t.Errorf("Unexpected path: %s", request.URL.Path)
+	}
+	if request.URL.Query().Get("sig") != "abc=" || request.Header.Get("Authorization") != "" {
+		t.Errorf("Request does not use the SAS token: %s, %q", request.URL.RawQuery, request.Header.Get("Authorization"))
+	}
+
+	// an invalid key is not replaced by an anonymous credential
+	storage = NewAzureStorageFromUrl(server.URL+"/devstoreaccount1", "container", developmentAccountName, "no base64!")
+	if _, err = storage.FileSize("test.txt"); err == nil {
+		t.Errorf("Expected the error of the invalid key")
+	}
+	if _, err = storage.(IContainerManager).ContainerExists(); err == nil {
+		t.Errorf("Expected the error of the invalid key for the container")
+	}
+	if _, err = NewAzureStorageWithCredential(server.URL+"/devstoreaccount1", "container",
+		SharedKeyCredential(developmentAccountName, "no base64!")); err == nil {
+		t.Errorf("Expected the error of the invalid key on creation")
+	}
+	request = lastRequest()
+	if request.URL.Path != "/devstoreaccount1/container/test.txt" || request.URL.Query().Get("sig") != "abc=" {
+		t.Errorf("Expected no request with the invalid key, actual: %s", request.URL)
+	}
+
+	if _, err = NewAzureStorageFromSASURL(server.URL+"/devstoreaccount1/container?sv=2020-08-04&sig=abc", "other"); err == nil {
+		t.Errorf("Expected error for a different container")
+	}
+	if _, err = NewAzureStorageFromSASURL(server.URL+"/devstoreaccount1/container", ""); err == nil {
+		t.Errorf("Expected error for a url without SAS token")
+	}
+}
+
+func TestAzureStorageWriteOptions(t *testing.T) {
+	server, lastRequest := newTestServer(t)
+	storage := NewAzureStorageFromUrl(server.URL+"/devstoreaccount1", "container", developmentAccountName, developmentAccountKey)
+
+	options := storageabstraction.WriteOptions{
+		CacheControl: "max-age=3600",
+		Metadata:     map[string]string{"author": "test"},
+		Tags:         map[string]string{"project": "gokies"},
+		AccessTier:   storageabstraction.AccessTierCool,
+	}
+	if err := storageabstraction.WriteWithOptions(storage, "site/app.css", 4, strings.NewReader("body"), options); err != nil {
+		t.Errorf("Error writing file: %v", err)
+		return
+	}
+	expectHeaders(t, lastRequest(), map[string]string{"X-Ms-Blob-Content-Type": storageabstraction.ContentTypeByName("app.css"),
+		"X-Ms-Blob-Cache-Control": "max-age=3600", "X-Ms-Meta-Author": "test", "X-Ms-Tags": "project=gokies",
+		"X-Ms-Access-Tier": "Cool", "X-Ms-Blob-Content-Disposition": ""})
+
+	if err := storage.Write("site/page", 15, strings.NewReader("<html></html>\n\n")); err != nil {
+		t.Errorf("Error writing file: %v", err)
+		return
+	}
+	expectHeaders(t, lastRequest(), map[string]string{"X-Ms-Blob-Content-Type": "text/html; charset=utf-8",
+		"X-Ms-Access-Tier": "Hot", "X-Ms-Meta-Createdby": ""})
+
+	// the content type of streamed blobs is detected from the first block
+	if err := storageabstraction.WriteFrom(storage, "site/page", strings.NewReader("<html></html>")); err != nil {
+		t.Errorf("Error writing file: %v", err)
+		return
+	}
+	request := lastRequest()
+	if request.URL.Query().Get("comp") != "blocklist" {
+		t.Errorf("Unexpected last request: %s", request.URL)
+	}
+	expectHeaders(t, request, map[string]string{"X-Ms-Blob-Content-Type": "text/html; charset=utf-8"})
+}
+
+func expectHeaders(t *testing.T, request *http.Request, headers map[string]string) {
+	for name, value := range headers {
+		if actual := request.Header.Get(name); actual != value {
+			t.Errorf("Unexpected header %s, expected: %q, actual: %q", name, value, actual)
+		}
+	}
+}
+
+// fakeBlobServer is a minimal in memory stand-in for the blob service, it supports just the requests of
+// the block upload and the ranged download and does not verify signatures
+type fakeBlobServer struct {
+	lock sync.Mutex
+	// containers are the public access levels of the containers, the blobs are all in "container"
+	containers map[string]string
+	blobs      map[string][]byte
+	blocks     map[string][]byte
+	requests   map[string]int
+	// leases are the lease ids of the leased blobs
+	leases map[string]string
+	// snapshots are the snapshots of the blobs oldest first, deleted are the soft-deleted blobs
+	snapshots map[string][]fakeSnapshot
+	deleted   map[string][]byte
+	inFlight  int
+	// maxInFlight is the maximum of parallel block requests
+	maxInFlight int
+}
+
+func newFakeBlobStorage(t *testing.T, options ...Option) (storageabstraction.IFileStorage, *fakeBlobServer) {
+	fake := &fakeBlobServer{containers: map[string]string{"container": ""}, blobs: map[string][]byte{},
+		blocks: map[string][]byte{}, requests: map[string]int{}, leases: map[string]string{},
+		snapshots: map[string][]fakeSnapshot{}, deleted: map[string][]byte{}}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	return NewAzureStorageFromUrl(server.URL+"/devstoreaccount1", "container", developmentAccountName, developmentAccountKey, options...), fake
+}
+
+func (fake *fakeBlobServer) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	name := strings.TrimPrefix(request.URL.Path, "/devstoreaccount1/container/")
+	query := request.URL.Query()
+	body, _ := io.ReadAll(request.Body)
+
+	fake.lock.Lock()
+	defer fake.lock.Unlock()
+
+	if query.Get("restype") == "container" || query.Get("comp") == "list" {
+		fake.serveContainer(writer, request)
+		return
+	}
+	if _, ok := fake.containers["container"]; !ok {
+		writer.Header().Set("x-ms-error-code", "ContainerNotFound")
+		writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if request.Method == http.MethodPut && query.Get("comp") == "lease" {
+		fake.lease(writer, request, name)
+		return
+	}
+	if request.Method == http.MethodPut && (query.Get("comp") == "snapshot" || query.Get("comp") == "undelete") {
+		fake.snapshot(writer, query.Get("comp"), name)
+		return
+	}
+	if (request.Method == http.MethodPut && query.Get("comp") != "block") || request.Method == http.MethodDelete {
+		if !fake.checkConditions(writer, request, name) {
+			return
+		}
+	}
+
+	switch {
+	case request.Method == http.MethodPut && query.Get("comp") == "block":
+		fake.requests["stageBlock"]++
+		fake.slowRequest()
+		fake.blocks[query.Get("blockid")] = body
+		writer.WriteHeader(http.StatusCreated)
+	case request.Method == http.MethodPut && query.Get("comp") == "blocklist":
+		fake.requests["commit"]++
+		var blockList struct {
+			Latest []string `xml:"Latest"`
+		}
+		_ = xml.Unmarshal(body, &blockList)
+		content := bytes.Buffer{}
+		for _, blockID := range blockList.Latest {
+			content.Write(fake.blocks[blockID])
+		}
+		fake.blobs[name] = content.Bytes()
+		writer.WriteHeader(http.StatusCreated)
+	case request.Method == http.MethodDelete:
+		fake.requests["delete"]++
+		if content, exists := fake.blobs[name]; exists {
+			fake.deleted[name] = content
+		}
+		delete(fake.blobs, name)
+		writer.WriteHeader(http.StatusAccepted)
+	case request.Method == http.MethodPut && request.Header.Get("x-ms-copy-source") != "":
+		fake.requests["copy"]++
+		source, _ := url.Parse(request.Header.Get("x-ms-copy-source"))
+		content, ok := fake.snapshotContent(strings.TrimPrefix(source.Path, "/devstoreaccount1/container/"), source.Query().Get("snapshot"))
+		if !ok {
+			writer.Header().Set("x-ms-error-code", "CannotVerifyCopySource")
+			writer.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fake.blobs[name] = content
+		writer.Header().Set("x-ms-copy-status", "success")
+		writer.WriteHeader(http.StatusAccepted)
+	case request.Method == http.MethodPut:
+		fake.requests["upload"]++
+		fake.blobs[name] = body
+		writer.WriteHeader(http.StatusCreated)
+	case request.Method == http.MethodHead:
+		content, ok := fake.blobs[name]
+		if !ok {
+			writer.Header().Set("x-ms-error-code", "BlobNotFound")
+			writer.WriteHeader(http.StatusNotFound)
+			return
+		}
+		writer.Header().Set("ETag", blobETag(content))
+		writer.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		writer.WriteHeader(http.StatusOK)
+	case request.Method == http.MethodGet:
+		fake.requests["download"]++
+		content, ok := fake.blobs[name]
+		if !ok {
+			writer.Header().Set("x-ms-error-code", "BlobNotFound")
+			writer.WriteHeader(http.StatusNotFound)
+			return
+		}
+		etag := blobETag(content)
+		if ifMatch := request.Header.Get("If-Match"); ifMatch != "" && ifMatch != etag {
+			writer.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		writer.Header().Set("ETag", etag)
+
+		byteRange := request.Header.Get("x-ms-range")
+		if byteRange == "" {
+			writer.Header().Set("Content-Length", strconv.Itoa(len(content)))
+			writer.WriteHeader(http.StatusOK)
+			_, _ = writer.Write(content)
+			return
+		}
+		startText, endText, _ := strings.Cut(strings.TrimPrefix(byteRange, "bytes="), "-")
+		start, _ := strconv.Atoi(startText)
+		end, err := strconv.Atoi(endText)
+		if start >= len(content) {
+			writer.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		if err != nil || end >= len(content) {
+			end = len(content) - 1
+		}
+		fake.slowRequest()
+		writer.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(content)))
+		writer.Header().Set("Content-Length", strconv.Itoa(end-start+1))
+		writer.WriteHeader(http.StatusPartialContent)
+		_, _ = writer.Write(content[start : end+1])
+	default:
+		writer.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+// blobETag changes with the length of the content, which is enough for the tests
+func blobETag(content []byte) string {
+	return fmt.Sprintf("\"%d\"", len(content))
+}
+
+// checkConditions answers the request with the error of the service, if the lease or a condition does not match
+func (fake *fakeBlobServer) checkConditions(writer http.ResponseWriter, request *http.Request, name string) bool {
+	content, exists := fake.blobs[name]
+	ifMatch := request.Header.Get("If-Match")
+	ifNoneMatch := request.Header.Get("If-None-Match")
+
+	switch {
+	case fake.leases[name] != "" && request.Header.Get("x-ms-lease-id") != fake.leases[name]:
+		writer.Header().Set("x-ms-error-code", "LeaseIdMissing")
+		writer.WriteHeader(http.StatusPreconditionFailed)
+	case ifNoneMatch == "*" && exists:
+		writer.Header().Set("x-ms-error-code", "BlobAlreadyExists")
+		writer.WriteHeader(http.StatusConflict)
+	case ifMatch != "" && (!exists || (ifMatch != "*" && ifMatch != blobETag(content))),
+		ifNoneMatch != "" && exists && ifNoneMatch == blobETag(content):
+		writer.Header().Set("x-ms-error-code", "ConditionNotMet")
+		writer.WriteHeader(http.StatusPreconditionFailed)
+	default:
+		return true
+	}
+	return false
+}
+
+// lease acquires, renews and releases the leases, they do not expire
+func (fake *fakeBlobServer) lease(writer http.ResponseWriter, request *http.Request, name string) {
+	action := request.Header.Get("x-ms-lease-action")
+	leaseID := fake.leases[name]
+	fake.requests[action+"Lease"]++
+
+	switch action {
+	case "acquire":
+		if _, exists := fake.blobs[name]; !exists {
+			writer.Header().Set("x-ms-error-code", "BlobNotFound")
+			writer.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if leaseID != "" {
+			writer.Header().Set("x-ms-error-code", "LeaseAlreadyPresent")
+			writer.WriteHeader(http.StatusConflict)
+			return
+		}
+		fake.leases[name] = request.Header.Get("x-ms-proposed-lease-id")
+		writer.Header().Set("x-ms-lease-id", fake.leases[name])
+		writer.WriteHeader(http.StatusCreated)
+	case "renew", "release":
+		if leaseID == "" || leaseID != request.Header.Get("x-ms-lease-id") {
+			writer.Header().Set("x-ms-error-code", "LeaseIdMismatchWithLeaseOperation")
+			writer.WriteHeader(http.StatusConflict)
+			return
+		}
+		if action == "release" {
+			delete(fake.leases, name)
+		}
+		writer.WriteHeader(http.StatusOK)
+	default:
+		writer.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+type fakeSnapshot struct {
+	id      string
+	content []byte
+}
+
+// snapshot creates the snapshots and restores the soft-deleted blobs, the lock is held by ServeHTTP
+func (fake *fakeBlobServer) snapshot(writer http.ResponseWriter, comp string, name string) {
+	fake.requests[comp]++
+	content, exists := fake.blobs[name]
+	deletedContent, deleted := fake.deleted[name]
+
+	switch {
+	case comp == "undelete" && (exists || deleted):
+		if deleted {
+			fake.blobs[name] = deletedContent
+			delete(fake.deleted, name)
+		}
+		writer.WriteHeader(http.StatusOK)
+	case comp == "snapshot" && exists:
+		// the snapshot ids must be unique, even if the snapshots are created within the same tick
+		created := time.Now().UTC().Add(time.Duration(fake.requests[comp]) * time.Microsecond)
+		snapshot := fakeSnapshot{id: created.Format("2006-01-02T15:04:05.0000000Z"), content: content}
+		fake.snapshots[name] = append(fake.snapshots[name], snapshot)
+		writer.Header().Set("x-ms-snapshot", snapshot.id)
+		writer.WriteHeader(http.StatusCreated)
+	default:
+		writer.Header().Set("x-ms-error-code", "BlobNotFound")
+		writer.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// snapshotContent returns the content of the snapshot, or of the blob without snapshot id
+func (fake *fakeBlobServer) snapshotContent(name string, id string) ([]byte, bool) {
+	if id == "" {
+		content, ok := fake.blobs[name]
+		return content, ok
+	}
+	if _, deleted := fake.deleted[name]; deleted {
+		return nil, false
+	}
+	for _, snapshot := range fake.snapshots[name] {
+		if snapshot.id == id {
+			return snapshot.content, true
+		}
+	}
+	return nil, false
+}
+
+// serveContainer handles the requests of the containers and of the account, the lock is held by ServeHTTP
+func (fake *fakeBlobServer) serveContainer(writer http.ResponseWriter, request *http.Request) {
+	containerName := strings.Trim(strings.TrimPrefix(request.URL.Path, "/devstoreaccount1"), "/")
+	query := request.URL.Query()
+	_, exists := fake.containers[containerName]
+
+	switch {
+	case query.Get("restype") == "" && query.Get("comp") == "list":
+		fake.requests["listContainers"]++
+		fake.listContainers(writer, query.Get("prefix"))
+		return
+	case !exists && request.Method != http.MethodPut:
+		writer.Header().Set("x-ms-error-code", "ContainerNotFound")
+		writer.WriteHeader(http.StatusNotFound)
+	case request.Method == http.MethodGet && query.Get("comp") == "list":
+		fake.requests["list"]++
+		fake.listBlobs(writer, query.Get("prefix"), query.Get("delimiter"), query.Get("include"))
+	case request.Method == http.MethodPut && query.Get("comp") == "acl":
+		fake.containers[containerName] = request.Header.Get("x-ms-blob-public-access")
+		writer.WriteHeader(http.StatusOK)
+	case request.Method == http.MethodPut:
+		fake.requests["createContainer"]++
+		if exists {
+			writer.Header().Set("x-ms-error-code", "ContainerAlreadyExists")
+			writer.WriteHeader(http.StatusConflict)
+			return
+		}
+		fake.containers[containerName] = request.Header.Get("x-ms-blob-public-access")
+		writer.WriteHeader(http.StatusCreated)
+	case request.Method == http.MethodDelete:
+		delete(fake.containers, containerName)
+		writer.WriteHeader(http.StatusAccepted)
+	default:
+		writer.WriteHeader(http.StatusOK)
+	}
+}
+
+func (fake *fakeBlobServer) listContainers(writer http.ResponseWriter, prefix string) {
+	type container struct {
+		Name string
+	}
+	result := struct {
+		XMLName    xml.Name    `xml:"EnumerationResults"`
+		Containers []container `xml:"Containers>Container"`
+		NextMarker string      `xml:"NextMarker"`
+	}{}
+	for name := range fake.containers {
+		if strings.HasPrefix(name, prefix) {
+			result.Containers = append(result.Containers, container{Name: name})
+		}
+	}
+
+	content, _ := xml.Marshal(result)
+	writer.Header().Set("Content-Type", "application/xml")
+	writer.WriteHeader(http.StatusOK)
+	_, _ = writer.Write(content)
+}
+
+// listBlobs returns all blobs of the prefix in a single segment, with the delimiter the blob names are
+// grouped by the next delimiter like the blob service does. The snapshots and the deleted blobs are only
+// listed flat
+func (fake *fakeBlobServer) listBlobs(writer http.ResponseWriter, prefix string, delimiter string, include string) {
+	type properties struct {
+		ContentLength int    `xml:"Content-Length"`
+		LastModified  string `xml:"Last-Modified"`
+		DeletedTime   string `xml:"DeletedTime,omitempty"`
+	}
+	type blob struct {
+		Name       string
+		Deleted    bool   `xml:"Deleted,omitempty"`
+		Snapshot   string `xml:"Snapshot,omitempty"`
+		Properties properties
+	}
+	type blobPrefix struct {
+		Name string
+	}
+	result := struct {
+		XMLName  xml.Name     `xml:"EnumerationResults"`
+		Prefix   string       `xml:"Prefix"`
+		Prefixes []blobPrefix `xml:"Blobs>BlobPrefix"`
+		Blobs    []blob       `xml:"Blobs>Blob"`
+		// an empty next marker ends the listing
+		NextMarker string `xml:"NextMarker"`
+	}{Prefix: prefix}
+
+	prefixes := map[string]bool{}
+	for name, content := range fake.blobs {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		if index := strings.Index(name[len(prefix):], delimiter); delimiter != "" && index >= 0 {
+			prefixes[name[:len(prefix)+index+1]] = true
+			continue
+		}
+		result.Blobs = append(result.Blobs, blob{Name: name,
+			Properties: properties{ContentLength: len(content), LastModified: time.Now().UTC().Format(http.TimeFormat)}})
+	}
+	for name := range prefixes {
+		result.Prefixes = append(result.Prefixes, blobPrefix{Name: name})
+	}
+	now := time.Now().UTC().Format(http.TimeFormat)
+	for name, snapshots := range fake.snapshots {
+		_, deleted := fake.deleted[name]
+		for _, snapshot := range snapshots {
+			if strings.HasPrefix(name, prefix) && strings.Contains(include, "snapshots") && (!deleted || strings.Contains(include, "deleted")) {
+				result.Blobs = append(result.Blobs, blob{Name: name, Snapshot: snapshot.id, Deleted: deleted,
+					Properties: properties{ContentLength: len(snapshot.content), LastModified: now}})
+			}
+		}
+	}
+	for name, content := range fake.deleted {
+		if strings.HasPrefix(name, prefix) && strings.Contains(include, "deleted") {
+			result.Blobs = append(result.Blobs, blob{Name: name, Deleted: true,
+				Properties: properties{ContentLength: len(content), LastModified: now, DeletedTime: now}})
+		}
+	}
+
+	content, _ := xml.Marshal(result)
+	writer.Header().Set("Content-Type", "application/xml")
+	writer.WriteHeader(http.StatusOK)
+	_, _ = writer.Write(content)
+}
+
+// slowRequest lets the request take some time without holding the lock, so parallel requests overlap
+func (fake *fakeBlobServer) slowRequest() {
+	fake.inFlight++
+	fake.maxInFlight = max(fake.maxInFlight, fake.inFlight)
+	fake.lock.Unlock()
+	time.Sleep(5 * time.Millisecond)
+	fake.lock.Lock()
+	fake.inFlight--
+}
+
+func TestAzureStorageParallelBlocks(t *testing.T) {
+	storage, fake := newFakeBlobStorage(t, WithBlockSize(1000), WithConcurrency(3))
+
+	content := make([]byte, 10500)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+	if err := storage.Write("backup.tar.gz", int64(len(content)), bytes.NewReader(content)); err != nil {
+		t.Errorf("Error writing file: %v", err)
+		return
+	}
+	if fake.requests["stageBlock"] != 11 || fake.requests["commit"] != 1 || fake.requests["upload"] != 0 {
+		t.Errorf("Unexpected upload requests: %v", fake.requests)
+	}
+	if fake.maxInFlight < 2 || fake.maxInFlight > 3 {
+		t.Errorf("Unexpected number of parallel uploads: %d", fake.maxInFlight)
+	}
+	if !bytes.Equal(fake.blobs["backup.tar.gz"], content) {
+		t.Errorf("Uploaded content differs")
+	}
+
+	fake.maxInFlight = 0
+	reader, err := storage.Read("backup.tar.gz")
+	if err != nil {
+		t.Errorf("Error reading file: %v", err)
+		return
+	}
+	downloaded, err := io.ReadAll(reader)
+	reader.Close()
+	if err != nil || !bytes.Equal(downloaded, content) {
+		t.Errorf("Downloaded content differs: %d bytes, %v", len(downloaded), err)
+	}
+	if fake.requests["download"] != 11 {
+		t.Errorf("Unexpected number of downloads: %d", fake.requests["download"])
+	}
+	if fake.maxInFlight < 2 || fake.maxInFlight > 3 {
+		t.Errorf("Unexpected number of parallel downloads: %d", fake.maxInFlight)
+	}
+
+	reader, err = storageabstraction.ReadRange(storage, "backup.tar.gz", 1500, 5000)
+	if err != nil {
+		t.Errorf("Error reading range: %v", err)
+		return
+	}
+	downloaded, err = io.ReadAll(reader)
+	reader.Close()
+	if err != nil || !bytes.Equal(downloaded, content[1500:6500]) {
+		t.Errorf("Downloaded range differs: %d bytes, %v", len(downloaded), err)
+	}
+
+	// small and empty blobs are uploaded and downloaded with a single request
+	for _, small := range [][]byte{content[:500], {}} {
+		if err = storage.Write("small.txt", int64(len(small)), bytes.NewReader(small)); err != nil {
+			t.Errorf("Error writing file: %v", err)
+			return
+		}
+		reader, err = storage.Read("small.txt")
+		if err != nil {
+			t.Errorf("Error reading file: %v", err)
+			return
+		}
+		downloaded, err = io.ReadAll(reader)
+		reader.Close()
+		if err != nil || !bytes.Equal(downloaded, small) {
+			t.Errorf("Downloaded content differs: %d bytes, %v", len(downloaded), err)
+		}
+	}
+	if _, err = storage.Read("missing.txt"); !errors.Is(err, storageabstraction.ErrNotExist) {
+		t.Errorf("Expected not exist error, actual: %v", err)
+	}
+}
+
+func TestAzureStorageReadChangedBlob(t *testing.T) {
+	storage, fake := newFakeBlobStorage(t, WithBlockSize(1000), WithConcurrency(2))
+	fake.blobs["file.bin"] = make([]byte, 5000)
+
+	reader, err := storage.Read("file.bin")
+	if err != nil {
+		t.Errorf("Error reading file: %v", err)
+		return
+	}
+	defer reader.Close()
+
+	fake.lock.Lock()
+	fake.blobs["file.bin"] = make([]byte, 6000)
+	fake.lock.Unlock()
+
+	if _, err = io.ReadAll(reader); !errors.Is(err, storageabstraction.ErrConflict) {
+		t.Errorf("Expected error for a changed blob, actual: %v", err)
+	}
+}
+
+type testTokenCredential struct {
+	lock   sync.Mutex
+	tokens []azcore.AccessToken
+	scopes []string
+}
+
+func (credential *testTokenCredential) GetToken(_ context.Context, options policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	credential.lock.Lock()
+	defer credential.lock.Unlock()
+
+	credential.scopes = options.Scopes
+	if len(credential.tokens) == 0 {
+		return azcore.AccessToken{}, errors.New("no token")
+	}
+	token := credential.tokens[0]
+	credential.tokens = credential.tokens[1:]
+	return token, nil
+}
+
+func TestTokenCredentialRefresh(t *testing.T) {
+	tokenCredential := &testTokenCredential{tokens: []azcore.AccessToken{
+		{Token: "first", ExpiresOn: time.Now().Add(tokenRefreshMargin + 50*time.Millisecond)},
+		{Token: "second", ExpiresOn: time.Now().Add(time.Hour)},
+	}}
+
+	credential, err := TokenCredential(tokenCredential)(nil)
+	if err != nil {
+		t.Errorf("[TestError] Error creating credential: %v", err)
+		return
+	}
+	blobCredential := credential.(azblob.TokenCredential)
+	if blobCredential.Token() != "first" {
+		t.Errorf("Unexpected initial token: %s", blobCredential.Token())
+	}
+	tokenCredential.lock.Lock()
+	if len(tokenCredential.scopes) != 1 || tokenCredential.scopes[0] != storageScope {
+		t.Errorf("Unexpected scopes: %v", tokenCredential.scopes)
+	}
+	tokenCredential.lock.Unlock()
+
+	for deadline := time.Now().Add(5 * time.Second); blobCredential.Token() != "second" && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if blobCredential.Token() != "second" {
+		t.Errorf("Token was not refreshed: %s", blobCredential.Token())
+	}
+
+	if _, err = TokenCredential(&testTokenCredential{})(nil); err == nil {
+		t.Errorf("Expected error if no token is available")
+	}
+}
+
+func TestAzureStorageWalk(t *testing.T) {
+	storage, fake := newFakeBlobStorage(t)
+	for _, fileName := range []string{"compressDir/test.txt", "compressDir/subDir/test3.txt", "other/test4.txt"} {
+		fake.blobs[fileName] = []byte(fileName)
+	}
+
+	for directory, expected := range map[string]string{
+		"":             ",compressDir,compressDir/subDir,compressDir/subDir/test3.txt,compressDir/test.txt,other,other/test4.txt",
+		"compressDir":  ",subDir,subDir/test3.txt,test.txt",
+		"compressDir/": ",subDir,subDir/test3.txt,test.txt",
+	} {
+		var walked []string
+		err := storage.Walk(directory, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() != (path == "" || !strings.HasSuffix(path, ".txt")) {
+				t.Errorf("Unexpected directory flag of %q: %v", path, info.IsDir())
+			}
+			walked = append(walked, path)
+			return nil
+		})
+		if err != nil {
+			t.Errorf("Error walking %q: %v", directory, err)
+		}
+		if strings.Join(walked, ",") != expected {
+			t.Errorf("Walk of %q failed, actual: %q", directory, walked)
+		}
+	}
+
+	entries, err := storageabstraction.List(storage, "compressDir")
+	if err != nil || len(entries) != 2 || entries[0].Name() != "subDir" || !entries[0].IsDir() ||
+		entries[1].Name() != "test.txt" || entries[1].Size() != int64(len("compressDir/test.txt")) {
+		t.Errorf("Unexpected entries: %v, %v", entries, err)
+	}
+	if _, err = storageabstraction.List(storage, "missing"); !errors.Is(err, storageabstraction.ErrNotExist) {
+		t.Errorf("Expected not exist error, actual: %v", err)
+	}
+	if info, err := storageabstraction.Stat(storage, "compressDir/subDir"); err != nil || !info.IsDir() {
+		t.Errorf("Expected the virtual directory, actual: %v, err: %v", info, err)
+	}
+	if info, err := storageabstraction.Stat(storage, "compressDir/missing.txt"); info != nil || !errors.Is(err, storageabstraction.ErrNotExist) {
+		t.Errorf("Expected not exist error for a missing blob, actual: %v, err: %v", info, err)
+	}
+	if exists, err := storageabstraction.Exists(storage, "compressDir/missing.txt"); exists || err != nil {
+		t.Errorf("Expected a missing blob not to exist, actual: %v, err: %v", exists, err)
+	}
+
+	archive := bytes.Buffer{}
+	if err = compression.NewCompression(storage).CompressDir("compressDir", &archive); err != nil {
+		t.Errorf("Error compressing dir: %v", err)
+		return
+	}
+	gzipReader, _ := gzip.NewReader(&archive)
+	tarReader := tar.NewReader(gzipReader)
+	var archived []string
+	for header, err := tarReader.Next(); err == nil; header, err = tarReader.Next() {
+		archived = append(archived, fmt.Sprintf("%s:%c", header.Name, header.Typeflag))
+	}
+	if strings.Join(archived, ",") != ":5,subDir:5,subDir/test3.txt:0,test.txt:0" {
+		t.Errorf("Unexpected archive entries: %q", archived)
+	}
+
+	if err = storage.DeleteDirectory("compressDir"); err != nil {
+		t.Errorf("Error deleting directory: %v", err)
+	}
+	if len(fake.blobs) != 1 || fake.blobs["other/test4.txt"] == nil {
+		t.Errorf("Expected only other/test4.txt to remain, actual: %d blobs", len(fake.blobs))
+	}
+	if err = storage.DeleteDirectory("compressDir"); err != nil {
+		t.Errorf("Error deleting missing directory: %v", err)
+	}
+}
+
+func TestAzureStorageCreateContainer(t *testing.T) {
+	storage, fake := newFakeBlobStorage(t, WithCreateContainer(PublicAccessBlob))
+	delete(fake.containers, "container")
+
+	for i := 0; i < 2; i++ {
+		if err := storage.Write("test.txt", 4, strings.NewReader("test")); err != nil {
+			t.Errorf("Error writing file: %v", err)
+			return
+		}
+	}
+	access, exists := fake.containers["container"]
+	if !exists || access != "blob" || fake.requests["createContainer"] != 1 {
+		t.Errorf("Container is not created once with blob access, exists: %v, access: %q, requests: %d",
+			exists, access, fake.requests["createContainer"])
+	}
+
+	manager := storage.(IContainerManager)
+	if err := manager.SetPublicAccess(PublicAccessNone); err != nil || fake.containers["container"] != "" {
+		t.Errorf("Error setting public access: %v, access: %q", err, fake.containers["container"])
+	}
+	containerNames, err := manager.ListContainers("cont")
+	if err != nil || strings.Join(containerNames, ",") != "container" {
+		t.Errorf("Unexpected containers: %v, %v", containerNames, err)
+	}
+
+	if err = manager.DeleteContainer(); err != nil {
+		t.Errorf("Error deleting container: %v", err)
+	}
+	if exists, err = manager.ContainerExists(); exists || err != nil {
+		t.Errorf("Deleted container exists: %v", err)
+	}
+	if _, err = storage.Read("test.txt"); !errors.Is(err, storageabstraction.ErrNotExist) {
+		t.Errorf("Expected not exist error, actual: %v", err)
+	}
+
+	if err = manager.CreateContainer(PublicAccessContainer); err != nil {
+		t.Errorf("Error creating container: %v", err)
+	}
+	if err = manager.CreateContainer(PublicAccessContainer); !errors.Is(err, storageabstraction.ErrAlreadyExists) {
+		t.Errorf("Expected already exists error, actual: %v", err)
+	}
+	if exists, err = manager.ContainerExists(); !exists || err != nil || fake.containers["container"] != "container" {
+		t.Errorf("Created container does not exist: %v", err)
+	}
+}
+
+func TestAzureStorageConditions(t *testing.T) {
+	storage, fake := newFakeBlobStorage(t, WithBlockSize(4))
+	createOnly := storageabstraction.WriteOptions{Conditions: storageabstraction.Conditions{IfNoneMatch: storageabstraction.ETagAny}}
+
+	if err := storageabstraction.WriteWithOptions(storage, "test.txt", 4, strings.NewReader("test"), createOnly); err != nil {
+		t.Errorf("Error creating file: %v", err)
+		return
+	}
+	err := storageabstraction.WriteWithOptions(storage, "test.txt", 4, strings.NewReader("next"), createOnly)
+	if !errors.Is(err, storageabstraction.ErrPreconditionFailed) || !errors.Is(err, storageabstraction.ErrConflict) {
+		t.Errorf("Expected precondition failed error for an existing file, actual: %v", err)
+	}
+
+	// the block upload checks the conditions on commit
+	outdated := storageabstraction.Conditions{IfMatch: blobETag([]byte("outdated"))}
+	err = storageabstraction.WriteWithOptions(storage, "test.txt", 10, strings.NewReader("new blocks"),
+		storageabstraction.WriteOptions{Conditions: outdated})
+	if !errors.Is(err, storageabstraction.ErrPreconditionFailed) || string(fake.blobs["test.txt"]) != "test" {
+		t.Errorf("Expected precondition failed error for an outdated ETag, actual: %v", err)
+	}
+	err = storageabstraction.WriteWithOptions(storage, "test.txt", 10, strings.NewReader("new blocks"),
+		storageabstraction.WriteOptions{Conditions: storageabstraction.Conditions{IfMatch: blobETag([]byte("test"))}})
+	if err != nil || string(fake.blobs["test.txt"]) != "new blocks" {
+		t.Errorf("Error replacing file with the current ETag: %v", err)
+	}
+
+	if err = storageabstraction.DeleteFileWithConditions(storage, "test.txt", outdated); !errors.Is(err, storageabstraction.ErrPreconditionFailed) {
+		t.Errorf("Expected precondition failed error for deleting with an outdated ETag, actual: %v", err)
+	}
+	err = storageabstraction.DeleteFileWithConditions(storage, "test.txt", storageabstraction.Conditions{IfMatch: blobETag([]byte("new blocks"))})
+	if _, exists := fake.blobs["test.txt"]; err != nil || exists {
+		t.Errorf("Error deleting file with the current ETag: %v", err)
+	}
+}
+
+func TestAzureStorageLock(t *testing.T) {
+	storage, fake := newFakeBlobStorage(t)
+	locker := storage.(storageabstraction.ILockFileStorage)
+
+	lock, err := locker.TryLock("locks/upload")
+	if err != nil {
+		t.Errorf("Error locking: %v", err)
+		return
+	}
+	if _, exists := fake.blobs["locks/upload"]; !exists {
+		t.Errorf("Lock blob is not created")
+	}
+	if _, err = locker.TryLock("locks/upload"); !errors.Is(err, storageabstraction.ErrConflict) {
+		t.Errorf("Expected conflict error for a locked file, actual: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err = locker.LockContext(ctx, "locks/upload"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected deadline exceeded error, actual: %v", err)
+	}
+
+	if err = lock.Unlock(); err != nil {
+		t.Errorf("Error unlocking: %v", err)
+	}
+	if err = lock.Unlock(); err != nil {
+		t.Errorf("Error unlocking twice: %v", err)
+	}
+
+	lock, err = locker.Lock("locks/upload")
+	if err != nil {
+		t.Errorf("Error locking the unlocked file: %v", err)
+		return
+	}
+	_ = lock.Unlock()
+	if len(fake.leases) != 0 || fake.requests["releaseLease"] != 2 {
+		t.Errorf("Leases are not released, leases: %v, releases: %d", fake.leases, fake.requests["releaseLease"])
+	}
+}
+
+func TestAzureStorageVersions(t *testing.T) {
+	storage, fake := newFakeBlobStorage(t, WithSnapshotBeforeOverwrite(), WithBlockSize(8))
+	versions := storage.(storageabstraction.IVersionFileStorage)
+
+	for _, file := range []struct{ name, content string }{
+		{"site/index.html", "first"}, {"site/index.html", "second"}, {"site/app.js", "app"}, {"site/index.html", "third block"},
+	} {
+		if err := storage.Write(file.name, int64(len(file.content)), strings.NewReader(file.content)); err != nil {
+			t.Errorf("[TestError] Error writing %s: %v", file.name, err)
+			return
+		}
+	}
+	if fake.requests["snapshot"] != 4 || len(fake.snapshots["site/index.html"]) != 2 {
+		t.Errorf("Expected snapshots of the overwritten blob only, snapshots: %d", len(fake.snapshots["site/index.html"]))
+	}
+
+	list, err := versions.Versions("site/index.html")
+	if err != nil || len(list) != 2 || list[0].Path != "site/index.html" || list[0].Size != 5 || list[1].Size != 6 ||
+		!list[0].Created.Before(list[1].Created) {
+		t.Errorf("Expected the two snapshots oldest first, actual: %v, %v", list, err)
+		return
+	}
+	if other, err := versions.Versions("site/index"); err != nil || len(other) != 0 {
+		t.Errorf("Expected no versions for a name prefix, actual: %v, %v", other, err)
+	}
+
+	// the restore keeps the replaced content as snapshot too
+	if err = versions.RestoreVersion("site/index.html", list[0].ID); err != nil || string(fake.blobs["site/index.html"]) != "first" {
+		t.Errorf("Error restoring version: %v", err)
+	}
+	if len(fake.snapshots["site/index.html"]) != 3 {
+		t.Errorf("Expected snapshot before restore, snapshots: %d", len(fake.snapshots["site/index.html"]))
+	}
+	if err = versions.RestoreVersion("site/index.html", "2000-01-01T00:00:00.0000000Z"); !errors.Is(err, storageabstraction.ErrNotExist) {
+		t.Errorf("Expected not exist error for an unknown version, actual: %v", err)
+	}
+
+	if err = storage.DeleteFile("site/app.js"); err != nil {
+		t.Errorf("[TestError] Error deleting file: %v", err)
+		return
+	}
+	list, err = versions.Versions("site")
+	if err != nil || len(list) != 5 || list[0].Path != "site/app.js" || !list[0].Deleted || list[0].ID != "" {
+		t.Errorf("Expected the deleted file and the snapshots, actual: %v, %v", list, err)
+	}
+	if err = versions.Undelete("site/app.js"); err != nil || string(fake.blobs["site/app.js"]) != "app" {
+		t.Errorf("Error undeleting file: %v", err)
+	}
+	if err = versions.Undelete("site/missing.js"); !errors.Is(err, storageabstraction.ErrNotExist) {
+		t.Errorf("Expected not exist error for undeleting a missing file, actual: %v", err)
+	}
+
+	id, err := versions.Snapshot("site/app.js")
+	if err != nil || id == "" || len(fake.snapshots["site/app.js"]) != 1 {
+		t.Errorf("Error creating snapshot: %v", err)
+	}
+}
+
+// TestAzurite runs against Azurite, if the connection string is set, e.g. AZURITE_CONNECTION_STRING=UseDevelopmentStorage=true
+func TestAzurite(t *testing.T) {
+	connectionString := os.Getenv("AZURITE_CONNECTION_STRING")
+	if connectionString == "" {
+		t.Skip("AZURITE_CONNECTION_STRING is not set")
+	}
+
+	storage, err := NewAzureStorageFromConnectionString(connectionString, "gokies-test")
+	if err != nil {
+		t.Errorf("[TestError] Error creating storage: %v", err)
+		return
+	}
+	_, containerURL := storage.(*tAzureFileStorage).getContainerURL()
+	_, err = containerURL.Create(context.Background(), azblob.Metadata{}, azblob.PublicAccessNone)
+	if err != nil && !errors.Is(convertError("create", "gokies-test", err), storageabstraction.ErrAlreadyExists) {
+		t.Errorf("[TestError] Error creating container: %v", err)
+		return
+	}
+
+	if err = storage.Write("dir/test.txt", 4, strings.NewReader("test")); err != nil {
+		t.Errorf("Error writing file: %v", err)
+		return
+	}
+	reader, err := storage.Read("dir/test.txt")
+	if err != nil {
+		t.Errorf("Error reading file: %v", err)
+		return
+	}
+	content, _ := io.ReadAll(reader)
+	reader.Close()
+	if string(content) != "test" {
+		t.Errorf("Unexpected content: %q", content)
+	}
+
+	if err = storage.DeleteDirectory("dir/"); err != nil {
+		t.Errorf("Error deleting directory: %v", err)
+	}
+	if _, err = storage.FileSize("dir/test.txt"); !errors.Is(err, storageabstraction.ErrNotExist) {
+		t.Errorf("Expected not exist error, actual: %v", err)
+	}
+}
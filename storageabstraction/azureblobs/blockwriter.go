@@ -0,0 +1,192 @@
+package azureblobs
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"github.com/2flow/gokies/storageabstraction"
+	"github.com/2flow/gokies/storageabstraction/common"
+	"io"
+	"sync"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+)
+
+const (
+	// defaultBlockSize is the size of the staged and downloaded blocks, if no block size is configured
+	defaultBlockSize = 4 * 1024 * 1024
+	// defaultConcurrency is the number of blocks which are staged or downloaded in parallel
+	defaultConcurrency = 4
+)
+
+func (azureStorage *tAzureFileStorage) OpenWriter(fileName string) (io.WriteCloser, error) {
+	return azureStorage.OpenWriterContext(context.Background(), fileName)
+}
+
+func (azureStorage *tAzureFileStorage) OpenWriterContext(ctx context.Context, fileName string) (io.WriteCloser, error) {
+	return azureStorage.OpenWriterWithOptionsContext(ctx, fileName, storageabstraction.WriteOptions{})
+}
+
+func (azureStorage *tAzureFileStorage) OpenWriterWithOptions(fileName string, options storageabstraction.WriteOptions) (io.WriteCloser, error) {
+	return azureStorage.OpenWriterWithOptionsContext(context.Background(), fileName, options)
+}
+
+// OpenWriterWithOptionsContext returns a writer which stages the content as blocks of the blob,
+// the block list is committed with the properties of the options on Close
+func (azureStorage *tAzureFileStorage) OpenWriterWithOptionsContext(ctx context.Context, fileName string, options storageabstraction.WriteOptions) (io.WriteCloser, error) {
+	if err := common.ValidatePath(fileName); err != nil {
+		return nil, err
+	}
+	writer, err := azureStorage.newBlockWriter(ctx, fileName, options)
+	if err != nil {
+		return nil, err
+	}
+
+	return common.LogWriteCloser(azureStorage.logger, "write", fileName, writer), nil
+}
+
+// newBlockWriter creates a writer which stages up to concurrency blocks in parallel
+func (azureStorage *tAzureFileStorage) newBlockWriter(ctx context.Context, fileName string, options storageabstraction.WriteOptions) (*tAzureBlockWriter, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err := azureStorage.ensureContainer(ctx); err != nil {
+		return nil, err
+	}
+	if err := azureStorage.snapshotBeforeOverwrite(ctx, fileName); err != nil {
+		return nil, err
+	}
+
+	// uncommitted blocks of other uploads to the same blob must not be mixed with ours
+	uploadID := make([]byte, 8)
+	if _, err := rand.Read(uploadID); err != nil {
+		return nil, err
+	}
+
+	_, blobURL := azureStorage.getBlobURL(fileName)
+
+	return &tAzureBlockWriter{
+		ctx:       ctx,
+		blobURL:   blobURL,
+		fileName:  fileName,
+		options:   options,
+		uploadID:  uploadID,
+		blockSize: azureStorage.blockSize,
+		slots:     make(chan struct{}, azureStorage.concurrency),
+	}, nil
+}
+
+type tAzureBlockWriter struct {
+	ctx       context.Context
+	blobURL   azblob.BlockBlobURL
+	fileName  string
+	options   storageabstraction.WriteOptions
+	uploadID  []byte
+	blockSize int
+
+	buffer   []byte
+	head     []byte // the start of the content to detect the content type
+	blockIDs []string
+
+	// slots limits the blocks which are staged in parallel
+	slots   chan struct{}
+	staging sync.WaitGroup
+	errLock sync.Mutex
+	err     error
+}
+
+func (writer *tAzureBlockWriter) Write(p []byte) (n int, err error) {
+	if err = writer.stageError(); err != nil {
+		return 0, err
+	}
+
+	if missing := storageabstraction.SniffLength - len(writer.head); missing > 0 {
+		writer.head = append(writer.head, p[:min(missing, len(p))]...)
+	}
+
+	writer.buffer = append(writer.buffer, p...)
+	for len(writer.buffer) >= writer.blockSize {
+		block := make([]byte, writer.blockSize)
+		copy(block, writer.buffer)
+		writer.buffer = append(writer.buffer[:0], writer.buffer[writer.blockSize:]...)
+
+		if err = writer.stageBlock(block); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(p), nil
+}
+
+// stageBlock uploads the data as next block of the blob in the background,
+// it waits until a slot is free if the maximum of blocks is already staged
+func (writer *tAzureBlockWriter) stageBlock(data []byte) error {
+	// all block ids of a blob must have the same length
+	blockID := make([]byte, 16)
+	copy(blockID, writer.uploadID)
+	binary.BigEndian.PutUint64(blockID[8:], uint64(len(writer.blockIDs)))
+	base64BlockID := base64.StdEncoding.EncodeToString(blockID)
+	writer.blockIDs = append(writer.blockIDs, base64BlockID)
+
+	select {
+	case writer.slots <- struct{}{}:
+	case <-writer.ctx.Done():
+		return writer.ctx.Err()
+	}
+
+	writer.staging.Add(1)
+	go func() {
+		defer writer.staging.Done()
+		defer func() { <-writer.slots }()
+
+		_, err := writer.blobURL.StageBlock(writer.ctx, base64BlockID, bytes.NewReader(data),
+			azblob.LeaseAccessConditions{}, nil, azblob.ClientProvidedKeyOptions{})
+		if err != nil {
+			writer.setStageError(convertError("write", writer.fileName, err))
+		}
+	}()
+
+	return writer.stageError()
+}
+
+func (writer *tAzureBlockWriter) stageError() error {
+	writer.errLock.Lock()
+	defer writer.errLock.Unlock()
+	return writer.err
+}
+
+// setStageError keeps the first error of the staged blocks
+func (writer *tAzureBlockWriter) setStageError(err error) {
+	writer.errLock.Lock()
+	defer writer.errLock.Unlock()
+	if writer.err == nil {
+		writer.err = err
+	}
+}
+
+func (writer *tAzureBlockWriter) Close() error {
+	if err := writer.ctx.Err(); err != nil {
+		writer.staging.Wait()
+		return err
+	}
+
+	if len(writer.buffer) > 0 && writer.stageError() == nil {
+		_ = writer.stageBlock(writer.buffer)
+	}
+	writer.staging.Wait()
+	if err := writer.stageError(); err != nil {
+		return err
+	}
+
+	options := writer.options
+	if options.ContentType == "" {
+		options.ContentType = storageabstraction.DetectContentTypeOf(writer.fileName, writer.head)
+	}
+
+	_, err := writer.blobURL.CommitBlockList(writer.ctx, writer.blockIDs, blobHTTPHeaders(options), azblob.Metadata(options.Metadata),
+		accessConditions(options.Conditions), accessTier(options.AccessTier), azblob.BlobTagsMap(options.Tags),
+		azblob.ClientProvidedKeyOptions{}, azblob.ImmutabilityPolicyOptions{})
+	return convertWriteError(writer.fileName, options.Conditions, err)
+}
@@ -0,0 +1,123 @@
+package azureblobs
+
+import (
+	"errors"
+	"fmt"
+	"github.com/2flow/gokies/storageabstraction"
+	"net/url"
+	"strings"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+)
+
+const (
+	// the well known account of the storage emulator Azurite
+	developmentAccountName  = "devstoreaccount1"
+	developmentAccountKey   = "Eby8vdM02xNOcqFlqUwJPLlmEtlCDXJ1OUzFT50uSRZ6IFsuFq2UVErCz4I6tq/K1SZFPTOtr/KBHBeksoGMGw=="
+	developmentBlobEndpoint = "http://127.0.0.1:10000/" + developmentAccountName
+)
+
+// connectionSettings are the settings of a connection string, which are used for the blob service
+type connectionSettings struct {
+	blobEndpoint          string
+	accountName           string
+	accountKey            string
+	sharedAccessSignature string
+}
+
+// parseConnectionString parses the connection string of the azure portal, e.g.
+// "DefaultEndpointsProtocol=https;AccountName=name;AccountKey=key;EndpointSuffix=core.windows.net",
+// "BlobEndpoint=https://name.blob.core.windows.net/;SharedAccessSignature=sv=..." or "UseDevelopmentStorage=true"
+func parseConnectionString(connectionString string) (connectionSettings, error) {
+	values := map[string]string{}
+	for _, setting := range strings.Split(connectionString, ";") {
+		if strings.TrimSpace(setting) == "" {
+			continue
+		}
+		key, value, found := strings.Cut(setting, "=")
+		if !found {
+			return connectionSettings{}, fmt.Errorf("invalid setting %q in the connection string", setting)
+		}
+		values[strings.ToLower(strings.TrimSpace(key))] = strings.TrimSpace(value)
+	}
+
+	if strings.EqualFold(values["usedevelopmentstorage"], "true") {
+		return connectionSettings{
+			blobEndpoint: developmentBlobEndpoint,
+			accountName:  developmentAccountName,
+			accountKey:   developmentAccountKey,
+		}, nil
+	}
+
+	settings := connectionSettings{
+		blobEndpoint:          values["blobendpoint"],
+		accountName:           values["accountname"],
+		accountKey:            values["accountkey"],
+		sharedAccessSignature: strings.TrimPrefix(values["sharedaccesssignature"], "?"),
+	}
+
+	if settings.blobEndpoint == "" {
+		if settings.accountName == "" {
+			return connectionSettings{}, errors.New("the connection string has neither a blob endpoint nor an account name")
+		}
+
+		protocol := values["defaultendpointsprotocol"]
+		if protocol == "" {
+			protocol = "https"
+		}
+		suffix := values["endpointsuffix"]
+		if suffix == "" {
+			suffix = "core.windows.net"
+		}
+		settings.blobEndpoint = fmt.Sprintf("%s://%s.blob.%s", protocol, settings.accountName, suffix)
+	}
+
+	if settings.sharedAccessSignature == "" && (settings.accountName == "" || settings.accountKey == "") {
+		return connectionSettings{}, errors.New("the connection string has neither a shared access signature nor an account key")
+	}
+
+	return settings, nil
+}
+
+// NewAzureStorageFromConnectionString creates the storage for the container with the connection string of the
+// storage account, "UseDevelopmentStorage=true" connects to Azurite
+func NewAzureStorageFromConnectionString(connectionString string, containerName string, options ...Option) (storageabstraction.IFileStorage, error) {
+	settings, err := parseConnectionString(connectionString)
+	if err != nil {
+		return nil, err
+	}
+
+	if settings.sharedAccessSignature != "" {
+		return NewAzureStorageWithCredential(settings.blobEndpoint+"?"+settings.sharedAccessSignature, containerName,
+			AnonymousCredential(), options...)
+	}
+	return NewAzureStorageWithCredential(settings.blobEndpoint, containerName,
+		SharedKeyCredential(settings.accountName, settings.accountKey), options...)
+}
+
+// NewAzureStorageFromSASURL creates the storage with a SAS url of the account or of the container.
+// For the url of a container the container name can be empty
+func NewAzureStorageFromSASURL(sasURL string, containerName string, options ...Option) (storageabstraction.IFileStorage, error) {
+	parsedURL, err := url.Parse(sasURL)
+	if err != nil {
+		return nil, err
+	}
+
+	parts := azblob.NewBlobURLParts(*parsedURL)
+	if parts.SAS.Signature() == "" {
+		return nil, errors.New("the url has no SAS token")
+	}
+	if parts.BlobName != "" {
+		return nil, errors.New("the SAS url must be the url of the account or the container, not of a blob")
+	}
+	if parts.ContainerName != "" {
+		if containerName != "" && containerName != parts.ContainerName {
+			return nil, fmt.Errorf("the SAS url is for the container %s and not for %s", parts.ContainerName, containerName)
+		}
+		containerName = parts.ContainerName
+		parts.ContainerName = ""
+	}
+
+	serviceURL := parts.URL()
+	return NewAzureStorageWithCredential(serviceURL.String(), containerName, AnonymousCredential(), options...)
+}
@@ -0,0 +1,171 @@
+package azureblobs
+
+import (
+	"context"
+	"errors"
+	"github.com/2flow/gokies/storageabstraction"
+	"github.com/2flow/gokies/storageabstraction/common"
+	"time"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+)
+
+// PublicAccess is the level of anonymous read access to the blobs of a container
+type PublicAccess string
+
+const (
+	// PublicAccessNone allows only authorized requests
+	PublicAccessNone PublicAccess = PublicAccess(azblob.PublicAccessNone)
+	// PublicAccessBlob allows anonymous reads of the blobs, but not to list the container
+	PublicAccessBlob PublicAccess = PublicAccess(azblob.PublicAccessBlob)
+	// PublicAccessContainer allows anonymous reads of the blobs and to list the container
+	PublicAccessContainer PublicAccess = PublicAccess(azblob.PublicAccessContainer)
+)
+
+// IContainerManager is implemented by the azure storage to manage its container and the containers of the account.
+// Use a type assertion on the storage returned by the constructors
+type IContainerManager interface {
+	CreateContainer(access PublicAccess) error
+	CreateContainerContext(ctx context.Context, access PublicAccess) error
+	ContainerExists() (bool, error)
+	ContainerExistsContext(ctx context.Context) (bool, error)
+	DeleteContainer() error
+	DeleteContainerContext(ctx context.Context) error
+	SetPublicAccess(access PublicAccess) error
+	SetPublicAccessContext(ctx context.Context, access PublicAccess) error
+	// ListContainers returns the names of all containers of the account with the prefix
+	ListContainers(prefix string) ([]string, error)
+	ListContainersContext(ctx context.Context, prefix string) ([]string, error)
+}
+
+// WithCreateContainer creates the container with the public access level before the first write,
+// if it does not exist yet
+func WithCreateContainer(access PublicAccess) Option {
+	return func(azureStorage *tAzureFileStorage) {
+		azureStorage.createContainer = true
+		azureStorage.publicAccess = access
+	}
+}
+
+func (azureStorage *tAzureFileStorage) CreateContainer(access PublicAccess) error {
+	return azureStorage.CreateContainerContext(context.Background(), access)
+}
+
+// CreateContainerContext creates the container of the storage, ErrAlreadyExists is returned if it exists
+func (azureStorage *tAzureFileStorage) CreateContainerContext(ctx context.Context, access PublicAccess) (err error) {
+	defer func(start time.Time) {
+		common.LogOperation(azureStorage.logger, "createContainer", azureStorage.containerName, -1, start, err)
+	}(time.Now())
+
+	_, containerURL := azureStorage.getContainerURL()
+	_, err = containerURL.Create(ctx, azblob.Metadata{}, azblob.PublicAccessType(access))
+	if err = convertError("createContainer", azureStorage.containerName, err); err == nil {
+		azureStorage.setContainerCreated(true)
+	}
+	return err
+}
+
+func (azureStorage *tAzureFileStorage) ContainerExists() (bool, error) {
+	return azureStorage.ContainerExistsContext(context.Background())
+}
+
+func (azureStorage *tAzureFileStorage) ContainerExistsContext(ctx context.Context) (bool, error) {
+	_, containerURL := azureStorage.getContainerURL()
+	_, err := containerURL.GetProperties(ctx, azblob.LeaseAccessConditions{})
+	err = convertError("containerExists", azureStorage.containerName, err)
+	if errors.Is(err, storageabstraction.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (azureStorage *tAzureFileStorage) DeleteContainer() error {
+	return azureStorage.DeleteContainerContext(context.Background())
+}
+
+// DeleteContainerContext deletes the container with all blobs. The service removes it in the background,
+// so a container of the same name can not be created for a while
+func (azureStorage *tAzureFileStorage) DeleteContainerContext(ctx context.Context) (err error) {
+	defer func(start time.Time) {
+		common.LogOperation(azureStorage.logger, "deleteContainer", azureStorage.containerName, -1, start, err)
+	}(time.Now())
+
+	_, containerURL := azureStorage.getContainerURL()
+	_, err = containerURL.Delete(ctx, azblob.ContainerAccessConditions{})
+	if err = convertError("deleteContainer", azureStorage.containerName, err); err == nil {
+		azureStorage.setContainerCreated(false)
+	}
+	return err
+}
+
+func (azureStorage *tAzureFileStorage) SetPublicAccess(access PublicAccess) error {
+	return azureStorage.SetPublicAccessContext(context.Background(), access)
+}
+
+// SetPublicAccessContext changes the public access level of the container, the stored access policies are removed
+func (azureStorage *tAzureFileStorage) SetPublicAccessContext(ctx context.Context, access PublicAccess) (err error) {
+	defer func(start time.Time) {
+		common.LogOperation(azureStorage.logger, "setPublicAccess", azureStorage.containerName, -1, start, err, "access", access)
+	}(time.Now())
+
+	_, containerURL := azureStorage.getContainerURL()
+	_, err = containerURL.SetAccessPolicy(ctx, azblob.PublicAccessType(access), nil, azblob.ContainerAccessConditions{})
+	return convertError("setPublicAccess", azureStorage.containerName, err)
+}
+
+func (azureStorage *tAzureFileStorage) ListContainers(prefix string) ([]string, error) {
+	return azureStorage.ListContainersContext(context.Background(), prefix)
+}
+
+func (azureStorage *tAzureFileStorage) ListContainersContext(ctx context.Context, prefix string) ([]string, error) {
+	var containerNames []string
+	for marker := (azblob.Marker{}); marker.NotDone(); {
+		listContainers, err := azureStorage.serviceURL.ListContainersSegment(ctx, marker, azblob.ListContainersSegmentOptions{Prefix: prefix})
+		if err != nil {
+			err = convertError("listContainers", prefix, err)
+			common.LogOperation(azureStorage.logger, "listContainers", prefix, -1, time.Now(), err)
+			return nil, err
+		}
+		marker = listContainers.NextMarker
+
+		for _, container := range listContainers.ContainerItems {
+			containerNames = append(containerNames, container.Name)
+		}
+	}
+
+	return containerNames, nil
+}
+
+// ensureContainer creates the container once, if the storage was created WithCreateContainer
+func (azureStorage *tAzureFileStorage) ensureContainer(ctx context.Context) error {
+	if !azureStorage.createContainer {
+		return nil
+	}
+
+	azureStorage.containerLock.Lock()
+	defer azureStorage.containerLock.Unlock()
+	if azureStorage.containerCreated {
+		return nil
+	}
+
+	start := time.Now()
+	_, containerURL := azureStorage.getContainerURL()
+	_, err := containerURL.Create(ctx, azblob.Metadata{}, azblob.PublicAccessType(azureStorage.publicAccess))
+	err = convertError("createContainer", azureStorage.containerName, err)
+	if errors.Is(err, storageabstraction.ErrAlreadyExists) {
+		err = nil
+	}
+	common.LogOperation(azureStorage.logger, "createContainer", azureStorage.containerName, -1, start, err)
+	if err != nil {
+		return err
+	}
+
+	azureStorage.containerCreated = true
+	return nil
+}
+
+func (azureStorage *tAzureFileStorage) setContainerCreated(created bool) {
+	azureStorage.containerLock.Lock()
+	defer azureStorage.containerLock.Unlock()
+	azureStorage.containerCreated = created
+}
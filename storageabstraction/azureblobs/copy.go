@@ -0,0 +1,208 @@
+package azureblobs
+
+import (
+	"context"
+	"fmt"
+	"github.com/2flow/gokies/storageabstraction"
+	"github.com/2flow/gokies/storageabstraction/common"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+)
+
+const (
+	// copyPollInterval is the time between two checks of a pending server side copy
+	copyPollInterval = 500 * time.Millisecond
+)
+
+func (azureStorage *tAzureFileStorage) CopyFile(source string, destination string) error {
+	return azureStorage.CopyFileContext(context.Background(), source, destination)
+}
+
+// CopyFileContext copies the blob with a server side copy and waits until the copy is done.
+// A pending copy is aborted if the context is done
+func (azureStorage *tAzureFileStorage) CopyFileContext(ctx context.Context, source string, destination string) (err error) {
+	defer func(start time.Time) {
+		common.LogOperation(azureStorage.logger, "copy", destination, -1, start, err, "source", source)
+	}(time.Now())
+
+	if err = common.ValidatePath(source); err != nil {
+		return err
+	}
+	if err = common.ValidatePath(destination); err != nil {
+		return err
+	}
+
+	if source == destination {
+		return nil
+	}
+
+	_, sourceURL := azureStorage.getBlobURL(source)
+	return azureStorage.copyBlob(ctx, sourceURL.URL(), source, destination)
+}
+
+// copyBlob starts the server side copy from the url and waits until it is done, source is the name used in the errors
+func (azureStorage *tAzureFileStorage) copyBlob(ctx context.Context, sourceURL url.URL, source string, destination string) error {
+	if err := azureStorage.snapshotBeforeOverwrite(ctx, destination); err != nil {
+		return err
+	}
+
+	_, destinationURL := azureStorage.getBlobURL(destination)
+
+	// the metadata of the source is kept if no metadata is passed
+	copyResponse, err := destinationURL.StartCopyFromURL(ctx, sourceURL, nil, azblob.ModifiedAccessConditions{},
+		azblob.BlobAccessConditions{}, azblob.AccessTierNone, nil)
+	if err != nil {
+		return convertError("copy", source, err)
+	}
+
+	copyStatus := copyResponse.CopyStatus()
+	for copyStatus == azblob.CopyStatusPending {
+		select {
+		case <-ctx.Done():
+			_, _ = destinationURL.AbortCopyFromURL(context.Background(), copyResponse.CopyID(), azblob.LeaseAccessConditions{})
+			return ctx.Err()
+		case <-time.After(copyPollInterval):
+		}
+
+		properties, err := destinationURL.GetProperties(ctx, azblob.BlobAccessConditions{}, azblob.ClientProvidedKeyOptions{})
+		if err != nil {
+			return convertError("copy", destination, err)
+		}
+		copyStatus = properties.CopyStatus()
+		if copyStatus != azblob.CopyStatusSuccess && copyStatus != azblob.CopyStatusPending {
+			return fmt.Errorf("copy of %s to %s %s: %s", source, destination, copyStatus, properties.CopyStatusDescription())
+		}
+	}
+
+	if copyStatus != azblob.CopyStatusSuccess {
+		return fmt.Errorf("copy of %s to %s %s", source, destination, copyStatus)
+	}
+	return nil
+}
+
+func (azureStorage *tAzureFileStorage) MoveFile(source string, destination string) error {
+	return azureStorage.MoveFileContext(context.Background(), source, destination)
+}
+
+// MoveFileContext copies the blob and deletes the source, blobs can not be renamed
+func (azureStorage *tAzureFileStorage) MoveFileContext(ctx context.Context, source string, destination string) (err error) {
+	defer func(start time.Time) {
+		common.LogOperation(azureStorage.logger, "move", destination, -1, start, err, "source", source)
+	}(time.Now())
+
+	if err = common.ValidatePath(source); err != nil {
+		return err
+	}
+	if err = common.ValidatePath(destination); err != nil {
+		return err
+	}
+
+	if source == destination {
+		return nil
+	}
+
+	if err := azureStorage.CopyFileContext(ctx, source, destination); err != nil {
+		return err
+	}
+	return azureStorage.DeleteFileContext(ctx, source)
+}
+
+func (azureStorage *tAzureFileStorage) CopyDirectory(source string, destination string) error {
+	return azureStorage.CopyDirectoryContext(context.Background(), source, destination)
+}
+
+func (azureStorage *tAzureFileStorage) CopyDirectoryContext(ctx context.Context, source string, destination string) (err error) {
+	defer func(start time.Time) {
+		common.LogOperation(azureStorage.logger, "copyDirectory", destination, -1, start, err, "source", source)
+	}(time.Now())
+
+	if err = common.ValidatePath(source); err != nil {
+		return err
+	}
+	if err = common.ValidatePath(destination); err != nil {
+		return err
+	}
+
+	_, err = azureStorage.copyDirectory(ctx, source, destination)
+	return err
+}
+
+func (azureStorage *tAzureFileStorage) MoveDirectory(source string, destination string) error {
+	return azureStorage.MoveDirectoryContext(context.Background(), source, destination)
+}
+
+// MoveDirectoryContext copies all blobs of the directory and deletes the copied blobs afterwards
+func (azureStorage *tAzureFileStorage) MoveDirectoryContext(ctx context.Context, source string, destination string) (err error) {
+	defer func(start time.Time) {
+		common.LogOperation(azureStorage.logger, "moveDirectory", destination, -1, start, err, "source", source)
+	}(time.Now())
+
+	if err = common.ValidatePath(source); err != nil {
+		return err
+	}
+	if err = common.ValidatePath(destination); err != nil {
+		return err
+	}
+
+	copied, err := azureStorage.copyDirectory(ctx, source, destination)
+	if err != nil {
+		return err
+	}
+
+	for _, blobName := range copied {
+		if err = azureStorage.DeleteFileContext(ctx, blobName); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyDirectory copies all blobs of the source directory into the destination directory,
+// the names of the copied blobs are returned
+func (azureStorage *tAzureFileStorage) copyDirectory(ctx context.Context, source string, destination string) ([]string, error) {
+	sourcePrefix := directoryPrefix(source)
+	destinationPrefix := directoryPrefix(destination)
+	if sourcePrefix == destinationPrefix {
+		return nil, nil
+	}
+
+	_, containerURL := azureStorage.getContainerURL()
+
+	// the names are collected first, so the copies do not show up in the listing
+	var blobNames []string
+	for marker := (azblob.Marker{}); marker.NotDone(); {
+		listBlob, err := containerURL.ListBlobsFlatSegment(ctx, marker, azblob.ListBlobsSegmentOptions{Prefix: sourcePrefix})
+		if err != nil {
+			return nil, convertError("copy", source, err)
+		}
+		marker = listBlob.NextMarker
+
+		for _, blobInfo := range listBlob.Segment.BlobItems {
+			blobNames = append(blobNames, blobInfo.Name)
+		}
+	}
+	if len(blobNames) == 0 {
+		return nil, storageabstraction.NewPathError("copy", source, storageabstraction.ErrNotExist, nil)
+	}
+
+	for _, blobName := range blobNames {
+		err := azureStorage.CopyFileContext(ctx, blobName, destinationPrefix+strings.TrimPrefix(blobName, sourcePrefix))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return blobNames, nil
+}
+
+// directoryPrefix returns the name prefix of all blobs in the directory
+func directoryPrefix(directory string) string {
+	prefix := strings.Trim(directory, "/")
+	if prefix == "" {
+		return ""
+	}
+	return prefix + "/"
+}
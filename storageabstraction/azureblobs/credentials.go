@@ -0,0 +1,77 @@
+package azureblobs
+
+import (
+	"context"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+)
+
+const (
+	// storageScope is the scope of the Azure AD tokens for the storage accounts
+	storageScope = "https://storage.azure.com/.default"
+	// tokenRefreshMargin is the time before the expiry when a token is refreshed
+	tokenRefreshMargin = 2 * time.Minute
+	// tokenRetryInterval is the time until the next try, if a token could not be refreshed
+	tokenRetryInterval  = 30 * time.Second
+	tokenRequestTimeout = time.Minute
+)
+
+// CredentialProvider creates the credential for the requests of a storage, it is called once when the storage is created.
+// Credentials with tokens refresh them on their own before they expire
+type CredentialProvider func(logger log.Logger) (azblob.Credential, error)
+
+// SharedKeyCredential signs the requests with the key of the storage account
+func SharedKeyCredential(accountName string, accountKey string) CredentialProvider {
+	return func(_ log.Logger) (azblob.Credential, error) {
+		return azblob.NewSharedKeyCredential(accountName, accountKey)
+	}
+}
+
+// AnonymousCredential sends the requests without credential, for public containers or urls with a SAS token
+func AnonymousCredential() CredentialProvider {
+	return func(_ log.Logger) (azblob.Credential, error) {
+		return azblob.NewAnonymousCredential(), nil
+	}
+}
+
+// TokenCredential uses the Azure AD tokens of the credential, e.g. of a managed identity or a service principal
+// created with azidentity. The token is refreshed before it expires, failed refreshes are logged and retried
+func TokenCredential(credential azcore.TokenCredential) CredentialProvider {
+	return func(logger log.Logger) (azblob.Credential, error) {
+		options := policy.TokenRequestOptions{Scopes: []string{storageScope}}
+
+		token, err := credential.GetToken(context.Background(), options)
+		if err != nil {
+			return nil, err
+		}
+		expiresOn := token.ExpiresOn
+
+		// the refresher is called immediately and afterwards after the returned duration
+		return azblob.NewTokenCredential(token.Token, func(tokenCredential azblob.TokenCredential) time.Duration {
+			if refreshIn := time.Until(expiresOn) - tokenRefreshMargin; refreshIn > 0 {
+				return refreshIn
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), tokenRequestTimeout)
+			defer cancel()
+
+			token, err := credential.GetToken(ctx, options)
+			if err != nil {
+				_ = level.Error(logger).Log("msg", "Unable to refresh the azure token", "err", err)
+				return tokenRetryInterval
+			}
+			tokenCredential.SetToken(token.Token)
+			expiresOn = token.ExpiresOn
+
+			if refreshIn := time.Until(expiresOn) - tokenRefreshMargin; refreshIn > tokenRetryInterval {
+				return refreshIn
+			}
+			return tokenRetryInterval
+		}), nil
+	}
+}
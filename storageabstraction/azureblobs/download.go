@@ -0,0 +1,171 @@
+package azureblobs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+)
+
+const (
+	// maxRetryRequests is the number of retries if the connection of a download breaks
+	maxRetryRequests = 3
+)
+
+// download returns a reader for count bytes of the blob, starting at offset. The first block is streamed,
+// the following blocks are downloaded in parallel, so up to concurrency blocks are downloaded ahead of the reader
+func (azureStorage *tAzureFileStorage) download(ctx context.Context, fileName string, offset int64, count int64) (io.ReadCloser, error) {
+	_, blobURL := azureStorage.getBlobURL(fileName)
+	blockSize := int64(azureStorage.blockSize)
+
+	if azureStorage.concurrency <= 1 || (count != azblob.CountToEnd && count <= blockSize) {
+		get, err := blobURL.Download(ctx, offset, count, azblob.BlobAccessConditions{}, false, azblob.ClientProvidedKeyOptions{})
+		if err != nil {
+			return nil, err
+		}
+		return get.Body(azblob.RetryReaderOptions{MaxRetryRequests: maxRetryRequests}), nil
+	}
+
+	get, err := blobURL.Download(ctx, offset, blockSize, azblob.BlobAccessConditions{}, false, azblob.ClientProvidedKeyOptions{})
+	if isInvalidRange(err) && offset == 0 {
+		// an empty blob has no range
+		get, err = blobURL.Download(ctx, 0, azblob.CountToEnd, azblob.BlobAccessConditions{}, false, azblob.ClientProvidedKeyOptions{})
+	}
+	if err != nil {
+		return nil, err
+	}
+	firstBlock := get.Body(azblob.RetryReaderOptions{MaxRetryRequests: maxRetryRequests})
+
+	end, ok := blobSize(get.ContentRange())
+	if !ok {
+		// the whole blob was returned
+		return firstBlock, nil
+	}
+	if count != azblob.CountToEnd {
+		end = min(end, offset+count)
+	}
+	if offset+blockSize >= end {
+		return firstBlock, nil
+	}
+
+	// the blocks must be of the same version of the blob
+	conditions := azblob.BlobAccessConditions{ModifiedAccessConditions: azblob.ModifiedAccessConditions{IfMatch: get.ETag()}}
+
+	ctx, cancel := context.WithCancel(ctx)
+	reader := &tAzureBlockReader{
+		cancel:  cancel,
+		current: firstBlock,
+		blocks:  make(chan chan blockResult, azureStorage.concurrency-1),
+	}
+
+	go func() {
+		defer close(reader.blocks)
+
+		for blockOffset := offset + blockSize; blockOffset < end; blockOffset += blockSize {
+			result := make(chan blockResult, 1)
+			select {
+			case reader.blocks <- result:
+			case <-ctx.Done():
+				return
+			}
+
+			go func(blockOffset int64, count int64) {
+				result <- downloadBlock(ctx, fileName, blobURL, blockOffset, count, conditions)
+			}(blockOffset, min(blockSize, end-blockOffset))
+		}
+	}()
+
+	return reader, nil
+}
+
+type blockResult struct {
+	content []byte
+	err     error
+}
+
+func downloadBlock(ctx context.Context, fileName string, blobURL azblob.BlockBlobURL, offset int64, count int64, conditions azblob.BlobAccessConditions) blockResult {
+	get, err := blobURL.Download(ctx, offset, count, conditions, false, azblob.ClientProvidedKeyOptions{})
+	if err != nil {
+		return blockResult{err: convertError("read", fileName, err)}
+	}
+
+	body := get.Body(azblob.RetryReaderOptions{MaxRetryRequests: maxRetryRequests})
+	defer body.Close()
+
+	content, err := io.ReadAll(body)
+	if err == nil && int64(len(content)) != count {
+		err = io.ErrUnexpectedEOF
+	}
+	return blockResult{content: content, err: err}
+}
+
+// tAzureBlockReader reads the blocks in order, while the following blocks are downloaded in the background
+type tAzureBlockReader struct {
+	cancel  context.CancelFunc
+	current io.ReadCloser
+	blocks  chan chan blockResult
+	err     error
+}
+
+func (reader *tAzureBlockReader) Read(p []byte) (n int, err error) {
+	for reader.err == nil {
+		if reader.current != nil {
+			n, err = reader.current.Read(p)
+			if err != io.EOF {
+				return n, err
+			}
+
+			_ = reader.current.Close()
+			reader.current = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+
+		result, ok := <-reader.blocks
+		if !ok {
+			reader.err = io.EOF
+			break
+		}
+		block := <-result
+		if block.err != nil {
+			reader.err = block.err
+			break
+		}
+		reader.current = io.NopCloser(bytes.NewReader(block.content))
+	}
+
+	return 0, reader.err
+}
+
+// Close stops the downloads of the following blocks
+func (reader *tAzureBlockReader) Close() error {
+	reader.cancel()
+	if reader.current != nil {
+		return reader.current.Close()
+	}
+	return nil
+}
+
+// blobSize returns the size of the blob from the content range "bytes 0-1023/4096"
+func blobSize(contentRange string) (int64, bool) {
+	_, total, found := strings.Cut(contentRange, "/")
+	if !found {
+		return 0, false
+	}
+
+	size, err := strconv.ParseInt(total, 10, 64)
+	return size, err == nil
+}
+
+func isInvalidRange(err error) bool {
+	var storageError azblob.StorageError
+	return errors.As(err, &storageError) && storageError.Response() != nil &&
+		storageError.Response().StatusCode == http.StatusRequestedRangeNotSatisfiable
+}
@@ -0,0 +1,75 @@
+package azureblobs
+
+import (
+	"context"
+	"github.com/2flow/gokies/storageabstraction"
+	"github.com/2flow/gokies/storageabstraction/common"
+	"io/fs"
+	"sort"
+	"strings"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/go-kit/log/level"
+)
+
+// delimiter separates the virtual directories in the blob names
+const delimiter = "/"
+
+func (azureStorage *tAzureFileStorage) List(directory string) ([]fs.FileInfo, error) {
+	return azureStorage.ListContext(context.Background(), directory)
+}
+
+// ListContext returns the blobs and virtual directories directly inside the directory sorted by name
+func (azureStorage *tAzureFileStorage) ListContext(ctx context.Context, directory string) ([]fs.FileInfo, error) {
+	if err := common.ValidatePath(directory); err != nil {
+		return nil, err
+	}
+	entries, err := azureStorage.listDirectory(ctx, directory)
+	if err != nil {
+		_ = level.Error(azureStorage.logger).Log("msg", "Unable to list content", "op", "list", "path", directory, "err", err)
+	}
+	return entries, err
+}
+
+// listDirectory lists one level of the blob names with the delimiter, the common prefixes are the sub directories.
+// Blob storage has no empty directories, so a directory without blobs does not exist
+func (azureStorage *tAzureFileStorage) listDirectory(ctx context.Context, directory string) ([]fs.FileInfo, error) {
+	_, containerURL := azureStorage.getContainerURL()
+	prefix := directoryPrefix(directory)
+
+	var entries []fs.FileInfo
+	for marker := (azblob.Marker{}); marker.NotDone(); {
+		listBlob, err := containerURL.ListBlobsHierarchySegment(ctx, marker, delimiter, azblob.ListBlobsSegmentOptions{Prefix: prefix})
+		if err != nil {
+			return nil, convertError("list", directory, err)
+		}
+		marker = listBlob.NextMarker
+
+		for _, blobPrefix := range listBlob.Segment.BlobPrefixes {
+			name := strings.TrimSuffix(strings.TrimPrefix(blobPrefix.Name, prefix), delimiter)
+			entries = append(entries, storageabstraction.NewFileInfoFromDetails(storageabstraction.FileDetails{Name: name, IsDir: true}))
+		}
+		for i := range listBlob.Segment.BlobItems {
+			entries = append(entries, &AzureFileInfo{blobInfo: &listBlob.Segment.BlobItems[i]})
+		}
+	}
+
+	if len(entries) == 0 && prefix != "" {
+		return nil, storageabstraction.NewPathError("list", directory, storageabstraction.ErrNotExist, nil)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries, nil
+}
+
+func (azureStorage *tAzureFileStorage) Walk(directory string, walk storageabstraction.WalkFunc) error {
+	return azureStorage.WalkContext(context.Background(), directory, walk)
+}
+
+// WalkContext walks the virtual directories like the local storage, the directory entries are synthesized
+// from the blob names. The paths are relative to the directory
+func (azureStorage *tAzureFileStorage) WalkContext(ctx context.Context, directory string, walk storageabstraction.WalkFunc) error {
+	return storageabstraction.WalkByList(ctx, directory, azureStorage.ListContext, walk)
+}
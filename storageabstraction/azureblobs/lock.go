@@ -0,0 +1,170 @@
+package azureblobs
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"github.com/2flow/gokies/storageabstraction"
+	"github.com/2flow/gokies/storageabstraction/common"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+)
+
+const (
+	// leaseDuration is the duration of the lease in seconds, it is renewed while the lock is held.
+	// If the process dies, the lock is released after this duration
+	leaseDuration = 60
+	// leaseRenewInterval leaves time for two more renewals before the lease expires
+	leaseRenewInterval = leaseDuration * time.Second / 3
+	// lockRetryInterval is the time between two attempts of Lock to acquire the lease
+	lockRetryInterval = time.Second
+)
+
+func (azureStorage *tAzureFileStorage) TryLock(fileName string) (storageabstraction.ILock, error) {
+	return azureStorage.TryLockContext(context.Background(), fileName)
+}
+
+// TryLockContext acquires a lease on the blob, an empty blob is created if it does not exist.
+// The lease is renewed in the background until the lock is unlocked
+func (azureStorage *tAzureFileStorage) TryLockContext(ctx context.Context, fileName string) (lock storageabstraction.ILock, err error) {
+	defer func(start time.Time) {
+		if !errors.Is(err, storageabstraction.ErrConflict) {
+			common.LogOperation(azureStorage.logger, "lock", fileName, -1, start, err)
+		}
+	}(time.Now())
+
+	if err = common.ValidatePath(fileName); err != nil {
+		return nil, err
+	}
+
+	leaseID, err := newLeaseID()
+	if err != nil {
+		return nil, err
+	}
+
+	_, blobURL := azureStorage.getBlobURL(fileName)
+	_, err = blobURL.AcquireLease(ctx, leaseID, leaseDuration, azblob.ModifiedAccessConditions{})
+	if errors.Is(convertError("lock", fileName, err), storageabstraction.ErrNotExist) {
+		if err = azureStorage.createLockBlob(ctx, fileName); err != nil {
+			return nil, err
+		}
+		_, err = blobURL.AcquireLease(ctx, leaseID, leaseDuration, azblob.ModifiedAccessConditions{})
+	}
+	if err != nil {
+		return nil, convertError("lock", fileName, err)
+	}
+
+	renewCtx, cancel := context.WithCancel(context.Background())
+	azureLock := &tAzureLock{
+		blobURL:  blobURL,
+		fileName: fileName,
+		leaseID:  leaseID,
+		logger:   azureStorage.logger,
+		cancel:   cancel,
+	}
+	azureLock.renewing.Add(1)
+	go azureLock.renew(renewCtx)
+
+	return azureLock, nil
+}
+
+func (azureStorage *tAzureFileStorage) Lock(fileName string) (storageabstraction.ILock, error) {
+	return azureStorage.LockContext(context.Background(), fileName)
+}
+
+// LockContext tries to lock the blob until the lease is acquired or the context is done
+func (azureStorage *tAzureFileStorage) LockContext(ctx context.Context, fileName string) (storageabstraction.ILock, error) {
+	for {
+		lock, err := azureStorage.TryLockContext(ctx, fileName)
+		if !errors.Is(err, storageabstraction.ErrConflict) {
+			return lock, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(lockRetryInterval):
+		}
+	}
+}
+
+// createLockBlob uploads an empty blob, which may have been created by someone else in the meantime
+func (azureStorage *tAzureFileStorage) createLockBlob(ctx context.Context, fileName string) error {
+	if err := azureStorage.ensureContainer(ctx); err != nil {
+		return err
+	}
+
+	_, blobURL := azureStorage.getBlobURL(fileName)
+	_, err := blobURL.Upload(ctx, bytes.NewReader(nil), azblob.BlobHTTPHeaders{}, azblob.Metadata{},
+		accessConditions(storageabstraction.Conditions{IfNoneMatch: storageabstraction.ETagAny}), azblob.DefaultAccessTier,
+		nil, azblob.ClientProvidedKeyOptions{}, azblob.ImmutabilityPolicyOptions{})
+	err = convertWriteError(fileName, storageabstraction.Conditions{IfNoneMatch: storageabstraction.ETagAny}, err)
+	if errors.Is(err, storageabstraction.ErrConflict) {
+		// created by someone else, maybe also leased already
+		return nil
+	}
+	return err
+}
+
+// newLeaseID returns a random UUID, the lease ids must be UUIDs
+func newLeaseID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	id[6] = (id[6] & 0x0f) | 0x40
+	id[8] = (id[8] & 0x3f) | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", id[0:4], id[4:6], id[6:8], id[8:10], id[10:]), nil
+}
+
+// tAzureLock holds the lease of a blob
+type tAzureLock struct {
+	blobURL  azblob.BlockBlobURL
+	fileName string
+	leaseID  string
+	logger   log.Logger
+
+	cancel   context.CancelFunc
+	renewing sync.WaitGroup
+	once     sync.Once
+}
+
+// renew renews the lease until the context is cancelled by Unlock
+func (lock *tAzureLock) renew(ctx context.Context) {
+	defer lock.renewing.Done()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(leaseRenewInterval):
+		}
+
+		if _, err := lock.blobURL.RenewLease(ctx, lock.leaseID, azblob.ModifiedAccessConditions{}); err != nil && ctx.Err() == nil {
+			// the lease may expire, if the renewal keeps failing
+			_ = level.Error(lock.logger).Log("msg", "Unable to renew the lease of the lock", "op", "lock", "path", lock.fileName,
+				"err", convertError("lock", lock.fileName, err))
+		}
+	}
+}
+
+// Unlock stops the renewal and releases the lease, so others can lock the blob immediately
+func (lock *tAzureLock) Unlock() (err error) {
+	lock.once.Do(func() {
+		start := time.Now()
+		lock.cancel()
+		lock.renewing.Wait()
+
+		_, err = lock.blobURL.ReleaseLease(context.Background(), lock.leaseID, azblob.ModifiedAccessConditions{})
+		err = convertError("unlock", lock.fileName, err)
+		common.LogOperation(lock.logger, "unlock", lock.fileName, -1, start, err)
+	})
+	return err
+}
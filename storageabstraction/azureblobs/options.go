@@ -0,0 +1,105 @@
+package azureblobs
+
+import (
+	"context"
+	"errors"
+	"github.com/2flow/gokies/storageabstraction"
+	"github.com/2flow/gokies/storageabstraction/common"
+	"io"
+	"time"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+)
+
+func (azureStorage *tAzureFileStorage) WriteWithOptions(fileName string, fileSize int64, reader io.ReadSeeker, options storageabstraction.WriteOptions) error {
+	return azureStorage.WriteWithOptionsContext(context.Background(), fileName, fileSize, reader, options)
+}
+
+// WriteWithOptionsContext uploads the blob with the HTTP headers, metadata, tags and access tier of the options
+func (azureStorage *tAzureFileStorage) WriteWithOptionsContext(ctx context.Context, fileName string, fileSize int64, reader io.ReadSeeker, options storageabstraction.WriteOptions) (err error) {
+	defer func(start time.Time) {
+		common.LogOperation(azureStorage.logger, "write", fileName, fileSize, start, err)
+	}(time.Now())
+
+	if err = common.ValidatePath(fileName); err != nil {
+		return err
+	}
+
+	if options.ContentType == "" {
+		if options.ContentType, err = storageabstraction.DetectContentType(fileName, reader); err != nil {
+			return err
+		}
+	}
+	if err = azureStorage.ensureContainer(ctx); err != nil {
+		return err
+	}
+
+	if fileSize > int64(azureStorage.blockSize) {
+		return azureStorage.writeBlocks(ctx, fileName, reader, options)
+	}
+
+	if err = azureStorage.snapshotBeforeOverwrite(ctx, fileName); err != nil {
+		return err
+	}
+
+	_, blobURL := azureStorage.getBlobURL(fileName)
+	_, err = blobURL.Upload(ctx, reader, blobHTTPHeaders(options), azblob.Metadata(options.Metadata), accessConditions(options.Conditions),
+		accessTier(options.AccessTier), azblob.BlobTagsMap(options.Tags), azblob.ClientProvidedKeyOptions{}, azblob.ImmutabilityPolicyOptions{})
+	return convertWriteError(fileName, options.Conditions, err)
+}
+
+// writeBlocks uploads the content in blocks, which are staged in parallel
+func (azureStorage *tAzureFileStorage) writeBlocks(ctx context.Context, fileName string, reader io.Reader, options storageabstraction.WriteOptions) error {
+	writerCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	writer, err := azureStorage.newBlockWriter(writerCtx, fileName, options)
+	if err != nil {
+		return err
+	}
+
+	if _, err = io.Copy(writer, reader); err != nil {
+		// aborts the upload, the staged blocks are not committed
+		cancel()
+		_ = writer.Close()
+		return err
+	}
+
+	return writer.Close()
+}
+
+// blobHTTPHeaders are the headers the blob is served with
+func blobHTTPHeaders(options storageabstraction.WriteOptions) azblob.BlobHTTPHeaders {
+	return azblob.BlobHTTPHeaders{
+		ContentType:        options.ContentType,
+		ContentEncoding:    options.ContentEncoding,
+		ContentDisposition: options.ContentDisposition,
+		CacheControl:       options.CacheControl,
+	}
+}
+
+// accessTier converts the tier, blobs are stored as hot blobs by default
+func accessTier(tier storageabstraction.AccessTier) azblob.AccessTierType {
+	if tier == storageabstraction.AccessTierDefault {
+		return azblob.AccessTierHot
+	}
+	return azblob.AccessTierType(tier)
+}
+
+// accessConditions passes the conditions as If-Match and If-None-Match headers
+func accessConditions(conditions storageabstraction.Conditions) azblob.BlobAccessConditions {
+	return azblob.BlobAccessConditions{ModifiedAccessConditions: azblob.ModifiedAccessConditions{
+		IfMatch:     azblob.ETag(conditions.IfMatch),
+		IfNoneMatch: azblob.ETag(conditions.IfNoneMatch),
+	}}
+}
+
+// convertWriteError converts the error of an upload, the service answers an existing blob
+// with BlobAlreadyExists instead of a failed precondition if IfNoneMatch is set
+func convertWriteError(fileName string, conditions storageabstraction.Conditions, err error) error {
+	err = convertError("write", fileName, err)
+	if conditions.IfNoneMatch != "" && errors.Is(err, storageabstraction.ErrAlreadyExists) {
+		return storageabstraction.WrapError(storageabstraction.ErrPreconditionFailed, err)
+	}
+	return err
+}
@@ -3,6 +3,7 @@ package azureblobs
 import (
 	"github.com/Azure/azure-storage-blob-go/azblob"
 	"io/fs"
+	"path"
 	"time"
 )
 
@@ -23,7 +24,7 @@ func (fileInfo *AzureFileInfo) Name() string {
 		return ""
 	}
 
-	return fileInfo.blobInfo.Name
+	return path.Base(fileInfo.blobInfo.Name)
 }
 
 func (fileInfo *AzureFileInfo) Size() int64 {
@@ -34,7 +35,7 @@ func (fileInfo *AzureFileInfo) Size() int64 {
 }
 
 func (fileInfo *AzureFileInfo) Mode() fs.FileMode {
-	return fs.ModeIrregular
+	return 0666
 }
 
 func (fileInfo *AzureFileInfo) ModTime() time.Time {
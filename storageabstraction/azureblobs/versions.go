@@ -0,0 +1,166 @@
+package azureblobs
+
+import (
+	"context"
+	"errors"
+	"github.com/2flow/gokies/storageabstraction"
+	"github.com/2flow/gokies/storageabstraction/common"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+)
+
+// WithSnapshotBeforeOverwrite creates a snapshot of a blob before it is overwritten, so the previous content can be
+// restored with RestoreVersion. The snapshots are billed like blobs, a lifecycle policy of the account should delete them
+func WithSnapshotBeforeOverwrite() Option {
+	return func(azureStorage *tAzureFileStorage) {
+		azureStorage.snapshotOverwrites = true
+	}
+}
+
+func (azureStorage *tAzureFileStorage) Snapshot(fileName string) (string, error) {
+	return azureStorage.SnapshotContext(context.Background(), fileName)
+}
+
+// SnapshotContext creates a snapshot of the blob, the id of the snapshot is its creation time
+func (azureStorage *tAzureFileStorage) SnapshotContext(ctx context.Context, fileName string) (snapshot string, err error) {
+	defer func(start time.Time) {
+		common.LogOperation(azureStorage.logger, "snapshot", fileName, -1, start, err, "version", snapshot)
+	}(time.Now())
+
+	if err = common.ValidatePath(fileName); err != nil {
+		return "", err
+	}
+
+	return azureStorage.createSnapshot(ctx, fileName)
+}
+
+func (azureStorage *tAzureFileStorage) createSnapshot(ctx context.Context, fileName string) (string, error) {
+	_, blobURL := azureStorage.getBlobURL(fileName)
+	response, err := blobURL.CreateSnapshot(ctx, azblob.Metadata{}, azblob.BlobAccessConditions{}, azblob.ClientProvidedKeyOptions{})
+	if err != nil {
+		return "", convertError("snapshot", fileName, err)
+	}
+	return response.Snapshot(), nil
+}
+
+// snapshotBeforeOverwrite keeps the current content of the blob if the option is set, new blobs have no snapshot
+func (azureStorage *tAzureFileStorage) snapshotBeforeOverwrite(ctx context.Context, fileName string) error {
+	if !azureStorage.snapshotOverwrites {
+		return nil
+	}
+
+	_, err := azureStorage.createSnapshot(ctx, fileName)
+	if errors.Is(err, storageabstraction.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (azureStorage *tAzureFileStorage) Versions(path string) ([]storageabstraction.FileVersion, error) {
+	return azureStorage.VersionsContext(context.Background(), path)
+}
+
+// VersionsContext lists the snapshots and the soft-deleted blobs of the blob or of the virtual directory
+func (azureStorage *tAzureFileStorage) VersionsContext(ctx context.Context, path string) (versions []storageabstraction.FileVersion, err error) {
+	defer func(start time.Time) {
+		if err != nil {
+			common.LogOperation(azureStorage.logger, "versions", path, -1, start, err)
+		}
+	}(time.Now())
+
+	if err = common.ValidatePath(path); err != nil {
+		return nil, err
+	}
+
+	prefix := strings.Trim(path, "/")
+	_, containerURL := azureStorage.getContainerURL()
+	options := azblob.ListBlobsSegmentOptions{Prefix: prefix,
+		Details: azblob.BlobListingDetails{Snapshots: true, Deleted: true}}
+
+	for marker := (azblob.Marker{}); marker.NotDone(); {
+		listBlob, err := containerURL.ListBlobsFlatSegment(ctx, marker, options)
+		if err != nil {
+			return nil, convertError("versions", path, err)
+		}
+		marker = listBlob.NextMarker
+
+		for _, blobInfo := range listBlob.Segment.BlobItems {
+			// the prefix also matches the blobs which only start with the name of the file
+			if blobInfo.Name != prefix && !strings.HasPrefix(blobInfo.Name, directoryPrefix(prefix)) {
+				continue
+			}
+			if blobInfo.Snapshot != "" || blobInfo.Deleted {
+				versions = append(versions, newFileVersion(blobInfo))
+			}
+		}
+	}
+
+	sort.SliceStable(versions, func(i, j int) bool {
+		if versions[i].Path != versions[j].Path {
+			return versions[i].Path < versions[j].Path
+		}
+		return versions[i].Created.Before(versions[j].Created)
+	})
+	return versions, nil
+}
+
+// newFileVersion converts a snapshot or a soft-deleted blob of the listing
+func newFileVersion(blobInfo azblob.BlobItemInternal) storageabstraction.FileVersion {
+	version := storageabstraction.FileVersion{
+		Path:    blobInfo.Name,
+		ID:      blobInfo.Snapshot,
+		ModTime: blobInfo.Properties.LastModified,
+		Deleted: blobInfo.Deleted,
+	}
+	if blobInfo.Properties.ContentLength != nil {
+		version.Size = *blobInfo.Properties.ContentLength
+	}
+
+	if version.ID != "" {
+		// the snapshot is the creation time in the ISO 8601 format with 7 fractional digits
+		version.Created, _ = time.Parse(time.RFC3339Nano, version.ID)
+	} else if blobInfo.Properties.DeletedTime != nil {
+		version.Created = *blobInfo.Properties.DeletedTime
+	}
+	return version
+}
+
+func (azureStorage *tAzureFileStorage) RestoreVersion(fileName string, versionID string) error {
+	return azureStorage.RestoreVersionContext(context.Background(), fileName, versionID)
+}
+
+// RestoreVersionContext copies the snapshot over the blob, with WithSnapshotBeforeOverwrite the restore can be undone
+func (azureStorage *tAzureFileStorage) RestoreVersionContext(ctx context.Context, fileName string, versionID string) (err error) {
+	defer func(start time.Time) {
+		common.LogOperation(azureStorage.logger, "restore", fileName, -1, start, err, "version", versionID)
+	}(time.Now())
+
+	if err = common.ValidatePath(fileName); err != nil {
+		return err
+	}
+
+	_, blobURL := azureStorage.getBlobURL(fileName)
+	return azureStorage.copyBlob(ctx, blobURL.WithSnapshot(versionID).URL(), fileName+"@"+versionID, fileName)
+}
+
+func (azureStorage *tAzureFileStorage) Undelete(fileName string) error {
+	return azureStorage.UndeleteContext(context.Background(), fileName)
+}
+
+// UndeleteContext restores the soft-deleted blob and its snapshots, soft delete must be enabled for the account
+func (azureStorage *tAzureFileStorage) UndeleteContext(ctx context.Context, fileName string) (err error) {
+	defer func(start time.Time) {
+		common.LogOperation(azureStorage.logger, "undelete", fileName, -1, start, err)
+	}(time.Now())
+
+	if err = common.ValidatePath(fileName); err != nil {
+		return err
+	}
+
+	_, blobURL := azureStorage.getBlobURL(fileName)
+	_, err = blobURL.Undelete(ctx)
+	return convertError("undelete", fileName, err)
+}
@@ -0,0 +1,233 @@
+package cache
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"github.com/2flow/gokies/storageabstraction"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+)
+
+const (
+	defaultTTL         = 5 * time.Minute
+	defaultMaxSize     = 64 * 1024 * 1024
+	defaultMaxFileSize = 4 * 1024 * 1024
+)
+
+// Option configures the cache
+type Option func(storage *cacheStorage)
+
+// WithTTL sets how long the files are cached, changes which are not made through the cache are visible after it.
+// The default is 5 minutes
+func WithTTL(ttl time.Duration) Option {
+	return func(storage *cacheStorage) {
+		if ttl > 0 {
+			storage.ttl = ttl
+		}
+	}
+}
+
+// WithMaxSize sets the size of all cached contents in bytes, the least recently used files are removed from the
+// cache to stay below it. The default is 64 MiB
+func WithMaxSize(maxSize int64) Option {
+	return func(storage *cacheStorage) {
+		if maxSize > 0 {
+			storage.maxSize = maxSize
+		}
+	}
+}
+
+// WithMaxFileSize sets the size of the largest file which is cached, the default is 4 MiB
+func WithMaxFileSize(maxFileSize int64) Option {
+	return func(storage *cacheStorage) {
+		if maxFileSize > 0 {
+			storage.maxFileSize = maxFileSize
+		}
+	}
+}
+
+// WithCacheStorage keeps the cached contents in the storage instead of the memory, e.g. a local storage on an empty
+// directory. Files of the storage which were not cached by this cache are not used and not removed
+func WithCacheStorage(cache storageabstraction.IFileStorage) Option {
+	return func(storage *cacheStorage) {
+		storage.cache = storageabstraction.WithContext(cache)
+	}
+}
+
+// WithLogger logs the failures of the cache storage, by default nothing is logged
+func WithLogger(logger log.Logger) Option {
+	return func(storage *cacheStorage) {
+		storage.logger = logger
+	}
+}
+
+// entry is a cached file, info and content are cached independently
+type entry struct {
+	key        string
+	info       *storageabstraction.FileInfo
+	hasContent bool  // the content is in the cache storage
+	size       int64 // the size of the cached content
+	expires    time.Time
+	element    *list.Element
+}
+
+// cacheKey is the cleaned path of the file, so "/index.html" and "index.html" are the same file
+func cacheKey(fileName string) string {
+	return strings.TrimPrefix(path.Clean("/"+fileName), "/")
+}
+
+// lookup returns the entry of the file if it is not expired, it becomes the most recently used entry
+func (storage *cacheStorage) lookup(key string) (entry, bool) {
+	storage.lock.Lock()
+	defer storage.lock.Unlock()
+
+	cached, ok := storage.entries[key]
+	if !ok {
+		return entry{}, false
+	}
+	if time.Now().After(cached.expires) {
+		storage.remove(cached)
+		return entry{}, false
+	}
+
+	storage.lru.MoveToFront(cached.element)
+	return *cached, true
+}
+
+// entry returns the entry of the file, a new entry is created if there is none. The lock must be held
+func (storage *cacheStorage) entry(key string) *entry {
+	if cached, ok := storage.entries[key]; ok {
+		storage.lru.MoveToFront(cached.element)
+		return cached
+	}
+
+	cached := &entry{key: key, expires: time.Now().Add(storage.ttl)}
+	cached.element = storage.lru.PushFront(cached)
+	storage.entries[key] = cached
+	return cached
+}
+
+// storeInfo caches the info of the file, unless the file was changed since generation
+func (storage *cacheStorage) storeInfo(key string, info *storageabstraction.FileInfo, generation uint64) {
+	storage.lock.Lock()
+	defer storage.lock.Unlock()
+
+	if generation == storage.generation {
+		storage.entry(key).info = info
+	}
+}
+
+// storeContent caches the content of the file, unless the file was changed since generation. The least recently
+// used contents are removed, until the cached contents fit into the max size
+func (storage *cacheStorage) storeContent(key string, content []byte, generation uint64) {
+	storage.lock.Lock()
+	defer storage.lock.Unlock()
+
+	if generation != storage.generation || int64(len(content)) > storage.maxFileSize {
+		return
+	}
+
+	cached := storage.entry(key)
+	if cached.hasContent {
+		return
+	}
+	err := storage.cache.WriteContext(context.Background(), key, int64(len(content)), bytes.NewReader(content))
+	if err != nil {
+		_ = level.Error(storage.logger).Log("msg", "Unable to write to the cache", "path", key, "err", err)
+		return
+	}
+	cached.hasContent = true
+	cached.size = int64(len(content))
+	storage.size += cached.size
+
+	storage.prune(cached)
+}
+
+// prune removes the expired entries and the least recently used contents until the cached contents fit into
+// the max size, except the entry keep. The lock must be held
+func (storage *cacheStorage) prune(keep *entry) {
+	now := time.Now()
+	for element := storage.lru.Back(); element != nil; {
+		previous := element.Prev()
+		oldest := element.Value.(*entry)
+		if oldest != keep && (now.After(oldest.expires) || (storage.size > storage.maxSize && oldest.hasContent)) {
+			storage.remove(oldest)
+		}
+		element = previous
+	}
+}
+
+// invalidate removes the files from the cache, with prefix all files of the directory are removed.
+// Reads which started before are not cached
+func (storage *cacheStorage) invalidate(key string, prefix bool) {
+	storage.lock.Lock()
+	defer storage.lock.Unlock()
+
+	storage.generation++
+	if !prefix {
+		if cached, ok := storage.entries[key]; ok {
+			storage.remove(cached)
+		}
+		return
+	}
+
+	for _, cached := range storage.entries {
+		if key == "" || strings.HasPrefix(cached.key, key+"/") {
+			storage.remove(cached)
+		}
+	}
+}
+
+// remove deletes the entry and its cached content, the lock must be held
+func (storage *cacheStorage) remove(cached *entry) {
+	storage.lru.Remove(cached.element)
+	delete(storage.entries, cached.key)
+	if !cached.hasContent {
+		return
+	}
+
+	storage.size -= cached.size
+	if err := storage.cache.DeleteFileContext(context.Background(), cached.key); err != nil {
+		_ = level.Error(storage.logger).Log("msg", "Unable to remove from the cache", "path", cached.key, "err", err)
+	}
+}
+
+// currentGeneration is taken before a read of the wrapped storage, its result is only cached if no file was changed
+func (storage *cacheStorage) currentGeneration() uint64 {
+	storage.lock.Lock()
+	defer storage.lock.Unlock()
+	return storage.generation
+}
+
+// cachingReader passes the content of the file through and caches it when it is read completely
+type cachingReader struct {
+	io.ReadCloser
+	buffer   bytes.Buffer
+	limit    int64
+	tooLarge bool
+	done     func(content []byte)
+}
+
+func (reader *cachingReader) Read(p []byte) (n int, err error) {
+	n, err = reader.ReadCloser.Read(p)
+	if !reader.tooLarge {
+		if int64(reader.buffer.Len()+n) > reader.limit {
+			reader.tooLarge = true
+			reader.buffer = bytes.Buffer{}
+		} else {
+			reader.buffer.Write(p[:n])
+		}
+	}
+
+	if err == io.EOF && !reader.tooLarge && reader.done != nil {
+		reader.done(reader.buffer.Bytes())
+		reader.done = nil
+	}
+	return n, err
+}
@@ -0,0 +1,180 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"github.com/2flow/gokies/storageabstraction"
+	"github.com/2flow/gokies/storageabstraction/localstorage"
+	"github.com/2flow/gokies/storageabstraction/memorystorage"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// countingStorage counts the reads and stats which reach the wrapped storage
+type countingStorage struct {
+	storageabstraction.IContextFileStorage
+	reads int
+	stats int
+}
+
+func (storage *countingStorage) ReadContext(ctx context.Context, fileName string) (io.ReadCloser, error) {
+	storage.reads++
+	return storage.IContextFileStorage.ReadContext(ctx, fileName)
+}
+
+func (storage *countingStorage) Stat(fileName string) (*storageabstraction.FileInfo, error) {
+	return storage.StatContext(context.Background(), fileName)
+}
+
+func (storage *countingStorage) StatContext(ctx context.Context, fileName string) (*storageabstraction.FileInfo, error) {
+	storage.stats++
+	return storageabstraction.StatContext(ctx, storage.IContextFileStorage, fileName)
+}
+
+func newCountingStorage() *countingStorage {
+	return &countingStorage{IContextFileStorage: memorystorage.NewMemoryStorage()}
+}
+
+func readAll(t *testing.T, storage storageabstraction.IFileStorage, fileName string) string {
+	reader, err := storage.Read(fileName)
+	if err != nil {
+		t.Errorf("Error reading %s: %v", fileName, err)
+		return ""
+	}
+	defer reader.Close()
+
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		t.Errorf("Error reading %s: %v", fileName, err)
+	}
+	return string(content)
+}
+
+func write(t *testing.T, storage storageabstraction.IFileStorage, fileName string, content string) bool {
+	if err := storage.Write(fileName, int64(len(content)), strings.NewReader(content)); err != nil {
+		t.Errorf("[TestError] Error writing %s: %v", fileName, err)
+		return false
+	}
+	return true
+}
+
+func TestCacheStorage(t *testing.T) {
+	wrapped := newCountingStorage()
+	storage := NewCacheStorage(wrapped)
+	if !write(t, storage, "site/index.html", "<html>") {
+		return
+	}
+
+	if readAll(t, storage, "site/index.html") != "<html>" || readAll(t, storage, "/site/index.html") != "<html>" || wrapped.reads != 1 {
+		t.Errorf("Expected one read of the wrapped storage, actual: %d", wrapped.reads)
+	}
+	reader, err := storageabstraction.ReadRange(storage, "site/index.html", 1, 4)
+	if err != nil {
+		t.Errorf("Error reading range: %v", err)
+		return
+	}
+	content, _ := io.ReadAll(reader)
+	_ = reader.Close()
+	if string(content) != "html" || wrapped.reads != 1 {
+		t.Errorf("Expected the range of the cached content, actual: %s, reads: %d", content, wrapped.reads)
+	}
+
+	for i := 0; i < 2; i++ {
+		if info, err := storageabstraction.Stat(storage, "site/index.html"); err != nil || info.Size() != 6 || wrapped.stats != 1 {
+			t.Errorf("Expected one stat of the wrapped storage, actual: %v, stats: %d", err, wrapped.stats)
+		}
+	}
+
+	// the changes through the cache are visible immediately
+	if !write(t, storage, "site/index.html", "<html></html>") {
+		return
+	}
+	if readAll(t, storage, "site/index.html") != "<html></html>" || wrapped.reads != 2 {
+		t.Errorf("Expected the new content after the write, reads: %d", wrapped.reads)
+	}
+	if info, err := storageabstraction.Stat(storage, "site/index.html"); err != nil || info.Size() != 13 {
+		t.Errorf("Expected the new size after the write, actual: %v", err)
+	}
+	if err = storage.DeleteDirectory("site"); err != nil {
+		t.Errorf("Error deleting directory: %v", err)
+	}
+	if _, err = storage.Read("site/index.html"); !errors.Is(err, storageabstraction.ErrNotExist) {
+		t.Errorf("Expected not exist error after the delete, actual: %v", err)
+	}
+
+	// a partial read is not cached
+	if !write(t, storage, "partial.txt", "content") {
+		return
+	}
+	reader, _ = storage.Read("partial.txt")
+	_, _ = reader.Read(make([]byte, 2))
+	_ = reader.Close()
+	reads := wrapped.reads
+	if readAll(t, storage, "partial.txt") != "content" || wrapped.reads != reads+1 {
+		t.Errorf("Expected partial read not to be cached, reads: %d", wrapped.reads-reads)
+	}
+}
+
+func TestCacheStorageEviction(t *testing.T) {
+	wrapped := newCountingStorage()
+	storage := NewCacheStorage(wrapped, WithMaxSize(10), WithMaxFileSize(8))
+	if !write(t, storage, "a.txt", "aaaaaa") || !write(t, storage, "b.txt", "bbbbbb") || !write(t, storage, "large.txt", "large file") {
+		return
+	}
+
+	// a is removed for b, the least recently used content is removed first
+	readAll(t, storage, "a.txt")
+	readAll(t, storage, "b.txt")
+	readAll(t, storage, "b.txt")
+	readAll(t, storage, "a.txt")
+	if wrapped.reads != 3 {
+		t.Errorf("Expected a to be removed from the cache, reads: %d", wrapped.reads)
+	}
+	readAll(t, storage, "large.txt")
+	readAll(t, storage, "large.txt")
+	if wrapped.reads != 5 {
+		t.Errorf("Expected files larger than the max file size not to be cached, reads: %d", wrapped.reads)
+	}
+
+	storage = NewCacheStorage(wrapped, WithTTL(10*time.Millisecond))
+	wrapped.reads = 0
+	readAll(t, storage, "a.txt")
+	time.Sleep(20 * time.Millisecond)
+	readAll(t, storage, "a.txt")
+	if wrapped.reads != 2 {
+		t.Errorf("Expected the content to expire, reads: %d", wrapped.reads)
+	}
+}
+
+func TestCacheStorageOnDisk(t *testing.T) {
+	wrapped := newCountingStorage()
+	cacheDir := t.TempDir()
+	storage := NewCacheStorage(wrapped, WithCacheStorage(localstorage.NewLocalStorage(cacheDir)))
+	if !write(t, storage, "js/app.js", "console.log()") {
+		return
+	}
+
+	if readAll(t, storage, "js/app.js") != "console.log()" || readAll(t, storage, "js/app.js") != "console.log()" || wrapped.reads != 1 {
+		t.Errorf("Expected one read of the wrapped storage, actual: %d", wrapped.reads)
+	}
+	if content, err := os.ReadFile(filepath.Join(cacheDir, "js", "app.js")); err != nil || string(content) != "console.log()" {
+		t.Errorf("Expected the content in the cache directory: %v", err)
+	}
+
+	// a removed cache file is read again
+	_ = os.Remove(filepath.Join(cacheDir, "js", "app.js"))
+	if readAll(t, storage, "js/app.js") != "console.log()" || wrapped.reads != 2 {
+		t.Errorf("Expected a read of the wrapped storage, actual: %d", wrapped.reads)
+	}
+
+	if err := storage.DeleteFile("js/app.js"); err != nil {
+		t.Errorf("Error deleting file: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(cacheDir, "js", "app.js")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected the file to be removed from the cache directory: %v", err)
+	}
+}
@@ -0,0 +1,336 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"github.com/2flow/gokies/storageabstraction"
+	"github.com/2flow/gokies/storageabstraction/memorystorage"
+	"io"
+	"io/fs"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+)
+
+// cacheStorage caches the contents and the details of the files of the wrapped storage
+type cacheStorage struct {
+	storage storageabstraction.IFileStorage
+	cache   storageabstraction.IContextFileStorage
+	logger  log.Logger
+
+	ttl         time.Duration
+	maxSize     int64
+	maxFileSize int64
+
+	lock    sync.Mutex
+	entries map[string]*entry
+	// lru has the most recently used entry in front
+	lru  *list.List
+	size int64
+	// generation is incremented whenever files are changed through the cache
+	generation uint64
+}
+
+// NewCacheStorage wraps the storage, so the contents and the details of its files are cached. The contents are
+// cached when they are read completely, by default in the memory. Writes and deletes through the cache remove the
+// files from the cache, changes of others are visible after the TTL. Walks and lists are not cached
+func NewCacheStorage(storage storageabstraction.IFileStorage, options ...Option) storageabstraction.IContextFileStorage {
+	cache := &cacheStorage{
+		storage:     storage,
+		cache:       memorystorage.NewMemoryStorage(),
+		logger:      log.NewNopLogger(),
+		ttl:         defaultTTL,
+		maxSize:     defaultMaxSize,
+		maxFileSize: defaultMaxFileSize,
+		entries:     map[string]*entry{},
+		lru:         list.New(),
+	}
+	for _, option := range options {
+		option(cache)
+	}
+
+	return cache
+}
+
+func (storage *cacheStorage) contextStorage() storageabstraction.IContextFileStorage {
+	return storageabstraction.WithContext(storage.storage)
+}
+
+func (storage *cacheStorage) Read(fileName string) (io.ReadCloser, error) {
+	return storage.ReadContext(context.Background(), fileName)
+}
+
+// ReadContext reads the cached content, or reads the file of the wrapped storage and caches it once it is read
+func (storage *cacheStorage) ReadContext(ctx context.Context, fileName string) (io.ReadCloser, error) {
+	if reader, ok := storage.readCached(ctx, fileName, 0, storageabstraction.CountToEnd); ok {
+		return reader, nil
+	}
+
+	generation := storage.currentGeneration()
+	reader, err := storage.contextStorage().ReadContext(ctx, fileName)
+	if err != nil {
+		return nil, err
+	}
+	return storage.cachingReader(fileName, reader, generation), nil
+}
+
+func (storage *cacheStorage) ReadRange(fileName string, offset int64, length int64) (io.ReadCloser, error) {
+	return storage.ReadRangeContext(context.Background(), fileName, offset, length)
+}
+
+// ReadRangeContext reads the range of the cached content. If the file is not cached, only a read of
+// the whole file is cached
+func (storage *cacheStorage) ReadRangeContext(ctx context.Context, fileName string, offset int64, length int64) (io.ReadCloser, error) {
+	if reader, ok := storage.readCached(ctx, fileName, offset, length); ok {
+		return reader, nil
+	}
+
+	generation := storage.currentGeneration()
+	reader, err := storageabstraction.ReadRangeContext(ctx, storage.storage, fileName, offset, length)
+	if err != nil || offset != 0 || length != storageabstraction.CountToEnd {
+		return reader, err
+	}
+	return storage.cachingReader(fileName, reader, generation), nil
+}
+
+// readCached returns the range of the cached content, if the content is cached
+func (storage *cacheStorage) readCached(ctx context.Context, fileName string, offset int64, length int64) (io.ReadCloser, bool) {
+	key := cacheKey(fileName)
+	if cached, ok := storage.lookup(key); !ok || !cached.hasContent {
+		return nil, false
+	}
+
+	reader, err := storageabstraction.ReadRangeContext(ctx, storage.cache, key, offset, length)
+	if err != nil {
+		// e.g. the directory of the cache was cleaned up, the file is read from the wrapped storage again
+		storage.invalidate(key, false)
+		return nil, false
+	}
+	return reader, true
+}
+
+func (storage *cacheStorage) cachingReader(fileName string, reader io.ReadCloser, generation uint64) io.ReadCloser {
+	key := cacheKey(fileName)
+	return &cachingReader{ReadCloser: reader, limit: storage.maxFileSize, done: func(content []byte) {
+		storage.storeContent(key, content, generation)
+	}}
+}
+
+func (storage *cacheStorage) Stat(fileName string) (*storageabstraction.FileInfo, error) {
+	return storage.StatContext(context.Background(), fileName)
+}
+
+// StatContext returns the cached details of the file, the details of directories are not cached
+func (storage *cacheStorage) StatContext(ctx context.Context, fileName string) (*storageabstraction.FileInfo, error) {
+	key := cacheKey(fileName)
+	if cached, ok := storage.lookup(key); ok && cached.info != nil {
+		return cached.info, nil
+	}
+
+	generation := storage.currentGeneration()
+	info, err := storageabstraction.StatContext(ctx, storage.storage, fileName)
+	if err == nil && !info.IsDir() {
+		storage.storeInfo(key, info, generation)
+	}
+	return info, err
+}
+
+func (storage *cacheStorage) FileSize(fileName string) (int64, error) {
+	return storage.FileSizeContext(context.Background(), fileName)
+}
+
+func (storage *cacheStorage) FileSizeContext(ctx context.Context, fileName string) (int64, error) {
+	if cached, ok := storage.lookup(cacheKey(fileName)); ok && cached.info != nil {
+		return cached.info.Size(), nil
+	}
+	return storage.contextStorage().FileSizeContext(ctx, fileName)
+}
+
+func (storage *cacheStorage) Write(fileName string, fileSize int64, reader io.ReadSeeker) error {
+	return storage.WriteContext(context.Background(), fileName, fileSize, reader)
+}
+
+func (storage *cacheStorage) WriteContext(ctx context.Context, fileName string, fileSize int64, reader io.ReadSeeker) error {
+	defer storage.invalidate(cacheKey(fileName), false)
+	return storage.contextStorage().WriteContext(ctx, fileName, fileSize, reader)
+}
+
+func (storage *cacheStorage) WriteWithOptions(fileName string, fileSize int64, reader io.ReadSeeker, options storageabstraction.WriteOptions) error {
+	return storage.WriteWithOptionsContext(context.Background(), fileName, fileSize, reader, options)
+}
+
+func (storage *cacheStorage) WriteWithOptionsContext(ctx context.Context, fileName string, fileSize int64, reader io.ReadSeeker, options storageabstraction.WriteOptions) error {
+	defer storage.invalidate(cacheKey(fileName), false)
+	return storageabstraction.WriteWithOptionsContext(ctx, storage.storage, fileName, fileSize, reader, options)
+}
+
+func (storage *cacheStorage) OpenWriter(fileName string) (io.WriteCloser, error) {
+	return storage.OpenWriterContext(context.Background(), fileName)
+}
+
+func (storage *cacheStorage) OpenWriterContext(ctx context.Context, fileName string) (io.WriteCloser, error) {
+	writer, err := storageabstraction.OpenWriterContext(ctx, storage.storage, fileName)
+	if err != nil {
+		return nil, err
+	}
+	return &invalidatingWriter{WriteCloser: writer, invalidate: func() { storage.invalidate(cacheKey(fileName), false) }}, nil
+}
+
+func (storage *cacheStorage) OpenWriterWithOptions(fileName string, options storageabstraction.WriteOptions) (io.WriteCloser, error) {
+	return storage.OpenWriterWithOptionsContext(context.Background(), fileName, options)
+}
+
+func (storage *cacheStorage) OpenWriterWithOptionsContext(ctx context.Context, fileName string, options storageabstraction.WriteOptions) (io.WriteCloser, error) {
+	writer, err := storageabstraction.OpenWriterWithOptionsContext(ctx, storage.storage, fileName, options)
+	if err != nil {
+		return nil, err
+	}
+	return &invalidatingWriter{WriteCloser: writer, invalidate: func() { storage.invalidate(cacheKey(fileName), false) }}, nil
+}
+
+// invalidatingWriter removes the file from the cache, when the written file is committed
+type invalidatingWriter struct {
+	io.WriteCloser
+	invalidate func()
+}
+
+func (writer *invalidatingWriter) Close() error {
+	defer writer.invalidate()
+	return writer.WriteCloser.Close()
+}
+
+func (storage *cacheStorage) DeleteDirectory(directory string) error {
+	return storage.DeleteDirectoryContext(context.Background(), directory)
+}
+
+func (storage *cacheStorage) DeleteDirectoryContext(ctx context.Context, directory string) error {
+	defer storage.invalidate(cacheKey(directory), true)
+	return storage.contextStorage().DeleteDirectoryContext(ctx, directory)
+}
+
+func (storage *cacheStorage) DeleteFile(fileName string) error {
+	return storage.DeleteFileContext(context.Background(), fileName)
+}
+
+func (storage *cacheStorage) DeleteFileContext(ctx context.Context, fileName string) error {
+	defer storage.invalidate(cacheKey(fileName), false)
+	return storage.contextStorage().DeleteFileContext(ctx, fileName)
+}
+
+func (storage *cacheStorage) DeleteFileWithConditions(fileName string, conditions storageabstraction.Conditions) error {
+	return storage.DeleteFileWithConditionsContext(context.Background(), fileName, conditions)
+}
+
+func (storage *cacheStorage) DeleteFileWithConditionsContext(ctx context.Context, fileName string, conditions storageabstraction.Conditions) error {
+	defer storage.invalidate(cacheKey(fileName), false)
+	return storageabstraction.DeleteFileWithConditionsContext(ctx, storage.storage, fileName, conditions)
+}
+
+func (storage *cacheStorage) Walk(directory string, walk storageabstraction.WalkFunc) error {
+	return storage.WalkContext(context.Background(), directory, walk)
+}
+
+func (storage *cacheStorage) WalkContext(ctx context.Context, directory string, walk storageabstraction.WalkFunc) error {
+	return storage.contextStorage().WalkContext(ctx, directory, walk)
+}
+
+func (storage *cacheStorage) List(directory string) ([]fs.FileInfo, error) {
+	return storage.ListContext(context.Background(), directory)
+}
+
+func (storage *cacheStorage) ListContext(ctx context.Context, directory string) ([]fs.FileInfo, error) {
+	return storageabstraction.ListContext(ctx, storage.storage, directory)
+}
+
+func (storage *cacheStorage) CopyFile(source string, destination string) error {
+	return storage.CopyFileContext(context.Background(), source, destination)
+}
+
+func (storage *cacheStorage) CopyFileContext(ctx context.Context, source string, destination string) error {
+	defer storage.invalidate(cacheKey(destination), false)
+	return storageabstraction.CopyFileContext(ctx, storage.storage, source, destination)
+}
+
+func (storage *cacheStorage) MoveFile(source string, destination string) error {
+	return storage.MoveFileContext(context.Background(), source, destination)
+}
+
+func (storage *cacheStorage) MoveFileContext(ctx context.Context, source string, destination string) error {
+	defer storage.invalidate(cacheKey(source), false)
+	defer storage.invalidate(cacheKey(destination), false)
+	return storageabstraction.MoveFileContext(ctx, storage.storage, source, destination)
+}
+
+func (storage *cacheStorage) CopyDirectory(source string, destination string) error {
+	return storage.CopyDirectoryContext(context.Background(), source, destination)
+}
+
+func (storage *cacheStorage) CopyDirectoryContext(ctx context.Context, source string, destination string) error {
+	defer storage.invalidate(cacheKey(destination), true)
+	return storageabstraction.CopyDirectoryContext(ctx, storage.storage, source, destination)
+}
+
+func (storage *cacheStorage) MoveDirectory(source string, destination string) error {
+	return storage.MoveDirectoryContext(context.Background(), source, destination)
+}
+
+func (storage *cacheStorage) MoveDirectoryContext(ctx context.Context, source string, destination string) error {
+	defer storage.invalidate(cacheKey(source), true)
+	defer storage.invalidate(cacheKey(destination), true)
+	return storageabstraction.MoveDirectoryContext(ctx, storage.storage, source, destination)
+}
+
+func (storage *cacheStorage) Join(paths ...string) string {
+	return storage.storage.Join(paths...)
+}
+
+func (storage *cacheStorage) Snapshot(fileName string) (string, error) {
+	return storage.SnapshotContext(context.Background(), fileName)
+}
+
+func (storage *cacheStorage) SnapshotContext(ctx context.Context, fileName string) (string, error) {
+	return storageabstraction.SnapshotContext(ctx, storage.storage, fileName)
+}
+
+func (storage *cacheStorage) Versions(path string) ([]storageabstraction.FileVersion, error) {
+	return storage.VersionsContext(context.Background(), path)
+}
+
+func (storage *cacheStorage) VersionsContext(ctx context.Context, path string) ([]storageabstraction.FileVersion, error) {
+	return storageabstraction.VersionsContext(ctx, storage.storage, path)
+}
+
+func (storage *cacheStorage) RestoreVersion(fileName string, versionID string) error {
+	return storage.RestoreVersionContext(context.Background(), fileName, versionID)
+}
+
+func (storage *cacheStorage) RestoreVersionContext(ctx context.Context, fileName string, versionID string) error {
+	defer storage.invalidate(cacheKey(fileName), false)
+	return storageabstraction.RestoreVersionContext(ctx, storage.storage, fileName, versionID)
+}
+
+func (storage *cacheStorage) Undelete(fileName string) error {
+	return storage.UndeleteContext(context.Background(), fileName)
+}
+
+func (storage *cacheStorage) UndeleteContext(ctx context.Context, fileName string) error {
+	defer storage.invalidate(cacheKey(fileName), false)
+	return storageabstraction.UndeleteContext(ctx, storage.storage, fileName)
+}
+
+func (storage *cacheStorage) TryLock(fileName string) (storageabstraction.ILock, error) {
+	return storage.TryLockContext(context.Background(), fileName)
+}
+
+func (storage *cacheStorage) TryLockContext(ctx context.Context, fileName string) (storageabstraction.ILock, error) {
+	return storageabstraction.TryLockContext(ctx, storage.storage, fileName)
+}
+
+func (storage *cacheStorage) Lock(fileName string) (storageabstraction.ILock, error) {
+	return storage.LockContext(context.Background(), fileName)
+}
+
+func (storage *cacheStorage) LockContext(ctx context.Context, fileName string) (storageabstraction.ILock, error) {
+	return storageabstraction.LockContext(ctx, storage.storage, fileName)
+}
@@ -0,0 +1,156 @@
+package cas
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/2flow/gokies/storageabstraction"
+	"hash"
+	"path"
+	"time"
+
+	"github.com/go-kit/log"
+)
+
+const (
+	blobsDirectory     = "blobs"
+	manifestsDirectory = "manifests"
+	// referencesDirectory has a marker for every reuse of an existing blob which may not be flushed yet
+	referencesDirectory = "references"
+	manifestExtension   = ".json"
+
+	defaultGracePeriod = time.Hour
+)
+
+// ICASFileStorage is a storage which keeps the contents of its files as blobs named by their SHA-256 hash, and the
+// paths of the files in a manifest. Writes, deletes, copies and moves only change the manifest in the memory,
+// Flush writes it as a new manifest into the wrapped storage. Flush fails with ErrConflict if another instance flushed
+// a manifest since the last Reload or Flush, the changes have to be written again after a Reload
+type ICASFileStorage interface {
+	storageabstraction.IContextFileStorage
+	storageabstraction.IFlushFileStorage
+
+	// Reload replaces the files with the ones of the latest manifest, e.g. one flushed by another instance.
+	// Changes which are not flushed are lost
+	Reload() error
+	ReloadContext(ctx context.Context) error
+	// GarbageCollect deletes all but the newest keep manifests, and the blobs which are neither referenced by
+	// them nor by the current files
+	GarbageCollect(keep int) (*Report, error)
+	GarbageCollectContext(ctx context.Context, keep int) (*Report, error)
+}
+
+// Report lists what the garbage collection deleted
+type Report struct {
+	// DeletedManifests are the names of the deleted manifests
+	DeletedManifests []string
+	// DeletedBlobs are the hashes of the deleted blobs
+	DeletedBlobs []string
+	// FreedBytes is the size of the deleted blobs
+	FreedBytes int64
+}
+
+// Option configures the content addressable storage
+type Option func(storage *casStorage)
+
+// WithLogger logs the flushed manifests and the garbage collections
+func WithLogger(logger log.Logger) Option {
+	return func(storage *casStorage) {
+		if logger != nil {
+			storage.logger = logger
+		}
+	}
+}
+
+// WithGracePeriod sets how old an unreferenced blob has to be before it is garbage collected, so blobs which other
+// instances wrote or reused but did not flush yet are kept. The default is 1 hour
+func WithGracePeriod(gracePeriod time.Duration) Option {
+	return func(storage *casStorage) {
+		if gracePeriod >= 0 {
+			storage.gracePeriod = gracePeriod
+		}
+	}
+}
+
+// manifest maps the paths of the files to their blobs
+type manifest struct {
+	Created time.Time         `json:"created"`
+	Files   map[string]*entry `json:"files"`
+}
+
+// entry is a file of the manifest, it is never modified once it is in the files
+type entry struct {
+	// Hash is the hex encoded SHA-256 hash of the content, it is the name of the blob
+	Hash            string    `json:"hash"`
+	Size            int64     `json:"size"`
+	ModTime         time.Time `json:"modTime"`
+	ContentType     string    `json:"contentType,omitempty"`
+	ContentEncoding string    `json:"contentEncoding,omitempty"`
+	ContentMD5      []byte    `json:"contentMD5,omitempty"`
+}
+
+// details are the details of the file with this entry, the ETag is the SHA-256 hash
+func (entry *entry) details(key string) storageabstraction.FileDetails {
+	return storageabstraction.FileDetails{
+		Name:            path.Base(key),
+		Size:            entry.Size,
+		ModTime:         entry.ModTime,
+		ContentType:     entry.ContentType,
+		ContentEncoding: entry.ContentEncoding,
+		ETag:            "\"" + entry.Hash + "\"",
+		ContentMD5:      entry.ContentMD5,
+	}
+}
+
+// blobPath is the path of the blob in the wrapped storage, the blobs are spread over directories by their first byte
+func blobPath(hash string) string {
+	return path.Join(blobsDirectory, hash[:2], hash)
+}
+
+// referencePath is the path of a new marker which references the blob, every reuse gets its own marker so the
+// garbage collection never deletes a marker which was just written
+func referencePath(hash string) (string, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return path.Join(referencesDirectory, hash[:2], hash+"."+hex.EncodeToString(id)), nil
+}
+
+// manifestName is the name of a manifest created at this time, the names sort by their creation
+func manifestName(created time.Time) string {
+	return fmt.Sprintf("%020d%s", created.UnixNano(), manifestExtension)
+}
+
+// hasher calculates the hashes of the content written into it
+type hasher struct {
+	sha256 hash.Hash
+	md5    hash.Hash
+	size   int64
+}
+
+func newHasher() *hasher {
+	return &hasher{sha256: sha256.New(), md5: md5.New()}
+}
+
+func (hasher *hasher) Write(p []byte) (n int, err error) {
+	_, _ = hasher.sha256.Write(p)
+	_, _ = hasher.md5.Write(p)
+	hasher.size += int64(len(p))
+	return len(p), nil
+}
+
+// entry creates the entry of the hashed content
+func (hasher *hasher) entry(options storageabstraction.WriteOptions) *entry {
+	return &entry{
+		Hash:            hex.EncodeToString(hasher.sha256.Sum(nil)),
+		Size:            hasher.size,
+		ModTime:         time.Now(),
+		ContentType:     options.ContentType,
+		ContentEncoding: options.ContentEncoding,
+		ContentMD5:      hasher.md5.Sum(nil),
+	}
+}
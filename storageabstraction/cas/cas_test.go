@@ -0,0 +1,331 @@
+package cas
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"github.com/2flow/gokies/storageabstraction"
+	"github.com/2flow/gokies/storageabstraction/localstorage"
+	"github.com/2flow/gokies/storageabstraction/memorystorage"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readAll returns the content of the reader, or the error as content
+func readAll(reader io.ReadCloser, err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	defer reader.Close()
+
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		return err.Error()
+	}
+	return string(content)
+}
+
+// files returns the paths of all files in the directory of the wrapped storage, e.g. the blobs
+func files(storage storageabstraction.IFileStorage, directory string) []string {
+	var paths []string
+	_ = storage.Walk(directory, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	return paths
+}
+
+func writeFiles(storage storageabstraction.IFileStorage, files map[string]string) error {
+	for fileName, content := range files {
+		if err := storage.Write(fileName, int64(len(content)), strings.NewReader(content)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestCASStorage(t *testing.T) {
+	wrapped := memorystorage.NewMemoryStorage()
+	storage, err := NewCASStorage(wrapped)
+	if err != nil {
+		t.Errorf("[TestError] Error creating storage: %v", err)
+		return
+	}
+
+	if err = writeFiles(storage, map[string]string{"site/index.html": "<html/>", "site/copy/index.html": "<html/>"}); err != nil {
+		t.Errorf("[TestError] Error writing files: %v", err)
+		return
+	}
+	writer, _ := storageabstraction.OpenWriter(storage, "site/js/app.js")
+	_, _ = writer.Write([]byte("console.log('cas')"))
+	if err = writer.Close(); err != nil {
+		t.Errorf("[TestError] Error closing writer: %v", err)
+		return
+	}
+
+	if paths := files(wrapped, blobsDirectory); len(paths) != 2 {
+		t.Errorf("Expected the same content to be stored once, actual blobs: %v", paths)
+	}
+	if content := readAll(storage.Read("site/copy/index.html")); content != "<html/>" {
+		t.Errorf("Expected the content of the file, actual: %q", content)
+	}
+	if content := readAll(storageabstraction.ReadRange(storage, "site/js/app.js", 8, 3)); content != "log" {
+		t.Errorf("Expected the range of the file, actual: %q", content)
+	}
+	info, err := storageabstraction.Stat(storage, "site/index.html")
+	if err != nil || info.Size() != 7 || info.ContentType() != "text/html; charset=utf-8" || len(info.ETag()) != 66 || info.ContentMD5() == nil {
+		t.Errorf("Expected the details of the file, actual: %v, err: %v", info, err)
+	}
+	entries, err := storageabstraction.List(storage, "site")
+	if err != nil || len(entries) != 3 || !entries[0].IsDir() || entries[1].Name() != "index.html" || entries[2].Name() != "js" {
+		t.Errorf("Expected the entries of the directory, actual: %v, err: %v", entries, err)
+	}
+	if err = storageabstraction.MoveDirectory(storage, "site/copy", "backup"); err != nil || len(files(wrapped, blobsDirectory)) != 2 {
+		t.Errorf("Expected the directory to be moved without new blobs, err: %v", err)
+	}
+
+	// the files are visible to others after the flush
+	other, err := NewCASStorage(wrapped)
+	if exists, _ := storageabstraction.Exists(other, "site/index.html"); err != nil || exists {
+		t.Errorf("Expected the files not to be visible before the flush, err: %v", err)
+		return
+	}
+	if err = storageabstraction.Flush(storage); err != nil {
+		t.Errorf("Error flushing: %v", err)
+		return
+	}
+	if err = other.Reload(); err != nil {
+		t.Errorf("Error loading the manifest: %v", err)
+		return
+	}
+	for fileName, expected := range map[string]string{"site/index.html": "<html/>", "backup/index.html": "<html/>", "site/js/app.js": "console.log('cas')"} {
+		if content := readAll(other.Read(fileName)); content != expected {
+			t.Errorf("Expected the flushed file %s, actual: %q", fileName, content)
+		}
+	}
+	if _, err = other.FileSize("site/copy/index.html"); !errors.Is(err, storageabstraction.ErrNotExist) {
+		t.Errorf("Expected the moved file not to exist, actual: %v", err)
+	}
+
+	// writing the same content again does not write the blob again
+	blob := blobPath(strings.Trim(info.ETag(), "\""))
+	before, _ := storageabstraction.Stat(wrapped, blob)
+	if err = other.Write("site/other.html", 7, strings.NewReader("<html/>")); err != nil {
+		t.Errorf("Error writing file: %v", err)
+	}
+	if after, _ := storageabstraction.Stat(wrapped, blob); !after.ModTime().Equal(before.ModTime()) {
+		t.Errorf("Expected the existing blob not to be written again")
+	}
+
+	err = storageabstraction.WriteWithOptions(other, "site/index.html", 4, strings.NewReader("new!"),
+		storageabstraction.WriteOptions{Conditions: storageabstraction.Conditions{IfMatch: "\"other\""}})
+	if !errors.Is(err, storageabstraction.ErrPreconditionFailed) {
+		t.Errorf("Expected the condition to fail, actual: %v", err)
+	}
+	if err = other.Write("site/index.html/file", 4, strings.NewReader("new!")); !errors.Is(err, storageabstraction.ErrConflict) {
+		t.Errorf("Expected a file inside of a file to conflict, actual: %v", err)
+	}
+}
+
+func TestCASStorageGarbageCollect(t *testing.T) {
+	wrapped := memorystorage.NewMemoryStorage()
+	storage, err := NewCASStorage(wrapped, WithGracePeriod(0))
+	if err != nil {
+		t.Errorf("[TestError] Error creating storage: %v", err)
+		return
+	}
+
+	releases := []map[string]string{
+		{"app/index.html": "release 1", "app/logo.svg": "<svg/>"},
+		{"app/index.html": "release 2", "app/logo.svg": "<svg/>"},
+		{"app/index.html": "release 3", "app/logo.svg": "<svg/>"},
+	}
+	for _, release := range releases {
+		if err = writeFiles(storage, release); err != nil {
+			t.Errorf("[TestError] Error writing release: %v", err)
+			return
+		}
+		if err = storage.Flush(); err != nil {
+			t.Errorf("[TestError] Error flushing release: %v", err)
+			return
+		}
+	}
+	if paths := files(wrapped, blobsDirectory); len(paths) != 4 {
+		t.Errorf("Expected a blob for every content, actual: %v", paths)
+	}
+
+	// the newest two manifests are kept, the first release is deleted
+	report, err := storage.GarbageCollect(2)
+	if err != nil || len(report.DeletedManifests) != 1 || len(report.DeletedBlobs) != 1 || report.FreedBytes != 9 {
+		t.Errorf("Expected the first release to be collected, actual: %+v, err: %v", report, err)
+	}
+	if manifests, _ := storageabstraction.List(wrapped, manifestsDirectory); len(manifests) != 2 {
+		t.Errorf("Expected two manifests to be kept, actual: %d", len(manifests))
+	}
+
+	// files which are not flushed yet are kept as well
+	if err = writeFiles(storage, map[string]string{"app/index.html": "release 4"}); err != nil {
+		t.Errorf("[TestError] Error writing release: %v", err)
+		return
+	}
+	report, err = storage.GarbageCollectContext(context.Background(), 1)
+	if err != nil || len(report.DeletedManifests) != 1 || len(report.DeletedBlobs) != 1 {
+		t.Errorf("Expected the second release to be collected, actual: %+v, err: %v", report, err)
+	}
+	for fileName, expected := range map[string]string{"app/index.html": "release 4", "app/logo.svg": "<svg/>"} {
+		if content := readAll(storage.Read(fileName)); content != expected {
+			t.Errorf("Expected %s to be readable after the collection, actual: %q", fileName, content)
+		}
+	}
+	if err = storage.Flush(); err != nil {
+		t.Errorf("Error flushing: %v", err)
+	}
+	if err = storage.Reload(); err != nil || readAll(storage.Read("app/index.html")) != "release 4" {
+		t.Errorf("Expected the latest manifest to be loaded, err: %v", err)
+	}
+
+	// blobs which are younger than the grace period are kept, they may belong to a write which is not flushed yet
+	young, _ := NewCASStorage(wrapped)
+	_ = wrapped.Write(blobPath("ab0000"), 3, strings.NewReader("new"))
+	if report, err = young.GarbageCollect(1); err != nil || len(report.DeletedBlobs) != 0 {
+		t.Errorf("Expected young blobs to be kept, actual: %+v, err: %v", report, err)
+	}
+}
+
+func TestCASStorageGarbageCollectKeepsReusedBlobs(t *testing.T) {
+	wrapped := memorystorage.NewMemoryStorage()
+	gracePeriod := 50 * time.Millisecond
+	storage, err := NewCASStorage(wrapped, WithGracePeriod(gracePeriod))
+	if err != nil {
+		t.Errorf("[TestError] Error creating storage: %v", err)
+		return
+	}
+	if err = writeFiles(storage, map[string]string{"app/index.html": "release 1"}); err != nil || storage.Flush() != nil {
+		t.Errorf("[TestError] Error writing release: %v", err)
+		return
+	}
+	if err = storage.DeleteFile("app/index.html"); err != nil || storage.Flush() != nil {
+		t.Errorf("[TestError] Error deleting release: %v", err)
+		return
+	}
+	time.Sleep(2 * gracePeriod)
+
+	// the blob is older than the grace period, but it is reused by a write which is not flushed yet
+	if err = writeFiles(storage, map[string]string{"app/index.html": "release 1"}); err != nil {
+		t.Errorf("[TestError] Error writing release again: %v", err)
+		return
+	}
+	other, _ := NewCASStorage(wrapped, WithGracePeriod(gracePeriod))
+	if report, err := other.GarbageCollect(1); err != nil || len(report.DeletedBlobs) != 0 {
+		t.Errorf("Expected the reused blob to be kept, actual: %+v, err: %v", report, err)
+	}
+	if err = storage.Flush(); err != nil {
+		t.Errorf("Error flushing: %v", err)
+		return
+	}
+	if content := readAll(storage.Read("app/index.html")); content != "release 1" {
+		t.Errorf("Expected the reused blob to be readable, actual: %q", content)
+	}
+
+	// once the manifest is flushed the references are not needed anymore
+	time.Sleep(2 * gracePeriod)
+	if report, err := other.GarbageCollect(1); err != nil || len(report.DeletedBlobs) != 0 {
+		t.Errorf("Expected the flushed blob to be kept, actual: %+v, err: %v", report, err)
+	}
+	if references := files(wrapped, referencesDirectory); len(references) != 0 {
+		t.Errorf("Expected the old references to be deleted, actual: %v", references)
+	}
+}
+
+func TestCASStorageFlushConflict(t *testing.T) {
+	wrapped := memorystorage.NewMemoryStorage()
+	storage, err := NewCASStorage(wrapped)
+	if err != nil {
+		t.Errorf("[TestError] Error creating storage: %v", err)
+		return
+	}
+	other, _ := NewCASStorage(wrapped)
+
+	if err = writeFiles(storage, map[string]string{"app/index.html": "storage"}); err != nil || storage.Flush() != nil {
+		t.Errorf("[TestError] Error writing file: %v", err)
+		return
+	}
+	if err = writeFiles(other, map[string]string{"app/logo.svg": "<svg/>"}); err != nil {
+		t.Errorf("[TestError] Error writing file: %v", err)
+		return
+	}
+	if err = other.Flush(); !errors.Is(err, storageabstraction.ErrConflict) {
+		t.Errorf("Expected the flush to conflict with the manifest of the other instance, actual: %v", err)
+	}
+	if manifests, _ := storageabstraction.List(wrapped, manifestsDirectory); len(manifests) != 1 {
+		t.Errorf("Expected the manifest not to be overwritten, actual: %v", manifests)
+	}
+
+	// after a reload the changes can be flushed
+	if err = other.Reload(); err != nil {
+		t.Errorf("Error loading the manifest: %v", err)
+		return
+	}
+	if err = writeFiles(other, map[string]string{"app/logo.svg": "<svg/>"}); err != nil || other.Flush() != nil {
+		t.Errorf("Expected the flush to succeed after the reload, err: %v", err)
+		return
+	}
+	if err = storage.Reload(); err != nil || readAll(storage.Read("app/index.html")) != "storage" || readAll(storage.Read("app/logo.svg")) != "<svg/>" {
+		t.Errorf("Expected the files of both instances, err: %v", err)
+	}
+}
+
+// cancelReader cancels the context once more than limit bytes were read
+type cancelReader struct {
+	io.ReadSeeker
+	cancel context.CancelFunc
+	read   int64
+	limit  int64
+}
+
+func (reader *cancelReader) Read(p []byte) (n int, err error) {
+	if reader.read > reader.limit {
+		reader.cancel()
+	}
+	n, err = reader.ReadSeeker.Read(p)
+	reader.read += int64(n)
+	return n, err
+}
+
+func TestCASStorageCancelledBlob(t *testing.T) {
+	wrapped := localstorage.NewLocalStorage(t.TempDir())
+	storage, err := NewCASStorage(wrapped)
+	if err != nil {
+		t.Errorf("[TestError] Error creating storage: %v", err)
+		return
+	}
+	content := strings.Repeat("cancelled blob\n", 10000)
+	blob := blobPath(fmt.Sprintf("%x", sha256.Sum256([]byte(content))))
+
+	// the content is read once to hash it, the write of the blob is cancelled halfway
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reader := &cancelReader{ReadSeeker: strings.NewReader(content), cancel: cancel, limit: int64(len(content) * 3 / 2)}
+	if err = storage.WriteContext(ctx, "app/index.html", int64(len(content)), reader); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the write to be cancelled, actual: %v", err)
+	}
+	if exists, _ := storageabstraction.Exists(wrapped, blob); exists {
+		t.Errorf("Expected no blob of the cancelled write")
+	}
+
+	// a blob which was not written completely is replaced
+	_ = wrapped.Write(blob, 9, strings.NewReader(content[:9]))
+	if err = storage.Write("app/index.html", int64(len(content)), strings.NewReader(content)); err != nil {
+		t.Errorf("Error writing again: %v", err)
+		return
+	}
+	if actual := readAll(storage.Read("app/index.html")); actual != content {
+		t.Errorf("Expected the whole content, actual: %d bytes", len(actual))
+	}
+}
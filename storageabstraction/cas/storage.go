@@ -0,0 +1,862 @@
+package cas
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/2flow/gokies/storageabstraction"
+	"github.com/2flow/gokies/storageabstraction/common"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+)
+
+// casStorage keeps the contents as blobs in the wrapped storage and the manifest of the files in the memory
+type casStorage struct {
+	storage     storageabstraction.IContextFileStorage
+	logger      log.Logger
+	gracePeriod time.Duration
+
+	lock  sync.RWMutex
+	files map[string]*entry
+	// generation is incremented on every change of the files, flushed is the generation of the last manifest
+	generation uint64
+	flushed    uint64
+	// latest is the name of the manifest which was loaded or flushed last, empty if there was none
+	latest string
+
+	// flushLock serializes flushes and reloads, so the newest manifest always has the latest files
+	flushLock sync.Mutex
+	// collectLock is held by the writes while they add a blob and by the garbage collection while it deletes blobs
+	collectLock sync.RWMutex
+}
+
+// NewCASStorage creates a content addressable storage on top of the storage and loads the files of its latest
+// manifest. The blobs are stored in the directory "blobs", the manifests in "manifests". A content is only written
+// if there is no blob with its hash yet, so files which are written again with the same content are not transferred.
+// Reusing a blob writes an empty marker into "references" instead, it keeps the blob for the grace period
+func NewCASStorage(storage storageabstraction.IFileStorage, options ...Option) (ICASFileStorage, error) {
+	cas := &casStorage{
+		storage:     storageabstraction.WithContext(storage),
+		logger:      log.NewNopLogger(),
+		gracePeriod: defaultGracePeriod,
+		files:       map[string]*entry{},
+	}
+	for _, option := range options {
+		option(cas)
+	}
+
+	if err := cas.ReloadContext(context.Background()); err != nil {
+		return nil, err
+	}
+	return cas, nil
+}
+
+// cleanPath converts the file name to the key used in the manifest, paths which escape from the root are rejected
+func cleanPath(fileName string) (string, error) {
+	return common.CleanPath(fileName)
+}
+
+// isInDirectory checks if the key is a (sub) entry of the directory
+func isInDirectory(key string, directory string) bool {
+	return directory == "" || strings.HasPrefix(key, directory+"/")
+}
+
+func (storage *casStorage) Write(fileName string, fileSize int64, reader io.ReadSeeker) error {
+	return storage.WriteContext(context.Background(), fileName, fileSize, reader)
+}
+
+func (storage *casStorage) WriteContext(ctx context.Context, fileName string, fileSize int64, reader io.ReadSeeker) error {
+	return storage.WriteWithOptionsContext(ctx, fileName, fileSize, reader, storageabstraction.WriteOptions{})
+}
+
+func (storage *casStorage) WriteWithOptions(fileName string, fileSize int64, reader io.ReadSeeker, options storageabstraction.WriteOptions) error {
+	return storage.WriteWithOptionsContext(context.Background(), fileName, fileSize, reader, options)
+}
+
+// WriteWithOptionsContext hashes the content and writes it as blob if there is none with its hash yet.
+// Only the content type, the content encoding and the conditions of the options are used
+func (storage *casStorage) WriteWithOptionsContext(ctx context.Context, fileName string, _ int64, reader io.ReadSeeker, options storageabstraction.WriteOptions) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	key, err := cleanPath(fileName)
+	if err != nil {
+		return err
+	} else if key == "" {
+		return &fs.PathError{Op: "write", Path: fileName, Err: fs.ErrInvalid}
+	}
+
+	offset, err := reader.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	hasher := newHasher()
+	if _, err = io.Copy(hasher, storageabstraction.NewContextReadSeeker(ctx, reader)); err != nil {
+		return err
+	}
+	if _, err = reader.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	return storage.commit(ctx, fileName, key, hasher, reader, options)
+}
+
+// commit writes the blob of the hashed content if it does not exist yet, and adds the file to the manifest
+func (storage *casStorage) commit(ctx context.Context, fileName string, key string, hasher *hasher, reader io.ReadSeeker,
+	options storageabstraction.WriteOptions) error {
+	if options.ContentType == "" {
+		contentType, err := storageabstraction.DetectContentType(key, reader)
+		if err != nil {
+			return err
+		}
+		options.ContentType = contentType
+	}
+	entry := hasher.entry(options)
+
+	// fails early, before the blob is written
+	storage.lock.RLock()
+	err := storage.checkWrite(fileName, key, options.Conditions)
+	storage.lock.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	storage.collectLock.RLock()
+	defer storage.collectLock.RUnlock()
+
+	if err = storage.writeBlob(ctx, entry, reader); err != nil {
+		return err
+	}
+
+	storage.lock.Lock()
+	defer storage.lock.Unlock()
+
+	if err = storage.checkWrite(fileName, key, options.Conditions); err != nil {
+		return err
+	}
+	storage.files[key] = entry
+	storage.generation++
+	return nil
+}
+
+// writeBlob writes the content as blob, unless the blob exists already. An existing blob may be older than the grace
+// period, so it is referenced by a marker until the manifest is flushed, and written again if it was collected meanwhile.
+// The writer commits the blob only once the whole content is written, existing blobs with another size are replaced
+func (storage *casStorage) writeBlob(ctx context.Context, entry *entry, reader io.ReadSeeker) error {
+	blob := blobPath(entry.Hash)
+	info, err := storageabstraction.StatContext(ctx, storage.storage, blob)
+	if err != nil && !errors.Is(err, storageabstraction.ErrNotExist) {
+		return err
+	}
+	if err == nil && info.Size() == entry.Size {
+		reference, err := referencePath(entry.Hash)
+		if err != nil {
+			return err
+		}
+		if err = storage.storage.WriteContext(ctx, reference, 0, bytes.NewReader(nil)); err != nil {
+			return err
+		}
+		if exists, err := storageabstraction.ExistsContext(ctx, storage.storage, blob); err != nil || exists {
+			return err
+		}
+	}
+	return storageabstraction.WriteFromContext(ctx, storage.storage, blob, storageabstraction.NewContextReadSeeker(ctx, reader))
+}
+
+// checkWrite checks the conditions and if the file can be written, the storage must be locked
+func (storage *casStorage) checkWrite(fileName string, key string, conditions storageabstraction.Conditions) error {
+	// like in a file system a file can not replace a directory or be inside of a file
+	for dir := path.Dir(key); dir != "."; dir = path.Dir(dir) {
+		if _, isFile := storage.files[dir]; isFile {
+			return storageabstraction.NewPathError("write", fileName, storageabstraction.ErrConflict, nil)
+		}
+	}
+	for existingKey := range storage.files {
+		if isInDirectory(existingKey, key) {
+			return storageabstraction.NewPathError("write", fileName, storageabstraction.ErrConflict, nil)
+		}
+	}
+
+	return storage.checkConditions("write", fileName, key, conditions)
+}
+
+// checkConditions compares the conditions with the ETag of the file, the storage must be locked
+func (storage *casStorage) checkConditions(op string, fileName string, key string, conditions storageabstraction.Conditions) error {
+	entry, exists := storage.files[key]
+	if !exists {
+		return conditions.Check(op, fileName, false, "")
+	}
+	return conditions.Check(op, fileName, true, entry.details(key).ETag)
+}
+
+func (storage *casStorage) OpenWriter(fileName string) (io.WriteCloser, error) {
+	return storage.OpenWriterContext(context.Background(), fileName)
+}
+
+func (storage *casStorage) OpenWriterContext(ctx context.Context, fileName string) (io.WriteCloser, error) {
+	return storage.OpenWriterWithOptionsContext(ctx, fileName, storageabstraction.WriteOptions{})
+}
+
+func (storage *casStorage) OpenWriterWithOptions(fileName string, options storageabstraction.WriteOptions) (io.WriteCloser, error) {
+	return storage.OpenWriterWithOptionsContext(context.Background(), fileName, options)
+}
+
+// OpenWriterWithOptionsContext hashes the content while it is spooled to a temp file, the blob is written on Close
+func (storage *casStorage) OpenWriterWithOptionsContext(ctx context.Context, fileName string, options storageabstraction.WriteOptions) (io.WriteCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	key, err := cleanPath(fileName)
+	if err != nil {
+		return nil, err
+	} else if key == "" {
+		return nil, &fs.PathError{Op: "open", Path: fileName, Err: fs.ErrInvalid}
+	}
+
+	file, err := os.CreateTemp("", "casWriter")
+	if err != nil {
+		return nil, err
+	}
+	return &casWriter{ctx: ctx, storage: storage, fileName: fileName, key: key, options: options, file: file, hasher: newHasher()}, nil
+}
+
+type casWriter struct {
+	ctx      context.Context
+	storage  *casStorage
+	fileName string
+	key      string
+	options  storageabstraction.WriteOptions
+	file     *os.File
+	hasher   *hasher
+}
+
+func (writer *casWriter) Write(p []byte) (n int, err error) {
+	if err := writer.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err = writer.file.Write(p)
+	_, _ = writer.hasher.Write(p[:n])
+	return n, err
+}
+
+func (writer *casWriter) Close() error {
+	defer os.Remove(writer.file.Name())
+	defer writer.file.Close()
+
+	if err := writer.ctx.Err(); err != nil {
+		return err
+	}
+	if _, err := writer.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return writer.storage.commit(writer.ctx, writer.fileName, writer.key, writer.hasher, writer.file, writer.options)
+}
+
+// entryOf returns the entry of the file, or an ErrNotExist error for the operation
+func (storage *casStorage) entryOf(op string, fileName string) (*entry, error) {
+	key, err := cleanPath(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	storage.lock.RLock()
+	defer storage.lock.RUnlock()
+
+	entry, ok := storage.files[key]
+	if !ok {
+		return nil, &fs.PathError{Op: op, Path: fileName, Err: fs.ErrNotExist}
+	}
+	return entry, nil
+}
+
+func (storage *casStorage) Read(fileName string) (io.ReadCloser, error) {
+	return storage.ReadContext(context.Background(), fileName)
+}
+
+// ReadContext reads the blob of the file
+func (storage *casStorage) ReadContext(ctx context.Context, fileName string) (io.ReadCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	entry, err := storage.entryOf("read", fileName)
+	if err != nil {
+		return nil, err
+	}
+	return storage.storage.ReadContext(ctx, blobPath(entry.Hash))
+}
+
+func (storage *casStorage) ReadRange(fileName string, offset int64, length int64) (io.ReadCloser, error) {
+	return storage.ReadRangeContext(context.Background(), fileName, offset, length)
+}
+
+func (storage *casStorage) ReadRangeContext(ctx context.Context, fileName string, offset int64, length int64) (io.ReadCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	entry, err := storage.entryOf("read", fileName)
+	if err != nil {
+		return nil, err
+	}
+	return storageabstraction.ReadRangeContext(ctx, storage.storage, blobPath(entry.Hash), offset, length)
+}
+
+func (storage *casStorage) FileSize(fileName string) (int64, error) {
+	return storage.FileSizeContext(context.Background(), fileName)
+}
+
+func (storage *casStorage) FileSizeContext(ctx context.Context, fileName string) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	entry, err := storage.entryOf("stat", fileName)
+	if err != nil {
+		return 0, err
+	}
+	return entry.Size, nil
+}
+
+func (storage *casStorage) Stat(fileName string) (*storageabstraction.FileInfo, error) {
+	return storage.StatContext(context.Background(), fileName)
+}
+
+// StatContext returns the details of the file or the directory from the manifest, the ETag is the SHA-256 hash
+func (storage *casStorage) StatContext(ctx context.Context, fileName string) (*storageabstraction.FileInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	key, err := cleanPath(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	storage.lock.RLock()
+	defer storage.lock.RUnlock()
+
+	if entry, ok := storage.files[key]; ok {
+		return storageabstraction.NewFileInfoFromDetails(entry.details(key)), nil
+	}
+	for existingKey := range storage.files {
+		if isInDirectory(existingKey, key) {
+			return storageabstraction.NewFileInfoFromDetails(storageabstraction.FileDetails{
+				Name:  path.Base("/" + key),
+				IsDir: true,
+			}), nil
+		}
+	}
+
+	return nil, &fs.PathError{Op: "stat", Path: fileName, Err: fs.ErrNotExist}
+}
+
+func (storage *casStorage) DeleteDirectory(directory string) error {
+	return storage.DeleteDirectoryContext(context.Background(), directory)
+}
+
+// DeleteDirectoryContext removes the files of the directory from the manifest, the blobs are garbage collected
+func (storage *casStorage) DeleteDirectoryContext(ctx context.Context, directory string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	directory, err := cleanPath(directory)
+	if err != nil {
+		return err
+	}
+
+	storage.lock.Lock()
+	defer storage.lock.Unlock()
+
+	for key := range storage.files {
+		if key == directory || isInDirectory(key, directory) {
+			delete(storage.files, key)
+			storage.generation++
+		}
+	}
+	return nil
+}
+
+func (storage *casStorage) DeleteFile(fileName string) error {
+	return storage.DeleteFileContext(context.Background(), fileName)
+}
+
+func (storage *casStorage) DeleteFileContext(ctx context.Context, fileName string) error {
+	return storage.DeleteFileWithConditionsContext(ctx, fileName, storageabstraction.Conditions{})
+}
+
+func (storage *casStorage) DeleteFileWithConditions(fileName string, conditions storageabstraction.Conditions) error {
+	return storage.DeleteFileWithConditionsContext(context.Background(), fileName, conditions)
+}
+
+// DeleteFileWithConditionsContext removes the file from the manifest if the conditions are met, the blob is garbage collected
+func (storage *casStorage) DeleteFileWithConditionsContext(ctx context.Context, fileName string, conditions storageabstraction.Conditions) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	key, err := cleanPath(fileName)
+	if err != nil {
+		return err
+	}
+
+	storage.lock.Lock()
+	defer storage.lock.Unlock()
+
+	if _, ok := storage.files[key]; !ok {
+		return &fs.PathError{Op: "remove", Path: fileName, Err: fs.ErrNotExist}
+	}
+	if err := storage.checkConditions("remove", fileName, key, conditions); err != nil {
+		return err
+	}
+
+	delete(storage.files, key)
+	storage.generation++
+	return nil
+}
+
+func (storage *casStorage) List(directory string) ([]fs.FileInfo, error) {
+	return storage.ListContext(context.Background(), directory)
+}
+
+// ListContext returns the files and sub directories of the directory from the manifest, sorted by name
+func (storage *casStorage) ListContext(ctx context.Context, directory string) ([]fs.FileInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	directoryKey, err := cleanPath(directory)
+	if err != nil {
+		return nil, err
+	}
+
+	storage.lock.RLock()
+	defer storage.lock.RUnlock()
+
+	var entries []fs.FileInfo
+	// the sub directories get the latest modification of their content
+	directories := map[string]time.Time{}
+	for key, entry := range storage.files {
+		if key == directoryKey || !isInDirectory(key, directoryKey) {
+			continue
+		}
+
+		relativePath := strings.TrimPrefix(strings.TrimPrefix(key, directoryKey), "/")
+		name, _, isSubEntry := strings.Cut(relativePath, "/")
+		if !isSubEntry {
+			entries = append(entries, storageabstraction.NewFileInfoFromDetails(entry.details(key)))
+		} else if directories[name].Before(entry.ModTime) {
+			directories[name] = entry.ModTime
+		}
+	}
+	if len(entries) == 0 && len(directories) == 0 {
+		return nil, &fs.PathError{Op: "list", Path: directory, Err: fs.ErrNotExist}
+	}
+
+	for name, modTime := range directories {
+		entries = append(entries, storageabstraction.NewFileInfoFromDetails(storageabstraction.FileDetails{
+			Name: name, IsDir: true, ModTime: modTime}))
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries, nil
+}
+
+func (storage *casStorage) Walk(directory string, walk storageabstraction.WalkFunc) error {
+	return storage.WalkContext(context.Background(), directory, walk)
+}
+
+// WalkContext walks the files of the manifest, a file is walked as itself with the path ""
+func (storage *casStorage) WalkContext(ctx context.Context, directory string, walk storageabstraction.WalkFunc) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	key, err := cleanPath(directory)
+	if err != nil {
+		_ = walk("", nil, err)
+		return err
+	}
+	storage.lock.RLock()
+	entry, isFile := storage.files[key]
+	storage.lock.RUnlock()
+
+	if isFile {
+		err := walk("", storageabstraction.NewFileInfoFromDetails(entry.details(key)), nil)
+		if err == fs.SkipDir || err == fs.SkipAll {
+			return nil
+		}
+		return err
+	}
+	return storageabstraction.WalkByList(ctx, directory, storage.ListContext, walk)
+}
+
+func (storage *casStorage) Join(paths ...string) string {
+	return common.LinuxPathJoin(paths...)
+}
+
+func (storage *casStorage) CopyFile(source string, destination string) error {
+	return storage.CopyFileContext(context.Background(), source, destination)
+}
+
+// CopyFileContext adds the blob of the source to the manifest with the destination path
+func (storage *casStorage) CopyFileContext(ctx context.Context, source string, destination string) error {
+	return storage.relocateFile(ctx, "copy", source, destination, false)
+}
+
+func (storage *casStorage) MoveFile(source string, destination string) error {
+	return storage.MoveFileContext(context.Background(), source, destination)
+}
+
+func (storage *casStorage) MoveFileContext(ctx context.Context, source string, destination string) error {
+	return storage.relocateFile(ctx, "move", source, destination, true)
+}
+
+// relocateFile copies the entry to the destination and removes the source if it is moved
+func (storage *casStorage) relocateFile(ctx context.Context, operation string, source string, destination string, move bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	sourceKey, err := cleanPath(source)
+	if err != nil {
+		return err
+	}
+	destinationKey, err := cleanPath(destination)
+	if err != nil {
+		return err
+	} else if destinationKey == "" {
+		return &fs.PathError{Op: operation, Path: destination, Err: fs.ErrInvalid}
+	}
+
+	storage.lock.Lock()
+	defer storage.lock.Unlock()
+
+	entry, ok := storage.files[sourceKey]
+	if !ok {
+		return &fs.PathError{Op: operation, Path: source, Err: fs.ErrNotExist}
+	}
+	if sourceKey == destinationKey {
+		return nil
+	}
+
+	copied := *entry
+	copied.ModTime = time.Now()
+	storage.files[destinationKey] = &copied
+	if move {
+		delete(storage.files, sourceKey)
+	}
+	storage.generation++
+	return nil
+}
+
+func (storage *casStorage) CopyDirectory(source string, destination string) error {
+	return storage.CopyDirectoryContext(context.Background(), source, destination)
+}
+
+func (storage *casStorage) CopyDirectoryContext(ctx context.Context, source string, destination string) error {
+	return storage.relocateDirectory(ctx, "copy", source, destination, false)
+}
+
+func (storage *casStorage) MoveDirectory(source string, destination string) error {
+	return storage.MoveDirectoryContext(context.Background(), source, destination)
+}
+
+func (storage *casStorage) MoveDirectoryContext(ctx context.Context, source string, destination string) error {
+	return storage.relocateDirectory(ctx, "move", source, destination, true)
+}
+
+// relocateDirectory copies the entries of the directory into the destination directory at once,
+// and removes them from the source if they are moved
+func (storage *casStorage) relocateDirectory(ctx context.Context, operation string, source string, destination string, move bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	sourceDirectory, err := cleanPath(source)
+	if err != nil {
+		return err
+	}
+	destinationDirectory, err := cleanPath(destination)
+	if err != nil {
+		return err
+	}
+	if sourceDirectory == destinationDirectory {
+		return nil
+	}
+
+	storage.lock.Lock()
+	defer storage.lock.Unlock()
+
+	relocated := map[string]*entry{}
+	for key, entry := range storage.files {
+		if isInDirectory(key, sourceDirectory) {
+			relativePath := strings.TrimPrefix(strings.TrimPrefix(key, sourceDirectory), "/")
+			copied := *entry
+			copied.ModTime = time.Now()
+			relocated[path.Join(destinationDirectory, relativePath)] = &copied
+			if move {
+				delete(storage.files, key)
+			}
+		}
+	}
+	if len(relocated) == 0 {
+		return &fs.PathError{Op: operation, Path: source, Err: fs.ErrNotExist}
+	}
+
+	for key, entry := range relocated {
+		storage.files[key] = entry
+	}
+	storage.generation++
+	return nil
+}
+
+func (storage *casStorage) Flush() error {
+	return storage.FlushContext(context.Background())
+}
+
+// FlushContext writes the files as a new manifest, if they were changed since the last flush. The manifests are listed
+// before and after the write, if another instance flushed one since the last load the own manifest is deleted again
+func (storage *casStorage) FlushContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	storage.flushLock.Lock()
+	defer storage.flushLock.Unlock()
+
+	storage.lock.RLock()
+	generation := storage.generation
+	if generation == storage.flushed {
+		storage.lock.RUnlock()
+		return nil
+	}
+	created := time.Now().UTC()
+	content, err := json.Marshal(manifest{Created: created, Files: storage.files})
+	storage.lock.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	if err = storage.checkLatest(ctx, ""); err != nil {
+		return err
+	}
+	name := manifestName(created)
+	start := time.Now()
+	err = storageabstraction.WriteWithOptionsContext(ctx, storage.storage, path.Join(manifestsDirectory, name),
+		int64(len(content)), bytes.NewReader(content),
+		storageabstraction.WriteOptions{Conditions: storageabstraction.Conditions{IfNoneMatch: storageabstraction.ETagAny}})
+	common.LogOperation(storage.logger, "flush", path.Join(manifestsDirectory, name), int64(len(content)), start, err)
+	if err != nil {
+		return err
+	}
+	// both instances fail if they flush at the same time, but never both succeed
+	if err = storage.checkLatest(ctx, name); err != nil {
+		_ = storage.storage.DeleteFileContext(context.WithoutCancel(ctx), path.Join(manifestsDirectory, name))
+		return err
+	}
+
+	storage.lock.Lock()
+	storage.flushed = generation
+	storage.latest = name
+	storage.lock.Unlock()
+	return nil
+}
+
+// checkLatest fails with ErrConflict if another manifest than own was flushed since the latest was loaded,
+// the flush lock must be held
+func (storage *casStorage) checkLatest(ctx context.Context, own string) error {
+	names, err := storage.manifests(ctx)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if name > storage.latest && name != own {
+			return storageabstraction.NewPathError("flush", path.Join(manifestsDirectory, name), storageabstraction.ErrConflict, nil)
+		}
+	}
+	return nil
+}
+
+func (storage *casStorage) Reload() error {
+	return storage.ReloadContext(context.Background())
+}
+
+// ReloadContext replaces the files with the ones of the latest manifest, without manifest the storage is empty
+func (storage *casStorage) ReloadContext(ctx context.Context) error {
+	storage.flushLock.Lock()
+	defer storage.flushLock.Unlock()
+
+	names, err := storage.manifests(ctx)
+	if err != nil {
+		return err
+	}
+	latest, name := &manifest{Files: map[string]*entry{}}, ""
+	if len(names) > 0 {
+		name = names[len(names)-1]
+		if latest, err = storage.readManifest(ctx, name); err != nil {
+			return err
+		}
+	}
+
+	storage.lock.Lock()
+	defer storage.lock.Unlock()
+
+	storage.files = latest.Files
+	storage.generation++
+	storage.flushed = storage.generation
+	storage.latest = name
+	return nil
+}
+
+// manifests returns the names of the manifests sorted by their creation
+func (storage *casStorage) manifests(ctx context.Context) ([]string, error) {
+	entries, err := storageabstraction.ListContext(ctx, storage.storage, manifestsDirectory)
+	if errors.Is(err, storageabstraction.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), manifestExtension) {
+			names = append(names, entry.Name())
+		}
+	}
+	return names, nil
+}
+
+func (storage *casStorage) readManifest(ctx context.Context, name string) (*manifest, error) {
+	reader, err := storage.storage.ReadContext(ctx, path.Join(manifestsDirectory, name))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	loaded := &manifest{}
+	if err = json.NewDecoder(reader).Decode(loaded); err != nil {
+		return nil, storageabstraction.NewPathError("read", path.Join(manifestsDirectory, name), fs.ErrInvalid, err)
+	}
+	if loaded.Files == nil {
+		loaded.Files = map[string]*entry{}
+	}
+	return loaded, nil
+}
+
+func (storage *casStorage) GarbageCollect(keep int) (*Report, error) {
+	return storage.GarbageCollectContext(context.Background(), keep)
+}
+
+// GarbageCollectContext deletes the old manifests before the blobs, so an aborted collection never leaves a manifest
+// with missing blobs. At least the newest manifest is kept, writes wait until the collection is done. The references
+// are read after the blobs were listed, so blobs which are reused meanwhile are kept
+func (storage *casStorage) GarbageCollectContext(ctx context.Context, keep int) (*Report, error) {
+	report := &Report{}
+
+	storage.collectLock.Lock()
+	defer storage.collectLock.Unlock()
+
+	names, err := storage.manifests(ctx)
+	if err != nil {
+		return report, err
+	}
+	kept := names[max(len(names)-max(keep, 1), 0):]
+	for _, name := range names[:len(names)-len(kept)] {
+		err = storage.storage.DeleteFileContext(ctx, path.Join(manifestsDirectory, name))
+		if err != nil && !errors.Is(err, storageabstraction.ErrNotExist) {
+			return report, err
+		}
+		report.DeletedManifests = append(report.DeletedManifests, name)
+	}
+
+	referenced := map[string]bool{}
+	for _, name := range kept {
+		keptManifest, err := storage.readManifest(ctx, name)
+		if err != nil {
+			return report, err
+		}
+		for _, entry := range keptManifest.Files {
+			referenced[entry.Hash] = true
+		}
+	}
+	storage.lock.RLock()
+	for _, entry := range storage.files {
+		referenced[entry.Hash] = true
+	}
+	storage.lock.RUnlock()
+
+	cutoff := time.Now().Add(-storage.gracePeriod)
+	// the unreferenced blobs which are older than the grace period and their infos
+	var candidates []string
+	var infos []os.FileInfo
+	err = storage.storage.WalkContext(ctx, blobsDirectory, func(blob string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && !referenced[path.Base(blob)] && !info.ModTime().After(cutoff) {
+			candidates = append(candidates, blob)
+			infos = append(infos, info)
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, storageabstraction.ErrNotExist) {
+		return report, err
+	}
+	if err = storage.readReferences(ctx, cutoff, referenced); err != nil {
+		return report, err
+	}
+
+	for i, blob := range candidates {
+		if referenced[path.Base(blob)] {
+			continue
+		}
+		err = storage.storage.DeleteFileContext(ctx, path.Join(blobsDirectory, blob))
+		if err != nil && !errors.Is(err, storageabstraction.ErrNotExist) {
+			return report, err
+		}
+		report.DeletedBlobs = append(report.DeletedBlobs, path.Base(blob))
+		report.FreedBytes += infos[i].Size()
+	}
+
+	_ = level.Info(storage.logger).Log("msg", "Garbage collected content addressable storage",
+		"manifests", len(report.DeletedManifests), "blobs", len(report.DeletedBlobs), "bytes", report.FreedBytes)
+	return report, nil
+}
+
+// readReferences adds the blobs of the references which are younger than the cutoff, the older ones are deleted
+func (storage *casStorage) readReferences(ctx context.Context, cutoff time.Time, referenced map[string]bool) error {
+	err := storage.storage.WalkContext(ctx, referencesDirectory, func(reference string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		if info.ModTime().After(cutoff) {
+			hash, _, _ := strings.Cut(path.Base(reference), ".")
+			referenced[hash] = true
+			return nil
+		}
+
+		err = storage.storage.DeleteFileContext(ctx, path.Join(referencesDirectory, reference))
+		if err != nil && !errors.Is(err, storageabstraction.ErrNotExist) {
+			return err
+		}
+		return nil
+	})
+	if errors.Is(err, storageabstraction.ErrNotExist) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,95 @@
+package common
+
+import (
+	"io"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+)
+
+// LogOperation logs a finished storage operation with the path, the transferred bytes, the duration and
+// additional key values. Failed operations are logged as error, all others as debug. Negative bytes are not logged
+func LogOperation(logger log.Logger, operation string, path string, bytes int64, start time.Time, err error, keyvals ...interface{}) {
+	keyvals = append([]interface{}{"op", operation, "path", path}, keyvals...)
+	if bytes >= 0 {
+		keyvals = append(keyvals, "bytes", bytes)
+	}
+	keyvals = append(keyvals, "duration", time.Since(start))
+
+	if err != nil {
+		_ = level.Error(logger).Log(append(keyvals, "err", err)...)
+		return
+	}
+	_ = level.Debug(logger).Log(keyvals...)
+}
+
+// LogReadCloser returns a reader which logs the operation with the count of read bytes when it is closed
+func LogReadCloser(logger log.Logger, operation string, path string, reader io.ReadCloser) io.ReadCloser {
+	return &logReadCloser{ReadCloser: reader, logger: logger, operation: operation, path: path, start: time.Now()}
+}
+
+type logReadCloser struct {
+	io.ReadCloser
+	logger    log.Logger
+	operation string
+	path      string
+	start     time.Time
+
+	bytes int64
+	err   error
+}
+
+func (reader *logReadCloser) Read(p []byte) (n int, err error) {
+	n, err = reader.ReadCloser.Read(p)
+	reader.bytes += int64(n)
+	if err != nil && err != io.EOF && reader.err == nil {
+		reader.err = err
+	}
+	return n, err
+}
+
+func (reader *logReadCloser) Close() error {
+	err := reader.ReadCloser.Close()
+	if reader.err == nil {
+		reader.err = err
+	}
+
+	LogOperation(reader.logger, reader.operation, reader.path, reader.bytes, reader.start, reader.err)
+	return err
+}
+
+// LogWriteCloser returns a writer which logs the operation with the count of written bytes when it is closed
+func LogWriteCloser(logger log.Logger, operation string, path string, writer io.WriteCloser) io.WriteCloser {
+	return &logWriteCloser{WriteCloser: writer, logger: logger, operation: operation, path: path, start: time.Now()}
+}
+
+type logWriteCloser struct {
+	io.WriteCloser
+	logger    log.Logger
+	operation string
+	path      string
+	start     time.Time
+
+	bytes int64
+	err   error
+}
+
+func (writer *logWriteCloser) Write(p []byte) (n int, err error) {
+	n, err = writer.WriteCloser.Write(p)
+	writer.bytes += int64(n)
+	if err != nil && writer.err == nil {
+		writer.err = err
+	}
+	return n, err
+}
+
+func (writer *logWriteCloser) Close() error {
+	err := writer.WriteCloser.Close()
+	if err != nil {
+		writer.err = err
+	}
+
+	LogOperation(writer.logger, writer.operation, writer.path, writer.bytes, writer.start, writer.err)
+	return err
+}
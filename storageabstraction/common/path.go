@@ -0,0 +1,79 @@
+package common
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+var (
+	// ErrPathEscape is the reason for paths which escape from the root of a storage, it matches fs.ErrPermission
+	ErrPathEscape = fmt.Errorf("path escapes from the root: %w", fs.ErrPermission)
+	// ErrIllegalCharacter is the reason for paths with a character no storage accepts, it matches fs.ErrInvalid
+	ErrIllegalCharacter = fmt.Errorf("illegal character in path: %w", fs.ErrInvalid)
+)
+
+// InvalidPathError is returned for paths which are rejected by the validation,
+// errors.Is matches it with its reason ErrPathEscape or ErrIllegalCharacter
+type InvalidPathError struct {
+	Path string
+	Err  error
+}
+
+func (err *InvalidPathError) Error() string {
+	return fmt.Sprintf("invalid path %q: %v", err.Path, err.Err)
+}
+
+func (err *InvalidPathError) Unwrap() error {
+	return err.Err
+}
+
+// ValidatePath checks that the path stays within the root of the storage, a leading "/" is the root itself.
+// "\" is a separator too, so paths which escape on windows are rejected on every system
+func ValidatePath(fileName string) error {
+	_, err := CleanPath(fileName)
+	return err
+}
+
+// CleanPath validates the path like ValidatePath and returns it relative to the root, cleaned and with "/" as separator.
+// The root itself is ""
+func CleanPath(fileName string) (string, error) {
+	if strings.ContainsRune(fileName, 0) {
+		return "", &InvalidPathError{Path: fileName, Err: ErrIllegalCharacter}
+	}
+
+	cleaned := path.Clean(strings.TrimLeft(strings.ReplaceAll(fileName, "\\", "/"), "/"))
+	if cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", &InvalidPathError{Path: fileName, Err: ErrPathEscape}
+	}
+	if cleaned == "." {
+		return "", nil
+	}
+	return cleaned, nil
+}
+
+// CleanArchivePath is CleanPath for the names of archive entries, which are always relative. Absolute names and names
+// with a windows drive are rejected as well, instead of being extracted relative to the target directory.
+// A name of the target directory itself is no file and fails with fs.ErrInvalid
+func CleanArchivePath(name string) (string, error) {
+	slashed := strings.ReplaceAll(name, "\\", "/")
+	if strings.HasPrefix(slashed, "/") || hasVolumeName(slashed) {
+		return "", &InvalidPathError{Path: name, Err: ErrPathEscape}
+	}
+
+	cleaned, err := CleanPath(name)
+	if err == nil && cleaned == "" {
+		err = &InvalidPathError{Path: name, Err: fs.ErrInvalid}
+	}
+	return cleaned, err
+}
+
+// hasVolumeName checks if the path starts with a windows drive like "C:"
+func hasVolumeName(fileName string) bool {
+	if len(fileName) < 2 || fileName[1] != ':' {
+		return false
+	}
+	letter := fileName[0] | 0x20
+	return letter >= 'a' && letter <= 'z'
+}
@@ -0,0 +1,101 @@
+package common
+
+import (
+	"errors"
+	"io/fs"
+	"path"
+	"strings"
+	"testing"
+)
+
+var pathSeeds = []string{"", ".", "/", "site/index.html", "/site/../index.html", "..", "../b/secret.txt",
+	"site/../../b/secret.txt", "/../b/secret.txt", "..\\b\\secret.txt", "a/./b//c/", "C:/windows", "c:\\windows",
+	"file\x00.txt", "....//x", "a/..\\../b"}
+
+// checkCleaned checks the properties which all cleaned paths have
+func checkCleaned(t *testing.T, fileName string, cleaned string) {
+	if strings.HasPrefix(cleaned, "/") || strings.Contains(cleaned, "\\") || strings.ContainsRune(cleaned, 0) {
+		t.Errorf("Expected a relative path with / as separator for %q, actual: %q", fileName, cleaned)
+	}
+	if cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		t.Errorf("Expected %q not to escape from the root, actual: %q", fileName, cleaned)
+	}
+	if cleaned != "" && path.Clean(cleaned) != cleaned {
+		t.Errorf("Expected a clean path for %q, actual: %q", fileName, cleaned)
+	}
+	if joined := path.Join("/root", cleaned); joined != "/root" && !strings.HasPrefix(joined, "/root/") {
+		t.Errorf("Expected %q to stay in the root, actual: %q", fileName, joined)
+	}
+}
+
+func TestCleanPath(t *testing.T) {
+	for fileName, expected := range map[string]string{"": "", "/": "", "/site/../index.html": "index.html",
+		"a/./b//c/": "a/b/c", "a\\b": "a/b", "....//x": "..../x"} {
+		if cleaned, err := CleanPath(fileName); err != nil || cleaned != expected {
+			t.Errorf("Expected %q to be cleaned to %q, actual: %q, err: %v", fileName, expected, cleaned, err)
+		}
+	}
+
+	for _, fileName := range []string{"..", "../b", "site/../../b", "/../b", "..\\b", "a/..\\../b"} {
+		_, err := CleanPath(fileName)
+		var pathErr *InvalidPathError
+		if !errors.Is(err, ErrPathEscape) || !errors.Is(err, fs.ErrPermission) || !errors.As(err, &pathErr) || pathErr.Path != fileName {
+			t.Errorf("Expected %q to escape, actual: %v", fileName, err)
+		}
+	}
+	if err := ValidatePath("file\x00.txt"); !errors.Is(err, ErrIllegalCharacter) || !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("Expected NUL to be illegal, actual: %v", err)
+	}
+}
+
+func TestCleanArchivePath(t *testing.T) {
+	if cleaned, err := CleanArchivePath("./site/index.html"); err != nil || cleaned != "site/index.html" {
+		t.Errorf("Expected a relative name to be cleaned, actual: %q, err: %v", cleaned, err)
+	}
+	for _, name := range []string{"/etc/passwd", "\\windows\\system.ini", "C:/windows", "c:evil", "../evil"} {
+		if _, err := CleanArchivePath(name); !errors.Is(err, ErrPathEscape) {
+			t.Errorf("Expected %q to escape, actual: %v", name, err)
+		}
+	}
+	for _, name := range []string{"", ".", "site/.."} {
+		if _, err := CleanArchivePath(name); !errors.Is(err, fs.ErrInvalid) {
+			t.Errorf("Expected %q to be no file, actual: %v", name, err)
+		}
+	}
+}
+
+func FuzzCleanPath(f *testing.F) {
+	for _, seed := range pathSeeds {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, fileName string) {
+		cleaned, err := CleanPath(fileName)
+		if err != nil {
+			if !errors.Is(err, ErrPathEscape) && !errors.Is(err, ErrIllegalCharacter) {
+				t.Errorf("Expected a typed error for %q, actual: %v", fileName, err)
+			}
+			return
+		}
+		checkCleaned(t, fileName, cleaned)
+
+		if again, err := CleanPath(cleaned); err != nil || again != cleaned {
+			t.Errorf("Expected %q to stay the same when cleaned again, actual: %q, err: %v", cleaned, again, err)
+		}
+	})
+}
+
+func FuzzCleanArchivePath(f *testing.F) {
+	for _, seed := range pathSeeds {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, name string) {
+		cleaned, err := CleanArchivePath(name)
+		if err != nil {
+			return
+		}
+		checkCleaned(t, name, cleaned)
+		if cleaned == "" || hasVolumeName(cleaned) {
+			t.Errorf("Expected the name %q of a file relative to the directory, actual: %q", name, cleaned)
+		}
+	})
+}
@@ -0,0 +1,21 @@
+package common
+
+import "io"
+
+// ReaderSize returns the number of bytes which are left in the reader, the position of the reader is not changed.
+// The storages use it instead of the file size of the caller, which may be wrong
+func ReaderSize(reader io.ReadSeeker) (int64, error) {
+	current, err := reader.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	end, err := reader.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+	if _, err = reader.Seek(current, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	return end - current, nil
+}
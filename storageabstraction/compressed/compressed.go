@@ -0,0 +1,132 @@
+package compressed
+
+import (
+	"bytes"
+	"compress/gzip"
+	"github.com/2flow/gokies/storageabstraction"
+	"io"
+	"mime"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Encoding is the compression of the stored files, it is stored as their content encoding
+type Encoding string
+
+const (
+	Gzip Encoding = "gzip"
+	Zstd Encoding = "zstd"
+)
+
+const defaultMinSize = 1024
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+	// defaultContentTypes are the text types, types ending with "/" match all their sub types
+	defaultContentTypes = []string{"text/", "application/javascript", "application/json", "application/xml",
+		"application/manifest+json", "image/svg+xml"}
+)
+
+// Option configures the compression
+type Option func(storage *compressedStorage)
+
+// WithEncoding sets the compression of new files, the default is gzip. Files of both encodings are read
+func WithEncoding(encoding Encoding) Option {
+	return func(storage *compressedStorage) {
+		if encoding == Gzip || encoding == Zstd {
+			storage.encoding = encoding
+		}
+	}
+}
+
+// WithContentTypes sets the content types of the files which are compressed, types ending with "/" match all their
+// sub types. The default are text, javascript, json, xml and svg files. The type is taken from the file extension,
+// so the files are only decompressed if their extension has one of the types
+func WithContentTypes(contentTypes ...string) Option {
+	return func(storage *compressedStorage) {
+		storage.contentTypes = contentTypes
+	}
+}
+
+// WithMinSize sets the size of the smallest file which is compressed, the default is 1 KiB
+func WithMinSize(minSize int64) Option {
+	return func(storage *compressedStorage) {
+		if minSize >= 0 {
+			storage.minSize = minSize
+		}
+	}
+}
+
+// eligible checks if files with this name are compressed
+func (storage *compressedStorage) eligible(fileName string) bool {
+	mediaType, _, err := mime.ParseMediaType(storageabstraction.ContentTypeByName(fileName))
+	if err != nil {
+		return false
+	}
+
+	for _, contentType := range storage.contentTypes {
+		if mediaType == contentType || (strings.HasSuffix(contentType, "/") && strings.HasPrefix(mediaType, contentType)) {
+			return true
+		}
+	}
+	return false
+}
+
+// encodingOf detects the compression from the first bytes of the file, text files never start with these bytes
+func encodingOf(head []byte) Encoding {
+	switch {
+	case bytes.HasPrefix(head, gzipMagic):
+		return Gzip
+	case bytes.HasPrefix(head, zstdMagic):
+		return Zstd
+	}
+	return ""
+}
+
+// newEncoder compresses into the writer, size is written into the zstd header if it is known
+func newEncoder(encoding Encoding, writer io.Writer, size int64) (io.WriteCloser, error) {
+	if encoding == Zstd {
+		encoder, err := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		encoder.ResetContentSize(writer, size)
+		return encoder, nil
+	}
+	return gzip.NewWriter(writer), nil
+}
+
+// decodedReader is the decompressed content of the reader
+type decodedReader struct {
+	io.Reader
+	decoder io.Closer
+	reader  io.Closer
+}
+
+func newDecodedReader(encoding Encoding, buffered io.Reader, reader io.ReadCloser) (io.ReadCloser, error) {
+	switch encoding {
+	case Gzip:
+		decoder, err := gzip.NewReader(buffered)
+		if err != nil {
+			return nil, err
+		}
+		return &decodedReader{Reader: decoder, decoder: decoder, reader: reader}, nil
+	case Zstd:
+		decoder, err := zstd.NewReader(buffered, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return &decodedReader{Reader: decoder, decoder: decoder.IOReadCloser(), reader: reader}, nil
+	}
+	return &decodedReader{Reader: buffered, reader: reader}, nil
+}
+
+func (reader *decodedReader) Close() error {
+	if reader.decoder != nil {
+		_ = reader.decoder.Close()
+	}
+	return reader.reader.Close()
+}
@@ -0,0 +1,154 @@
+package compressed
+
+import (
+	"bytes"
+	"github.com/2flow/gokies/storageabstraction"
+	"github.com/2flow/gokies/storageabstraction/memorystorage"
+	"io"
+	"strings"
+	"testing"
+)
+
+var script = strings.Repeat("console.log('compressed');\n", 200)
+
+// readAll returns the content of the reader, or the error as content
+func readAll(reader io.ReadCloser, err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	defer reader.Close()
+
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		return err.Error()
+	}
+	return string(content)
+}
+
+func TestCompressedStorage(t *testing.T) {
+	for _, encoding := range []Encoding{Gzip, Zstd} {
+		wrapped := memorystorage.NewMemoryStorage()
+		storage := NewCompressedStorage(wrapped, WithEncoding(encoding))
+
+		if err := storage.Write("js/app.js", int64(len(script)), strings.NewReader(script)); err != nil {
+			t.Errorf("[TestError] Error writing file: %v", err)
+			return
+		}
+		writer, err := storageabstraction.OpenWriter(storage, "js/streamed.js")
+		if err != nil {
+			t.Errorf("[TestError] Error opening writer: %v", err)
+			return
+		}
+		for _, line := range strings.SplitAfter(script, "\n") {
+			_, _ = writer.Write([]byte(line))
+		}
+		if err = writer.Close(); err != nil {
+			t.Errorf("[TestError] Error closing writer: %v", err)
+			return
+		}
+
+		for _, fileName := range []string{"js/app.js", "js/streamed.js"} {
+			stored, _ := storageabstraction.Stat(wrapped, fileName)
+			if stored.ContentEncoding() != string(encoding) || stored.Size() >= int64(len(script)) {
+				t.Errorf("Expected %s to be stored with %s, actual: %s, size: %d", fileName, encoding, stored.ContentEncoding(), stored.Size())
+			}
+
+			if content := readAll(storage.Read(fileName)); content != script {
+				t.Errorf("Expected the decompressed content of %s, actual: %d bytes", fileName, len(content))
+			}
+			if content := readAll(storageabstraction.ReadRange(storage, fileName, 27, 7)); content != "console" {
+				t.Errorf("Expected the range of the decompressed content, actual: %q", content)
+			}
+			if size, err := storage.FileSize(fileName); err != nil || size != int64(len(script)) {
+				t.Errorf("Expected the decompressed size of %s, actual: %d, err: %v", fileName, size, err)
+			}
+			info, err := storageabstraction.Stat(storage, fileName)
+			if err != nil || info.Size() != int64(len(script)) || info.ContentEncoding() != "" || info.ContentMD5() != nil {
+				t.Errorf("Expected the details of the decompressed file, actual: %v, err: %v", info, err)
+			}
+
+			encoded, err := storageabstraction.StatEncoded(storage, fileName)
+			if err != nil || encoded.ContentEncoding() != string(encoding) || encoded.Size() != stored.Size() {
+				t.Errorf("Expected the details of the stored file, actual: %v, err: %v", encoded, err)
+			}
+			raw := readAll(storageabstraction.ReadEncodedRange(storage, fileName, 0, storageabstraction.CountToEnd))
+			if int64(len(raw)) != stored.Size() || encodingOf([]byte(raw)) != encoding {
+				t.Errorf("Expected the stored content, actual: %d bytes", len(raw))
+			}
+		}
+
+		entries, err := storageabstraction.List(storage, "js")
+		if err != nil || len(entries) != 2 || entries[0].Size() != int64(len(script)) || entries[1].Size() != int64(len(script)) {
+			t.Errorf("Expected the listed files with the decompressed size, actual: %v, err: %v", entries, err)
+		}
+	}
+}
+
+func TestCompressedStorageUncompressed(t *testing.T) {
+	wrapped := memorystorage.NewMemoryStorage()
+	storage := NewCompressedStorage(wrapped)
+
+	image := bytes.Repeat([]byte{0x89, 'P', 'N', 'G'}, 1000)
+	files := map[string]string{"small.css": "body {}", "image.png": string(image)}
+	for fileName, content := range files {
+		if err := storage.Write(fileName, int64(len(content)), strings.NewReader(content)); err != nil {
+			t.Errorf("[TestError] Error writing file: %v", err)
+			return
+		}
+	}
+	// files written without compression are read as they are
+	if err := wrapped.Write("old.js", int64(len(script)), strings.NewReader(script)); err != nil {
+		t.Errorf("[TestError] Error writing file: %v", err)
+		return
+	}
+	files["old.js"] = script
+
+	for fileName, content := range files {
+		if stored, _ := storageabstraction.Stat(wrapped, fileName); stored.ContentEncoding() != "" || stored.Size() != int64(len(content)) {
+			t.Errorf("Expected %s to be stored uncompressed, actual: %s", fileName, stored.ContentEncoding())
+		}
+		if actual := readAll(storage.Read(fileName)); actual != content {
+			t.Errorf("Expected the content of %s, actual: %d bytes", fileName, len(actual))
+		}
+		if size, err := storage.FileSize(fileName); err != nil || size != int64(len(content)) {
+			t.Errorf("Expected the size of %s, actual: %d, err: %v", fileName, size, err)
+		}
+		if encoded, err := storageabstraction.StatEncoded(storage, fileName); err != nil || encoded.ContentEncoding() != "" {
+			t.Errorf("Expected %s not to be encoded, actual: %v", fileName, err)
+		}
+	}
+
+	// small streamed files are not compressed either
+	writer, _ := storageabstraction.OpenWriter(storage, "small.json")
+	_, _ = writer.Write([]byte("{}"))
+	if err := writer.Close(); err != nil {
+		t.Errorf("Error closing writer: %v", err)
+	}
+	if actual := readAll(wrapped.Read("small.json")); actual != "{}" {
+		t.Errorf("Expected a small file to be stored uncompressed, actual: %q", actual)
+	}
+}
+
+func TestCompressedStorageWrongFileSize(t *testing.T) {
+	wrapped := memorystorage.NewMemoryStorage()
+	storage := NewCompressedStorage(wrapped)
+
+	// the size is taken from the content, so a wrong file size neither truncates it nor decides the compression
+	for fileName, fileSize := range map[string]int64{"app.js": 3, "small.js": int64(len(script)), "missing.js": -1} {
+		content := script
+		if fileName == "small.js" {
+			content = "{}"
+		}
+		if err := storage.Write(fileName, fileSize, strings.NewReader(content)); err != nil {
+			t.Errorf("Error writing %s: %v", fileName, err)
+			continue
+		}
+		if actual := readAll(storage.Read(fileName)); actual != content {
+			t.Errorf("Expected the whole content of %s, actual: %d bytes", fileName, len(actual))
+		}
+		stored, _ := storageabstraction.Stat(wrapped, fileName)
+		if compressed := stored.ContentEncoding() != ""; compressed != (content == script) {
+			t.Errorf("Expected %s to be compressed only if the content is large enough, actual: %q", fileName, stored.ContentEncoding())
+		}
+	}
+}
@@ -0,0 +1,546 @@
+package compressed
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"github.com/2flow/gokies/storageabstraction"
+	"github.com/2flow/gokies/storageabstraction/common"
+	"io"
+	"io/fs"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// compressedStorage compresses the text files of the wrapped storage
+type compressedStorage struct {
+	storage      storageabstraction.IFileStorage
+	encoding     Encoding
+	contentTypes []string
+	minSize      int64
+}
+
+// NewCompressedStorage wraps the storage, so text files like javascript, css and json are compressed when they are
+// written and decompressed when they are read. The encoding is stored as content encoding of the file, and the
+// compressed files can be read as they are with storageabstraction.ReadEncodedRange. Sizes and details are the ones
+// of the decompressed files, which costs small reads for compressed files. Range reads of compressed files
+// decompress the file from the start
+func NewCompressedStorage(storage storageabstraction.IFileStorage, options ...Option) storageabstraction.IContextFileStorage {
+	compressed := &compressedStorage{
+		storage:      storage,
+		encoding:     Gzip,
+		contentTypes: defaultContentTypes,
+		minSize:      defaultMinSize,
+	}
+	for _, option := range options {
+		option(compressed)
+	}
+
+	return compressed
+}
+
+func (storage *compressedStorage) contextStorage() storageabstraction.IContextFileStorage {
+	return storageabstraction.WithContext(storage.storage)
+}
+
+func (storage *compressedStorage) Write(fileName string, fileSize int64, reader io.ReadSeeker) error {
+	return storage.WriteContext(context.Background(), fileName, fileSize, reader)
+}
+
+func (storage *compressedStorage) WriteContext(ctx context.Context, fileName string, fileSize int64, reader io.ReadSeeker) error {
+	return storage.WriteWithOptionsContext(ctx, fileName, fileSize, reader, storageabstraction.WriteOptions{})
+}
+
+func (storage *compressedStorage) WriteWithOptions(fileName string, fileSize int64, reader io.ReadSeeker, options storageabstraction.WriteOptions) error {
+	return storage.WriteWithOptionsContext(context.Background(), fileName, fileSize, reader, options)
+}
+
+// WriteWithOptionsContext compresses the file if it is eligible, its content type is detected from the uncompressed
+// content. The compressed content is streamed into the wrapped storage. The size is taken from the reader, the
+// fileSize is ignored like for the other storages
+func (storage *compressedStorage) WriteWithOptionsContext(ctx context.Context, fileName string, _ int64, reader io.ReadSeeker, options storageabstraction.WriteOptions) error {
+	fileSize, err := common.ReaderSize(reader)
+	if err != nil {
+		return err
+	}
+	if options.ContentType == "" {
+		contentType, err := storageabstraction.DetectContentType(fileName, reader)
+		if err != nil {
+			return err
+		}
+		options.ContentType = contentType
+	}
+	if !storage.eligible(fileName) || fileSize < storage.minSize {
+		return storageabstraction.WriteWithOptionsContext(ctx, storage.storage, fileName, fileSize, reader, options)
+	}
+
+	writer := storage.newWriter(ctx, fileName, options, 0, fileSize)
+	if _, err = io.Copy(writer, reader); err != nil {
+		writer.fail(err)
+	}
+	return writer.Close()
+}
+
+func (storage *compressedStorage) OpenWriter(fileName string) (io.WriteCloser, error) {
+	return storage.OpenWriterContext(context.Background(), fileName)
+}
+
+func (storage *compressedStorage) OpenWriterContext(ctx context.Context, fileName string) (io.WriteCloser, error) {
+	return storage.OpenWriterWithOptionsContext(ctx, fileName, storageabstraction.WriteOptions{})
+}
+
+func (storage *compressedStorage) OpenWriterWithOptions(fileName string, options storageabstraction.WriteOptions) (io.WriteCloser, error) {
+	return storage.OpenWriterWithOptionsContext(context.Background(), fileName, options)
+}
+
+// OpenWriterWithOptionsContext returns a writer which compresses eligible files, once the written content reaches
+// the min size. The file of the wrapped storage is opened then, or on Close for smaller files
+func (storage *compressedStorage) OpenWriterWithOptionsContext(ctx context.Context, fileName string, options storageabstraction.WriteOptions) (io.WriteCloser, error) {
+	if !storage.eligible(fileName) {
+		return storageabstraction.OpenWriterWithOptionsContext(ctx, storage.storage, fileName, options)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if options.ContentType == "" {
+		options.ContentType = storageabstraction.ContentTypeByName(fileName)
+	}
+	return storage.newWriter(ctx, fileName, options, storage.minSize, -1), nil
+}
+
+func (storage *compressedStorage) Read(fileName string) (io.ReadCloser, error) {
+	return storage.ReadContext(context.Background(), fileName)
+}
+
+// ReadContext decompresses the file if it is compressed, which is detected from its first bytes
+func (storage *compressedStorage) ReadContext(ctx context.Context, fileName string) (io.ReadCloser, error) {
+	reader, err := storage.contextStorage().ReadContext(ctx, fileName)
+	if err != nil || !storage.eligible(fileName) {
+		return reader, err
+	}
+
+	buffered := bufio.NewReader(reader)
+	head, _ := buffered.Peek(len(zstdMagic))
+	decoded, err := newDecodedReader(encodingOf(head), buffered, reader)
+	if err != nil {
+		_ = reader.Close()
+		return nil, err
+	}
+	return decoded, nil
+}
+
+func (storage *compressedStorage) ReadRange(fileName string, offset int64, length int64) (io.ReadCloser, error) {
+	return storage.ReadRangeContext(context.Background(), fileName, offset, length)
+}
+
+func (storage *compressedStorage) ReadRangeContext(ctx context.Context, fileName string, offset int64, length int64) (io.ReadCloser, error) {
+	if !storage.eligible(fileName) {
+		return storageabstraction.ReadRangeContext(ctx, storage.storage, fileName, offset, length)
+	}
+
+	reader, err := storage.ReadContext(ctx, fileName)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = io.CopyN(io.Discard, reader, offset); err != nil && err != io.EOF {
+		_ = reader.Close()
+		return nil, err
+	}
+	return storageabstraction.LimitReadCloser(reader, length), nil
+}
+
+func (storage *compressedStorage) ReadEncodedRange(fileName string, offset int64, length int64) (io.ReadCloser, error) {
+	return storage.ReadEncodedRangeContext(context.Background(), fileName, offset, length)
+}
+
+// ReadEncodedRangeContext reads the range of the file as it is stored
+func (storage *compressedStorage) ReadEncodedRangeContext(ctx context.Context, fileName string, offset int64, length int64) (io.ReadCloser, error) {
+	return storageabstraction.ReadRangeContext(ctx, storage.storage, fileName, offset, length)
+}
+
+// readBytes reads the range of the stored file into the memory
+func (storage *compressedStorage) readBytes(ctx context.Context, fileName string, offset int64, length int64) ([]byte, error) {
+	reader, err := storageabstraction.ReadRangeContext(ctx, storage.storage, fileName, offset, length)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	return io.ReadAll(reader)
+}
+
+// decodedSize returns the encoding and the decompressed size of the stored file. The size of gzip files is taken
+// from their trailer, so it is only correct for files below 4 GiB. Zstd files which were streamed have no size
+// in their header and are decompressed to count it
+func (storage *compressedStorage) decodedSize(ctx context.Context, fileName string, size int64) (Encoding, int64, error) {
+	head, err := storage.readBytes(ctx, fileName, 0, zstd.HeaderMaxSize)
+	if err != nil {
+		return "", 0, err
+	}
+
+	switch encoding := encodingOf(head); encoding {
+	case Gzip:
+		trailer, err := storage.readBytes(ctx, fileName, max(size-4, 0), 4)
+		if err != nil || len(trailer) != 4 {
+			return "", 0, storageabstraction.NewPathError("stat", fileName, fs.ErrInvalid, err)
+		}
+		return Gzip, int64(binary.LittleEndian.Uint32(trailer)), nil
+
+	case Zstd:
+		var header zstd.Header
+		if header.Decode(head) == nil && header.HasFCS {
+			return Zstd, int64(header.FrameContentSize), nil
+		}
+
+		reader, err := storage.ReadContext(ctx, fileName)
+		if err != nil {
+			return "", 0, err
+		}
+		defer reader.Close()
+		size, err = io.Copy(io.Discard, reader)
+		return Zstd, size, err
+	}
+	return "", size, nil
+}
+
+// decodedInfo replaces the size of the file info with the decompressed size, the content hash of the stored file
+// is dropped
+func (storage *compressedStorage) decodedInfo(ctx context.Context, fileName string, info fs.FileInfo) (fs.FileInfo, error) {
+	if info == nil || info.IsDir() || !storage.eligible(fileName) {
+		return info, nil
+	}
+
+	encoding, size, err := storage.decodedSize(ctx, fileName, info.Size())
+	if err != nil || encoding == "" {
+		return info, err
+	}
+
+	details := storageabstraction.FileDetails{Name: info.Name(), ModTime: info.ModTime(),
+		ContentType: storageabstraction.ContentTypeByName(fileName)}
+	if fileInfo, ok := info.(*storageabstraction.FileInfo); ok {
+		details = fileInfo.Details()
+	}
+	details.Size = size
+	details.ContentEncoding = ""
+	details.ContentMD5 = nil
+	return storageabstraction.NewFileInfoFromDetails(details), nil
+}
+
+func (storage *compressedStorage) FileSize(fileName string) (int64, error) {
+	return storage.FileSizeContext(context.Background(), fileName)
+}
+
+func (storage *compressedStorage) FileSizeContext(ctx context.Context, fileName string) (int64, error) {
+	size, err := storage.contextStorage().FileSizeContext(ctx, fileName)
+	if err != nil || !storage.eligible(fileName) {
+		return size, err
+	}
+
+	_, size, err = storage.decodedSize(ctx, fileName, size)
+	return size, err
+}
+
+func (storage *compressedStorage) Stat(fileName string) (*storageabstraction.FileInfo, error) {
+	return storage.StatContext(context.Background(), fileName)
+}
+
+// StatContext returns the details of the decompressed file
+func (storage *compressedStorage) StatContext(ctx context.Context, fileName string) (*storageabstraction.FileInfo, error) {
+	info, err := storageabstraction.StatContext(ctx, storage.storage, fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	decoded, err := storage.decodedInfo(ctx, fileName, info)
+	if err != nil {
+		return nil, err
+	}
+	return decoded.(*storageabstraction.FileInfo), nil
+}
+
+func (storage *compressedStorage) StatEncoded(fileName string) (*storageabstraction.FileInfo, error) {
+	return storage.StatEncodedContext(context.Background(), fileName)
+}
+
+// StatEncodedContext returns the details of the stored file, the content encoding is detected if the wrapped storage
+// does not keep it
+func (storage *compressedStorage) StatEncodedContext(ctx context.Context, fileName string) (*storageabstraction.FileInfo, error) {
+	info, err := storageabstraction.StatContext(ctx, storage.storage, fileName)
+	if err != nil || info.IsDir() || info.ContentEncoding() != "" || !storage.eligible(fileName) {
+		return info, err
+	}
+
+	head, err := storage.readBytes(ctx, fileName, 0, int64(len(zstdMagic)))
+	if err != nil {
+		return nil, err
+	}
+	encoding := encodingOf(head)
+	if encoding == "" {
+		return info, nil
+	}
+
+	details := info.Details()
+	details.ContentEncoding = string(encoding)
+	return storageabstraction.NewFileInfoFromDetails(details), nil
+}
+
+func (storage *compressedStorage) DeleteDirectory(directory string) error {
+	return storage.DeleteDirectoryContext(context.Background(), directory)
+}
+
+func (storage *compressedStorage) DeleteDirectoryContext(ctx context.Context, directory string) error {
+	return storage.contextStorage().DeleteDirectoryContext(ctx, directory)
+}
+
+func (storage *compressedStorage) DeleteFile(fileName string) error {
+	return storage.DeleteFileContext(context.Background(), fileName)
+}
+
+func (storage *compressedStorage) DeleteFileContext(ctx context.Context, fileName string) error {
+	return storage.contextStorage().DeleteFileContext(ctx, fileName)
+}
+
+func (storage *compressedStorage) DeleteFileWithConditions(fileName string, conditions storageabstraction.Conditions) error {
+	return storage.DeleteFileWithConditionsContext(context.Background(), fileName, conditions)
+}
+
+func (storage *compressedStorage) DeleteFileWithConditionsContext(ctx context.Context, fileName string, conditions storageabstraction.Conditions) error {
+	return storageabstraction.DeleteFileWithConditionsContext(ctx, storage.storage, fileName, conditions)
+}
+
+func (storage *compressedStorage) Walk(directory string, walk storageabstraction.WalkFunc) error {
+	return storage.WalkContext(context.Background(), directory, walk)
+}
+
+// WalkContext walks the wrapped storage with the decompressed sizes of the files
+func (storage *compressedStorage) WalkContext(ctx context.Context, directory string, walk storageabstraction.WalkFunc) error {
+	return storage.contextStorage().WalkContext(ctx, directory, func(filePath string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return walk(filePath, info, err)
+		}
+
+		info, err = storage.decodedInfo(ctx, storage.storage.Join(directory, filePath), info)
+		return walk(filePath, info, err)
+	})
+}
+
+func (storage *compressedStorage) List(directory string) ([]fs.FileInfo, error) {
+	return storage.ListContext(context.Background(), directory)
+}
+
+// ListContext lists the wrapped storage with the decompressed sizes of the files
+func (storage *compressedStorage) ListContext(ctx context.Context, directory string) ([]fs.FileInfo, error) {
+	entries, err := storageabstraction.ListContext(ctx, storage.storage, directory)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, entry := range entries {
+		if entries[i], err = storage.decodedInfo(ctx, storage.storage.Join(directory, entry.Name()), entry); err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+func (storage *compressedStorage) CopyFile(source string, destination string) error {
+	return storage.CopyFileContext(context.Background(), source, destination)
+}
+
+func (storage *compressedStorage) CopyFileContext(ctx context.Context, source string, destination string) error {
+	return storageabstraction.CopyFileContext(ctx, storage.storage, source, destination)
+}
+
+func (storage *compressedStorage) MoveFile(source string, destination string) error {
+	return storage.MoveFileContext(context.Background(), source, destination)
+}
+
+func (storage *compressedStorage) MoveFileContext(ctx context.Context, source string, destination string) error {
+	return storageabstraction.MoveFileContext(ctx, storage.storage, source, destination)
+}
+
+func (storage *compressedStorage) CopyDirectory(source string, destination string) error {
+	return storage.CopyDirectoryContext(context.Background(), source, destination)
+}
+
+func (storage *compressedStorage) CopyDirectoryContext(ctx context.Context, source string, destination string) error {
+	return storageabstraction.CopyDirectoryContext(ctx, storage.storage, source, destination)
+}
+
+func (storage *compressedStorage) MoveDirectory(source string, destination string) error {
+	return storage.MoveDirectoryContext(context.Background(), source, destination)
+}
+
+func (storage *compressedStorage) MoveDirectoryContext(ctx context.Context, source string, destination string) error {
+	return storageabstraction.MoveDirectoryContext(ctx, storage.storage, source, destination)
+}
+
+func (storage *compressedStorage) Join(paths ...string) string {
+	return storage.storage.Join(paths...)
+}
+
+func (storage *compressedStorage) Snapshot(fileName string) (string, error) {
+	return storage.SnapshotContext(context.Background(), fileName)
+}
+
+func (storage *compressedStorage) SnapshotContext(ctx context.Context, fileName string) (string, error) {
+	return storageabstraction.SnapshotContext(ctx, storage.storage, fileName)
+}
+
+func (storage *compressedStorage) Versions(path string) ([]storageabstraction.FileVersion, error) {
+	return storage.VersionsContext(context.Background(), path)
+}
+
+// VersionsContext returns the versions of the wrapped storage, the sizes of compressed versions are the compressed ones
+func (storage *compressedStorage) VersionsContext(ctx context.Context, path string) ([]storageabstraction.FileVersion, error) {
+	return storageabstraction.VersionsContext(ctx, storage.storage, path)
+}
+
+func (storage *compressedStorage) RestoreVersion(fileName string, versionID string) error {
+	return storage.RestoreVersionContext(context.Background(), fileName, versionID)
+}
+
+// RestoreVersionContext restores the version with the content encoding it was written with
+func (storage *compressedStorage) RestoreVersionContext(ctx context.Context, fileName string, versionID string) error {
+	return storageabstraction.RestoreVersionContext(ctx, storage.storage, fileName, versionID)
+}
+
+func (storage *compressedStorage) Undelete(fileName string) error {
+	return storage.UndeleteContext(context.Background(), fileName)
+}
+
+func (storage *compressedStorage) UndeleteContext(ctx context.Context, fileName string) error {
+	return storageabstraction.UndeleteContext(ctx, storage.storage, fileName)
+}
+
+func (storage *compressedStorage) TryLock(fileName string) (storageabstraction.ILock, error) {
+	return storage.TryLockContext(context.Background(), fileName)
+}
+
+func (storage *compressedStorage) TryLockContext(ctx context.Context, fileName string) (storageabstraction.ILock, error) {
+	return storageabstraction.TryLockContext(ctx, storage.storage, fileName)
+}
+
+func (storage *compressedStorage) Lock(fileName string) (storageabstraction.ILock, error) {
+	return storage.LockContext(context.Background(), fileName)
+}
+
+func (storage *compressedStorage) LockContext(ctx context.Context, fileName string) (storageabstraction.ILock, error) {
+	return storageabstraction.LockContext(ctx, storage.storage, fileName)
+}
+
+// compressingWriter keeps the content until it reaches the min size, then the file of the wrapped storage is opened
+// and the content is compressed into it. Smaller files are written uncompressed on Close
+type compressingWriter struct {
+	ctx      context.Context
+	cancel   context.CancelFunc
+	storage  *compressedStorage
+	fileName string
+	options  storageabstraction.WriteOptions
+	minSize  int64
+	size     int64
+
+	buffer  bytes.Buffer
+	writer  io.WriteCloser
+	encoder io.WriteCloser
+	err     error
+}
+
+// newWriter returns a writer for the file, size is the uncompressed size if it is known or -1
+func (storage *compressedStorage) newWriter(ctx context.Context, fileName string, options storageabstraction.WriteOptions, minSize int64, size int64) *compressingWriter {
+	// fail cancels the writer of the wrapped storage, e.g. if the encoder can not be created
+	ctx, cancel := context.WithCancel(ctx)
+	return &compressingWriter{ctx: ctx, cancel: cancel, storage: storage, fileName: fileName, options: options,
+		minSize: minSize, size: size}
+}
+
+func (writer *compressingWriter) Write(p []byte) (n int, err error) {
+	if writer.err != nil {
+		return 0, writer.err
+	}
+	if writer.encoder != nil {
+		n, err = writer.encoder.Write(p)
+		if err != nil {
+			writer.fail(err)
+		}
+		return n, err
+	}
+
+	writer.buffer.Write(p)
+	if int64(writer.buffer.Len()) >= writer.minSize {
+		if err = writer.open(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// open opens the file of the wrapped storage and writes the buffered content into it
+func (writer *compressingWriter) open(compress bool) error {
+	options := writer.options
+	if compress {
+		options.ContentEncoding = string(writer.storage.encoding)
+	}
+
+	var err error
+	writer.writer, err = storageabstraction.OpenWriterWithOptionsContext(writer.ctx, writer.storage.storage, writer.fileName, options)
+	if err != nil {
+		writer.fail(err)
+		return err
+	}
+
+	writer.encoder = nopCloser{writer.writer}
+	if compress {
+		if writer.encoder, err = newEncoder(writer.storage.encoding, writer.writer, writer.size); err != nil {
+			writer.fail(err)
+			return err
+		}
+	}
+
+	if _, err = writer.encoder.Write(writer.buffer.Bytes()); err != nil {
+		writer.fail(err)
+		return err
+	}
+	writer.buffer = bytes.Buffer{}
+	return nil
+}
+
+// fail aborts the write, Close returns the error
+func (writer *compressingWriter) fail(err error) {
+	if writer.err == nil {
+		writer.err = err
+	}
+	writer.cancel()
+}
+
+// Close writes the rest of the compressed content and commits the file
+func (writer *compressingWriter) Close() error {
+	defer writer.cancel()
+
+	if writer.err == nil && writer.encoder == nil {
+		_ = writer.open(false)
+	}
+	if writer.err == nil {
+		if err := writer.encoder.Close(); err != nil {
+			writer.fail(err)
+		}
+	}
+
+	if writer.writer == nil {
+		return writer.err
+	}
+	if err := writer.writer.Close(); writer.err == nil {
+		return err
+	}
+	return writer.err
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
@@ -0,0 +1,79 @@
+package storageabstraction
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+)
+
+// ETagAny matches the ETag of every existing file, IfNoneMatch: ETagAny only writes files which do not exist yet
+const ETagAny = "*"
+
+// Conditions make a write or delete depend on the current ETag of the file, so concurrent writers
+// do not overwrite each other. A failed condition is reported with ErrPreconditionFailed
+type Conditions struct {
+	// IfMatch is the ETag the file must have, e.g. as returned by Stat, ETagAny requires an existing file
+	IfMatch string
+	// IfNoneMatch is an ETag the file must not have, ETagAny requires that the file does not exist
+	IfNoneMatch string
+}
+
+// IsZero is true if there are no conditions
+func (conditions Conditions) IsZero() bool {
+	return conditions.IfMatch == "" && conditions.IfNoneMatch == ""
+}
+
+// Check tests the conditions against the current ETag of the file, which is "" if the storage does not know it
+func (conditions Conditions) Check(op string, fileName string, exists bool, etag string) error {
+	matches := func(expected string) bool {
+		return exists && (expected == ETagAny || (etag != "" && expected == etag))
+	}
+
+	if conditions.IfMatch != "" && !matches(conditions.IfMatch) {
+		return NewPathError(op, fileName, ErrPreconditionFailed, nil)
+	}
+	if conditions.IfNoneMatch != "" && matches(conditions.IfNoneMatch) {
+		return NewPathError(op, fileName, ErrPreconditionFailed, nil)
+	}
+	return nil
+}
+
+// IConditionalFileStorage is implemented by storages which delete files depending on their ETag atomically,
+// conditional writes use the Conditions of the WriteOptions
+type IConditionalFileStorage interface {
+	DeleteFileWithConditions(fileName string, conditions Conditions) error
+	DeleteFileWithConditionsContext(ctx context.Context, fileName string, conditions Conditions) error
+}
+
+// DeleteFileWithConditions deletes the file if the conditions are met. Storages which do not implement
+// IConditionalFileStorage check the conditions before the delete, which is not atomic
+func DeleteFileWithConditions(storage IFileStorage, fileName string, conditions Conditions) error {
+	return DeleteFileWithConditionsContext(context.Background(), storage, fileName, conditions)
+}
+
+// DeleteFileWithConditionsContext is DeleteFileWithConditions with a context
+func DeleteFileWithConditionsContext(ctx context.Context, storage IFileStorage, fileName string, conditions Conditions) error {
+	if conditionalStorage, ok := storage.(IConditionalFileStorage); ok {
+		return conditionalStorage.DeleteFileWithConditionsContext(ctx, fileName, conditions)
+	}
+
+	if err := checkConditions(ctx, storage, "remove", fileName, conditions); err != nil {
+		return err
+	}
+	return WithContext(storage).DeleteFileContext(ctx, fileName)
+}
+
+// checkConditions compares the conditions with the ETag returned by Stat
+func checkConditions(ctx context.Context, storage IFileStorage, op string, fileName string, conditions Conditions) error {
+	if conditions.IsZero() {
+		return nil
+	}
+
+	info, err := StatContext(ctx, storage, fileName)
+	if errors.Is(err, fs.ErrNotExist) {
+		return conditions.Check(op, fileName, false, "")
+	} else if err != nil {
+		return err
+	}
+	return conditions.Check(op, fileName, true, info.ETag())
+}
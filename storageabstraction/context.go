@@ -0,0 +1,126 @@
+package storageabstraction
+
+import (
+	"context"
+	"io"
+	"os"
+)
+
+// IContextFileStorage is an IFileStorage whose operations can be cancelled or bound to a deadline.
+// The methods without a context behave like their context variant called with context.Background()
+type IContextFileStorage interface {
+	IFileStorage
+
+	WriteContext(ctx context.Context, fileName string, fileSize int64, reader io.ReadSeeker) error
+	ReadContext(ctx context.Context, fileName string) (io.ReadCloser, error)
+	FileSizeContext(ctx context.Context, fileName string) (int64, error)
+	DeleteDirectoryContext(ctx context.Context, directory string) error
+	DeleteFileContext(ctx context.Context, fileName string) error
+	WalkContext(ctx context.Context, directory string, walk WalkFunc) error
+}
+
+// WithContext returns the context aware variant of the storage.
+// Storages which do not support a context natively are wrapped, the context is then checked
+// before every operation, every walked entry and every read from a returned reader.
+func WithContext(storage IFileStorage) IContextFileStorage {
+	if contextStorage, ok := storage.(IContextFileStorage); ok {
+		return contextStorage
+	}
+
+	return &contextFileStorage{IFileStorage: storage}
+}
+
+type contextFileStorage struct {
+	IFileStorage
+}
+
+func (storage *contextFileStorage) WriteContext(ctx context.Context, fileName string, fileSize int64, reader io.ReadSeeker) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return storage.Write(fileName, fileSize, NewContextReadSeeker(ctx, reader))
+}
+
+func (storage *contextFileStorage) ReadContext(ctx context.Context, fileName string) (io.ReadCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	reader, err := storage.Read(fileName)
+	if err != nil {
+		return nil, err
+	}
+	return NewContextReadCloser(ctx, reader), nil
+}
+
+func (storage *contextFileStorage) FileSizeContext(ctx context.Context, fileName string) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	return storage.FileSize(fileName)
+}
+
+func (storage *contextFileStorage) DeleteDirectoryContext(ctx context.Context, directory string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return storage.DeleteDirectory(directory)
+}
+
+func (storage *contextFileStorage) DeleteFileContext(ctx context.Context, fileName string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return storage.DeleteFile(fileName)
+}
+
+func (storage *contextFileStorage) WalkContext(ctx context.Context, directory string, walk WalkFunc) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return storage.Walk(directory, func(path string, info os.FileInfo, err error) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		return walk(path, info, err)
+	})
+}
+
+type contextReadCloser struct {
+	ctx    context.Context
+	reader io.ReadCloser
+}
+
+// NewContextReadCloser wraps the reader, so every Read fails with the context error as soon as the context is done
+func NewContextReadCloser(ctx context.Context, reader io.ReadCloser) io.ReadCloser {
+	return &contextReadCloser{ctx: ctx, reader: reader}
+}
+
+func (reader *contextReadCloser) Read(p []byte) (n int, err error) {
+	if err := reader.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return reader.reader.Read(p)
+}
+
+func (reader *contextReadCloser) Close() error {
+	return reader.reader.Close()
+}
+
+type contextReadSeeker struct {
+	io.ReadSeeker
+	ctx context.Context
+}
+
+// NewContextReadSeeker wraps the reader, so every Read fails with the context error as soon as the context is done
+func NewContextReadSeeker(ctx context.Context, reader io.ReadSeeker) io.ReadSeeker {
+	return &contextReadSeeker{ReadSeeker: reader, ctx: ctx}
+}
+
+func (reader *contextReadSeeker) Read(p []byte) (n int, err error) {
+	if err := reader.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return reader.ReadSeeker.Read(p)
+}
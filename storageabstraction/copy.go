@@ -0,0 +1,155 @@
+package storageabstraction
+
+import (
+	"context"
+	"os"
+)
+
+// ICopyFileStorage is implemented by storages which can copy and move files within the storage,
+// without transferring the content through the client.
+// Copying or moving a directory merges it into the destination directory, existing files are replaced.
+type ICopyFileStorage interface {
+	CopyFile(source string, destination string) error
+	CopyFileContext(ctx context.Context, source string, destination string) error
+	MoveFile(source string, destination string) error
+	MoveFileContext(ctx context.Context, source string, destination string) error
+
+	CopyDirectory(source string, destination string) error
+	CopyDirectoryContext(ctx context.Context, source string, destination string) error
+	MoveDirectory(source string, destination string) error
+	MoveDirectoryContext(ctx context.Context, source string, destination string) error
+}
+
+// CopyFile copies the file within the storage.
+// Storages which do not implement ICopyFileStorage read the file and write it again
+func CopyFile(storage IFileStorage, source string, destination string) error {
+	return CopyFileContext(context.Background(), storage, source, destination)
+}
+
+// CopyFileContext is CopyFile with a context
+func CopyFileContext(ctx context.Context, storage IFileStorage, source string, destination string) error {
+	if copyStorage, ok := storage.(ICopyFileStorage); ok {
+		return copyStorage.CopyFileContext(ctx, source, destination)
+	}
+	return CopyFileBetweenContext(ctx, storage, source, storage, destination)
+}
+
+// MoveFile moves the file within the storage.
+// Storages which do not implement ICopyFileStorage copy the file and delete the source
+func MoveFile(storage IFileStorage, source string, destination string) error {
+	return MoveFileContext(context.Background(), storage, source, destination)
+}
+
+// MoveFileContext is MoveFile with a context
+func MoveFileContext(ctx context.Context, storage IFileStorage, source string, destination string) error {
+	if copyStorage, ok := storage.(ICopyFileStorage); ok {
+		return copyStorage.MoveFileContext(ctx, source, destination)
+	}
+	return MoveFileBetweenContext(ctx, storage, source, storage, destination)
+}
+
+// CopyDirectory copies all files of the directory within the storage.
+// Storages which do not implement ICopyFileStorage read every file and write it again
+func CopyDirectory(storage IFileStorage, source string, destination string) error {
+	return CopyDirectoryContext(context.Background(), storage, source, destination)
+}
+
+// CopyDirectoryContext is CopyDirectory with a context
+func CopyDirectoryContext(ctx context.Context, storage IFileStorage, source string, destination string) error {
+	if copyStorage, ok := storage.(ICopyFileStorage); ok {
+		return copyStorage.CopyDirectoryContext(ctx, source, destination)
+	}
+	return CopyDirectoryBetweenContext(ctx, storage, source, storage, destination)
+}
+
+// MoveDirectory moves all files of the directory within the storage.
+// Storages which do not implement ICopyFileStorage copy the files and delete the source directory
+func MoveDirectory(storage IFileStorage, source string, destination string) error {
+	return MoveDirectoryContext(context.Background(), storage, source, destination)
+}
+
+// MoveDirectoryContext is MoveDirectory with a context
+func MoveDirectoryContext(ctx context.Context, storage IFileStorage, source string, destination string) error {
+	if copyStorage, ok := storage.(ICopyFileStorage); ok {
+		return copyStorage.MoveDirectoryContext(ctx, source, destination)
+	}
+	return MoveDirectoryBetweenContext(ctx, storage, source, storage, destination)
+}
+
+// CopyFileBetween copies a file from one storage to another one, by streaming the content through the client
+func CopyFileBetween(source IFileStorage, sourcePath string, destination IFileStorage, destinationPath string) error {
+	return CopyFileBetweenContext(context.Background(), source, sourcePath, destination, destinationPath)
+}
+
+// CopyFileBetweenContext is CopyFileBetween with a context
+func CopyFileBetweenContext(ctx context.Context, source IFileStorage, sourcePath string,
+	destination IFileStorage, destinationPath string) error {
+	reader, err := WithContext(source).ReadContext(ctx, sourcePath)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	return WriteFromContext(ctx, destination, destinationPath, reader)
+}
+
+// MoveFileBetween copies a file from one storage to another one and deletes it in the source storage
+func MoveFileBetween(source IFileStorage, sourcePath string, destination IFileStorage, destinationPath string) error {
+	return MoveFileBetweenContext(context.Background(), source, sourcePath, destination, destinationPath)
+}
+
+// MoveFileBetweenContext is MoveFileBetween with a context
+func MoveFileBetweenContext(ctx context.Context, source IFileStorage, sourcePath string,
+	destination IFileStorage, destinationPath string) error {
+	if err := CopyFileBetweenContext(ctx, source, sourcePath, destination, destinationPath); err != nil {
+		return err
+	}
+	return WithContext(source).DeleteFileContext(ctx, sourcePath)
+}
+
+// CopyDirectoryBetween copies all files of a directory from one storage to another one
+func CopyDirectoryBetween(source IFileStorage, sourcePath string, destination IFileStorage, destinationPath string) error {
+	return CopyDirectoryBetweenContext(context.Background(), source, sourcePath, destination, destinationPath)
+}
+
+// CopyDirectoryBetweenContext is CopyDirectoryBetween with a context
+func CopyDirectoryBetweenContext(ctx context.Context, source IFileStorage, sourcePath string,
+	destination IFileStorage, destinationPath string) error {
+	// collect the files first, so the walk is not affected by writes into the same storage
+	var files []string
+	err := WithContext(source).WalkContext(ctx, sourcePath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		err = CopyFileBetweenContext(ctx, source, source.Join(sourcePath, file), destination, destination.Join(destinationPath, file))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// MoveDirectoryBetween copies all files of a directory from one storage to another one and deletes the source directory
+func MoveDirectoryBetween(source IFileStorage, sourcePath string, destination IFileStorage, destinationPath string) error {
+	return MoveDirectoryBetweenContext(context.Background(), source, sourcePath, destination, destinationPath)
+}
+
+// MoveDirectoryBetweenContext is MoveDirectoryBetween with a context
+func MoveDirectoryBetweenContext(ctx context.Context, source IFileStorage, sourcePath string,
+	destination IFileStorage, destinationPath string) error {
+	if err := CopyDirectoryBetweenContext(ctx, source, sourcePath, destination, destinationPath); err != nil {
+		return err
+	}
+	return WithContext(source).DeleteDirectoryContext(ctx, sourcePath)
+}
@@ -0,0 +1,43 @@
+package storageabstraction
+
+import (
+	"context"
+	"io"
+)
+
+// IEncodedFileStorage is implemented by storages which keep the files encoded, e.g. compressed, and decode them when
+// they are read. The encoded files can be passed on as they are, e.g. to http clients which accept the encoding
+type IEncodedFileStorage interface {
+	StatEncoded(fileName string) (*FileInfo, error)
+	StatEncodedContext(ctx context.Context, fileName string) (*FileInfo, error)
+	ReadEncodedRange(fileName string, offset int64, length int64) (io.ReadCloser, error)
+	ReadEncodedRangeContext(ctx context.Context, fileName string, offset int64, length int64) (io.ReadCloser, error)
+}
+
+// StatEncoded returns the details of the file as it is stored, its ContentEncoding is empty if it is not encoded.
+// For storages which do not implement IEncodedFileStorage it is the same as Stat
+func StatEncoded(storage IFileStorage, fileName string) (*FileInfo, error) {
+	return StatEncodedContext(context.Background(), storage, fileName)
+}
+
+// StatEncodedContext is StatEncoded with a context
+func StatEncodedContext(ctx context.Context, storage IFileStorage, fileName string) (*FileInfo, error) {
+	if encodedStorage, ok := storage.(IEncodedFileStorage); ok {
+		return encodedStorage.StatEncodedContext(ctx, fileName)
+	}
+	return StatContext(ctx, storage, fileName)
+}
+
+// ReadEncodedRange reads the range of the file as it is stored, without decoding it.
+// For storages which do not implement IEncodedFileStorage it is the same as ReadRange
+func ReadEncodedRange(storage IFileStorage, fileName string, offset int64, length int64) (io.ReadCloser, error) {
+	return ReadEncodedRangeContext(context.Background(), storage, fileName, offset, length)
+}
+
+// ReadEncodedRangeContext is ReadEncodedRange with a context
+func ReadEncodedRangeContext(ctx context.Context, storage IFileStorage, fileName string, offset int64, length int64) (io.ReadCloser, error) {
+	if encodedStorage, ok := storage.(IEncodedFileStorage); ok {
+		return encodedStorage.ReadEncodedRangeContext(ctx, fileName, offset, length)
+	}
+	return ReadRangeContext(ctx, storage, fileName, offset, length)
+}
@@ -0,0 +1,221 @@
+package encryption
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+const (
+	defaultChunkSize = 64 * 1024
+	// maxChunkSize keeps the chunks in the memory and the sizes in the header
+	maxChunkSize = 16 * 1024 * 1024
+
+	// magic starts every encrypted file, the version is part of it
+	magic = "GKE1"
+	// prefixLength is the length of the magic, the header length and the chunk size, which are enough to calculate
+	// the plaintext size from the encrypted size
+	prefixLength = 12
+	// headerReadLength is read at once for the header, so most headers need only one request
+	headerReadLength = 1024
+
+	dataKeyLength = 32
+	tagSize       = 16
+	nonceSize     = 12
+)
+
+// ErrInvalidCiphertext is returned if a file can not be decrypted, because it is not encrypted, was modified or
+// truncated, or its data key can not be unwrapped
+var ErrInvalidCiphertext = errors.New("invalid encrypted file")
+
+// Option configures the encryption
+type Option func(storage *encryptedStorage)
+
+// WithChunkSize sets the size of the plaintext chunks which are encrypted separately, the default is 64 KiB.
+// Range reads decrypt whole chunks, each chunk adds 16 bytes to the file. Files written with another chunk size
+// can still be read
+func WithChunkSize(chunkSize int) Option {
+	return func(storage *encryptedStorage) {
+		if chunkSize > 0 && chunkSize <= maxChunkSize {
+			storage.chunkSize = chunkSize
+		}
+	}
+}
+
+// IKeyProvider wraps the data keys of the files with a key encryption key, e.g. of a key vault or a KMS
+type IKeyProvider interface {
+	// WrapKey encrypts the data key of a new file, the key ID is stored with the file to unwrap the key again
+	WrapKey(ctx context.Context, dataKey []byte) (keyID string, wrappedKey []byte, err error)
+	UnwrapKey(ctx context.Context, keyID string, wrappedKey []byte) ([]byte, error)
+}
+
+// staticKeyProvider wraps the data keys with AES-GCM keys held in the memory
+type staticKeyProvider struct {
+	currentKeyID string
+	keys         map[string]cipher.AEAD
+}
+
+// NewStaticKeyProvider wraps the data keys with the AES keys of 16, 24 or 32 bytes by their key ID. New files use
+// the current key, the other keys unwrap the files which were written before a key rotation
+func NewStaticKeyProvider(currentKeyID string, keys map[string][]byte) (IKeyProvider, error) {
+	if _, ok := keys[currentKeyID]; !ok {
+		return nil, fmt.Errorf("key %q is missing", currentKeyID)
+	}
+
+	provider := &staticKeyProvider{currentKeyID: currentKeyID, keys: map[string]cipher.AEAD{}}
+	for keyID, key := range keys {
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", keyID, err)
+		}
+		provider.keys[keyID] = aead
+	}
+	return provider, nil
+}
+
+// WrapKey encrypts the data key with the current key, the wrapped key is the nonce followed by the sealed key
+func (provider *staticKeyProvider) WrapKey(_ context.Context, dataKey []byte) (string, []byte, error) {
+	nonce := make([]byte, nonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", nil, err
+	}
+
+	aead := provider.keys[provider.currentKeyID]
+	return provider.currentKeyID, aead.Seal(nonce, nonce, dataKey, []byte(provider.currentKeyID)), nil
+}
+
+func (provider *staticKeyProvider) UnwrapKey(_ context.Context, keyID string, wrappedKey []byte) ([]byte, error) {
+	aead, ok := provider.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", keyID)
+	}
+	if len(wrappedKey) < nonceSize {
+		return nil, errors.New("wrapped key is too short")
+	}
+
+	return aead.Open(nil, wrappedKey[:nonceSize], wrappedKey[nonceSize:], []byte(keyID))
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// header starts the encrypted file:
+// magic | header length uint32 | chunk size uint32 | key ID length uint16 | key ID | wrapped key length uint16 | wrapped key
+//
+// The chunks follow the header, every chunk is sealed with the data key and the whole header as additional data.
+// The nonce is the index of the chunk with a flag for the last chunk, so chunks can not be reordered or cut off
+type header struct {
+	raw        []byte
+	chunkSize  int64
+	keyID      string
+	wrappedKey []byte
+}
+
+func newHeader(chunkSize int, keyID string, wrappedKey []byte) (*header, error) {
+	if len(keyID) > math.MaxUint16 || len(wrappedKey) > math.MaxUint16 {
+		return nil, errors.New("key ID or wrapped key is too long")
+	}
+
+	raw := make([]byte, prefixLength, prefixLength+4+len(keyID)+len(wrappedKey))
+	copy(raw, magic)
+	raw = binary.BigEndian.AppendUint16(raw, uint16(len(keyID)))
+	raw = append(raw, keyID...)
+	raw = binary.BigEndian.AppendUint16(raw, uint16(len(wrappedKey)))
+	raw = append(raw, wrappedKey...)
+	binary.BigEndian.PutUint32(raw[4:], uint32(len(raw)))
+	binary.BigEndian.PutUint32(raw[8:], uint32(chunkSize))
+
+	return &header{raw: raw, chunkSize: int64(chunkSize), keyID: keyID, wrappedKey: wrappedKey}, nil
+}
+
+// parsePrefix returns the header length and the chunk size
+func parsePrefix(prefix []byte) (int64, int64, error) {
+	if len(prefix) < prefixLength || string(prefix[:4]) != magic {
+		return 0, 0, ErrInvalidCiphertext
+	}
+
+	headerLength := int64(binary.BigEndian.Uint32(prefix[4:]))
+	chunkSize := int64(binary.BigEndian.Uint32(prefix[8:]))
+	if headerLength < prefixLength+4 || chunkSize <= 0 || chunkSize > maxChunkSize {
+		return 0, 0, ErrInvalidCiphertext
+	}
+	return headerLength, chunkSize, nil
+}
+
+func parseHeader(raw []byte) (*header, error) {
+	headerLength, chunkSize, err := parsePrefix(raw)
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(raw)) != headerLength {
+		return nil, ErrInvalidCiphertext
+	}
+
+	rest := raw[prefixLength:]
+	keyID, rest, ok := cutField(rest)
+	if !ok {
+		return nil, ErrInvalidCiphertext
+	}
+	wrappedKey, rest, ok := cutField(rest)
+	if !ok || len(rest) != 0 {
+		return nil, ErrInvalidCiphertext
+	}
+
+	return &header{raw: raw, chunkSize: chunkSize, keyID: string(keyID), wrappedKey: wrappedKey}, nil
+}
+
+// cutField returns the field with an uint16 length and the bytes after it
+func cutField(data []byte) ([]byte, []byte, bool) {
+	if len(data) < 2 {
+		return nil, nil, false
+	}
+	length := int(binary.BigEndian.Uint16(data))
+	if len(data) < 2+length {
+		return nil, nil, false
+	}
+	return data[2 : 2+length], data[2+length:], true
+}
+
+func (header *header) length() int64 {
+	return int64(len(header.raw))
+}
+
+// encryptedSize is the size of the encrypted file, an empty file has one empty chunk
+func encryptedSize(headerLength int64, chunkSize int64, size int64) int64 {
+	return headerLength + size + chunkCount(chunkSize, size)*tagSize
+}
+
+func chunkCount(chunkSize int64, size int64) int64 {
+	return max((size+chunkSize-1)/chunkSize, 1)
+}
+
+// plaintextSize is the size of the decrypted file
+func plaintextSize(headerLength int64, chunkSize int64, encryptedSize int64) (int64, error) {
+	body := encryptedSize - headerLength
+	fullChunk := chunkSize + tagSize
+	chunks := (body + fullChunk - 1) / fullChunk
+	if body < tagSize || body-(chunks-1)*fullChunk < tagSize {
+		return 0, ErrInvalidCiphertext
+	}
+	return body - chunks*tagSize, nil
+}
+
+// chunkNonce is the nonce of the chunk with the index, the last byte marks the last chunk
+func chunkNonce(index int64, last bool) []byte {
+	nonce := make([]byte, nonceSize)
+	binary.BigEndian.PutUint64(nonce[3:], uint64(index))
+	if last {
+		nonce[nonceSize-1] = 1
+	}
+	return nonce
+}
@@ -0,0 +1,192 @@
+package encryption
+
+import (
+	"bytes"
+	"errors"
+	"github.com/2flow/gokies/compression"
+	"github.com/2flow/gokies/httputils"
+	"github.com/2flow/gokies/storageabstraction"
+	"github.com/2flow/gokies/storageabstraction/memorystorage"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newKeyProvider(t *testing.T, currentKeyID string) IKeyProvider {
+	keys, err := NewStaticKeyProvider(currentKeyID, map[string][]byte{
+		"old": bytes.Repeat([]byte{1}, 32),
+		"new": bytes.Repeat([]byte{2}, 32),
+	})
+	if err != nil {
+		t.Errorf("[TestError] Error creating key provider: %v", err)
+	}
+	return keys
+}
+
+func content(size int) []byte {
+	content := make([]byte, size)
+	for i := range content {
+		content[i] = byte('a' + i%26)
+	}
+	return content
+}
+
+func readAll(storage storageabstraction.IFileStorage, fileName string, offset int64, length int64) ([]byte, error) {
+	reader, err := storageabstraction.ReadRange(storage, fileName, offset, length)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+func TestEncryptedStorage(t *testing.T) {
+	wrapped := memorystorage.NewMemoryStorage()
+	storage := NewEncryptedStorage(wrapped, newKeyProvider(t, "new"), WithChunkSize(16))
+
+	for _, size := range []int{0, 1, 15, 16, 17, 48, 100} {
+		plaintext := content(size)
+		if err := storage.Write("file.txt", int64(size), bytes.NewReader(plaintext)); err != nil {
+			t.Errorf("Error writing %d bytes: %v", size, err)
+			continue
+		}
+
+		encrypted, err := readAll(wrapped, "file.txt", 0, storageabstraction.CountToEnd)
+		if err != nil || (size > 4 && bytes.Contains(encrypted, plaintext[:5])) {
+			t.Errorf("Expected the wrapped file to be encrypted, size: %d, err: %v", size, err)
+		}
+		if actual, err := readAll(storage, "file.txt", 0, storageabstraction.CountToEnd); err != nil || !bytes.Equal(actual, plaintext) {
+			t.Errorf("Expected the decrypted content of %d bytes, actual: %q, err: %v", size, actual, err)
+		}
+		if fileSize, err := storage.FileSize("file.txt"); err != nil || fileSize != int64(size) {
+			t.Errorf("Expected the plaintext size %d, actual: %d, err: %v", size, fileSize, err)
+		}
+		if info, err := storageabstraction.Stat(storage, "file.txt"); err != nil || info.Size() != int64(size) ||
+			info.ContentMD5() != nil || info.ContentType() != "text/plain; charset=utf-8" {
+			t.Errorf("Expected the details of the plaintext, actual: %v, err: %v", info, err)
+		}
+
+		for _, part := range [][2]int64{{0, 5}, {3, 0}, {16, 16}, {17, 40}, {20, 1}} {
+			if part[0] > int64(size) {
+				continue
+			}
+			end := int64(size)
+			if part[1] != storageabstraction.CountToEnd {
+				end = min(end, part[0]+part[1])
+			}
+			if actual, err := readAll(storage, "file.txt", part[0], part[1]); err != nil || !bytes.Equal(actual, plaintext[part[0]:end]) {
+				t.Errorf("Expected the range %v of %d bytes, actual: %q, err: %v", part, size, actual, err)
+			}
+		}
+
+		// streamed files are the same as written files
+		writer, err := storageabstraction.OpenWriter(storage, "streamed.txt")
+		if err != nil {
+			t.Errorf("Error opening writer: %v", err)
+			continue
+		}
+		for offset := 0; offset < size; offset += 7 {
+			_, _ = writer.Write(plaintext[offset:min(offset+7, size)])
+		}
+		if err = writer.Close(); err != nil {
+			t.Errorf("Error closing writer: %v", err)
+		}
+		if actual, err := readAll(storage, "streamed.txt", 0, storageabstraction.CountToEnd); err != nil || !bytes.Equal(actual, plaintext) {
+			t.Errorf("Expected the decrypted stream of %d bytes, actual: %q, err: %v", size, actual, err)
+		}
+		if encryptedSize, _ := wrapped.FileSize("streamed.txt"); encryptedSize != int64(len(encrypted)) {
+			t.Errorf("Expected the streamed file to have the size of the written file, actual: %d", encryptedSize)
+		}
+	}
+
+	entries, err := storageabstraction.List(storage, "")
+	if err != nil || len(entries) != 2 || entries[0].Size() != 100 {
+		t.Errorf("Expected the listed files with the plaintext size, actual: %v, err: %v", entries, err)
+	}
+}
+
+func TestEncryptedStorageWrongFileSize(t *testing.T) {
+	storage := NewEncryptedStorage(memorystorage.NewMemoryStorage(), newKeyProvider(t, "new"), WithChunkSize(4))
+
+	// the size is taken from the reader, so a wrong file size neither truncates the content nor panics
+	for _, fileSize := range []int64{3, -1, 100} {
+		reader := bytes.NewReader([]byte("xyabcdefgh"))
+		_, _ = reader.Seek(2, io.SeekStart)
+		if err := storage.Write("file.txt", fileSize, reader); err != nil {
+			t.Errorf("Error writing with the file size %d: %v", fileSize, err)
+			continue
+		}
+		if actual, err := readAll(storage, "file.txt", 0, storageabstraction.CountToEnd); err != nil || string(actual) != "abcdefgh" {
+			t.Errorf("Expected the whole content with the file size %d, actual: %q, err: %v", fileSize, actual, err)
+		}
+	}
+}
+
+func TestEncryptedStorageModified(t *testing.T) {
+	wrapped := memorystorage.NewMemoryStorage()
+	storage := NewEncryptedStorage(wrapped, newKeyProvider(t, "old"), WithChunkSize(16))
+	if err := storage.Write("file.txt", 48, bytes.NewReader(content(48))); err != nil {
+		t.Errorf("[TestError] Error writing file: %v", err)
+		return
+	}
+	encrypted, _ := readAll(wrapped, "file.txt", 0, storageabstraction.CountToEnd)
+
+	modify := func(name string, modified []byte) {
+		_ = wrapped.Write("modified.txt", int64(len(modified)), bytes.NewReader(modified))
+		if _, err := readAll(storage, "modified.txt", 0, storageabstraction.CountToEnd); !errors.Is(err, ErrInvalidCiphertext) {
+			t.Errorf("Expected %s to fail the decryption, actual: %v", name, err)
+		}
+	}
+	flipped := bytes.Clone(encrypted)
+	flipped[len(flipped)-20] ^= 1
+	modify("flipped byte", flipped)
+	modify("cut off chunk", encrypted[:len(encrypted)-32])
+	modify("appended bytes", append(bytes.Clone(encrypted), 0))
+	modify("plaintext file", []byte("not encrypted"))
+
+	if _, err := storage.FileSize("modified.txt"); !errors.Is(err, ErrInvalidCiphertext) {
+		t.Errorf("Expected the size of a plaintext file to fail, actual: %v", err)
+	}
+
+	// after a key rotation the files of the old key can be read
+	rotated := NewEncryptedStorage(wrapped, newKeyProvider(t, "new"))
+	if actual, err := readAll(rotated, "file.txt", 20, 10); err != nil || !bytes.Equal(actual, content(48)[20:30]) {
+		t.Errorf("Expected the file of the old key to be read, actual: %q, err: %v", actual, err)
+	}
+	otherKeys, _ := NewStaticKeyProvider("other", map[string][]byte{"other": bytes.Repeat([]byte{3}, 32)})
+	if _, err := NewEncryptedStorage(wrapped, otherKeys).Read("file.txt"); !errors.Is(err, ErrInvalidCiphertext) {
+		t.Errorf("Expected an unknown key to fail, actual: %v", err)
+	}
+}
+
+func TestEncryptedStorageTransparent(t *testing.T) {
+	source := NewEncryptedStorage(memorystorage.NewMemoryStorage(), newKeyProvider(t, "new"), WithChunkSize(1024))
+	index := content(5000)
+	if err := source.Write("site/index.html", int64(len(index)), bytes.NewReader(index)); err != nil {
+		t.Errorf("[TestError] Error writing file: %v", err)
+		return
+	}
+
+	var archive bytes.Buffer
+	if err := compression.NewCompression(source).CompressDir("site", &archive); err != nil {
+		t.Errorf("Error compressing encrypted files: %v", err)
+		return
+	}
+	destination := NewEncryptedStorage(memorystorage.NewMemoryStorage(), newKeyProvider(t, "new"))
+	if _, err := compression.NewGzipExtractor(destination).ExtractFromStream("copy", &archive); err != nil {
+		t.Errorf("Error extracting into encrypted files: %v", err)
+		return
+	}
+
+	container := httputils.HTTPFileContainer{FileStorage: destination}
+	request := httptest.NewRequest(http.MethodGet, "/copy/index.html", nil)
+	request.Header.Set("Sec-Fetch-Dest", "script")
+	request.Header.Set("Range", "bytes=2000-2999")
+	recorder := httptest.NewRecorder()
+	container.ProvideFileHandler().ServeHTTP(recorder, request)
+
+	if recorder.Code != http.StatusPartialContent || !bytes.Equal(recorder.Body.Bytes(), index[2000:3000]) {
+		t.Errorf("Expected the decrypted range, actual: %d %q", recorder.Code, recorder.Body.String())
+	}
+}
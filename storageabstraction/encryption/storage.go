@@ -0,0 +1,452 @@
+package encryption
+
+import (
+	"context"
+	"crypto/cipher"
+	"crypto/rand"
+	"github.com/2flow/gokies/storageabstraction"
+	"io"
+	"io/fs"
+)
+
+// encryptedStorage encrypts the files of the wrapped storage
+type encryptedStorage struct {
+	storage   storageabstraction.IFileStorage
+	keys      IKeyProvider
+	chunkSize int
+}
+
+// NewEncryptedStorage wraps the storage, so the files are encrypted with AES-GCM before they are written and
+// decrypted when they are read. Every file has its own data key, which is stored with the file wrapped by the key
+// provider. The sizes and details of the files are the ones of the plaintext, which costs a small read per file.
+// Copies and moves keep the files encrypted, files which were not written by an encrypted storage can not be read
+func NewEncryptedStorage(storage storageabstraction.IFileStorage, keys IKeyProvider, options ...Option) storageabstraction.IContextFileStorage {
+	encrypted := &encryptedStorage{storage: storage, keys: keys, chunkSize: defaultChunkSize}
+	for _, option := range options {
+		option(encrypted)
+	}
+
+	return encrypted
+}
+
+func (storage *encryptedStorage) contextStorage() storageabstraction.IContextFileStorage {
+	return storageabstraction.WithContext(storage.storage)
+}
+
+// newDataKey creates the data key and the header of a new file
+func (storage *encryptedStorage) newDataKey(ctx context.Context) (cipher.AEAD, *header, error) {
+	dataKey := make([]byte, dataKeyLength)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, nil, err
+	}
+	keyID, wrappedKey, err := storage.keys.WrapKey(ctx, dataKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	header, err := newHeader(storage.chunkSize, keyID, wrappedKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	aead, err := newAEAD(dataKey)
+	return aead, header, err
+}
+
+// readHeader reads the header of the file and unwraps its data key
+func (storage *encryptedStorage) readHeader(ctx context.Context, fileName string) (cipher.AEAD, *header, error) {
+	reader, err := storageabstraction.ReadRangeContext(ctx, storage.storage, fileName, 0, headerReadLength)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer reader.Close()
+
+	return storage.parseHeader(ctx, fileName, reader)
+}
+
+// parseHeader reads the header from the start of the encrypted file and unwraps its data key
+func (storage *encryptedStorage) parseHeader(ctx context.Context, fileName string, reader io.Reader) (cipher.AEAD, *header, error) {
+	raw := make([]byte, prefixLength)
+	if _, err := io.ReadFull(reader, raw); err != nil {
+		return nil, nil, invalidFile("read", fileName, err)
+	}
+	headerLength, _, err := parsePrefix(raw)
+	if err != nil {
+		return nil, nil, invalidFile("read", fileName, nil)
+	}
+
+	raw = append(raw, make([]byte, headerLength-prefixLength)...)
+	if _, err = io.ReadFull(reader, raw[prefixLength:]); err != nil {
+		return nil, nil, invalidFile("read", fileName, err)
+	}
+	header, err := parseHeader(raw)
+	if err != nil {
+		return nil, nil, invalidFile("read", fileName, nil)
+	}
+
+	dataKey, err := storage.keys.UnwrapKey(ctx, header.keyID, header.wrappedKey)
+	if err != nil {
+		return nil, nil, storageabstraction.NewPathError("read", fileName, ErrInvalidCiphertext, err)
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, nil, storageabstraction.NewPathError("read", fileName, ErrInvalidCiphertext, err)
+	}
+	return aead, header, nil
+}
+
+// invalidFile is the error of a file which can not be decrypted, errors of reading the file are kept
+func invalidFile(op string, fileName string, err error) error {
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	return storageabstraction.NewPathError(op, fileName, ErrInvalidCiphertext, nil)
+}
+
+func (storage *encryptedStorage) Write(fileName string, fileSize int64, reader io.ReadSeeker) error {
+	return storage.WriteContext(context.Background(), fileName, fileSize, reader)
+}
+
+// WriteContext encrypts the file, its content type is detected from the plaintext
+func (storage *encryptedStorage) WriteContext(ctx context.Context, fileName string, fileSize int64, reader io.ReadSeeker) error {
+	return storage.WriteWithOptionsContext(ctx, fileName, fileSize, reader, storageabstraction.WriteOptions{})
+}
+
+func (storage *encryptedStorage) WriteWithOptions(fileName string, fileSize int64, reader io.ReadSeeker, options storageabstraction.WriteOptions) error {
+	return storage.WriteWithOptionsContext(context.Background(), fileName, fileSize, reader, options)
+}
+
+// WriteWithOptionsContext encrypts the file, the size of the plaintext is taken from the reader and the fileSize is
+// ignored like for the other storages
+func (storage *encryptedStorage) WriteWithOptionsContext(ctx context.Context, fileName string, _ int64, reader io.ReadSeeker, options storageabstraction.WriteOptions) error {
+	if options.ContentType == "" {
+		contentType, err := storageabstraction.DetectContentType(fileName, reader)
+		if err != nil {
+			return err
+		}
+		options.ContentType = contentType
+	}
+
+	aead, header, err := storage.newDataKey(ctx)
+	if err != nil {
+		return err
+	}
+	encrypted, err := newEncryptingReader(reader, aead, header)
+	if err != nil {
+		return err
+	}
+
+	return storageabstraction.WriteWithOptionsContext(ctx, storage.storage, fileName, encrypted.total, encrypted, options)
+}
+
+func (storage *encryptedStorage) OpenWriter(fileName string) (io.WriteCloser, error) {
+	return storage.OpenWriterContext(context.Background(), fileName)
+}
+
+func (storage *encryptedStorage) OpenWriterContext(ctx context.Context, fileName string) (io.WriteCloser, error) {
+	return storage.OpenWriterWithOptionsContext(ctx, fileName, storageabstraction.WriteOptions{})
+}
+
+func (storage *encryptedStorage) OpenWriterWithOptions(fileName string, options storageabstraction.WriteOptions) (io.WriteCloser, error) {
+	return storage.OpenWriterWithOptionsContext(context.Background(), fileName, options)
+}
+
+// OpenWriterWithOptionsContext returns a writer which encrypts the content, without content type it is detected from
+// the file extension only
+func (storage *encryptedStorage) OpenWriterWithOptionsContext(ctx context.Context, fileName string, options storageabstraction.WriteOptions) (io.WriteCloser, error) {
+	if options.ContentType == "" {
+		options.ContentType = storageabstraction.ContentTypeByName(fileName)
+	}
+
+	aead, header, err := storage.newDataKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Close cancels the writer of the wrapped storage if a chunk could not be encrypted or written
+	writerCtx, cancel := context.WithCancel(ctx)
+	writer, err := storageabstraction.OpenWriterWithOptionsContext(writerCtx, storage.storage, fileName, options)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	return &encryptingWriter{
+		writer: writer,
+		cancel: cancel,
+		aead:   aead,
+		header: header,
+		buffer: make([]byte, 0, storage.chunkSize),
+	}, nil
+}
+
+func (storage *encryptedStorage) Read(fileName string) (io.ReadCloser, error) {
+	return storage.ReadContext(context.Background(), fileName)
+}
+
+// ReadContext decrypts the file while it is read, a modified file fails with ErrInvalidCiphertext
+func (storage *encryptedStorage) ReadContext(ctx context.Context, fileName string) (io.ReadCloser, error) {
+	reader, err := storage.contextStorage().ReadContext(ctx, fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, header, err := storage.parseHeader(ctx, fileName, reader)
+	if err != nil {
+		_ = reader.Close()
+		return nil, err
+	}
+	return newDecryptingReader(reader, aead, header, 0, 0), nil
+}
+
+func (storage *encryptedStorage) ReadRange(fileName string, offset int64, length int64) (io.ReadCloser, error) {
+	return storage.ReadRangeContext(context.Background(), fileName, offset, length)
+}
+
+// ReadRangeContext reads the header, then the chunks from the one which contains the offset
+func (storage *encryptedStorage) ReadRangeContext(ctx context.Context, fileName string, offset int64, length int64) (io.ReadCloser, error) {
+	if offset == 0 {
+		reader, err := storage.ReadContext(ctx, fileName)
+		if err != nil {
+			return nil, err
+		}
+		return storageabstraction.LimitReadCloser(reader, length), nil
+	}
+
+	aead, header, err := storage.readHeader(ctx, fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	index := offset / header.chunkSize
+	// a range at the end of a chunk starts with this chunk, which tells if the file ends there or was cut off
+	if index > 0 && offset%header.chunkSize == 0 {
+		index--
+	}
+	reader, err := storageabstraction.ReadRangeContext(ctx, storage.storage, fileName,
+		header.length()+index*(header.chunkSize+tagSize), storageabstraction.CountToEnd)
+	if err != nil {
+		return nil, err
+	}
+
+	decrypted := newDecryptingReader(reader, aead, header, index, offset-index*header.chunkSize)
+	return storageabstraction.LimitReadCloser(decrypted, length), nil
+}
+
+func (storage *encryptedStorage) FileSize(fileName string) (int64, error) {
+	return storage.FileSizeContext(context.Background(), fileName)
+}
+
+func (storage *encryptedStorage) FileSizeContext(ctx context.Context, fileName string) (int64, error) {
+	size, err := storage.contextStorage().FileSizeContext(ctx, fileName)
+	if err != nil {
+		return 0, err
+	}
+	return storage.plaintextSize(ctx, fileName, size)
+}
+
+// plaintextSize reads the prefix of the file to calculate the decrypted size from the encrypted size
+func (storage *encryptedStorage) plaintextSize(ctx context.Context, fileName string, size int64) (int64, error) {
+	reader, err := storageabstraction.ReadRangeContext(ctx, storage.storage, fileName, 0, prefixLength)
+	if err != nil {
+		return 0, err
+	}
+	defer reader.Close()
+
+	prefix := make([]byte, prefixLength)
+	if _, err = io.ReadFull(reader, prefix); err != nil {
+		return 0, invalidFile("stat", fileName, err)
+	}
+	headerLength, chunkSize, err := parsePrefix(prefix)
+	if err == nil {
+		size, err = plaintextSize(headerLength, chunkSize, size)
+	}
+	if err != nil {
+		return 0, invalidFile("stat", fileName, nil)
+	}
+	return size, nil
+}
+
+// plaintextInfo replaces the size of the file info with the decrypted size, the content hash of the encrypted file
+// is dropped
+func (storage *encryptedStorage) plaintextInfo(ctx context.Context, fileName string, info fs.FileInfo) (fs.FileInfo, error) {
+	if info == nil || info.IsDir() {
+		return info, nil
+	}
+
+	size, err := storage.plaintextSize(ctx, fileName, info.Size())
+	if err != nil {
+		return nil, err
+	}
+
+	details := storageabstraction.FileDetails{Name: info.Name(), ModTime: info.ModTime(),
+		ContentType: storageabstraction.ContentTypeByName(fileName)}
+	if fileInfo, ok := info.(*storageabstraction.FileInfo); ok {
+		details = fileInfo.Details()
+	}
+	details.Size = size
+	details.ContentMD5 = nil
+	return storageabstraction.NewFileInfoFromDetails(details), nil
+}
+
+func (storage *encryptedStorage) Stat(fileName string) (*storageabstraction.FileInfo, error) {
+	return storage.StatContext(context.Background(), fileName)
+}
+
+func (storage *encryptedStorage) StatContext(ctx context.Context, fileName string) (*storageabstraction.FileInfo, error) {
+	info, err := storageabstraction.StatContext(ctx, storage.storage, fileName)
+	if err != nil || info.IsDir() {
+		return info, err
+	}
+
+	plaintext, err := storage.plaintextInfo(ctx, fileName, info)
+	if err != nil {
+		return nil, err
+	}
+	return plaintext.(*storageabstraction.FileInfo), nil
+}
+
+func (storage *encryptedStorage) DeleteDirectory(directory string) error {
+	return storage.DeleteDirectoryContext(context.Background(), directory)
+}
+
+func (storage *encryptedStorage) DeleteDirectoryContext(ctx context.Context, directory string) error {
+	return storage.contextStorage().DeleteDirectoryContext(ctx, directory)
+}
+
+func (storage *encryptedStorage) DeleteFile(fileName string) error {
+	return storage.DeleteFileContext(context.Background(), fileName)
+}
+
+func (storage *encryptedStorage) DeleteFileContext(ctx context.Context, fileName string) error {
+	return storage.contextStorage().DeleteFileContext(ctx, fileName)
+}
+
+func (storage *encryptedStorage) DeleteFileWithConditions(fileName string, conditions storageabstraction.Conditions) error {
+	return storage.DeleteFileWithConditionsContext(context.Background(), fileName, conditions)
+}
+
+func (storage *encryptedStorage) DeleteFileWithConditionsContext(ctx context.Context, fileName string, conditions storageabstraction.Conditions) error {
+	return storageabstraction.DeleteFileWithConditionsContext(ctx, storage.storage, fileName, conditions)
+}
+
+func (storage *encryptedStorage) Walk(directory string, walk storageabstraction.WalkFunc) error {
+	return storage.WalkContext(context.Background(), directory, walk)
+}
+
+// WalkContext walks the wrapped storage with the decrypted sizes of the files
+func (storage *encryptedStorage) WalkContext(ctx context.Context, directory string, walk storageabstraction.WalkFunc) error {
+	return storage.contextStorage().WalkContext(ctx, directory, func(filePath string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return walk(filePath, info, err)
+		}
+
+		info, err = storage.plaintextInfo(ctx, storage.storage.Join(directory, filePath), info)
+		return walk(filePath, info, err)
+	})
+}
+
+func (storage *encryptedStorage) List(directory string) ([]fs.FileInfo, error) {
+	return storage.ListContext(context.Background(), directory)
+}
+
+// ListContext lists the wrapped storage with the decrypted sizes of the files
+func (storage *encryptedStorage) ListContext(ctx context.Context, directory string) ([]fs.FileInfo, error) {
+	entries, err := storageabstraction.ListContext(ctx, storage.storage, directory)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, entry := range entries {
+		if entries[i], err = storage.plaintextInfo(ctx, storage.storage.Join(directory, entry.Name()), entry); err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+func (storage *encryptedStorage) CopyFile(source string, destination string) error {
+	return storage.CopyFileContext(context.Background(), source, destination)
+}
+
+func (storage *encryptedStorage) CopyFileContext(ctx context.Context, source string, destination string) error {
+	return storageabstraction.CopyFileContext(ctx, storage.storage, source, destination)
+}
+
+func (storage *encryptedStorage) MoveFile(source string, destination string) error {
+	return storage.MoveFileContext(context.Background(), source, destination)
+}
+
+func (storage *encryptedStorage) MoveFileContext(ctx context.Context, source string, destination string) error {
+	return storageabstraction.MoveFileContext(ctx, storage.storage, source, destination)
+}
+
+func (storage *encryptedStorage) CopyDirectory(source string, destination string) error {
+	return storage.CopyDirectoryContext(context.Background(), source, destination)
+}
+
+func (storage *encryptedStorage) CopyDirectoryContext(ctx context.Context, source string, destination string) error {
+	return storageabstraction.CopyDirectoryContext(ctx, storage.storage, source, destination)
+}
+
+func (storage *encryptedStorage) MoveDirectory(source string, destination string) error {
+	return storage.MoveDirectoryContext(context.Background(), source, destination)
+}
+
+func (storage *encryptedStorage) MoveDirectoryContext(ctx context.Context, source string, destination string) error {
+	return storageabstraction.MoveDirectoryContext(ctx, storage.storage, source, destination)
+}
+
+func (storage *encryptedStorage) Join(paths ...string) string {
+	return storage.storage.Join(paths...)
+}
+
+func (storage *encryptedStorage) Snapshot(fileName string) (string, error) {
+	return storage.SnapshotContext(context.Background(), fileName)
+}
+
+// SnapshotContext keeps the encrypted content with its wrapped data key, so the version stays readable
+func (storage *encryptedStorage) SnapshotContext(ctx context.Context, fileName string) (string, error) {
+	return storageabstraction.SnapshotContext(ctx, storage.storage, fileName)
+}
+
+func (storage *encryptedStorage) Versions(path string) ([]storageabstraction.FileVersion, error) {
+	return storage.VersionsContext(context.Background(), path)
+}
+
+// VersionsContext returns the versions of the wrapped storage, their sizes are the ones of the encrypted content
+func (storage *encryptedStorage) VersionsContext(ctx context.Context, path string) ([]storageabstraction.FileVersion, error) {
+	return storageabstraction.VersionsContext(ctx, storage.storage, path)
+}
+
+func (storage *encryptedStorage) RestoreVersion(fileName string, versionID string) error {
+	return storage.RestoreVersionContext(context.Background(), fileName, versionID)
+}
+
+func (storage *encryptedStorage) RestoreVersionContext(ctx context.Context, fileName string, versionID string) error {
+	return storageabstraction.RestoreVersionContext(ctx, storage.storage, fileName, versionID)
+}
+
+func (storage *encryptedStorage) Undelete(fileName string) error {
+	return storage.UndeleteContext(context.Background(), fileName)
+}
+
+func (storage *encryptedStorage) UndeleteContext(ctx context.Context, fileName string) error {
+	return storageabstraction.UndeleteContext(ctx, storage.storage, fileName)
+}
+
+func (storage *encryptedStorage) TryLock(fileName string) (storageabstraction.ILock, error) {
+	return storage.TryLockContext(context.Background(), fileName)
+}
+
+// TryLockContext locks the file of the wrapped storage, the content of a locked file is not changed
+func (storage *encryptedStorage) TryLockContext(ctx context.Context, fileName string) (storageabstraction.ILock, error) {
+	return storageabstraction.TryLockContext(ctx, storage.storage, fileName)
+}
+
+func (storage *encryptedStorage) Lock(fileName string) (storageabstraction.ILock, error) {
+	return storage.LockContext(context.Background(), fileName)
+}
+
+func (storage *encryptedStorage) LockContext(ctx context.Context, fileName string) (storageabstraction.ILock, error) {
+	return storageabstraction.LockContext(ctx, storage.storage, fileName)
+}
@@ -0,0 +1,261 @@
+package encryption
+
+import (
+	"context"
+	"crypto/cipher"
+	"errors"
+	"github.com/2flow/gokies/storageabstraction/common"
+	"io"
+)
+
+// encryptingReader is the encrypted content of the plaintext reader, the chunks are encrypted when they are read.
+// Seeking encrypts the chunk at the offset again, so backends can rewind it for retries
+type encryptingReader struct {
+	source      io.ReadSeeker
+	sourceStart int64
+	aead        cipher.AEAD
+	header      *header
+	size        int64
+	total       int64
+
+	offset     int64
+	chunk      []byte
+	chunkIndex int64
+}
+
+// newEncryptingReader encrypts the rest of the source, starting at its current position
+func newEncryptingReader(source io.ReadSeeker, aead cipher.AEAD, header *header) (*encryptingReader, error) {
+	sourceStart, err := source.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	size, err := common.ReaderSize(source)
+	if err != nil {
+		return nil, err
+	}
+
+	return &encryptingReader{
+		source:      source,
+		sourceStart: sourceStart,
+		aead:        aead,
+		header:      header,
+		size:        size,
+		total:       encryptedSize(header.length(), header.chunkSize, size),
+		chunkIndex:  -1,
+	}, nil
+}
+
+func (reader *encryptingReader) Read(p []byte) (n int, err error) {
+	if reader.offset >= reader.total {
+		return 0, io.EOF
+	}
+
+	if reader.offset < reader.header.length() {
+		n = copy(p, reader.header.raw[reader.offset:])
+		reader.offset += int64(n)
+		return n, nil
+	}
+
+	fullChunk := reader.header.chunkSize + tagSize
+	bodyOffset := reader.offset - reader.header.length()
+	if index := bodyOffset / fullChunk; index != reader.chunkIndex {
+		if err = reader.encryptChunk(index); err != nil {
+			return 0, err
+		}
+	}
+
+	n = copy(p, reader.chunk[bodyOffset-reader.chunkIndex*fullChunk:])
+	reader.offset += int64(n)
+	return n, nil
+}
+
+// encryptChunk reads and encrypts the chunk with the index, the source is only moved if the chunk does not follow
+// the previous one
+func (reader *encryptingReader) encryptChunk(index int64) error {
+	chunkSize := reader.header.chunkSize
+	if index != reader.chunkIndex+1 {
+		if _, err := reader.source.Seek(reader.sourceStart+index*chunkSize, io.SeekStart); err != nil {
+			return err
+		}
+	}
+
+	plaintext := make([]byte, min(chunkSize, reader.size-index*chunkSize), chunkSize+tagSize)
+	if _, err := io.ReadFull(reader.source, plaintext); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+
+	last := index == chunkCount(chunkSize, reader.size)-1
+	reader.chunk = reader.aead.Seal(plaintext[:0], chunkNonce(index, last), plaintext, reader.header.raw)
+	reader.chunkIndex = index
+	return nil
+}
+
+func (reader *encryptingReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += reader.offset
+	case io.SeekEnd:
+		offset += reader.total
+	}
+	if offset < 0 {
+		return 0, errors.New("seek before the start of the file")
+	}
+
+	reader.offset = offset
+	return offset, nil
+}
+
+// encryptingWriter encrypts the written content chunk by chunk. The last chunk is only known on Close,
+// so a full chunk is kept until more content is written
+type encryptingWriter struct {
+	writer io.WriteCloser
+	cancel context.CancelFunc
+	aead   cipher.AEAD
+	header *header
+
+	buffer        []byte
+	index         int64
+	headerWritten bool
+	err           error
+}
+
+func (writer *encryptingWriter) Write(p []byte) (n int, err error) {
+	if writer.err != nil {
+		return 0, writer.err
+	}
+
+	chunkSize := int(writer.header.chunkSize)
+	for len(p) > 0 {
+		if len(writer.buffer) == chunkSize {
+			if err = writer.flush(false); err != nil {
+				return n, err
+			}
+		}
+
+		copied := copy(writer.buffer[len(writer.buffer):chunkSize], p)
+		writer.buffer = writer.buffer[:len(writer.buffer)+copied]
+		p = p[copied:]
+		n += copied
+	}
+	return n, nil
+}
+
+// flush writes the buffered chunk, the header is written before the first chunk
+func (writer *encryptingWriter) flush(last bool) error {
+	if !writer.headerWritten {
+		if _, err := writer.writer.Write(writer.header.raw); err != nil {
+			writer.err = err
+			return err
+		}
+		writer.headerWritten = true
+	}
+
+	chunk := writer.aead.Seal(nil, chunkNonce(writer.index, last), writer.buffer, writer.header.raw)
+	if _, err := writer.writer.Write(chunk); err != nil {
+		writer.err = err
+		return err
+	}
+	writer.buffer = writer.buffer[:0]
+	writer.index++
+	return nil
+}
+
+// Close writes the last chunk and commits the file, after a failed write the file is not committed
+func (writer *encryptingWriter) Close() error {
+	defer writer.cancel()
+
+	if writer.err == nil {
+		_ = writer.flush(true)
+	}
+	if writer.err != nil {
+		writer.cancel()
+		_ = writer.writer.Close()
+		return writer.err
+	}
+	return writer.writer.Close()
+}
+
+// decryptingReader decrypts the chunks of the source, which starts at the chunk with the index.
+// Skip bytes of the first chunk are dropped, e.g. for a range which starts within the chunk
+type decryptingReader struct {
+	source io.ReadCloser
+	aead   cipher.AEAD
+	header *header
+
+	index     int64
+	skip      int64
+	buffer    []byte
+	plaintext []byte
+	last      bool
+	err       error
+}
+
+func newDecryptingReader(source io.ReadCloser, aead cipher.AEAD, header *header, index int64, skip int64) *decryptingReader {
+	return &decryptingReader{
+		source: source,
+		aead:   aead,
+		header: header,
+		index:  index,
+		skip:   skip,
+		buffer: make([]byte, header.chunkSize+tagSize),
+	}
+}
+
+func (reader *decryptingReader) Read(p []byte) (n int, err error) {
+	for len(reader.plaintext) == 0 {
+		if reader.err != nil {
+			return 0, reader.err
+		}
+		reader.err = reader.decryptChunk()
+	}
+
+	n = copy(p, reader.plaintext)
+	reader.plaintext = reader.plaintext[n:]
+	return n, nil
+}
+
+// decryptChunk decrypts the next chunk. A full chunk may be the last one, which is decided by its authentication.
+// After the last chunk the source must end, anything else is a modified file
+func (reader *decryptingReader) decryptChunk() error {
+	if reader.last {
+		if n, err := io.ReadFull(reader.source, reader.buffer[:1]); n > 0 {
+			return ErrInvalidCiphertext
+		} else if err != io.EOF {
+			return err
+		}
+		return io.EOF
+	}
+
+	n, err := io.ReadFull(reader.source, reader.buffer)
+	switch {
+	case err == io.EOF:
+		// the last chunk was cut off
+		return ErrInvalidCiphertext
+	case err == io.ErrUnexpectedEOF:
+		reader.last = true
+	case err != nil:
+		return err
+	}
+
+	chunk := reader.buffer[:n]
+	plaintext, err := reader.aead.Open(nil, chunkNonce(reader.index, reader.last), chunk, reader.header.raw)
+	if err != nil && !reader.last {
+		reader.last = true
+		plaintext, err = reader.aead.Open(nil, chunkNonce(reader.index, true), chunk, reader.header.raw)
+	}
+	if err != nil {
+		return ErrInvalidCiphertext
+	}
+
+	reader.index++
+	reader.plaintext = plaintext[min(reader.skip, int64(len(plaintext))):]
+	reader.skip = 0
+	return nil
+}
+
+func (reader *decryptingReader) Close() error {
+	return reader.source.Close()
+}
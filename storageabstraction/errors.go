@@ -0,0 +1,57 @@
+package storageabstraction
+
+import (
+	"errors"
+	"fmt"
+	"github.com/2flow/gokies/storageabstraction/common"
+	"io/fs"
+)
+
+// The errors of the storages match these errors with errors.Is, the original error of the backend is wrapped as well.
+// They are the same as the fs errors, so the errors of the os package match them too
+var (
+	ErrNotExist      = fs.ErrNotExist
+	ErrPermission    = fs.ErrPermission
+	ErrAlreadyExists = fs.ErrExist
+	// ErrConflict is returned if the operation conflicts with the current state of the file,
+	// e.g. a directory is deleted as a file or the file was modified by someone else
+	ErrConflict = errors.New("conflict with the current state of the file")
+	// ErrPreconditionFailed is returned if the Conditions of a write or delete are not met, it matches ErrConflict too
+	ErrPreconditionFailed = fmt.Errorf("precondition failed: %w", ErrConflict)
+	// ErrUnavailable is returned if the backend is temporarily unavailable or throttles the requests,
+	// the operation may succeed if it is retried later
+	ErrUnavailable = errors.New("storage temporarily unavailable")
+	// ErrPathEscape is returned for paths which escape from the root of a storage with "..", it matches ErrPermission too.
+	// The paths are validated by common.CleanPath, which returns a common.InvalidPathError
+	ErrPathEscape = common.ErrPathEscape
+)
+
+// storageError is an error of a backend, which also matches the sentinel error of its kind
+type storageError struct {
+	kind error
+	err  error
+}
+
+func (err *storageError) Error() string {
+	return err.kind.Error() + ": " + err.err.Error()
+}
+
+func (err *storageError) Unwrap() []error {
+	return []error{err.kind, err.err}
+}
+
+// WrapError returns an error which matches kind and err with errors.Is and errors.As
+func WrapError(kind error, err error) error {
+	if err == nil || errors.Is(err, kind) {
+		return err
+	}
+	return &storageError{kind: kind, err: err}
+}
+
+// NewPathError returns the error of the operation on the file, err is the original error of the backend and may be nil
+func NewPathError(op string, path string, kind error, err error) error {
+	if err == nil {
+		return &fs.PathError{Op: op, Path: path, Err: kind}
+	}
+	return &fs.PathError{Op: op, Path: path, Err: WrapError(kind, err)}
+}
@@ -9,16 +9,37 @@ import (
 
 type WalkFunc func(path string, info os.FileInfo, err error) error
 
+// FileDetails are the properties of a file which are known by a storage
+type FileDetails struct {
+	Name    string // base name of the file
+	Size    int64
+	IsDir   bool
+	ModTime time.Time
+
+	ContentType string
+	// ContentEncoding is the encoding of the stored content, e.g. "gzip", it is empty for files which are not encoded
+	ContentEncoding string
+	// ETag is the quoted entity tag of the file content, it changes whenever the file is modified
+	ETag string
+	// ContentMD5 is the MD5 hash of the content, nil if the storage does not know it
+	ContentMD5 []byte
+}
+
+// FileInfo is the fs.FileInfo of the storages, extended by the content type, the ETag and the content hash
 type FileInfo struct {
-	size  int64
-	isDir bool
+	details FileDetails
 }
 
 func NewFileInfo(size int64, isDir bool) *FileInfo {
-	return &FileInfo{
-		size:  size,
-		isDir: isDir,
-	}
+	return &FileInfo{details: FileDetails{
+		Size:  size,
+		IsDir: isDir,
+	}}
+}
+
+// NewFileInfoFromDetails creates a FileInfo with all details known by the storage
+func NewFileInfoFromDetails(details FileDetails) *FileInfo {
+	return &FileInfo{details: details}
 }
 
 // IFileStorage is the interface for a filestorage used by the ecosystem
@@ -36,23 +57,47 @@ type IFileStorage interface {
 }
 
 func (fileInfo *FileInfo) Name() string {
-	return ""
+	return fileInfo.details.Name
 }
 
 func (fileInfo *FileInfo) Size() int64 {
-	return fileInfo.size
+	return fileInfo.details.Size
 }
 
 func (fileInfo *FileInfo) Mode() fs.FileMode {
-	return fs.ModeIrregular
+	if fileInfo.details.IsDir {
+		return fs.ModeDir | 0777
+	}
+	return 0666
 }
 
 func (fileInfo *FileInfo) ModTime() time.Time {
-	return time.Time{}
+	return fileInfo.details.ModTime
 }
 
 func (fileInfo *FileInfo) IsDir() bool {
-	return fileInfo.isDir
+	return fileInfo.details.IsDir
+}
+
+func (fileInfo *FileInfo) ContentType() string {
+	return fileInfo.details.ContentType
+}
+
+func (fileInfo *FileInfo) ContentEncoding() string {
+	return fileInfo.details.ContentEncoding
+}
+
+func (fileInfo *FileInfo) ETag() string {
+	return fileInfo.details.ETag
+}
+
+func (fileInfo *FileInfo) ContentMD5() []byte {
+	return fileInfo.details.ContentMD5
+}
+
+// Details returns a copy of all properties, e.g. to create a modified FileInfo
+func (fileInfo *FileInfo) Details() FileDetails {
+	return fileInfo.details
 }
 
 func (fileInfo *FileInfo) Sys() any {
@@ -0,0 +1,25 @@
+package storageabstraction
+
+import (
+	"context"
+)
+
+// IFlushFileStorage is implemented by storages which keep their changes until they are flushed,
+// e.g. the content addressable storage writes the manifest of its files on Flush
+type IFlushFileStorage interface {
+	Flush() error
+	FlushContext(ctx context.Context) error
+}
+
+// Flush persists the pending changes of the storage, it does nothing for storages which do not implement IFlushFileStorage
+func Flush(storage IFileStorage) error {
+	return FlushContext(context.Background(), storage)
+}
+
+// FlushContext is Flush with a context
+func FlushContext(ctx context.Context, storage IFileStorage) error {
+	if flushStorage, ok := storage.(IFlushFileStorage); ok {
+		return flushStorage.FlushContext(ctx)
+	}
+	return nil
+}
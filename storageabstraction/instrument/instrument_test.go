@@ -0,0 +1,118 @@
+package instrument
+
+import (
+	"errors"
+	"github.com/2flow/gokies/storageabstraction"
+	"github.com/2flow/gokies/storageabstraction/memorystorage"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestInstrumentedStorage(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	recorder := tracetest.NewSpanRecorder()
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer("test")
+
+	storage, err := NewInstrumentedStorage(memorystorage.NewMemoryStorage(), WithName("memory"),
+		WithPrometheus(registry), WithTracer(tracer))
+	if err != nil {
+		t.Errorf("[TestError] Error creating storage: %v", err)
+		return
+	}
+
+	if err = storage.Write("dir/file.txt", 7, strings.NewReader("content")); err != nil {
+		t.Errorf("[TestError] Error writing file: %v", err)
+		return
+	}
+	reader, err := storage.Read("dir/file.txt")
+	if err != nil {
+		t.Errorf("Error reading file: %v", err)
+		return
+	}
+	_, _ = io.ReadAll(reader)
+	if len(recorder.Ended()) != 1 {
+		t.Errorf("Expected the read to be recorded when it is closed, actual spans: %d", len(recorder.Ended()))
+	}
+	_ = reader.Close()
+	if _, err = storage.Read("missing.txt"); !errors.Is(err, storageabstraction.ErrNotExist) {
+		t.Errorf("Expected not exist error, actual: %v", err)
+	}
+
+	if count := testutil.ToFloat64(storageOperations(t, registry, "memory", "read", "ok")); count != 1 {
+		t.Errorf("Expected one successful read, actual: %v", count)
+	}
+	if count := testutil.ToFloat64(storageOperations(t, registry, "memory", "read", "not_exist")); count != 1 {
+		t.Errorf("Expected one failed read, actual: %v", count)
+	}
+
+	metrics, _ := newMetrics(registry)
+	if read := testutil.ToFloat64(metrics.bytes.WithLabelValues("memory", "read")); read != 7 {
+		t.Errorf("Expected 7 read bytes, actual: %v", read)
+	}
+	if written := testutil.ToFloat64(metrics.bytes.WithLabelValues("memory", "write")); written != 7 {
+		t.Errorf("Expected 7 written bytes, actual: %v", written)
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 3 {
+		t.Errorf("Expected 3 spans, actual: %d", len(spans))
+		return
+	}
+	if spans[1].Name() != "storage.read" || spans[1].Status().Code != codes.Unset {
+		t.Errorf("Expected a successful read span, actual: %s %v", spans[1].Name(), spans[1].Status())
+	}
+	if spans[2].Status().Code != codes.Error || len(spans[2].Events()) != 1 {
+		t.Errorf("Expected the error to be recorded in the span, actual: %v", spans[2].Status())
+	}
+
+	// a second storage with another name shares the collectors of the registry
+	if _, err = NewInstrumentedStorage(memorystorage.NewMemoryStorage(), WithName("other"), WithPrometheus(registry)); err != nil {
+		t.Errorf("Expected the collectors to be reused, actual: %v", err)
+	}
+}
+
+func TestInstrumentedStorageWriter(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	storage, err := NewInstrumentedStorage(memorystorage.NewMemoryStorage(), WithPrometheus(registry))
+	if err != nil {
+		t.Errorf("[TestError] Error creating storage: %v", err)
+		return
+	}
+
+	writer, err := storageabstraction.OpenWriter(storage, "file.txt")
+	if err != nil {
+		t.Errorf("Error opening writer: %v", err)
+		return
+	}
+	_, _ = writer.Write([]byte("streamed"))
+	if count := testutil.CollectAndCount(registry, "storage_operations_total"); count != 0 {
+		t.Errorf("Expected the write to be recorded when it is closed, actual: %d", count)
+	}
+	if err = writer.Close(); err != nil {
+		t.Errorf("Error closing writer: %v", err)
+	}
+
+	if count := testutil.ToFloat64(storageOperations(t, registry, "storage", "write", "ok")); count != 1 {
+		t.Errorf("Expected one write, actual: %v", count)
+	}
+	metrics, _ := newMetrics(registry)
+	if written := testutil.ToFloat64(metrics.bytes.WithLabelValues("storage", "write")); written != 8 {
+		t.Errorf("Expected 8 written bytes, actual: %v", written)
+	}
+}
+
+func storageOperations(t *testing.T, registry *prometheus.Registry, name string, op string, result string) prometheus.Counter {
+	metrics, err := newMetrics(registry)
+	if err != nil {
+		t.Errorf("[TestError] Error getting metrics: %v", err)
+		return prometheus.NewCounter(prometheus.CounterOpts{Name: "unused"})
+	}
+	return metrics.operations.WithLabelValues(name, op, result)
+}
@@ -0,0 +1,81 @@
+package instrument
+
+import (
+	"context"
+	"errors"
+	"github.com/2flow/gokies/storageabstraction"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// metrics are the prometheus collectors, they are shared by all storages of a registry and labeled by the storage name
+type metrics struct {
+	operations *prometheus.CounterVec
+	duration   *prometheus.HistogramVec
+	bytes      *prometheus.CounterVec
+}
+
+// newMetrics registers the collectors, collectors which are registered already by another storage are reused
+func newMetrics(registerer prometheus.Registerer) (*metrics, error) {
+	operations := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "storage_operations_total",
+		Help: "Count of the storage operations by their result, which is ok or the kind of the error",
+	}, []string{"storage", "op", "result"})
+	duration := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "storage_operation_duration_seconds",
+		Help:    "Duration of the storage operations, reads and streamed writes last until they are closed",
+		Buckets: prometheus.ExponentialBuckets(0.001, 4, 9),
+	}, []string{"storage", "op"})
+	bytes := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "storage_bytes_total",
+		Help: "Bytes read from and written to the storage",
+	}, []string{"storage", "op"})
+
+	var err error
+	if operations, err = register(registerer, operations); err != nil {
+		return nil, err
+	}
+	if duration, err = register(registerer, duration); err != nil {
+		return nil, err
+	}
+	if bytes, err = register(registerer, bytes); err != nil {
+		return nil, err
+	}
+	return &metrics{operations: operations, duration: duration, bytes: bytes}, nil
+}
+
+// register returns the registered collector, which is the existing one if it is registered already
+func register[T prometheus.Collector](registerer prometheus.Registerer, collector T) (T, error) {
+	err := registerer.Register(collector)
+
+	var registered prometheus.AlreadyRegisteredError
+	if errors.As(err, &registered) {
+		if existing, ok := registered.ExistingCollector.(T); ok {
+			return existing, nil
+		}
+	}
+	return collector, err
+}
+
+// result labels the operation with the kind of its error
+func result(err error) string {
+	switch {
+	case err == nil:
+		return "ok"
+	case errors.Is(err, storageabstraction.ErrNotExist):
+		return "not_exist"
+	case errors.Is(err, storageabstraction.ErrPermission):
+		return "permission"
+	case errors.Is(err, storageabstraction.ErrAlreadyExists):
+		return "already_exists"
+	case errors.Is(err, storageabstraction.ErrPreconditionFailed):
+		return "precondition_failed"
+	case errors.Is(err, storageabstraction.ErrConflict):
+		return "conflict"
+	case errors.Is(err, storageabstraction.ErrUnavailable):
+		return "unavailable"
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return "canceled"
+	}
+	return "error"
+}
@@ -0,0 +1,443 @@
+package instrument
+
+import (
+	"context"
+	"github.com/2flow/gokies/storageabstraction"
+	"io"
+	"io/fs"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// Option configures the instrumentation
+type Option func(storage *instrumentedStorage)
+
+// WithName sets the storage label of the metrics and the storage.name attribute of the spans, the default is "storage".
+// Storages which are registered at the same registry need different names
+func WithName(name string) Option {
+	return func(storage *instrumentedStorage) {
+		storage.name = name
+	}
+}
+
+// WithPrometheus records the operations, their durations and the transferred bytes as metrics of the registerer,
+// e.g. prometheus.DefaultRegisterer
+func WithPrometheus(registerer prometheus.Registerer) Option {
+	return func(storage *instrumentedStorage) {
+		storage.registerer = registerer
+	}
+}
+
+// WithTracer creates a span for every operation, e.g. with otel.Tracer("storage"). By default no spans are created
+func WithTracer(tracer trace.Tracer) Option {
+	return func(storage *instrumentedStorage) {
+		storage.tracer = tracer
+	}
+}
+
+// instrumentedStorage records the operations of the wrapped storage
+type instrumentedStorage struct {
+	storage    storageabstraction.IFileStorage
+	name       string
+	registerer prometheus.Registerer
+	metrics    *metrics
+	tracer     trace.Tracer
+}
+
+// NewInstrumentedStorage wraps the storage, so its operations are recorded as prometheus metrics and OpenTelemetry spans.
+// Reads and streamed writes are recorded when they are closed. Locks are recorded until they are acquired, not while
+// they are held
+func NewInstrumentedStorage(storage storageabstraction.IFileStorage, options ...Option) (storageabstraction.IContextFileStorage, error) {
+	instrumented := &instrumentedStorage{storage: storage, name: "storage", tracer: noop.NewTracerProvider().Tracer("")}
+	for _, option := range options {
+		option(instrumented)
+	}
+
+	if instrumented.registerer != nil {
+		metrics, err := newMetrics(instrumented.registerer)
+		if err != nil {
+			return nil, err
+		}
+		instrumented.metrics = metrics
+	}
+	return instrumented, nil
+}
+
+// operation is a running operation of the storage
+type operation struct {
+	storage *instrumentedStorage
+	op      string
+	start   time.Time
+	span    trace.Span
+}
+
+// begin starts the span of the operation, the returned context carries the span for the wrapped storage
+func (storage *instrumentedStorage) begin(ctx context.Context, op string, path string, attributes ...attribute.KeyValue) (context.Context, *operation) {
+	ctx, span := storage.tracer.Start(ctx, "storage."+op, trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(append(attributes, attribute.String("storage.name", storage.name), attribute.String("storage.path", path))...))
+	return ctx, &operation{storage: storage, op: op, start: time.Now(), span: span}
+}
+
+// finish records the operation with its error and the transferred bytes, negative bytes are not recorded
+func (operation *operation) finish(err error, bytes int64) {
+	if metrics := operation.storage.metrics; metrics != nil {
+		name := operation.storage.name
+		metrics.operations.WithLabelValues(name, operation.op, result(err)).Inc()
+		metrics.duration.WithLabelValues(name, operation.op).Observe(time.Since(operation.start).Seconds())
+		if bytes > 0 {
+			metrics.bytes.WithLabelValues(name, operation.op).Add(float64(bytes))
+		}
+	}
+
+	if bytes >= 0 {
+		operation.span.SetAttributes(attribute.Int64("storage.bytes", bytes))
+	}
+	if err != nil {
+		operation.span.RecordError(err)
+		operation.span.SetStatus(codes.Error, err.Error())
+	}
+	operation.span.End()
+}
+
+func (storage *instrumentedStorage) contextStorage() storageabstraction.IContextFileStorage {
+	return storageabstraction.WithContext(storage.storage)
+}
+
+func (storage *instrumentedStorage) Write(fileName string, fileSize int64, reader io.ReadSeeker) error {
+	return storage.WriteContext(context.Background(), fileName, fileSize, reader)
+}
+
+func (storage *instrumentedStorage) WriteContext(ctx context.Context, fileName string, fileSize int64, reader io.ReadSeeker) error {
+	ctx, operation := storage.begin(ctx, "write", fileName)
+	err := storage.contextStorage().WriteContext(ctx, fileName, fileSize, reader)
+	operation.finish(err, writtenBytes(fileSize, err))
+	return err
+}
+
+func (storage *instrumentedStorage) WriteWithOptions(fileName string, fileSize int64, reader io.ReadSeeker, options storageabstraction.WriteOptions) error {
+	return storage.WriteWithOptionsContext(context.Background(), fileName, fileSize, reader, options)
+}
+
+func (storage *instrumentedStorage) WriteWithOptionsContext(ctx context.Context, fileName string, fileSize int64, reader io.ReadSeeker, options storageabstraction.WriteOptions) error {
+	ctx, operation := storage.begin(ctx, "write", fileName)
+	err := storageabstraction.WriteWithOptionsContext(ctx, storage.storage, fileName, fileSize, reader, options)
+	operation.finish(err, writtenBytes(fileSize, err))
+	return err
+}
+
+// writtenBytes are the bytes of a successful write
+func writtenBytes(fileSize int64, err error) int64 {
+	if err != nil {
+		return -1
+	}
+	return fileSize
+}
+
+func (storage *instrumentedStorage) OpenWriter(fileName string) (io.WriteCloser, error) {
+	return storage.OpenWriterContext(context.Background(), fileName)
+}
+
+func (storage *instrumentedStorage) OpenWriterContext(ctx context.Context, fileName string) (io.WriteCloser, error) {
+	ctx, operation := storage.begin(ctx, "write", fileName)
+	writer, err := storageabstraction.OpenWriterContext(ctx, storage.storage, fileName)
+	if err != nil {
+		operation.finish(err, -1)
+		return nil, err
+	}
+	return &writeCloser{WriteCloser: writer, operation: operation}, nil
+}
+
+func (storage *instrumentedStorage) OpenWriterWithOptions(fileName string, options storageabstraction.WriteOptions) (io.WriteCloser, error) {
+	return storage.OpenWriterWithOptionsContext(context.Background(), fileName, options)
+}
+
+func (storage *instrumentedStorage) OpenWriterWithOptionsContext(ctx context.Context, fileName string, options storageabstraction.WriteOptions) (io.WriteCloser, error) {
+	ctx, operation := storage.begin(ctx, "write", fileName)
+	writer, err := storageabstraction.OpenWriterWithOptionsContext(ctx, storage.storage, fileName, options)
+	if err != nil {
+		operation.finish(err, -1)
+		return nil, err
+	}
+	return &writeCloser{WriteCloser: writer, operation: operation}, nil
+}
+
+func (storage *instrumentedStorage) Read(fileName string) (io.ReadCloser, error) {
+	return storage.ReadContext(context.Background(), fileName)
+}
+
+func (storage *instrumentedStorage) ReadContext(ctx context.Context, fileName string) (io.ReadCloser, error) {
+	ctx, operation := storage.begin(ctx, "read", fileName)
+	reader, err := storage.contextStorage().ReadContext(ctx, fileName)
+	if err != nil {
+		operation.finish(err, -1)
+		return nil, err
+	}
+	return &readCloser{ReadCloser: reader, operation: operation}, nil
+}
+
+func (storage *instrumentedStorage) ReadRange(fileName string, offset int64, length int64) (io.ReadCloser, error) {
+	return storage.ReadRangeContext(context.Background(), fileName, offset, length)
+}
+
+func (storage *instrumentedStorage) ReadRangeContext(ctx context.Context, fileName string, offset int64, length int64) (io.ReadCloser, error) {
+	ctx, operation := storage.begin(ctx, "readRange", fileName,
+		attribute.Int64("storage.offset", offset), attribute.Int64("storage.length", length))
+	reader, err := storageabstraction.ReadRangeContext(ctx, storage.storage, fileName, offset, length)
+	if err != nil {
+		operation.finish(err, -1)
+		return nil, err
+	}
+	return &readCloser{ReadCloser: reader, operation: operation}, nil
+}
+
+func (storage *instrumentedStorage) FileSize(fileName string) (int64, error) {
+	return storage.FileSizeContext(context.Background(), fileName)
+}
+
+func (storage *instrumentedStorage) FileSizeContext(ctx context.Context, fileName string) (int64, error) {
+	ctx, operation := storage.begin(ctx, "fileSize", fileName)
+	size, err := storage.contextStorage().FileSizeContext(ctx, fileName)
+	operation.finish(err, -1)
+	return size, err
+}
+
+func (storage *instrumentedStorage) Stat(fileName string) (*storageabstraction.FileInfo, error) {
+	return storage.StatContext(context.Background(), fileName)
+}
+
+func (storage *instrumentedStorage) StatContext(ctx context.Context, fileName string) (*storageabstraction.FileInfo, error) {
+	ctx, operation := storage.begin(ctx, "stat", fileName)
+	info, err := storageabstraction.StatContext(ctx, storage.storage, fileName)
+	operation.finish(err, -1)
+	return info, err
+}
+
+func (storage *instrumentedStorage) DeleteDirectory(directory string) error {
+	return storage.DeleteDirectoryContext(context.Background(), directory)
+}
+
+func (storage *instrumentedStorage) DeleteDirectoryContext(ctx context.Context, directory string) error {
+	ctx, operation := storage.begin(ctx, "deleteDirectory", directory)
+	err := storage.contextStorage().DeleteDirectoryContext(ctx, directory)
+	operation.finish(err, -1)
+	return err
+}
+
+func (storage *instrumentedStorage) DeleteFile(fileName string) error {
+	return storage.DeleteFileContext(context.Background(), fileName)
+}
+
+func (storage *instrumentedStorage) DeleteFileContext(ctx context.Context, fileName string) error {
+	ctx, operation := storage.begin(ctx, "delete", fileName)
+	err := storage.contextStorage().DeleteFileContext(ctx, fileName)
+	operation.finish(err, -1)
+	return err
+}
+
+func (storage *instrumentedStorage) DeleteFileWithConditions(fileName string, conditions storageabstraction.Conditions) error {
+	return storage.DeleteFileWithConditionsContext(context.Background(), fileName, conditions)
+}
+
+func (storage *instrumentedStorage) DeleteFileWithConditionsContext(ctx context.Context, fileName string, conditions storageabstraction.Conditions) error {
+	ctx, operation := storage.begin(ctx, "delete", fileName)
+	err := storageabstraction.DeleteFileWithConditionsContext(ctx, storage.storage, fileName, conditions)
+	operation.finish(err, -1)
+	return err
+}
+
+func (storage *instrumentedStorage) Walk(directory string, walk storageabstraction.WalkFunc) error {
+	return storage.WalkContext(context.Background(), directory, walk)
+}
+
+func (storage *instrumentedStorage) WalkContext(ctx context.Context, directory string, walk storageabstraction.WalkFunc) error {
+	ctx, operation := storage.begin(ctx, "walk", directory)
+	err := storage.contextStorage().WalkContext(ctx, directory, walk)
+	operation.finish(err, -1)
+	return err
+}
+
+func (storage *instrumentedStorage) List(directory string) ([]fs.FileInfo, error) {
+	return storage.ListContext(context.Background(), directory)
+}
+
+func (storage *instrumentedStorage) ListContext(ctx context.Context, directory string) ([]fs.FileInfo, error) {
+	ctx, operation := storage.begin(ctx, "list", directory)
+	entries, err := storageabstraction.ListContext(ctx, storage.storage, directory)
+	operation.finish(err, -1)
+	return entries, err
+}
+
+func (storage *instrumentedStorage) CopyFile(source string, destination string) error {
+	return storage.CopyFileContext(context.Background(), source, destination)
+}
+
+func (storage *instrumentedStorage) CopyFileContext(ctx context.Context, source string, destination string) error {
+	ctx, operation := storage.begin(ctx, "copy", destination, attribute.String("storage.source", source))
+	err := storageabstraction.CopyFileContext(ctx, storage.storage, source, destination)
+	operation.finish(err, -1)
+	return err
+}
+
+func (storage *instrumentedStorage) MoveFile(source string, destination string) error {
+	return storage.MoveFileContext(context.Background(), source, destination)
+}
+
+func (storage *instrumentedStorage) MoveFileContext(ctx context.Context, source string, destination string) error {
+	ctx, operation := storage.begin(ctx, "move", destination, attribute.String("storage.source", source))
+	err := storageabstraction.MoveFileContext(ctx, storage.storage, source, destination)
+	operation.finish(err, -1)
+	return err
+}
+
+func (storage *instrumentedStorage) CopyDirectory(source string, destination string) error {
+	return storage.CopyDirectoryContext(context.Background(), source, destination)
+}
+
+func (storage *instrumentedStorage) CopyDirectoryContext(ctx context.Context, source string, destination string) error {
+	ctx, operation := storage.begin(ctx, "copyDirectory", destination, attribute.String("storage.source", source))
+	err := storageabstraction.CopyDirectoryContext(ctx, storage.storage, source, destination)
+	operation.finish(err, -1)
+	return err
+}
+
+func (storage *instrumentedStorage) MoveDirectory(source string, destination string) error {
+	return storage.MoveDirectoryContext(context.Background(), source, destination)
+}
+
+func (storage *instrumentedStorage) MoveDirectoryContext(ctx context.Context, source string, destination string) error {
+	ctx, operation := storage.begin(ctx, "moveDirectory", destination, attribute.String("storage.source", source))
+	err := storageabstraction.MoveDirectoryContext(ctx, storage.storage, source, destination)
+	operation.finish(err, -1)
+	return err
+}
+
+func (storage *instrumentedStorage) Join(paths ...string) string {
+	return storage.storage.Join(paths...)
+}
+
+// readCloser records the read with the count of read bytes when it is closed
+type readCloser struct {
+	io.ReadCloser
+	operation *operation
+
+	bytes int64
+	err   error
+}
+
+func (reader *readCloser) Read(p []byte) (n int, err error) {
+	n, err = reader.ReadCloser.Read(p)
+	reader.bytes += int64(n)
+	if err != nil && err != io.EOF && reader.err == nil {
+		reader.err = err
+	}
+	return n, err
+}
+
+func (reader *readCloser) Close() error {
+	err := reader.ReadCloser.Close()
+	if reader.err == nil {
+		reader.err = err
+	}
+
+	reader.operation.finish(reader.err, reader.bytes)
+	return err
+}
+
+// writeCloser records the write with the count of written bytes when it is closed
+type writeCloser struct {
+	io.WriteCloser
+	operation *operation
+
+	bytes int64
+	err   error
+}
+
+func (writer *writeCloser) Write(p []byte) (n int, err error) {
+	n, err = writer.WriteCloser.Write(p)
+	writer.bytes += int64(n)
+	if err != nil && writer.err == nil {
+		writer.err = err
+	}
+	return n, err
+}
+
+func (writer *writeCloser) Close() error {
+	err := writer.WriteCloser.Close()
+	if err != nil {
+		writer.err = err
+	}
+
+	writer.operation.finish(writer.err, writer.bytes)
+	return err
+}
+
+func (storage *instrumentedStorage) Snapshot(fileName string) (string, error) {
+	return storage.SnapshotContext(context.Background(), fileName)
+}
+
+func (storage *instrumentedStorage) SnapshotContext(ctx context.Context, fileName string) (string, error) {
+	ctx, operation := storage.begin(ctx, "snapshot", fileName)
+	snapshot, err := storageabstraction.SnapshotContext(ctx, storage.storage, fileName)
+	operation.finish(err, -1)
+	return snapshot, err
+}
+
+func (storage *instrumentedStorage) Versions(path string) ([]storageabstraction.FileVersion, error) {
+	return storage.VersionsContext(context.Background(), path)
+}
+
+func (storage *instrumentedStorage) VersionsContext(ctx context.Context, path string) ([]storageabstraction.FileVersion, error) {
+	ctx, operation := storage.begin(ctx, "versions", path)
+	versions, err := storageabstraction.VersionsContext(ctx, storage.storage, path)
+	operation.finish(err, -1)
+	return versions, err
+}
+
+func (storage *instrumentedStorage) RestoreVersion(fileName string, versionID string) error {
+	return storage.RestoreVersionContext(context.Background(), fileName, versionID)
+}
+
+func (storage *instrumentedStorage) RestoreVersionContext(ctx context.Context, fileName string, versionID string) error {
+	ctx, operation := storage.begin(ctx, "restore", fileName, attribute.String("storage.version", versionID))
+	err := storageabstraction.RestoreVersionContext(ctx, storage.storage, fileName, versionID)
+	operation.finish(err, -1)
+	return err
+}
+
+func (storage *instrumentedStorage) Undelete(fileName string) error {
+	return storage.UndeleteContext(context.Background(), fileName)
+}
+
+func (storage *instrumentedStorage) UndeleteContext(ctx context.Context, fileName string) error {
+	ctx, operation := storage.begin(ctx, "undelete", fileName)
+	err := storageabstraction.UndeleteContext(ctx, storage.storage, fileName)
+	operation.finish(err, -1)
+	return err
+}
+
+func (storage *instrumentedStorage) TryLock(fileName string) (storageabstraction.ILock, error) {
+	return storage.TryLockContext(context.Background(), fileName)
+}
+
+func (storage *instrumentedStorage) TryLockContext(ctx context.Context, fileName string) (storageabstraction.ILock, error) {
+	ctx, operation := storage.begin(ctx, "lock", fileName)
+	lock, err := storageabstraction.TryLockContext(ctx, storage.storage, fileName)
+	operation.finish(err, -1)
+	return lock, err
+}
+
+func (storage *instrumentedStorage) Lock(fileName string) (storageabstraction.ILock, error) {
+	return storage.LockContext(context.Background(), fileName)
+}
+
+func (storage *instrumentedStorage) LockContext(ctx context.Context, fileName string) (storageabstraction.ILock, error) {
+	ctx, operation := storage.begin(ctx, "lock", fileName)
+	lock, err := storageabstraction.LockContext(ctx, storage.storage, fileName)
+	operation.finish(err, -1)
+	return lock, err
+}
@@ -0,0 +1,130 @@
+package storageabstraction
+
+import (
+	"context"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+)
+
+// IListFileStorage is implemented by storages which can list the entries of a directory without its sub directories
+type IListFileStorage interface {
+	List(directory string) ([]fs.FileInfo, error)
+	ListContext(ctx context.Context, directory string) ([]fs.FileInfo, error)
+}
+
+// ListFunc returns the files and sub directories of the directory, the names are the base names.
+// A missing directory is reported with ErrNotExist
+type ListFunc func(ctx context.Context, directory string) ([]fs.FileInfo, error)
+
+// List returns the files and sub directories of the directory sorted by name.
+// For storages which do not implement IListFileStorage the directory is walked
+func List(storage IFileStorage, directory string) ([]fs.FileInfo, error) {
+	return ListContext(context.Background(), storage, directory)
+}
+
+// ListContext is List with a context
+func ListContext(ctx context.Context, storage IFileStorage, directory string) ([]fs.FileInfo, error) {
+	if listStorage, ok := storage.(IListFileStorage); ok {
+		return listStorage.ListContext(ctx, directory)
+	}
+
+	var entries []fs.FileInfo
+	directories := map[string]bool{}
+
+	err := WithContext(storage).WalkContext(ctx, directory, func(filePath string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		filePath = strings.TrimPrefix(filePath, "/")
+		if filePath == "" {
+			return nil
+		}
+
+		// storages without directory entries only return files, their directories are synthesized
+		name, _, isNested := strings.Cut(filePath, "/")
+		if isNested || info.IsDir() {
+			if !directories[name] {
+				directories[name] = true
+				entries = append(entries, NewFileInfoFromDetails(FileDetails{Name: name, IsDir: true}))
+			}
+			if !isNested {
+				return fs.SkipDir
+			}
+			return nil
+		}
+
+		entries = append(entries, info)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sortByName(entries)
+	return entries, nil
+}
+
+// WalkByList walks the directory with the entries returned by list, in the same order as the local storage:
+// First the directory itself with the path "", then its entries sorted by name, each sub directory directly
+// followed by its content. The paths are relative to the directory and use "/" as separator
+func WalkByList(ctx context.Context, directory string, list ListFunc, walk WalkFunc) error {
+	entries, err := list(ctx, directory)
+	if err != nil {
+		_ = walk("", nil, err)
+		return err
+	}
+
+	err = walk("", NewFileInfoFromDetails(FileDetails{Name: path.Base(path.Clean("/" + directory)), IsDir: true}), nil)
+	if err == nil {
+		err = walkEntries(ctx, directory, "", entries, list, walk)
+	}
+	if err == fs.SkipDir || err == fs.SkipAll {
+		return nil
+	}
+	return err
+}
+
+func walkEntries(ctx context.Context, directory string, relativeDirectory string, entries []fs.FileInfo, list ListFunc, walk WalkFunc) error {
+	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		relativePath := path.Join(relativeDirectory, entry.Name())
+		err := walk(relativePath, entry, nil)
+		if err == fs.SkipDir {
+			if entry.IsDir() {
+				continue
+			}
+			// like filepath.Walk the remaining entries of the directory are skipped
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !entry.IsDir() {
+			continue
+		}
+
+		subEntries, err := list(ctx, path.Join(directory, relativePath))
+		if err != nil {
+			if err = walk(relativePath, entry, err); err != nil && err != fs.SkipDir {
+				return err
+			}
+			continue
+		}
+		if err = walkEntries(ctx, directory, relativePath, subEntries, list, walk); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func sortByName(entries []fs.FileInfo) {
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+}
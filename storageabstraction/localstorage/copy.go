@@ -0,0 +1,241 @@
+package localstorage
+
+import (
+	"context"
+	"errors"
+	"github.com/2flow/gokies/storageabstraction"
+	"github.com/2flow/gokies/storageabstraction/common"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+func (storage *localStorage) CopyFile(source string, destination string) error {
+	return storage.CopyFileContext(context.Background(), source, destination)
+}
+
+// CopyFileContext creates a hard link of the file, the content is copied if the link can not be created.
+// Writes replace files instead of modifying them, so the linked files do not affect each other
+func (storage *localStorage) CopyFileContext(ctx context.Context, source string, destination string) (err error) {
+	defer func(start time.Time) {
+		common.LogOperation(storage.logger, "copy", destination, -1, start, err, "source", source)
+	}(time.Now())
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	sourcePath, err := storage.resolve(source)
+	if err != nil {
+		return err
+	}
+	stats, err := os.Stat(sourcePath)
+	if err != nil {
+		return convertError(err)
+	} else if stats.IsDir() {
+		return storageabstraction.NewPathError("copy", source, storageabstraction.ErrConflict, syscall.EISDIR)
+	}
+
+	destinationPath, err := storage.prepareFilePath(destination)
+	if err != nil {
+		return err
+	} else if destinationPath == "" {
+		return &fs.PathError{Op: "copy", Path: destination, Err: fs.ErrInvalid}
+	}
+	if sameFile(sourcePath, destinationPath) {
+		return nil
+	}
+
+	_ = os.Remove(destinationPath)
+	if err = os.Link(sourcePath, destinationPath); err == nil {
+		return nil
+	}
+
+	return storage.copyContent(ctx, sourcePath, destination)
+}
+
+// copyContent copies the content of the file, if it can not be linked
+func (storage *localStorage) copyContent(ctx context.Context, sourcePath string, destination string) error {
+	file, err := os.Open(sourcePath)
+	if err != nil {
+		return convertError(err)
+	}
+	defer file.Close()
+
+	return storageabstraction.WriteFromContext(ctx, storage, destination, storageabstraction.NewContextReadCloser(ctx, file))
+}
+
+func (storage *localStorage) MoveFile(source string, destination string) error {
+	return storage.MoveFileContext(context.Background(), source, destination)
+}
+
+// MoveFileContext renames the file, it is copied and deleted if it can not be renamed
+func (storage *localStorage) MoveFileContext(ctx context.Context, source string, destination string) (err error) {
+	defer func(start time.Time) {
+		common.LogOperation(storage.logger, "move", destination, -1, start, err, "source", source)
+	}(time.Now())
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	sourcePath, err := storage.resolve(source)
+	if err != nil {
+		return err
+	}
+	stats, err := os.Stat(sourcePath)
+	if err != nil {
+		return convertError(err)
+	} else if stats.IsDir() {
+		return storageabstraction.NewPathError("move", source, storageabstraction.ErrConflict, syscall.EISDIR)
+	}
+
+	destinationPath, err := storage.prepareFilePath(destination)
+	if err != nil {
+		return err
+	} else if destinationPath == "" {
+		return &fs.PathError{Op: "move", Path: destination, Err: fs.ErrInvalid}
+	}
+	if sameFile(sourcePath, destinationPath) {
+		// renaming a hard link onto the same file keeps both names
+		if path.Clean(sourcePath) == path.Clean(destinationPath) {
+			return nil
+		}
+		return os.Remove(sourcePath)
+	}
+
+	if err = os.Rename(sourcePath, destinationPath); err == nil {
+		return nil
+	}
+
+	if err = storage.copyContent(ctx, sourcePath, destination); err != nil {
+		return err
+	}
+	return os.Remove(sourcePath)
+}
+
+func (storage *localStorage) CopyDirectory(source string, destination string) error {
+	return storage.CopyDirectoryContext(context.Background(), source, destination)
+}
+
+// CopyDirectoryContext copies every file of the directory with CopyFileContext
+func (storage *localStorage) CopyDirectoryContext(ctx context.Context, source string, destination string) (err error) {
+	defer func(start time.Time) {
+		common.LogOperation(storage.logger, "copyDirectory", destination, -1, start, err, "source", source)
+	}(time.Now())
+
+	files, err := storage.directoryFiles(ctx, source)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		err = storage.CopyFileContext(ctx, path.Join(source, file), path.Join(destination, file))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (storage *localStorage) MoveDirectory(source string, destination string) error {
+	return storage.MoveDirectoryContext(context.Background(), source, destination)
+}
+
+// MoveDirectoryContext renames the directory if the destination does not exist,
+// otherwise the files are moved into the destination one by one
+func (storage *localStorage) MoveDirectoryContext(ctx context.Context, source string, destination string) (err error) {
+	defer func(start time.Time) {
+		common.LogOperation(storage.logger, "moveDirectory", destination, -1, start, err, "source", source)
+	}(time.Now())
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	sourcePath, err := storage.resolve(source)
+	if err != nil {
+		return err
+	}
+	destinationPath, err := storage.prepareFilePath(destination)
+	if err != nil {
+		return err
+	} else if destinationPath == "" {
+		return &fs.PathError{Op: "move", Path: destination, Err: fs.ErrInvalid}
+	}
+	if sameFile(sourcePath, destinationPath) {
+		return nil
+	} else if strings.HasPrefix(path.Clean(destinationPath), path.Clean(sourcePath)+"/") {
+		return &fs.PathError{Op: "move", Path: destination, Err: errors.New("is inside of the source directory")}
+	}
+
+	if _, err = os.Stat(destinationPath); errors.Is(err, fs.ErrNotExist) {
+		if err = os.Rename(sourcePath, destinationPath); err == nil {
+			return nil
+		}
+	}
+
+	files, err := storage.directoryFiles(ctx, source)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		err = storage.MoveFileContext(ctx, path.Join(source, file), path.Join(destination, file))
+		if err != nil {
+			return err
+		}
+	}
+
+	return convertError(os.RemoveAll(sourcePath))
+}
+
+// directoryFiles returns the paths of all files in the directory, relative to the directory
+func (storage *localStorage) directoryFiles(ctx context.Context, directory string) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	directoryPath, err := storage.resolve(directory)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	err = filepath.WalkDir(directoryPath, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if entry.IsDir() {
+			return nil
+		}
+
+		relativePath, err := filepath.Rel(directoryPath, filePath)
+		if err != nil {
+			return err
+		}
+		files = append(files, filepath.ToSlash(relativePath))
+		return nil
+	})
+
+	return files, err
+}
+
+// sameFile reports whether both paths point to the same existing file
+func sameFile(firstPath string, secondPath string) bool {
+	firstStats, err := os.Stat(firstPath)
+	if err != nil {
+		return false
+	}
+	secondStats, err := os.Stat(secondPath)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(firstStats, secondStats)
+}
@@ -1,6 +1,8 @@
 package localstorage
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/2flow/gokies/storageabstraction"
 	"github.com/2flow/gokies/storageabstraction/common"
@@ -11,61 +13,115 @@ import (
 	"path"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
 )
 
 type localStorage struct {
 	storageabstraction.IFileStorage
 	rootDirectory string
+	logger        log.Logger
+}
+
+// Option configures the local storage
+type Option func(storage *localStorage)
+
+// WithLogger sets the logger for the operations of the storage, by default nothing is logged
+func WithLogger(logger log.Logger) Option {
+	return func(storage *localStorage) {
+		storage.logger = logger
+	}
 }
 
 // NewLocalStorage creates a new instance of an local storage
-func NewLocalStorage(rootDir string) storageabstraction.IFileStorage {
+func NewLocalStorage(rootDir string, options ...Option) storageabstraction.IFileStorage {
+	storage := &localStorage{rootDirectory: rootDir, logger: log.NewNopLogger()}
+	for _, option := range options {
+		option(storage)
+	}
+
 	err := os.MkdirAll(rootDir, 0777|os.ModeDir)
 	if err != nil {
+		_ = level.Error(storage.logger).Log("msg", "Unable to create root directory", "path", rootDir, "err", err)
 		return nil
 	}
-	return &localStorage{rootDirectory: rootDir}
+	return storage
 }
 
 // create path if not exists, and set the owner of it
-func createFolder(path string, uid, gid int) {
-	path = filepath.ToSlash(path)
+func createFolder(logger log.Logger, path string, uid, gid int) {
+	path = strings.TrimRight(filepath.ToSlash(path), "/")
 	if _, err := os.Stat(path); os.IsNotExist(err) {
 		subPath, filepath := filepath.Split(path)
 		if filepath == "" || subPath == "" {
 			return
 		}
 
-		createFolder(subPath, uid, gid)
+		createFolder(logger, subPath, uid, gid)
 
 		err := os.MkdirAll(path, 0777|os.ModeDir)
 		if err != nil {
-			_ = fmt.Errorf("[createFolder] Error during mkdir %s", err.Error())
+			_ = level.Warn(logger).Log("msg", "Unable to create directory", "path", path, "err", err)
 		}
 		err = os.Chown(path, uid, gid)
 		if err != nil {
-			_ = fmt.Errorf("[createFolder] Error during chown %s", err.Error())
+			_ = level.Warn(logger).Log("msg", "Unable to change owner of directory", "path", path, "err", err)
 		}
 	}
 }
 
-func (storage *localStorage) Write(fileName string, _ int64, reader io.ReadSeeker) error {
-	filePath := path.Join(storage.rootDirectory, fileName)
+// convertError adds storageabstraction.ErrConflict to the errors of operations which do not fit the type of the file,
+// all other os errors already match the storageabstraction errors
+func convertError(err error) error {
+	if !errors.Is(err, syscall.ENOTEMPTY) && !errors.Is(err, syscall.EISDIR) && !errors.Is(err, syscall.ENOTDIR) {
+		return err
+	}
+
+	var pathError *fs.PathError
+	if errors.As(err, &pathError) {
+		return storageabstraction.NewPathError(pathError.Op, pathError.Path, storageabstraction.ErrConflict, pathError.Err)
+	}
+	return storageabstraction.WrapError(storageabstraction.ErrConflict, err)
+}
+
+func (storage *localStorage) Write(fileName string, fileSize int64, reader io.ReadSeeker) error {
+	return storage.WriteContext(context.Background(), fileName, fileSize, reader)
+}
+
+// resolve returns the path of the file in the root directory, paths which escape from it return a common.InvalidPathError
+func (storage *localStorage) resolve(fileName string) (string, error) {
+	if err := common.ValidatePath(fileName); err != nil {
+		return "", err
+	}
+	return path.Join(storage.rootDirectory, fileName), nil
+}
+
+// prepareFilePath returns the path of the file and creates its directory owned by www-data.
+// An empty path is returned for the root directory itself
+func (storage *localStorage) prepareFilePath(fileName string) (string, error) {
+	filePath, err := storage.resolve(fileName)
+	if err != nil {
+		return "", err
+	}
 	filePath = filepath.ToSlash(filePath)
 
 	dirPath, _ := filepath.Split(filePath)
 	if len(dirPath) < len(storage.rootDirectory) {
-		return nil
+		return "", nil
 	}
 
 	group, err := user.Lookup("www-data")
 	if err != nil {
-		_ = fmt.Errorf("[LocalStorageWrite]" + "Unable to find group www-data")
-		return err
+		_ = level.Error(storage.logger).Log("msg", "Unable to find group www-data", "err", err)
+		return "", err
 	}
 	uid, _ := strconv.Atoi(group.Uid)
 	gid, _ := strconv.Atoi(group.Gid)
-	createFolder(dirPath, uid, gid)
+	createFolder(storage.logger, dirPath, uid, gid)
 
 	/*if err != nil {
 		fmt.Errorf("[LocalStorageWrite]"+"Unable create directory %s, FILE: %s, ERROR: %s", dirPath, filePath,
@@ -73,46 +129,131 @@ func (storage *localStorage) Write(fileName string, _ int64, reader io.ReadSeeke
 		return err
 	}*/
 
-	err = os.Remove(filePath)
-	if err != nil {
-		_ = fmt.Errorf("[LocalStorageWrite]"+"Unable to remove file %s: %s", filePath, err.Error())
+	return filePath, nil
+}
+
+// WriteContext writes the content through a writer like OpenWriterContext, so the file is only replaced once the whole
+// content is written, and hard linked copies of the file are never modified
+func (storage *localStorage) WriteContext(ctx context.Context, fileName string, _ int64, reader io.ReadSeeker) error {
+	return storageabstraction.WriteFromContext(ctx, storage, fileName, storageabstraction.NewContextReadSeeker(ctx, reader))
+}
+
+func (storage *localStorage) OpenWriter(fileName string) (io.WriteCloser, error) {
+	return storage.OpenWriterContext(context.Background(), fileName)
+}
+
+// OpenWriterContext writes to a temp file next to the file, which replaces the file on Close
+func (storage *localStorage) OpenWriterContext(ctx context.Context, fileName string) (io.WriteCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
 	}
-	file, err := os.OpenFile(filePath, os.O_CREATE|os.O_WRONLY, 0777|os.ModeDir)
 
+	filePath, err := storage.prepareFilePath(fileName)
 	if err != nil {
-		_ = fmt.Errorf("[LocalStorageWrite]"+"Unable to Open file %s: %s", filePath, err.Error())
-		return err
+		return nil, err
+	} else if filePath == "" {
+		return nil, &fs.PathError{Op: "open", Path: fileName, Err: fs.ErrInvalid}
+	}
+	// the rename on Close can not replace a directory
+	if info, err := os.Stat(filePath); err == nil && info.IsDir() {
+		return nil, storageabstraction.NewPathError("open", fileName, storageabstraction.ErrConflict, syscall.EISDIR)
 	}
-	defer file.Close()
 
-	buffer := make([]byte, 1024)
+	dirPath, baseName := filepath.Split(filePath)
+	file, err := os.CreateTemp(dirPath, "."+baseName+".*.tmp")
+	if err != nil {
+		return nil, convertError(err)
+	}
 
-	for {
-		bytesCount, err := reader.Read(buffer)
-		if err != nil && err != io.EOF {
-			return err
-		} else if err == io.EOF {
-			break
-		}
+	return common.LogWriteCloser(storage.logger, "write", fileName, &localFileWriter{ctx: ctx, file: file, filePath: filePath}), nil
+}
 
-		writeCount, err := file.Write(buffer[:bytesCount])
-		if err != nil || writeCount != bytesCount {
-			errStr := "actual write Count is not equal to bytesCount"
-			if err != nil {
-				errStr = err.Error()
-			}
+type localFileWriter struct {
+	ctx      context.Context
+	file     *os.File
+	filePath string
+}
 
-			_ = fmt.Errorf("[LocalStorageWrite]"+"Unable to write file %s: %s", filePath, errStr)
-			return err
-		}
+func (writer *localFileWriter) Write(p []byte) (n int, err error) {
+	if err := writer.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return writer.file.Write(p)
+}
 
+func (writer *localFileWriter) Close() error {
+	err := writer.ctx.Err()
+	if closeErr := writer.file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		// the permissions of the files created with 0777 and the default umask
+		err = os.Chmod(writer.file.Name(), 0755)
+	}
+	if err == nil {
+		err = convertError(os.Rename(writer.file.Name(), writer.filePath))
 	}
 
-	return nil
+	if err != nil {
+		_ = os.Remove(writer.file.Name())
+	}
+	return err
 }
 
 func (storage *localStorage) Read(fileName string) (io.ReadCloser, error) {
-	return os.OpenFile(path.Join(storage.rootDirectory, fileName), os.O_RDONLY, 0644)
+	filePath, err := storage.resolve(fileName)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(filePath, os.O_RDONLY, 0644)
+	if err != nil {
+		err = convertError(err)
+		common.LogOperation(storage.logger, "read", fileName, -1, time.Now(), err)
+		return nil, err
+	}
+	return common.LogReadCloser(storage.logger, "read", fileName, file), nil
+}
+
+func (storage *localStorage) ReadContext(ctx context.Context, fileName string) (io.ReadCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	file, err := storage.Read(fileName)
+	if err != nil {
+		return nil, err
+	}
+	return storageabstraction.NewContextReadCloser(ctx, file), nil
+}
+
+func (storage *localStorage) ReadRange(fileName string, offset int64, length int64) (io.ReadCloser, error) {
+	return storage.ReadRangeContext(context.Background(), fileName, offset, length)
+}
+
+func (storage *localStorage) ReadRangeContext(ctx context.Context, fileName string, offset int64, length int64) (io.ReadCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	filePath, err := storage.resolve(fileName)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(filePath, os.O_RDONLY, 0644)
+	if err == nil {
+		_, err = file.Seek(offset, io.SeekStart)
+		if err != nil {
+			_ = file.Close()
+		}
+	}
+	if err != nil {
+		err = convertError(err)
+		common.LogOperation(storage.logger, "readRange", fileName, -1, time.Now(), err)
+		return nil, err
+	}
+
+	reader := common.LogReadCloser(storage.logger, "readRange", fileName, storageabstraction.LimitReadCloser(file, length))
+	return storageabstraction.NewContextReadCloser(ctx, reader), nil
 }
 
 func (storage *localStorage) Join(paths ...string) string {
@@ -120,44 +261,129 @@ func (storage *localStorage) Join(paths ...string) string {
 }
 
 func (storage *localStorage) FileSize(fileName string) (int64, error) {
-	stats, err := os.Stat(path.Join(storage.rootDirectory, fileName))
+	return storage.FileSizeContext(context.Background(), fileName)
+}
+
+func (storage *localStorage) FileSizeContext(ctx context.Context, fileName string) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	filePath, err := storage.resolve(fileName)
 	if err != nil {
 		return 0, err
 	}
+	stats, err := os.Stat(filePath)
+	if err != nil {
+		return 0, convertError(err)
+	}
 
 	return stats.Size(), nil
 }
 
+func (storage *localStorage) Stat(fileName string) (*storageabstraction.FileInfo, error) {
+	return storage.StatContext(context.Background(), fileName)
+}
+
+// StatContext returns the details of the file, the content type is derived from the file extension
+// and the ETag from the modification time and the size
+func (storage *localStorage) StatContext(ctx context.Context, fileName string) (*storageabstraction.FileInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	filePath, err := storage.resolve(fileName)
+	if err != nil {
+		return nil, err
+	}
+	stats, err := os.Stat(filePath)
+	if err != nil {
+		return nil, convertError(err)
+	}
+
+	details := storageabstraction.FileDetails{
+		Name:    stats.Name(),
+		Size:    stats.Size(),
+		IsDir:   stats.IsDir(),
+		ModTime: stats.ModTime(),
+		ETag:    fmt.Sprintf("\"%x-%x\"", stats.ModTime().UnixNano(), stats.Size()),
+	}
+	if !stats.IsDir() {
+		details.ContentType = storageabstraction.ContentTypeByName(fileName)
+	}
+
+	return storageabstraction.NewFileInfoFromDetails(details), nil
+}
+
 func (storage *localStorage) DeleteDirectory(directory string) error {
-	return os.RemoveAll(path.Join(storage.rootDirectory, directory))
+	return storage.DeleteDirectoryContext(context.Background(), directory)
+}
+
+func (storage *localStorage) DeleteDirectoryContext(ctx context.Context, directory string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	directoryPath, err := storage.resolve(directory)
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	err = convertError(os.RemoveAll(directoryPath))
+	common.LogOperation(storage.logger, "deleteDirectory", directory, -1, start, err)
+	return err
 }
 
 func (storage *localStorage) DeleteFile(fileName string) error {
-	return os.Remove(path.Join(storage.rootDirectory, fileName))
+	return storage.DeleteFileContext(context.Background(), fileName)
+}
+
+func (storage *localStorage) DeleteFileContext(ctx context.Context, fileName string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	filePath, err := storage.resolve(fileName)
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	err = convertError(os.Remove(filePath))
+	common.LogOperation(storage.logger, "delete", fileName, -1, start, err)
+	return err
 }
 
 func (storage *localStorage) Walk(directory string, walk storageabstraction.WalkFunc) error {
+	return storage.WalkContext(context.Background(), directory, walk)
+}
+
+func (storage *localStorage) WalkContext(ctx context.Context, directory string, walk storageabstraction.WalkFunc) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 
-	rootPath := storage.Join(storage.rootDirectory, directory)
-	rootPath = filepath.ToSlash(rootPath)
-	trimLen := len(rootPath)
-	if directory[len(directory)-1] != '/' && directory[len(directory)-1] != '\\' {
-		trimLen++
+	rootPath, err := storage.resolve(directory)
+	if err != nil {
+		_ = walk("", nil, err)
+		return err
 	}
 
-	return filepath.Walk(path.Join(storage.rootDirectory, directory), func(path string, info fs.FileInfo, err error) error {
+	return filepath.Walk(rootPath, func(filePath string, info fs.FileInfo, err error) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
 		if err != nil {
 			_ = walk("", info, err)
 			return err
 		}
-		path = filepath.ToSlash(path)
 
-		if len(path) <= trimLen {
-			path = ""
-		} else {
-			path = path[trimLen:]
+		// the paths are relative to the directory, the directory itself is ""
+		relativePath, err := filepath.Rel(rootPath, filePath)
+		if err != nil {
+			return err
+		}
+		if relativePath == "." {
+			relativePath = ""
 		}
 
-		return walk(path, info, nil)
+		return walk(filepath.ToSlash(relativePath), info, nil)
 	})
 }
@@ -1,10 +1,17 @@
 package localstorage
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"github.com/2flow/gokies/storageabstraction"
+	"io"
+	"io/fs"
 	"os"
 	"strings"
 	"testing"
+
+	"github.com/go-kit/log"
 )
 
 const (
@@ -25,6 +32,315 @@ func TestLocalStorage(t *testing.T) {
 	testLocalStorageRead(t)
 }
 
+func TestLocalStorageStat(t *testing.T) {
+	os.RemoveAll(testTempDir)
+	defer os.RemoveAll(testTempDir)
+
+	err := createTestDir()
+	if err != nil {
+		t.Errorf("[TestError] Error creating test dir: %v", err)
+		return
+	}
+
+	storage := NewLocalStorage(testTempDir)
+	info, err := storageabstraction.Stat(storage, "compressDir/test2.txt")
+	if err != nil {
+		t.Errorf("Error getting file details: %v", err)
+		return
+	}
+	if info.Name() != "test2.txt" || info.Size() != 5 || info.IsDir() || info.ETag() == "" ||
+		info.ModTime().IsZero() || !strings.HasPrefix(info.ContentType(), "text/plain") {
+		t.Errorf("Unexpected file details: %+v", info.Details())
+	}
+
+	exists, err := storageabstraction.Exists(storage, "compressDir/subDir")
+	if !exists || err != nil {
+		t.Errorf("Directory does not exist: %v", err)
+	}
+	exists, err = storageabstraction.Exists(storage, "compressDir/missing.txt")
+	if exists || err != nil {
+		t.Errorf("Missing file exists: %v", err)
+	}
+}
+
+func TestLocalStorageOpenWriter(t *testing.T) {
+	os.RemoveAll(testTempDir)
+	defer os.RemoveAll(testTempDir)
+
+	storage := NewLocalStorage(testTempDir)
+	writer, err := storageabstraction.OpenWriter(storage, "stream/test.txt")
+	if err != nil {
+		t.Errorf("Error opening writer: %v", err)
+		return
+	}
+	_, _ = writer.Write([]byte("streamed "))
+	_, _ = writer.Write([]byte("content"))
+	if err = writer.Close(); err != nil {
+		t.Errorf("Error closing writer: %v", err)
+		return
+	}
+
+	content, err := os.ReadFile(testTempDir + "/stream/test.txt")
+	if err != nil || string(content) != "streamed content" {
+		t.Errorf("Unexpected content %q: %v", content, err)
+	}
+
+	// a cancelled write keeps the existing file
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	writer, err = storageabstraction.OpenWriterContext(ctx, storage, "stream/test.txt")
+	if err != nil {
+		t.Errorf("Error opening writer: %v", err)
+		return
+	}
+	_, _ = writer.Write([]byte("aborted"))
+	cancel()
+	if err = writer.Close(); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected cancel error, actual: %v", err)
+	}
+
+	content, _ = os.ReadFile(testTempDir + "/stream/test.txt")
+	if string(content) != "streamed content" {
+		t.Errorf("Aborted write modified the file: %q", content)
+	}
+	entries, _ := os.ReadDir(testTempDir + "/stream")
+	if len(entries) != 1 {
+		t.Errorf("Temp file of aborted write was not removed")
+	}
+}
+
+// cancelReader cancels the context after the first read
+type cancelReader struct {
+	*strings.Reader
+	cancel context.CancelFunc
+}
+
+func (reader *cancelReader) Read(p []byte) (n int, err error) {
+	defer reader.cancel()
+	return reader.Reader.Read(p[:min(len(p), 100)])
+}
+
+func TestLocalStorageCopyAndMove(t *testing.T) {
+	os.RemoveAll(testTempDir)
+	defer os.RemoveAll(testTempDir)
+
+	err := createTestDir()
+	if err != nil {
+		t.Errorf("[TestError] Error creating test dir: %v", err)
+		return
+	}
+
+	storage := NewLocalStorage(testTempDir)
+	if err = storageabstraction.CopyFile(storage, "compressDir/test.txt", "copy/test.txt"); err != nil {
+		t.Errorf("Error copying file: %v", err)
+		return
+	}
+
+	// the copy is independent of the source
+	if err = storage.Write("compressDir/test.txt", 7, strings.NewReader("changed")); err != nil {
+		t.Errorf("Error writing file: %v", err)
+		return
+	}
+	content, err := os.ReadFile(testTempDir + "/copy/test.txt")
+	if err != nil || string(content) != "test" {
+		t.Errorf("Unexpected content of copy %q: %v", content, err)
+	}
+
+	// a write replaces the whole file, a cancelled write keeps it
+	if err = storage.Write("copy/test.txt", 3, strings.NewReader("new")); err != nil {
+		t.Errorf("Error writing file: %v", err)
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reader := &cancelReader{Reader: strings.NewReader(strings.Repeat("cancelled", 1000)), cancel: cancel}
+	if err = storageabstraction.WithContext(storage).WriteContext(ctx, "copy/test.txt", 9000, reader); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected cancel error, actual: %v", err)
+	}
+	for fileName, expected := range map[string]string{"copy/test.txt": "new", "compressDir/test.txt": "changed"} {
+		if content, err = os.ReadFile(testTempDir + "/" + fileName); err != nil || string(content) != expected {
+			t.Errorf("Unexpected content of %s %q: %v", fileName, content, err)
+		}
+	}
+
+	if err = storageabstraction.MoveDirectory(storage, "compressDir", "copy"); err != nil {
+		t.Errorf("Error moving directory: %v", err)
+		return
+	}
+	content, err = os.ReadFile(testTempDir + "/copy/subDir/test3.txt")
+	if err != nil || string(content) != "test3" {
+		t.Errorf("Unexpected content of moved file %q: %v", content, err)
+	}
+	if _, err = os.Stat(testTempDir + "/compressDir"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Source directory still exists: %v", err)
+	}
+
+	if err = storageabstraction.MoveFile(storage, "copy/test2.txt", "renamed.txt"); err != nil {
+		t.Errorf("Error moving file: %v", err)
+	}
+	if exists, _ := storageabstraction.Exists(storage, "copy/test2.txt"); exists {
+		t.Errorf("Moved file still exists")
+	}
+}
+
+func TestLocalStorageConditions(t *testing.T) {
+	os.RemoveAll(testTempDir)
+	defer os.RemoveAll(testTempDir)
+
+	// the local storage has no conditional writes, the conditions are checked with the ETag of Stat before
+	storage := NewLocalStorage(testTempDir)
+	createOnly := storageabstraction.WriteOptions{Conditions: storageabstraction.Conditions{IfNoneMatch: storageabstraction.ETagAny}}
+	if err := storageabstraction.WriteWithOptions(storage, "test.txt", 4, strings.NewReader("test"), createOnly); err != nil {
+		t.Errorf("Error creating file: %v", err)
+		return
+	}
+	err := storageabstraction.WriteWithOptions(storage, "test.txt", 4, strings.NewReader("next"), createOnly)
+	if !errors.Is(err, storageabstraction.ErrPreconditionFailed) {
+		t.Errorf("Expected precondition failed error for an existing file, actual: %v", err)
+	}
+
+	err = storageabstraction.DeleteFileWithConditions(storage, "test.txt", storageabstraction.Conditions{IfMatch: "\"outdated\""})
+	if !errors.Is(err, storageabstraction.ErrPreconditionFailed) {
+		t.Errorf("Expected precondition failed error for an outdated ETag, actual: %v", err)
+	}
+	info, err := storageabstraction.Stat(storage, "test.txt")
+	if err != nil {
+		t.Errorf("[TestError] Error getting file details: %v", err)
+		return
+	}
+	if err = storageabstraction.DeleteFileWithConditions(storage, "test.txt", storageabstraction.Conditions{IfMatch: info.ETag()}); err != nil {
+		t.Errorf("Error deleting file with the current ETag: %v", err)
+	}
+}
+
+func TestLocalStorageErrors(t *testing.T) {
+	os.RemoveAll(testTempDir)
+	defer os.RemoveAll(testTempDir)
+
+	err := createTestDir()
+	if err != nil {
+		t.Errorf("[TestError] Error creating test dir: %v", err)
+		return
+	}
+
+	storage := NewLocalStorage(testTempDir)
+	if _, err = storage.Read("compressDir/missing.txt"); !errors.Is(err, storageabstraction.ErrNotExist) {
+		t.Errorf("Expected not exist error, actual: %v", err)
+	}
+	if err = storage.DeleteFile("compressDir"); !errors.Is(err, storageabstraction.ErrConflict) {
+		t.Errorf("Expected conflict error for deleting a directory, actual: %v", err)
+	}
+	if err = storage.Write("compressDir", 4, strings.NewReader("test")); !errors.Is(err, storageabstraction.ErrConflict) {
+		t.Errorf("Expected conflict error for replacing a directory, actual: %v", err)
+	}
+}
+
+func TestLocalStoragePathEscape(t *testing.T) {
+	os.RemoveAll(testTempDir)
+	defer os.RemoveAll(testTempDir)
+
+	if err := os.MkdirAll(testTempDir+"/root", 0777); err != nil {
+		t.Errorf("[TestError] Error creating test dir: %v", err)
+		return
+	}
+	if err := os.WriteFile(testTempDir+"/secret.txt", []byte("secret"), 0666); err != nil {
+		t.Errorf("[TestError] Error creating test file: %v", err)
+		return
+	}
+
+	storage := NewLocalStorage(testTempDir + "/root")
+	for _, fileName := range []string{"../secret.txt", "dir/../../secret.txt", "..\\secret.txt"} {
+		if _, err := storage.Read(fileName); !errors.Is(err, storageabstraction.ErrPathEscape) {
+			t.Errorf("Expected reading %q to escape, actual: %v", fileName, err)
+		}
+		if err := storage.Write(fileName, 4, strings.NewReader("test")); !errors.Is(err, storageabstraction.ErrPathEscape) {
+			t.Errorf("Expected writing %q to escape, actual: %v", fileName, err)
+		}
+		if err := storageabstraction.CopyFile(storage, fileName, "copy.txt"); !errors.Is(err, storageabstraction.ErrPathEscape) {
+			t.Errorf("Expected copying %q to escape, actual: %v", fileName, err)
+		}
+		if err := storage.DeleteFile(fileName); !errors.Is(err, storageabstraction.ErrPathEscape) {
+			t.Errorf("Expected deleting %q to escape, actual: %v", fileName, err)
+		}
+	}
+	if err := storage.DeleteDirectory(".."); !errors.Is(err, storageabstraction.ErrPermission) {
+		t.Errorf("Expected deleting the parent directory to fail, actual: %v", err)
+	}
+	if err := storage.Write("", 4, strings.NewReader("test")); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("Expected writing the root directory to fail, actual: %v", err)
+	}
+
+	content, err := os.ReadFile(testTempDir + "/secret.txt")
+	if err != nil || string(content) != "secret" {
+		t.Errorf("Expected the file outside of the root to be unchanged, actual: %q, err: %v", content, err)
+	}
+}
+
+func TestLocalStorageLogging(t *testing.T) {
+	os.RemoveAll(testTempDir)
+	defer os.RemoveAll(testTempDir)
+
+	var output bytes.Buffer
+	storage := NewLocalStorage(testTempDir, WithLogger(log.NewLogfmtLogger(&output)))
+
+	if err := storage.Write("dir/test.txt", 4, strings.NewReader("test")); err != nil {
+		t.Errorf("[TestError] Error writing file: %v", err)
+		return
+	}
+	reader, err := storage.Read("dir/test.txt")
+	if err != nil {
+		t.Errorf("[TestError] Error reading file: %v", err)
+		return
+	}
+	_, _ = io.ReadAll(reader)
+	reader.Close()
+	_, _ = storage.Read("dir/missing.txt")
+
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	if len(lines) != 3 {
+		t.Errorf("Expected 3 log lines, actual: %q", output.String())
+		return
+	}
+	for i, expected := range []string{"level=debug op=write path=dir/test.txt bytes=4 ", "level=debug op=read path=dir/test.txt bytes=4 ", "level=error op=read path=dir/missing.txt "} {
+		if !strings.HasPrefix(lines[i], expected) {
+			t.Errorf("Unexpected log line, expected prefix: %q, actual: %q", expected, lines[i])
+		}
+	}
+	if !strings.Contains(lines[2], "err=") {
+		t.Errorf("Error is not logged: %q", lines[2])
+	}
+}
+
+func TestLocalStorageWalk(t *testing.T) {
+	os.RemoveAll(testTempDir)
+	defer os.RemoveAll(testTempDir)
+
+	err := createTestDir()
+	if err != nil {
+		t.Errorf("[TestError] Error creating test dir: %v", err)
+		return
+	}
+
+	storage := NewLocalStorage(testTempDir)
+	for directory, expected := range map[string]string{
+		"":             ",compressDir,compressDir/subDir,compressDir/subDir/test3.txt,compressDir/test.txt,compressDir/test2.txt",
+		"compressDir":  ",subDir,subDir/test3.txt,test.txt,test2.txt",
+		"compressDir/": ",subDir,subDir/test3.txt,test.txt,test2.txt",
+	} {
+		var walked []string
+		err = storage.Walk(directory, func(path string, info fs.FileInfo, err error) error {
+			walked = append(walked, path)
+			return err
+		})
+		if err != nil {
+			t.Errorf("Error walking %q: %v", directory, err)
+		}
+		if strings.Join(walked, ",") != expected {
+			t.Errorf("Walk of %q failed, actual: %q", directory, walked)
+		}
+	}
+}
+
 func TestPathJoin(t *testing.T) {
 	storage := NewLocalStorage(testTempDir)
 
@@ -0,0 +1,49 @@
+package storageabstraction
+
+import (
+	"context"
+	"errors"
+)
+
+// ILock is a lock on a file, which is held until Unlock is called
+type ILock interface {
+	Unlock() error
+}
+
+// ILockFileStorage is implemented by storages which lock files across processes, e.g. for the replicas
+// of a service which share the storage. The locked file is only used as lock, its content is not changed
+type ILockFileStorage interface {
+	// TryLock locks the file, ErrConflict is returned if it is locked by someone else
+	TryLock(fileName string) (ILock, error)
+	TryLockContext(ctx context.Context, fileName string) (ILock, error)
+	// Lock waits until the file is locked
+	Lock(fileName string) (ILock, error)
+	LockContext(ctx context.Context, fileName string) (ILock, error)
+}
+
+// TryLock locks the file, ErrConflict is returned if it is locked by someone else.
+// Storages which do not implement ILockFileStorage return errors.ErrUnsupported
+func TryLock(storage IFileStorage, fileName string) (ILock, error) {
+	return TryLockContext(context.Background(), storage, fileName)
+}
+
+// TryLockContext is TryLock with a context
+func TryLockContext(ctx context.Context, storage IFileStorage, fileName string) (ILock, error) {
+	if lockStorage, ok := storage.(ILockFileStorage); ok {
+		return lockStorage.TryLockContext(ctx, fileName)
+	}
+	return nil, NewPathError("lock", fileName, errors.ErrUnsupported, nil)
+}
+
+// Lock waits until the file is locked, storages which do not implement ILockFileStorage return errors.ErrUnsupported
+func Lock(storage IFileStorage, fileName string) (ILock, error) {
+	return LockContext(context.Background(), storage, fileName)
+}
+
+// LockContext is Lock with a context
+func LockContext(ctx context.Context, storage IFileStorage, fileName string) (ILock, error) {
+	if lockStorage, ok := storage.(ILockFileStorage); ok {
+		return lockStorage.LockContext(ctx, fileName)
+	}
+	return nil, NewPathError("lock", fileName, errors.ErrUnsupported, nil)
+}
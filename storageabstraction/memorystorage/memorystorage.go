@@ -0,0 +1,577 @@
+package memorystorage
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"github.com/2flow/gokies/storageabstraction"
+	"github.com/2flow/gokies/storageabstraction/common"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type memoryFile struct {
+	content     []byte
+	contentMD5  []byte
+	contentType string
+	encoding    string
+	modTime     time.Time
+}
+
+// etag is the quoted MD5 hash of the content
+func (file *memoryFile) etag() string {
+	return "\"" + hex.EncodeToString(file.contentMD5) + "\""
+}
+
+type memoryStorage struct {
+	files map[string]*memoryFile
+	lock  sync.RWMutex
+}
+
+// NewMemoryStorage creates a new, empty storage which keeps all files in memory.
+// It is safe for concurrent use and behaves like the local storage, directories exist as long as they contain files.
+func NewMemoryStorage() storageabstraction.IContextFileStorage {
+	return &memoryStorage{files: map[string]*memoryFile{}}
+}
+
+// cleanPath converts the file name to the key used in the files map, paths which escape from the root are rejected
+func cleanPath(fileName string) (string, error) {
+	return common.CleanPath(fileName)
+}
+
+// isInDirectory checks if the key is a (sub) entry of the directory
+func isInDirectory(key string, directory string) bool {
+	return directory == "" || strings.HasPrefix(key, directory+"/")
+}
+
+func (storage *memoryStorage) Write(fileName string, fileSize int64, reader io.ReadSeeker) error {
+	return storage.WriteContext(context.Background(), fileName, fileSize, reader)
+}
+
+func (storage *memoryStorage) WriteContext(ctx context.Context, fileName string, fileSize int64, reader io.ReadSeeker) error {
+	return storage.WriteWithOptionsContext(ctx, fileName, fileSize, reader, storageabstraction.WriteOptions{})
+}
+
+func (storage *memoryStorage) WriteWithOptions(fileName string, fileSize int64, reader io.ReadSeeker, options storageabstraction.WriteOptions) error {
+	return storage.WriteWithOptionsContext(context.Background(), fileName, fileSize, reader, options)
+}
+
+// WriteWithOptionsContext writes the file if the conditions are met, only the content type and encoding of the options are kept
+func (storage *memoryStorage) WriteWithOptionsContext(ctx context.Context, fileName string, _ int64, reader io.ReadSeeker, options storageabstraction.WriteOptions) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	content, err := io.ReadAll(storageabstraction.NewContextReadSeeker(ctx, reader))
+	if err != nil {
+		return err
+	}
+
+	return storage.store(fileName, content, options)
+}
+
+// store replaces the content of the file if the conditions of the options are met, only the content type and the
+// content encoding are kept. Without content type it is detected from the name and the content
+func (storage *memoryStorage) store(fileName string, content []byte, options storageabstraction.WriteOptions) error {
+	key, err := cleanPath(fileName)
+	if err != nil {
+		return err
+	} else if key == "" {
+		return &fs.PathError{Op: "write", Path: fileName, Err: fs.ErrInvalid}
+	}
+
+	storage.lock.Lock()
+	defer storage.lock.Unlock()
+
+	// like in a file system a file can not replace a directory or be inside of a file
+	for dir := path.Dir(key); dir != "."; dir = path.Dir(dir) {
+		if _, isFile := storage.files[dir]; isFile {
+			return storageabstraction.NewPathError("write", fileName, storageabstraction.ErrConflict, nil)
+		}
+	}
+	for existingKey := range storage.files {
+		if isInDirectory(existingKey, key) {
+			return storageabstraction.NewPathError("write", fileName, storageabstraction.ErrConflict, nil)
+		}
+	}
+
+	if err := storage.checkConditions("write", fileName, key, options.Conditions); err != nil {
+		return err
+	}
+
+	contentType := options.ContentType
+	if contentType == "" {
+		contentType = storageabstraction.DetectContentTypeOf(key, content)
+	}
+
+	contentMD5 := md5.Sum(content)
+	storage.files[key] = &memoryFile{content: content, contentMD5: contentMD5[:], contentType: contentType,
+		encoding: options.ContentEncoding, modTime: time.Now()}
+	return nil
+}
+
+// checkConditions compares the conditions with the ETag of the file, the storage must be locked
+func (storage *memoryStorage) checkConditions(op string, fileName string, key string, conditions storageabstraction.Conditions) error {
+	file, exists := storage.files[key]
+	if !exists {
+		return conditions.Check(op, fileName, false, "")
+	}
+	return conditions.Check(op, fileName, true, file.etag())
+}
+
+func (storage *memoryStorage) OpenWriter(fileName string) (io.WriteCloser, error) {
+	return storage.OpenWriterContext(context.Background(), fileName)
+}
+
+func (storage *memoryStorage) OpenWriterContext(ctx context.Context, fileName string) (io.WriteCloser, error) {
+	return storage.OpenWriterWithOptionsContext(ctx, fileName, storageabstraction.WriteOptions{})
+}
+
+func (storage *memoryStorage) OpenWriterWithOptions(fileName string, options storageabstraction.WriteOptions) (io.WriteCloser, error) {
+	return storage.OpenWriterWithOptionsContext(context.Background(), fileName, options)
+}
+
+// OpenWriterWithOptionsContext buffers the content, the file is replaced on Close
+func (storage *memoryStorage) OpenWriterWithOptionsContext(ctx context.Context, fileName string, options storageabstraction.WriteOptions) (io.WriteCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if key, err := cleanPath(fileName); err != nil {
+		return nil, err
+	} else if key == "" {
+		return nil, &fs.PathError{Op: "open", Path: fileName, Err: fs.ErrInvalid}
+	}
+
+	return &memoryFileWriter{ctx: ctx, storage: storage, fileName: fileName, options: options}, nil
+}
+
+type memoryFileWriter struct {
+	ctx      context.Context
+	storage  *memoryStorage
+	fileName string
+	options  storageabstraction.WriteOptions
+	content  bytes.Buffer
+}
+
+func (writer *memoryFileWriter) Write(p []byte) (n int, err error) {
+	if err := writer.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return writer.content.Write(p)
+}
+
+func (writer *memoryFileWriter) Close() error {
+	if err := writer.ctx.Err(); err != nil {
+		return err
+	}
+	return writer.storage.store(writer.fileName, writer.content.Bytes(), writer.options)
+}
+
+func (storage *memoryStorage) Read(fileName string) (io.ReadCloser, error) {
+	return storage.ReadContext(context.Background(), fileName)
+}
+
+func (storage *memoryStorage) ReadContext(ctx context.Context, fileName string) (io.ReadCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	key, err := cleanPath(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	storage.lock.RLock()
+	defer storage.lock.RUnlock()
+
+	file, ok := storage.files[key]
+	if !ok {
+		return nil, &fs.PathError{Op: "read", Path: fileName, Err: fs.ErrNotExist}
+	}
+
+	// the content is never modified, a write replaces the whole file
+	return storageabstraction.NewContextReadCloser(ctx, io.NopCloser(bytes.NewReader(file.content))), nil
+}
+
+func (storage *memoryStorage) ReadRange(fileName string, offset int64, length int64) (io.ReadCloser, error) {
+	return storage.ReadRangeContext(context.Background(), fileName, offset, length)
+}
+
+func (storage *memoryStorage) ReadRangeContext(ctx context.Context, fileName string, offset int64, length int64) (io.ReadCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if offset < 0 || length < 0 {
+		return nil, &fs.PathError{Op: "read", Path: fileName, Err: fs.ErrInvalid}
+	}
+
+	key, err := cleanPath(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	storage.lock.RLock()
+	defer storage.lock.RUnlock()
+
+	file, ok := storage.files[key]
+	if !ok {
+		return nil, &fs.PathError{Op: "read", Path: fileName, Err: fs.ErrNotExist}
+	}
+
+	content := file.content[min(offset, int64(len(file.content))):]
+	if length != storageabstraction.CountToEnd && length < int64(len(content)) {
+		content = content[:length]
+	}
+
+	return storageabstraction.NewContextReadCloser(ctx, io.NopCloser(bytes.NewReader(content))), nil
+}
+
+func (storage *memoryStorage) FileSize(fileName string) (int64, error) {
+	return storage.FileSizeContext(context.Background(), fileName)
+}
+
+func (storage *memoryStorage) FileSizeContext(ctx context.Context, fileName string) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	key, err := cleanPath(fileName)
+	if err != nil {
+		return 0, err
+	}
+
+	storage.lock.RLock()
+	defer storage.lock.RUnlock()
+
+	file, ok := storage.files[key]
+	if !ok {
+		return 0, &fs.PathError{Op: "stat", Path: fileName, Err: fs.ErrNotExist}
+	}
+
+	return int64(len(file.content)), nil
+}
+
+func (storage *memoryStorage) Stat(fileName string) (*storageabstraction.FileInfo, error) {
+	return storage.StatContext(context.Background(), fileName)
+}
+
+// StatContext returns the details of the file or the directory, the ETag is the MD5 hash of the content
+func (storage *memoryStorage) StatContext(ctx context.Context, fileName string) (*storageabstraction.FileInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	key, err := cleanPath(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	storage.lock.RLock()
+	defer storage.lock.RUnlock()
+
+	if file, ok := storage.files[key]; ok {
+		return storageabstraction.NewFileInfoFromDetails(storageabstraction.FileDetails{
+			Name:            path.Base(key),
+			Size:            int64(len(file.content)),
+			ModTime:         file.modTime,
+			ContentType:     file.contentType,
+			ContentEncoding: file.encoding,
+			ETag:            file.etag(),
+			ContentMD5:      file.contentMD5,
+		}), nil
+	}
+
+	for existingKey := range storage.files {
+		if isInDirectory(existingKey, key) {
+			return storageabstraction.NewFileInfoFromDetails(storageabstraction.FileDetails{
+				Name:  path.Base("/" + key),
+				IsDir: true,
+			}), nil
+		}
+	}
+
+	return nil, &fs.PathError{Op: "stat", Path: fileName, Err: fs.ErrNotExist}
+}
+
+func (storage *memoryStorage) DeleteDirectory(directory string) error {
+	return storage.DeleteDirectoryContext(context.Background(), directory)
+}
+
+func (storage *memoryStorage) DeleteDirectoryContext(ctx context.Context, directory string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	directory, err := cleanPath(directory)
+	if err != nil {
+		return err
+	}
+
+	storage.lock.Lock()
+	defer storage.lock.Unlock()
+
+	for key := range storage.files {
+		if key == directory || isInDirectory(key, directory) {
+			delete(storage.files, key)
+		}
+	}
+
+	return nil
+}
+
+func (storage *memoryStorage) DeleteFile(fileName string) error {
+	return storage.DeleteFileContext(context.Background(), fileName)
+}
+
+func (storage *memoryStorage) DeleteFileContext(ctx context.Context, fileName string) error {
+	return storage.DeleteFileWithConditionsContext(ctx, fileName, storageabstraction.Conditions{})
+}
+
+func (storage *memoryStorage) DeleteFileWithConditions(fileName string, conditions storageabstraction.Conditions) error {
+	return storage.DeleteFileWithConditionsContext(context.Background(), fileName, conditions)
+}
+
+func (storage *memoryStorage) DeleteFileWithConditionsContext(ctx context.Context, fileName string, conditions storageabstraction.Conditions) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	key, err := cleanPath(fileName)
+	if err != nil {
+		return err
+	}
+
+	storage.lock.Lock()
+	defer storage.lock.Unlock()
+
+	if _, ok := storage.files[key]; !ok {
+		return &fs.PathError{Op: "remove", Path: fileName, Err: fs.ErrNotExist}
+	}
+	if err := storage.checkConditions("remove", fileName, key, conditions); err != nil {
+		return err
+	}
+
+	delete(storage.files, key)
+	return nil
+}
+
+func (storage *memoryStorage) Walk(directory string, walk storageabstraction.WalkFunc) error {
+	return storage.WalkContext(context.Background(), directory, walk)
+}
+
+// WalkContext walks the directory like filepath.Walk does for the local storage:
+// first the directory itself with an empty path, then all files and sub directories in lexical order.
+// The storage is not locked while walk is called, so the files can be modified from within walk.
+func (storage *memoryStorage) WalkContext(ctx context.Context, directory string, walk storageabstraction.WalkFunc) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	key, err := cleanPath(directory)
+	if err != nil {
+		_ = walk("", nil, err)
+		return err
+	}
+	entries, err := storage.listEntries(key)
+	if err != nil {
+		err = &fs.PathError{Op: "walk", Path: directory, Err: err}
+		_ = walk("", nil, err)
+		return err
+	}
+
+	for i := 0; i < len(entries); i++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		entry := entries[i]
+		err := walk(entry.name, entry, nil)
+		if err == fs.SkipDir && entry.isDir {
+			// the content of a directory directly follows the directory
+			for i+1 < len(entries) && isInDirectory(entries[i+1].name, entry.name) {
+				i++
+			}
+			continue
+		}
+		if err != nil {
+			if err == fs.SkipDir || err == fs.SkipAll {
+				return nil
+			}
+			return err
+		}
+	}
+
+	return nil
+}
+
+// listEntries returns the directory itself, all files and all sub directories in walk order.
+// The names are relative to the directory.
+func (storage *memoryStorage) listEntries(directory string) ([]*fileInfo, error) {
+	storage.lock.RLock()
+	defer storage.lock.RUnlock()
+
+	if file, isFile := storage.files[directory]; isFile {
+		return []*fileInfo{newFileInfo(directory, "", file)}, nil
+	}
+
+	directories := map[string]time.Time{}
+	var entries []*fileInfo
+
+	for key, file := range storage.files {
+		if !isInDirectory(key, directory) {
+			continue
+		}
+
+		relativePath := strings.TrimPrefix(strings.TrimPrefix(key, directory), "/")
+		entries = append(entries, newFileInfo(key, relativePath, file))
+
+		// synthesize all parent directories of the file, the directory gets the latest modification of its content
+		for dir := path.Dir(relativePath); dir != "."; dir = path.Dir(dir) {
+			if directories[dir].Before(file.modTime) {
+				directories[dir] = file.modTime
+			}
+		}
+		if directories[""].Before(file.modTime) {
+			directories[""] = file.modTime
+		}
+	}
+
+	if len(entries) == 0 {
+		return nil, fs.ErrNotExist
+	}
+
+	for dir, modTime := range directories {
+		entries = append(entries, &fileInfo{name: dir, baseName: path.Base(path.Join(directory, dir)), modTime: modTime, isDir: true})
+	}
+
+	// "/" is sorted before every other character, so a directory content follows the directory directly
+	sort.Slice(entries, func(i, j int) bool {
+		return strings.ReplaceAll(entries[i].name, "/", "\x00") < strings.ReplaceAll(entries[j].name, "/", "\x00")
+	})
+
+	return entries, nil
+}
+
+func (storage *memoryStorage) Join(paths ...string) string {
+	return common.LinuxPathJoin(paths...)
+}
+
+func (storage *memoryStorage) CopyFile(source string, destination string) error {
+	return storage.CopyFileContext(context.Background(), source, destination)
+}
+
+// CopyFileContext copies the file, the content is shared because it is never modified
+func (storage *memoryStorage) CopyFileContext(ctx context.Context, source string, destination string) error {
+	return storage.relocateFile(ctx, "copy", source, destination, false)
+}
+
+func (storage *memoryStorage) MoveFile(source string, destination string) error {
+	return storage.MoveFileContext(context.Background(), source, destination)
+}
+
+func (storage *memoryStorage) MoveFileContext(ctx context.Context, source string, destination string) error {
+	return storage.relocateFile(ctx, "move", source, destination, true)
+}
+
+// relocateFile copies the file to the destination and removes the source if it is moved
+func (storage *memoryStorage) relocateFile(ctx context.Context, operation string, source string, destination string, move bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	sourceKey, err := cleanPath(source)
+	if err != nil {
+		return err
+	}
+	destinationKey, err := cleanPath(destination)
+	if err != nil {
+		return err
+	} else if destinationKey == "" {
+		return &fs.PathError{Op: operation, Path: destination, Err: fs.ErrInvalid}
+	}
+
+	storage.lock.Lock()
+	defer storage.lock.Unlock()
+
+	file, ok := storage.files[sourceKey]
+	if !ok {
+		return &fs.PathError{Op: operation, Path: source, Err: fs.ErrNotExist}
+	}
+	if sourceKey == destinationKey {
+		return nil
+	}
+
+	copied := *file
+	copied.modTime = time.Now()
+	storage.files[destinationKey] = &copied
+	if move {
+		delete(storage.files, sourceKey)
+	}
+	return nil
+}
+
+func (storage *memoryStorage) CopyDirectory(source string, destination string) error {
+	return storage.CopyDirectoryContext(context.Background(), source, destination)
+}
+
+func (storage *memoryStorage) CopyDirectoryContext(ctx context.Context, source string, destination string) error {
+	return storage.relocateDirectory(ctx, "copy", source, destination, false)
+}
+
+func (storage *memoryStorage) MoveDirectory(source string, destination string) error {
+	return storage.MoveDirectoryContext(context.Background(), source, destination)
+}
+
+func (storage *memoryStorage) MoveDirectoryContext(ctx context.Context, source string, destination string) error {
+	return storage.relocateDirectory(ctx, "move", source, destination, true)
+}
+
+// relocateDirectory copies all files of the directory into the destination directory at once,
+// and removes them from the source if they are moved
+func (storage *memoryStorage) relocateDirectory(ctx context.Context, operation string, source string, destination string, move bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	sourceDirectory, err := cleanPath(source)
+	if err != nil {
+		return err
+	}
+	destinationDirectory, err := cleanPath(destination)
+	if err != nil {
+		return err
+	}
+	if sourceDirectory == destinationDirectory {
+		return nil
+	}
+
+	storage.lock.Lock()
+	defer storage.lock.Unlock()
+
+	relocated := map[string]*memoryFile{}
+	for key, file := range storage.files {
+		if isInDirectory(key, sourceDirectory) {
+			relativePath := strings.TrimPrefix(strings.TrimPrefix(key, sourceDirectory), "/")
+			copied := *file
+			copied.modTime = time.Now()
+			relocated[path.Join(destinationDirectory, relativePath)] = &copied
+			if move {
+				delete(storage.files, key)
+			}
+		}
+	}
+	if len(relocated) == 0 {
+		return &fs.PathError{Op: operation, Path: source, Err: fs.ErrNotExist}
+	}
+
+	for key, file := range relocated {
+		storage.files[key] = file
+	}
+	return nil
+}
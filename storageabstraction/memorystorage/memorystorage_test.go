@@ -0,0 +1,379 @@
+package memorystorage
+
+import (
+	"errors"
+	"github.com/2flow/gokies/storageabstraction"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestMemoryStorageReadWrite(t *testing.T) {
+	storage := NewMemoryStorage()
+
+	testText := "Some test Text"
+	err := storage.Write("dir/writeTest1.txt", int64(len(testText)), strings.NewReader(testText))
+	if err != nil {
+		t.Errorf("Error writing test file: %v", err)
+		return
+	}
+
+	reader, err := storage.Read("/dir/./writeTest1.txt")
+	if err != nil {
+		t.Errorf("Error reading test file: %v", err)
+		return
+	}
+	defer reader.Close()
+
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		t.Errorf("Error reading written test file: %v", err)
+		return
+	}
+	if string(content) != testText {
+		t.Errorf("Values are not equal, expected: %v, actual: %v", testText, string(content))
+	}
+
+	size, err := storage.FileSize("dir/writeTest1.txt")
+	if err != nil || size != int64(len(testText)) {
+		t.Errorf("Unexpected file size %d: %v", size, err)
+	}
+
+	_, err = storage.Read("dir/missing.txt")
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected not exist error, actual: %v", err)
+	}
+}
+
+func TestMemoryStorageWalk(t *testing.T) {
+	storage := NewMemoryStorage()
+	writeTestFiles(t, storage, "compressDir/test.txt", "compressDir/test2.txt", "compressDir/subDir/test3.txt",
+		"compressDir-other/test4.txt")
+
+	var walked []string
+	err := storage.Walk("compressDir", func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			path += "/"
+		}
+		walked = append(walked, path)
+		return nil
+	})
+	if err != nil {
+		t.Errorf("Error walking directory: %v", err)
+		return
+	}
+
+	expected := []string{"/", "subDir/", "subDir/test3.txt", "test.txt", "test2.txt"}
+	if strings.Join(walked, ",") != strings.Join(expected, ",") {
+		t.Errorf("Walk failed, expected: %v, actual: %v", expected, walked)
+	}
+
+	entries, err := storageabstraction.List(storage, "compressDir")
+	if err != nil || len(entries) != 3 || entries[0].Name() != "subDir" || !entries[0].IsDir() || entries[2].Name() != "test2.txt" {
+		t.Errorf("Unexpected entries: %v, %v", entries, err)
+	}
+
+	err = storage.Walk("missingDir", func(path string, info os.FileInfo, err error) error {
+		return err
+	})
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected not exist error, actual: %v", err)
+	}
+}
+
+func TestMemoryStorageStat(t *testing.T) {
+	storage := NewMemoryStorage()
+	writeTestFiles(t, storage, "dir/sub/a.txt")
+
+	info, err := storageabstraction.Stat(storage, "dir/sub/a.txt")
+	if err != nil {
+		t.Errorf("Error getting file details: %v", err)
+		return
+	}
+	if info.Name() != "a.txt" || info.Size() != int64(len("dir/sub/a.txt")) || info.IsDir() ||
+		info.ETag() == "" || len(info.ContentMD5()) == 0 {
+		t.Errorf("Unexpected file details: %+v", info.Details())
+	}
+
+	info, err = storageabstraction.Stat(storage, "dir/sub")
+	if err != nil || !info.IsDir() || info.Name() != "sub" {
+		t.Errorf("Unexpected directory details: %v", err)
+	}
+
+	if exists, err := storageabstraction.Exists(storage, "dir/su"); exists || err != nil {
+		t.Errorf("Partial directory name exists: %v", err)
+	}
+}
+
+func TestMemoryStorageContentType(t *testing.T) {
+	storage := NewMemoryStorage()
+
+	options := storageabstraction.WriteOptions{ContentType: "application/x-custom"}
+	if err := storageabstraction.WriteWithOptions(storage, "custom.txt", 4, strings.NewReader("test"), options); err != nil {
+		t.Errorf("[TestError] Error writing file: %v", err)
+		return
+	}
+	if err := storage.Write("page", 13, strings.NewReader("<html></html>")); err != nil {
+		t.Errorf("[TestError] Error writing file: %v", err)
+		return
+	}
+	writer, err := storageabstraction.OpenWriterWithOptions(storage, "stream.bin", options)
+	if err != nil {
+		t.Errorf("[TestError] Error opening writer: %v", err)
+		return
+	}
+	_, _ = writer.Write([]byte("test"))
+	if err = writer.Close(); err != nil {
+		t.Errorf("[TestError] Error closing writer: %v", err)
+		return
+	}
+	if err = storageabstraction.CopyFile(storage, "custom.txt", "copy.txt"); err != nil {
+		t.Errorf("[TestError] Error copying file: %v", err)
+		return
+	}
+
+	for fileName, expected := range map[string]string{"custom.txt": "application/x-custom", "page": "text/html; charset=utf-8",
+		"stream.bin": "application/x-custom", "copy.txt": "application/x-custom"} {
+		info, err := storageabstraction.Stat(storage, fileName)
+		if err != nil {
+			t.Errorf("Error reading details of %s: %v", fileName, err)
+			continue
+		}
+		if info.ContentType() != expected {
+			t.Errorf("Unexpected content type of %s, expected: %s, actual: %s", fileName, expected, info.ContentType())
+		}
+	}
+}
+
+func TestMemoryStorageDelete(t *testing.T) {
+	storage := NewMemoryStorage()
+	writeTestFiles(t, storage, "dir/a.txt", "dir/sub/b.txt", "dir2/c.txt")
+
+	if err := storage.DeleteDirectory("dir"); err != nil {
+		t.Errorf("Error deleting directory: %v", err)
+	}
+	if _, err := storage.FileSize("dir/sub/b.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("File in deleted directory still exists: %v", err)
+	}
+
+	if err := storage.DeleteFile("dir2/c.txt"); err != nil {
+		t.Errorf("Error deleting file: %v", err)
+	}
+	if err := storage.DeleteFile("dir2/c.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected not exist error, actual: %v", err)
+	}
+}
+
+func TestMemoryStorageConditions(t *testing.T) {
+	storage := NewMemoryStorage()
+	createOnly := storageabstraction.WriteOptions{Conditions: storageabstraction.Conditions{IfNoneMatch: storageabstraction.ETagAny}}
+
+	if err := storageabstraction.WriteWithOptions(storage, "test.txt", 4, strings.NewReader("test"), createOnly); err != nil {
+		t.Errorf("Error creating file: %v", err)
+		return
+	}
+	writer, err := storageabstraction.OpenWriterWithOptions(storage, "test.txt", createOnly)
+	if err != nil {
+		t.Errorf("Error opening writer: %v", err)
+		return
+	}
+	_, _ = writer.Write([]byte("other"))
+	if err = writer.Close(); !errors.Is(err, storageabstraction.ErrPreconditionFailed) {
+		t.Errorf("Expected precondition failed error for an existing file, actual: %v", err)
+	}
+
+	info, err := storageabstraction.Stat(storage, "test.txt")
+	if err != nil {
+		t.Errorf("[TestError] Error getting file details: %v", err)
+		return
+	}
+	current := storageabstraction.Conditions{IfMatch: info.ETag()}
+	if err = storageabstraction.WriteWithOptions(storage, "test.txt", 3, strings.NewReader("new"), storageabstraction.WriteOptions{Conditions: current}); err != nil {
+		t.Errorf("Error replacing file with the current ETag: %v", err)
+	}
+	if err = storageabstraction.DeleteFileWithConditions(storage, "test.txt", current); !errors.Is(err, storageabstraction.ErrPreconditionFailed) {
+		t.Errorf("Expected precondition failed error for an outdated ETag, actual: %v", err)
+	}
+	if err = storageabstraction.DeleteFileWithConditions(storage, "test.txt", storageabstraction.Conditions{IfNoneMatch: info.ETag()}); err != nil {
+		t.Errorf("Error deleting file: %v", err)
+	}
+}
+
+func TestMemoryStorageErrors(t *testing.T) {
+	storage := NewMemoryStorage()
+	writeTestFiles(t, storage, "dir/a.txt")
+
+	if _, err := storage.Read("dir/missing.txt"); !errors.Is(err, storageabstraction.ErrNotExist) {
+		t.Errorf("Expected not exist error, actual: %v", err)
+	}
+	if err := storage.Write("dir", 4, strings.NewReader("test")); !errors.Is(err, storageabstraction.ErrConflict) {
+		t.Errorf("Expected conflict error for replacing a directory, actual: %v", err)
+	}
+	if err := storage.Write("dir/a.txt/b.txt", 4, strings.NewReader("test")); !errors.Is(err, storageabstraction.ErrConflict) {
+		t.Errorf("Expected conflict error for a file inside of a file, actual: %v", err)
+	}
+	if _, err := storageabstraction.ReadRange(storage, "dir/a.txt", -1, storageabstraction.CountToEnd); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("Expected invalid error for a negative offset, actual: %v", err)
+	}
+	if _, err := storageabstraction.ReadRange(storage, "dir/a.txt", 0, -2); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("Expected invalid error for a negative length, actual: %v", err)
+	}
+	if err := storage.Write("../a.txt", 4, strings.NewReader("test")); !errors.Is(err, storageabstraction.ErrPathEscape) {
+		t.Errorf("Expected path escape error for a file outside of the root, actual: %v", err)
+	}
+	if err := storageabstraction.CopyDirectory(storage, "dir", "dir/../.."); !errors.Is(err, storageabstraction.ErrPathEscape) {
+		t.Errorf("Expected path escape error for a directory outside of the root, actual: %v", err)
+	}
+}
+
+func TestMemoryStorageCopyAndMove(t *testing.T) {
+	storage := NewMemoryStorage()
+	writeTestFiles(t, storage, "dir/a.txt", "dir/sub/b.txt", "dir2/c.txt")
+
+	if err := storageabstraction.CopyFile(storage, "dir/a.txt", "copy/a.txt"); err != nil {
+		t.Errorf("Error copying file: %v", err)
+	}
+	if err := storageabstraction.MoveDirectory(storage, "dir", "dir2/moved"); err != nil {
+		t.Errorf("Error moving directory: %v", err)
+	}
+
+	var walked []string
+	_ = storage.Walk("", func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			walked = append(walked, path)
+		}
+		return err
+	})
+	if strings.Join(walked, ",") != "copy/a.txt,dir2/c.txt,dir2/moved/a.txt,dir2/moved/sub/b.txt" {
+		t.Errorf("Unexpected files after copy and move: %v", walked)
+	}
+
+	if err := storageabstraction.MoveFile(storage, "dir/a.txt", "a.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected not exist error, actual: %v", err)
+	}
+}
+
+func TestCopyDirectoryBetweenStorages(t *testing.T) {
+	source := NewMemoryStorage()
+	destination := NewMemoryStorage()
+	writeTestFiles(t, source, "dir/a.txt", "dir/sub/b.txt")
+
+	if err := storageabstraction.MoveDirectoryBetween(source, "dir", destination, "target"); err != nil {
+		t.Errorf("Error moving directory: %v", err)
+		return
+	}
+
+	reader, err := destination.Read("target/sub/b.txt")
+	if err != nil {
+		t.Errorf("Moved file does not exist: %v", err)
+		return
+	}
+	defer reader.Close()
+	if content, _ := io.ReadAll(reader); string(content) != "dir/sub/b.txt" {
+		t.Errorf("Unexpected content of moved file: %q", content)
+	}
+
+	if exists, _ := storageabstraction.Exists(source, "dir"); exists {
+		t.Errorf("Source directory still exists")
+	}
+}
+
+func TestMemoryStorageConcurrentUse(t *testing.T) {
+	storage := NewMemoryStorage()
+	waitGroup := sync.WaitGroup{}
+
+	for i := 0; i < 20; i++ {
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			_ = storage.Write("dir/file.txt", 4, strings.NewReader("test"))
+			_ = storage.Walk("dir", func(path string, info os.FileInfo, err error) error { return nil })
+			_ = storage.DeleteDirectory("dir")
+		}()
+	}
+
+	waitGroup.Wait()
+}
+
+func writeTestFiles(t *testing.T, storage storageabstraction.IFileStorage, fileNames ...string) {
+	for _, fileName := range fileNames {
+		if err := storage.Write(fileName, int64(len(fileName)), strings.NewReader(fileName)); err != nil {
+			t.Errorf("[TestError] Error writing %s: %v", fileName, err)
+		}
+	}
+}
+
+func TestSubStorage(t *testing.T) {
+	storage := NewMemoryStorage()
+	if err := storage.Write("tenants/b/secret.txt", 6, strings.NewReader("secret")); err != nil {
+		t.Errorf("[TestError] Error writing file: %v", err)
+		return
+	}
+
+	tenants, err := storageabstraction.Sub(storage, "tenants")
+	if err != nil {
+		t.Errorf("[TestError] Error creating sub storage: %v", err)
+		return
+	}
+	sub, err := storageabstraction.Sub(tenants, "a")
+	if err != nil {
+		t.Errorf("[TestError] Error creating sub storage: %v", err)
+		return
+	}
+
+	for _, fileName := range []string{"index.html", "/site/app.js", "site/../site/style.css"} {
+		if err = sub.Write(fileName, 4, strings.NewReader("test")); err != nil {
+			t.Errorf("Error writing %s: %v", fileName, err)
+		}
+	}
+	for _, fileName := range []string{"tenants/a/index.html", "tenants/a/site/app.js", "tenants/a/site/style.css"} {
+		if size, err := storage.FileSize(fileName); err != nil || size != 4 {
+			t.Errorf("Expected %s in the wrapped storage, actual: %d, err: %v", fileName, size, err)
+		}
+	}
+
+	var walked []string
+	err = sub.Walk("", func(path string, info os.FileInfo, err error) error {
+		walked = append(walked, path)
+		return err
+	})
+	if err != nil || strings.Join(walked, ",") != ",index.html,site,site/app.js,site/style.css" {
+		t.Errorf("Expected the files of the sub storage, actual: %v, err: %v", walked, err)
+	}
+	if err = storageabstraction.CopyDirectory(sub, "site", "backup"); err != nil {
+		t.Errorf("Error copying directory: %v", err)
+	}
+	if exists, _ := storageabstraction.Exists(storage, "tenants/a/backup/app.js"); !exists {
+		t.Errorf("Expected the directory to be copied within the sub storage")
+	}
+
+	for _, fileName := range []string{"../b/secret.txt", "site/../../b/secret.txt", "..", "/../b/secret.txt", "..\\b\\secret.txt"} {
+		if _, err = sub.Read(fileName); !errors.Is(err, storageabstraction.ErrPathEscape) || !errors.Is(err, storageabstraction.ErrPermission) {
+			t.Errorf("Expected reading %q to escape, actual: %v", fileName, err)
+		}
+		if err = sub.Write(fileName, 4, strings.NewReader("test")); !errors.Is(err, storageabstraction.ErrPathEscape) {
+			t.Errorf("Expected writing %q to escape, actual: %v", fileName, err)
+		}
+		if err = sub.DeleteDirectory(fileName); !errors.Is(err, storageabstraction.ErrPathEscape) {
+			t.Errorf("Expected deleting %q to escape, actual: %v", fileName, err)
+		}
+	}
+	if err = storageabstraction.MoveFile(sub, "index.html", "../b/secret.txt"); !errors.Is(err, storageabstraction.ErrPathEscape) {
+		t.Errorf("Expected moving out of the sub storage to escape, actual: %v", err)
+	}
+	if err = sub.Write("", 4, strings.NewReader("test")); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("Expected writing the directory of the sub storage to fail, actual: %v", err)
+	}
+	if _, err = storageabstraction.Sub(storage, "../other"); !errors.Is(err, storageabstraction.ErrPathEscape) {
+		t.Errorf("Expected a sub storage outside of the storage to fail, actual: %v", err)
+	}
+	if size, err := storage.FileSize("tenants/b/secret.txt"); err != nil || size != 6 {
+		t.Errorf("Expected the file of the other tenant to be untouched, actual: %d, err: %v", size, err)
+	}
+}
@@ -0,0 +1,53 @@
+package memorystorage
+
+import (
+	"io/fs"
+	"strings"
+	"time"
+)
+
+// fileInfo describes a file or a synthesized directory of the memory storage
+type fileInfo struct {
+	name     string // relative to the walked directory
+	baseName string
+	size     int64
+	modTime  time.Time
+	isDir    bool
+}
+
+func newFileInfo(key string, relativePath string, file *memoryFile) *fileInfo {
+	return &fileInfo{
+		name:     relativePath,
+		baseName: key[strings.LastIndex(key, "/")+1:],
+		size:     int64(len(file.content)),
+		modTime:  file.modTime,
+		isDir:    false,
+	}
+}
+
+func (info *fileInfo) Name() string {
+	return info.baseName
+}
+
+func (info *fileInfo) Size() int64 {
+	return info.size
+}
+
+func (info *fileInfo) Mode() fs.FileMode {
+	if info.isDir {
+		return fs.ModeDir | 0777
+	}
+	return 0666
+}
+
+func (info *fileInfo) ModTime() time.Time {
+	return info.modTime
+}
+
+func (info *fileInfo) IsDir() bool {
+	return info.isDir
+}
+
+func (info *fileInfo) Sys() any {
+	return nil
+}
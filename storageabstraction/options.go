@@ -0,0 +1,117 @@
+package storageabstraction
+
+import (
+	"context"
+	"io"
+	"net/http"
+)
+
+// AccessTier is the storage tier of a file, storages without tiers ignore it
+type AccessTier string
+
+const (
+	// AccessTierDefault uses the default tier of the storage
+	AccessTierDefault AccessTier = ""
+	AccessTierHot     AccessTier = "Hot"
+	AccessTierCool    AccessTier = "Cool"
+	AccessTierCold    AccessTier = "Cold"
+	AccessTierArchive AccessTier = "Archive"
+)
+
+// SniffLength is the number of bytes used by http.DetectContentType, DetectContentTypeOf needs no more of the content
+const SniffLength = 512
+
+// WriteOptions are the properties of a written file, storages keep the properties they support.
+// The zero value detects the content type and uses the defaults of the storage for all other properties
+type WriteOptions struct {
+	// ContentType is detected from the file extension or the content if it is empty
+	ContentType        string
+	ContentEncoding    string
+	ContentDisposition string
+	CacheControl       string
+	Metadata           map[string]string
+	Tags               map[string]string
+	AccessTier         AccessTier
+	// Conditions are checked against the ETag of the existing file, before it is replaced
+	Conditions Conditions
+}
+
+// IWriteOptionsFileStorage is implemented by storages which store the properties of the WriteOptions with the file
+type IWriteOptionsFileStorage interface {
+	WriteWithOptions(fileName string, fileSize int64, reader io.ReadSeeker, options WriteOptions) error
+	WriteWithOptionsContext(ctx context.Context, fileName string, fileSize int64, reader io.ReadSeeker, options WriteOptions) error
+	OpenWriterWithOptions(fileName string, options WriteOptions) (io.WriteCloser, error)
+	OpenWriterWithOptionsContext(ctx context.Context, fileName string, options WriteOptions) (io.WriteCloser, error)
+}
+
+// WriteWithOptions writes the file with the properties of the options. Storages which do not implement
+// IWriteOptionsFileStorage ignore the options, only the conditions are checked before the write, which is not atomic
+func WriteWithOptions(storage IFileStorage, fileName string, fileSize int64, reader io.ReadSeeker, options WriteOptions) error {
+	return WriteWithOptionsContext(context.Background(), storage, fileName, fileSize, reader, options)
+}
+
+// WriteWithOptionsContext is WriteWithOptions with a context
+func WriteWithOptionsContext(ctx context.Context, storage IFileStorage, fileName string, fileSize int64, reader io.ReadSeeker, options WriteOptions) error {
+	if optionsStorage, ok := storage.(IWriteOptionsFileStorage); ok {
+		return optionsStorage.WriteWithOptionsContext(ctx, fileName, fileSize, reader, options)
+	}
+
+	if err := checkConditions(ctx, storage, "write", fileName, options.Conditions); err != nil {
+		return err
+	}
+	return WithContext(storage).WriteContext(ctx, fileName, fileSize, reader)
+}
+
+// OpenWriterWithOptions returns a writer for the file like OpenWriter, the file gets the properties of the options.
+// Storages which do not implement IWriteOptionsFileStorage ignore the options, only the conditions are checked on open
+func OpenWriterWithOptions(storage IFileStorage, fileName string, options WriteOptions) (io.WriteCloser, error) {
+	return OpenWriterWithOptionsContext(context.Background(), storage, fileName, options)
+}
+
+// OpenWriterWithOptionsContext is OpenWriterWithOptions with a context
+func OpenWriterWithOptionsContext(ctx context.Context, storage IFileStorage, fileName string, options WriteOptions) (io.WriteCloser, error) {
+	if optionsStorage, ok := storage.(IWriteOptionsFileStorage); ok {
+		return optionsStorage.OpenWriterWithOptionsContext(ctx, fileName, options)
+	}
+
+	if err := checkConditions(ctx, storage, "open", fileName, options.Conditions); err != nil {
+		return nil, err
+	}
+	return OpenWriterContext(ctx, storage, fileName)
+}
+
+// DetectContentType returns the content type of the file extension. For unknown extensions
+// the first bytes of the content are checked, the reader is reset to its position afterwards
+func DetectContentType(fileName string, reader io.ReadSeeker) (string, error) {
+	if contentType := ContentTypeByName(fileName); contentType != "" {
+		return contentType, nil
+	}
+
+	offset, err := reader.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return "", err
+	}
+
+	head := make([]byte, SniffLength)
+	n, err := io.ReadFull(reader, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	if _, err = reader.Seek(offset, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	return DetectContentTypeOf(fileName, head[:n]), nil
+}
+
+// DetectContentTypeOf returns the content type of the file extension or of the first bytes of the content
+func DetectContentTypeOf(fileName string, head []byte) string {
+	if contentType := ContentTypeByName(fileName); contentType != "" {
+		return contentType
+	}
+
+	if len(head) > SniffLength {
+		head = head[:SniffLength]
+	}
+	return http.DetectContentType(head)
+}
@@ -0,0 +1,60 @@
+package storageabstraction
+
+import (
+	"context"
+	"io"
+)
+
+const (
+	// CountToEnd as length of a range reads until the end of the file
+	CountToEnd int64 = 0
+)
+
+// IRangeFileStorage is implemented by storages which can read a part of a file
+type IRangeFileStorage interface {
+	ReadRange(fileName string, offset int64, length int64) (io.ReadCloser, error)
+	ReadRangeContext(ctx context.Context, fileName string, offset int64, length int64) (io.ReadCloser, error)
+}
+
+// ReadRange returns a reader for length bytes of the file, starting at offset. Use CountToEnd to read until the end.
+// Storages which do not implement IRangeFileStorage read the whole file and skip the bytes before the offset
+func ReadRange(storage IFileStorage, fileName string, offset int64, length int64) (io.ReadCloser, error) {
+	return ReadRangeContext(context.Background(), storage, fileName, offset, length)
+}
+
+// ReadRangeContext is ReadRange with a context
+func ReadRangeContext(ctx context.Context, storage IFileStorage, fileName string, offset int64, length int64) (io.ReadCloser, error) {
+	if rangeStorage, ok := storage.(IRangeFileStorage); ok {
+		return rangeStorage.ReadRangeContext(ctx, fileName, offset, length)
+	}
+
+	reader, err := WithContext(storage).ReadContext(ctx, fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = io.CopyN(io.Discard, reader, offset); err != nil && err != io.EOF {
+		_ = reader.Close()
+		return nil, err
+	}
+
+	return LimitReadCloser(reader, length), nil
+}
+
+type limitedReadCloser struct {
+	io.Reader
+	closer io.Closer
+}
+
+func (reader *limitedReadCloser) Close() error {
+	return reader.closer.Close()
+}
+
+// LimitReadCloser returns a reader which reads at most length bytes from the reader, CountToEnd does not limit it
+func LimitReadCloser(reader io.ReadCloser, length int64) io.ReadCloser {
+	if length == CountToEnd {
+		return reader
+	}
+
+	return &limitedReadCloser{Reader: io.LimitReader(reader, length), closer: reader}
+}
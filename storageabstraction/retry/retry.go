@@ -0,0 +1,147 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"github.com/2flow/gokies/storageabstraction"
+	"io"
+	"math/rand/v2"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+)
+
+const (
+	defaultMaxAttempts    = 4
+	defaultInitialBackoff = 100 * time.Millisecond
+	defaultMaxBackoff     = 5 * time.Second
+	defaultMultiplier     = 2
+	defaultJitter         = 0.2
+)
+
+// Option configures the retries of the storage
+type Option func(storage *retryStorage)
+
+// WithMaxAttempts sets how often an operation is tried, including the first attempt. The default is 4
+func WithMaxAttempts(maxAttempts int) Option {
+	return func(storage *retryStorage) {
+		if maxAttempts > 0 {
+			storage.maxAttempts = maxAttempts
+		}
+	}
+}
+
+// WithBackoff sets the wait time before the first retry and the maximum wait time, the default is 100ms up to 5s
+func WithBackoff(initial time.Duration, maximum time.Duration) Option {
+	return func(storage *retryStorage) {
+		if initial > 0 {
+			storage.initialBackoff = initial
+		}
+		if maximum >= initial {
+			storage.maxBackoff = maximum
+		}
+	}
+}
+
+// WithMultiplier sets the factor the wait time grows with after every retry, the default is 2
+func WithMultiplier(multiplier float64) Option {
+	return func(storage *retryStorage) {
+		if multiplier >= 1 {
+			storage.multiplier = multiplier
+		}
+	}
+}
+
+// WithJitter varies the wait times randomly by the fraction, so clients which failed together do not retry together.
+// The default is 0.2, 0 disables the jitter
+func WithJitter(jitter float64) Option {
+	return func(storage *retryStorage) {
+		if jitter >= 0 && jitter <= 1 {
+			storage.jitter = jitter
+		}
+	}
+}
+
+// WithRetryable sets the classification of the errors which are retried, the default is IsRetryable
+func WithRetryable(retryable func(err error) bool) Option {
+	return func(storage *retryStorage) {
+		if retryable != nil {
+			storage.retryable = retryable
+		}
+	}
+}
+
+// WithLogger logs the retries as warnings, by default nothing is logged
+func WithLogger(logger log.Logger) Option {
+	return func(storage *retryStorage) {
+		storage.logger = logger
+	}
+}
+
+// IsRetryable is the default classification of the errors. Unavailable backends, timeouts and broken connections
+// are retried, errors of the request itself like ErrNotExist or ErrConflict and cancelled contexts are not
+func IsRetryable(err error) bool {
+	switch {
+	case err == nil, errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return false
+	case errors.Is(err, storageabstraction.ErrUnavailable), errors.Is(err, io.ErrUnexpectedEOF),
+		errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.ECONNREFUSED), errors.Is(err, syscall.EPIPE):
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// permanentError ends the retries with the wrapped error, e.g. if a walk has passed entries to the walk function
+type permanentError struct {
+	err error
+}
+
+func (err *permanentError) Error() string {
+	return err.err.Error()
+}
+
+// do runs the operation until it succeeds, fails with an error which is not retried or the attempts are used up.
+// If the context is done while waiting for the next attempt, the context error is returned
+func (storage *retryStorage) do(ctx context.Context, op string, path string, operation func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := operation()
+
+		var permanent *permanentError
+		if errors.As(err, &permanent) {
+			return permanent.err
+		}
+		if err == nil || attempt >= storage.maxAttempts || !storage.retryable(err) || ctx.Err() != nil {
+			return err
+		}
+
+		backoff := storage.backoff(attempt)
+		_ = level.Warn(storage.logger).Log("msg", "Retrying storage operation", "op", op, "path", path,
+			"attempt", attempt, "backoff", backoff, "err", err)
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// backoff returns the wait time after the failed attempt, it grows exponentially up to the maximum
+func (storage *retryStorage) backoff(attempt int) time.Duration {
+	backoff := float64(storage.initialBackoff)
+	for i := 1; i < attempt && backoff < float64(storage.maxBackoff); i++ {
+		backoff *= storage.multiplier
+	}
+	backoff = min(backoff, float64(storage.maxBackoff))
+
+	// the jitter may exceed the maximum, so the retries are spread at the maximum too
+	backoff += backoff * storage.jitter * (2*rand.Float64() - 1)
+	return time.Duration(backoff)
+}
@@ -0,0 +1,172 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/2flow/gokies/storageabstraction"
+	"github.com/2flow/gokies/storageabstraction/memorystorage"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// flakyStorage fails the operations with ErrUnavailable, until the failures of the operation are used up
+type flakyStorage struct {
+	storageabstraction.IContextFileStorage
+	failures map[string]int
+	attempts map[string]int
+}
+
+func newFlakyStorage(failures map[string]int) *flakyStorage {
+	return &flakyStorage{IContextFileStorage: memorystorage.NewMemoryStorage(), failures: failures, attempts: map[string]int{}}
+}
+
+func (storage *flakyStorage) fail(op string) error {
+	storage.attempts[op]++
+	if storage.failures[op] > 0 {
+		storage.failures[op]--
+		return storageabstraction.NewPathError(op, "", storageabstraction.ErrUnavailable, errors.New("503 server busy"))
+	}
+	return nil
+}
+
+func (storage *flakyStorage) WriteContext(ctx context.Context, fileName string, fileSize int64, reader io.ReadSeeker) error {
+	if err := storage.fail("write"); err != nil {
+		// the failed attempt has consumed a part of the content, like a broken upload
+		_, _ = io.CopyN(io.Discard, reader, 2)
+		return err
+	}
+	return storage.IContextFileStorage.WriteContext(ctx, fileName, fileSize, reader)
+}
+
+func (storage *flakyStorage) ReadContext(ctx context.Context, fileName string) (io.ReadCloser, error) {
+	if err := storage.fail("read"); err != nil {
+		return nil, err
+	}
+	return storage.IContextFileStorage.ReadContext(ctx, fileName)
+}
+
+// WalkContext fails before the first entry with "walk" and after the first entry with "walkEntries"
+func (storage *flakyStorage) WalkContext(ctx context.Context, directory string, walk storageabstraction.WalkFunc) error {
+	if err := storage.fail("walk"); err != nil {
+		return walk("", nil, err)
+	}
+	return storage.IContextFileStorage.WalkContext(ctx, directory, func(filePath string, info os.FileInfo, err error) error {
+		if err == nil && filePath != "" {
+			if failErr := storage.fail("walkEntries"); failErr != nil {
+				err = walk(filePath, info, nil)
+				if err == nil {
+					err = walk("", nil, failErr)
+				}
+				return err
+			}
+		}
+		return walk(filePath, info, err)
+	})
+}
+
+func TestRetryStorage(t *testing.T) {
+	flaky := newFlakyStorage(map[string]int{"write": 2, "read": 10, "walk": 1, "walkEntries": 1})
+	storage := NewRetryStorage(flaky, WithBackoff(time.Millisecond, 2*time.Millisecond))
+
+	if err := storage.Write("dir/test.txt", 7, strings.NewReader("content")); err != nil || flaky.attempts["write"] != 3 {
+		t.Errorf("Error writing file after 3 attempts: %v, attempts: %d", err, flaky.attempts["write"])
+		return
+	}
+	reader, err := flaky.IContextFileStorage.Read("dir/test.txt")
+	if err != nil {
+		t.Errorf("[TestError] Error reading written file: %v", err)
+		return
+	}
+	content, _ := io.ReadAll(reader)
+	_ = reader.Close()
+	if string(content) != "content" {
+		t.Errorf("Expected the reader to be rewound before the retries, actual content: %s", content)
+	}
+
+	_, err = storage.Read("dir/test.txt")
+	if !errors.Is(err, storageabstraction.ErrUnavailable) || flaky.attempts["read"] != defaultMaxAttempts {
+		t.Errorf("Expected unavailable error after %d attempts, actual: %v, attempts: %d", defaultMaxAttempts, err, flaky.attempts["read"])
+	}
+
+	flaky.failures["read"] = 0
+	flaky.attempts["read"] = 0
+	if _, err = storage.Read("dir/missing.txt"); !errors.Is(err, storageabstraction.ErrNotExist) || flaky.attempts["read"] != 1 {
+		t.Errorf("Expected not exist error without retry, actual: %v, attempts: %d", err, flaky.attempts["read"])
+	}
+
+	// the failure before the first entry is retried, the failure after the first entry is not
+	var walked []string
+	err = storage.Walk("dir", func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		walked = append(walked, filePath)
+		return nil
+	})
+	if !errors.Is(err, storageabstraction.ErrUnavailable) || flaky.attempts["walk"] != 2 || strings.Join(walked, ",") != ",test.txt" {
+		t.Errorf("Expected walk error after the first entry, actual: %v, attempts: %d, walked: %v", err, flaky.attempts["walk"], walked)
+	}
+}
+
+func TestRetryStorageContext(t *testing.T) {
+	flaky := newFlakyStorage(map[string]int{"read": 10})
+	storage := NewRetryStorage(flaky, WithBackoff(time.Hour, time.Hour), WithMaxAttempts(2))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := storage.ReadContext(ctx, "test.txt"); !errors.Is(err, context.DeadlineExceeded) || flaky.attempts["read"] != 1 {
+		t.Errorf("Expected deadline exceeded error while waiting for the retry, actual: %v", err)
+	}
+
+	storage = NewRetryStorage(flaky, WithBackoff(time.Millisecond, time.Millisecond),
+		WithRetryable(func(err error) bool { return false }))
+	flaky.attempts["read"] = 0
+	if _, err := storage.Read("test.txt"); err == nil || flaky.attempts["read"] != 1 {
+		t.Errorf("Expected no retries with a custom classification, attempts: %d", flaky.attempts["read"])
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	storage := NewRetryStorage(nil, WithBackoff(100*time.Millisecond, time.Second), WithMultiplier(3), WithJitter(0)).(*retryStorage)
+	for attempt, expected := range []time.Duration{100 * time.Millisecond, 300 * time.Millisecond, 900 * time.Millisecond, time.Second, time.Second} {
+		if backoff := storage.backoff(attempt + 1); backoff != expected {
+			t.Errorf("Expected backoff %v after attempt %d, actual: %v", expected, attempt+1, backoff)
+		}
+	}
+
+	storage = NewRetryStorage(nil, WithBackoff(100*time.Millisecond, time.Second), WithJitter(0.5)).(*retryStorage)
+	for i := 0; i < 100; i++ {
+		if backoff := storage.backoff(1); backoff < 50*time.Millisecond || backoff > 150*time.Millisecond {
+			t.Errorf("Expected backoff with jitter between 50ms and 150ms, actual: %v", backoff)
+			return
+		}
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	for _, test := range []struct {
+		err       error
+		retryable bool
+	}{
+		{nil, false},
+		{storageabstraction.NewPathError("read", "test.txt", storageabstraction.ErrUnavailable, nil), true},
+		{storageabstraction.NewPathError("read", "test.txt", storageabstraction.ErrNotExist, nil), false},
+		{storageabstraction.NewPathError("write", "test.txt", storageabstraction.ErrPreconditionFailed, nil), false},
+		{fmt.Errorf("read: %w", io.ErrUnexpectedEOF), true},
+		{&net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}, true},
+		{&net.DNSError{IsTimeout: true}, true},
+		{context.Canceled, false},
+		{context.DeadlineExceeded, false},
+		{errors.New("unknown"), false},
+	} {
+		if IsRetryable(test.err) != test.retryable {
+			t.Errorf("Expected retryable %v for %v", test.retryable, test.err)
+		}
+	}
+}
@@ -0,0 +1,330 @@
+package retry
+
+import (
+	"context"
+	"github.com/2flow/gokies/storageabstraction"
+	"io"
+	"io/fs"
+	"os"
+	"time"
+
+	"github.com/go-kit/log"
+)
+
+// retryStorage retries the failed operations of the wrapped storage
+type retryStorage struct {
+	storage storageabstraction.IFileStorage
+	logger  log.Logger
+
+	maxAttempts    int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	multiplier     float64
+	jitter         float64
+	retryable      func(err error) bool
+}
+
+// NewRetryStorage wraps the storage, so its failed operations are retried with an exponential backoff.
+// Reads are retried until the reader is returned, streamed writes of OpenWriter are not retried at all,
+// because their content can not be repeated. Walks are only retried until the first entry is walked
+func NewRetryStorage(storage storageabstraction.IFileStorage, options ...Option) storageabstraction.IContextFileStorage {
+	retry := &retryStorage{
+		storage:        storage,
+		logger:         log.NewNopLogger(),
+		maxAttempts:    defaultMaxAttempts,
+		initialBackoff: defaultInitialBackoff,
+		maxBackoff:     defaultMaxBackoff,
+		multiplier:     defaultMultiplier,
+		jitter:         defaultJitter,
+		retryable:      IsRetryable,
+	}
+	for _, option := range options {
+		option(retry)
+	}
+
+	return retry
+}
+
+func (storage *retryStorage) contextStorage() storageabstraction.IContextFileStorage {
+	return storageabstraction.WithContext(storage.storage)
+}
+
+func (storage *retryStorage) Write(fileName string, fileSize int64, reader io.ReadSeeker) error {
+	return storage.WriteContext(context.Background(), fileName, fileSize, reader)
+}
+
+func (storage *retryStorage) WriteContext(ctx context.Context, fileName string, fileSize int64, reader io.ReadSeeker) error {
+	return storage.retryWrite(ctx, "write", fileName, reader, func() error {
+		return storage.contextStorage().WriteContext(ctx, fileName, fileSize, reader)
+	})
+}
+
+func (storage *retryStorage) WriteWithOptions(fileName string, fileSize int64, reader io.ReadSeeker, options storageabstraction.WriteOptions) error {
+	return storage.WriteWithOptionsContext(context.Background(), fileName, fileSize, reader, options)
+}
+
+func (storage *retryStorage) WriteWithOptionsContext(ctx context.Context, fileName string, fileSize int64, reader io.ReadSeeker, options storageabstraction.WriteOptions) error {
+	return storage.retryWrite(ctx, "write", fileName, reader, func() error {
+		return storageabstraction.WriteWithOptionsContext(ctx, storage.storage, fileName, fileSize, reader, options)
+	})
+}
+
+// retryWrite rewinds the reader to its current position before every retry, the write is not retried
+// if the reader can not tell its position
+func (storage *retryStorage) retryWrite(ctx context.Context, op string, fileName string, reader io.ReadSeeker, write func() error) error {
+	start, err := reader.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return write()
+	}
+
+	attempt := 0
+	return storage.do(ctx, op, fileName, func() error {
+		if attempt++; attempt > 1 {
+			if _, err := reader.Seek(start, io.SeekStart); err != nil {
+				return &permanentError{err: err}
+			}
+		}
+		return write()
+	})
+}
+
+func (storage *retryStorage) OpenWriter(fileName string) (io.WriteCloser, error) {
+	return storage.OpenWriterContext(context.Background(), fileName)
+}
+
+func (storage *retryStorage) OpenWriterContext(ctx context.Context, fileName string) (io.WriteCloser, error) {
+	return storageabstraction.OpenWriterContext(ctx, storage.storage, fileName)
+}
+
+func (storage *retryStorage) OpenWriterWithOptions(fileName string, options storageabstraction.WriteOptions) (io.WriteCloser, error) {
+	return storage.OpenWriterWithOptionsContext(context.Background(), fileName, options)
+}
+
+func (storage *retryStorage) OpenWriterWithOptionsContext(ctx context.Context, fileName string, options storageabstraction.WriteOptions) (io.WriteCloser, error) {
+	return storageabstraction.OpenWriterWithOptionsContext(ctx, storage.storage, fileName, options)
+}
+
+func (storage *retryStorage) Read(fileName string) (io.ReadCloser, error) {
+	return storage.ReadContext(context.Background(), fileName)
+}
+
+func (storage *retryStorage) ReadContext(ctx context.Context, fileName string) (reader io.ReadCloser, err error) {
+	err = storage.do(ctx, "read", fileName, func() error {
+		reader, err = storage.contextStorage().ReadContext(ctx, fileName)
+		return err
+	})
+	return reader, err
+}
+
+func (storage *retryStorage) ReadRange(fileName string, offset int64, length int64) (io.ReadCloser, error) {
+	return storage.ReadRangeContext(context.Background(), fileName, offset, length)
+}
+
+func (storage *retryStorage) ReadRangeContext(ctx context.Context, fileName string, offset int64, length int64) (reader io.ReadCloser, err error) {
+	err = storage.do(ctx, "readRange", fileName, func() error {
+		reader, err = storageabstraction.ReadRangeContext(ctx, storage.storage, fileName, offset, length)
+		return err
+	})
+	return reader, err
+}
+
+func (storage *retryStorage) FileSize(fileName string) (int64, error) {
+	return storage.FileSizeContext(context.Background(), fileName)
+}
+
+func (storage *retryStorage) FileSizeContext(ctx context.Context, fileName string) (size int64, err error) {
+	err = storage.do(ctx, "fileSize", fileName, func() error {
+		size, err = storage.contextStorage().FileSizeContext(ctx, fileName)
+		return err
+	})
+	return size, err
+}
+
+func (storage *retryStorage) Stat(fileName string) (*storageabstraction.FileInfo, error) {
+	return storage.StatContext(context.Background(), fileName)
+}
+
+func (storage *retryStorage) StatContext(ctx context.Context, fileName string) (info *storageabstraction.FileInfo, err error) {
+	err = storage.do(ctx, "stat", fileName, func() error {
+		info, err = storageabstraction.StatContext(ctx, storage.storage, fileName)
+		return err
+	})
+	return info, err
+}
+
+func (storage *retryStorage) DeleteDirectory(directory string) error {
+	return storage.DeleteDirectoryContext(context.Background(), directory)
+}
+
+func (storage *retryStorage) DeleteDirectoryContext(ctx context.Context, directory string) error {
+	return storage.do(ctx, "deleteDirectory", directory, func() error {
+		return storage.contextStorage().DeleteDirectoryContext(ctx, directory)
+	})
+}
+
+func (storage *retryStorage) DeleteFile(fileName string) error {
+	return storage.DeleteFileContext(context.Background(), fileName)
+}
+
+func (storage *retryStorage) DeleteFileContext(ctx context.Context, fileName string) error {
+	return storage.do(ctx, "delete", fileName, func() error {
+		return storage.contextStorage().DeleteFileContext(ctx, fileName)
+	})
+}
+
+func (storage *retryStorage) DeleteFileWithConditions(fileName string, conditions storageabstraction.Conditions) error {
+	return storage.DeleteFileWithConditionsContext(context.Background(), fileName, conditions)
+}
+
+func (storage *retryStorage) DeleteFileWithConditionsContext(ctx context.Context, fileName string, conditions storageabstraction.Conditions) error {
+	return storage.do(ctx, "delete", fileName, func() error {
+		return storageabstraction.DeleteFileWithConditionsContext(ctx, storage.storage, fileName, conditions)
+	})
+}
+
+func (storage *retryStorage) Walk(directory string, walk storageabstraction.WalkFunc) error {
+	return storage.WalkContext(context.Background(), directory, walk)
+}
+
+// WalkContext retries the walk as long as no entry is passed to the walk function, so no entry is walked twice
+func (storage *retryStorage) WalkContext(ctx context.Context, directory string, walk storageabstraction.WalkFunc) error {
+	started := false
+	return storage.do(ctx, "walk", directory, func() error {
+		err := storage.contextStorage().WalkContext(ctx, directory, func(filePath string, info os.FileInfo, err error) error {
+			if err != nil && !started && storage.retryable(err) {
+				return err
+			}
+			started = true
+			return walk(filePath, info, err)
+		})
+		if err != nil && started {
+			return &permanentError{err: err}
+		}
+		return err
+	})
+}
+
+func (storage *retryStorage) List(directory string) ([]fs.FileInfo, error) {
+	return storage.ListContext(context.Background(), directory)
+}
+
+func (storage *retryStorage) ListContext(ctx context.Context, directory string) (entries []fs.FileInfo, err error) {
+	err = storage.do(ctx, "list", directory, func() error {
+		entries, err = storageabstraction.ListContext(ctx, storage.storage, directory)
+		return err
+	})
+	return entries, err
+}
+
+func (storage *retryStorage) CopyFile(source string, destination string) error {
+	return storage.CopyFileContext(context.Background(), source, destination)
+}
+
+func (storage *retryStorage) CopyFileContext(ctx context.Context, source string, destination string) error {
+	return storage.do(ctx, "copy", destination, func() error {
+		return storageabstraction.CopyFileContext(ctx, storage.storage, source, destination)
+	})
+}
+
+func (storage *retryStorage) MoveFile(source string, destination string) error {
+	return storage.MoveFileContext(context.Background(), source, destination)
+}
+
+func (storage *retryStorage) MoveFileContext(ctx context.Context, source string, destination string) error {
+	return storage.do(ctx, "move", destination, func() error {
+		return storageabstraction.MoveFileContext(ctx, storage.storage, source, destination)
+	})
+}
+
+func (storage *retryStorage) CopyDirectory(source string, destination string) error {
+	return storage.CopyDirectoryContext(context.Background(), source, destination)
+}
+
+func (storage *retryStorage) CopyDirectoryContext(ctx context.Context, source string, destination string) error {
+	return storage.do(ctx, "copyDirectory", destination, func() error {
+		return storageabstraction.CopyDirectoryContext(ctx, storage.storage, source, destination)
+	})
+}
+
+func (storage *retryStorage) MoveDirectory(source string, destination string) error {
+	return storage.MoveDirectoryContext(context.Background(), source, destination)
+}
+
+func (storage *retryStorage) MoveDirectoryContext(ctx context.Context, source string, destination string) error {
+	return storage.do(ctx, "moveDirectory", destination, func() error {
+		return storageabstraction.MoveDirectoryContext(ctx, storage.storage, source, destination)
+	})
+}
+
+func (storage *retryStorage) Join(paths ...string) string {
+	return storage.storage.Join(paths...)
+}
+
+func (storage *retryStorage) Snapshot(fileName string) (string, error) {
+	return storage.SnapshotContext(context.Background(), fileName)
+}
+
+func (storage *retryStorage) SnapshotContext(ctx context.Context, fileName string) (snapshot string, err error) {
+	err = storage.do(ctx, "snapshot", fileName, func() error {
+		snapshot, err = storageabstraction.SnapshotContext(ctx, storage.storage, fileName)
+		return err
+	})
+	return snapshot, err
+}
+
+func (storage *retryStorage) Versions(path string) ([]storageabstraction.FileVersion, error) {
+	return storage.VersionsContext(context.Background(), path)
+}
+
+func (storage *retryStorage) VersionsContext(ctx context.Context, path string) (versions []storageabstraction.FileVersion, err error) {
+	err = storage.do(ctx, "versions", path, func() error {
+		versions, err = storageabstraction.VersionsContext(ctx, storage.storage, path)
+		return err
+	})
+	return versions, err
+}
+
+func (storage *retryStorage) RestoreVersion(fileName string, versionID string) error {
+	return storage.RestoreVersionContext(context.Background(), fileName, versionID)
+}
+
+func (storage *retryStorage) RestoreVersionContext(ctx context.Context, fileName string, versionID string) error {
+	return storage.do(ctx, "restore", fileName, func() error {
+		return storageabstraction.RestoreVersionContext(ctx, storage.storage, fileName, versionID)
+	})
+}
+
+func (storage *retryStorage) Undelete(fileName string) error {
+	return storage.UndeleteContext(context.Background(), fileName)
+}
+
+func (storage *retryStorage) UndeleteContext(ctx context.Context, fileName string) error {
+	return storage.do(ctx, "undelete", fileName, func() error {
+		return storageabstraction.UndeleteContext(ctx, storage.storage, fileName)
+	})
+}
+
+func (storage *retryStorage) TryLock(fileName string) (storageabstraction.ILock, error) {
+	return storage.TryLockContext(context.Background(), fileName)
+}
+
+// TryLockContext retries the transient errors, a lock held by someone else is returned as ErrConflict right away
+func (storage *retryStorage) TryLockContext(ctx context.Context, fileName string) (lock storageabstraction.ILock, err error) {
+	err = storage.do(ctx, "lock", fileName, func() error {
+		lock, err = storageabstraction.TryLockContext(ctx, storage.storage, fileName)
+		return err
+	})
+	return lock, err
+}
+
+func (storage *retryStorage) Lock(fileName string) (storageabstraction.ILock, error) {
+	return storage.LockContext(context.Background(), fileName)
+}
+
+func (storage *retryStorage) LockContext(ctx context.Context, fileName string) (lock storageabstraction.ILock, err error) {
+	err = storage.do(ctx, "lock", fileName, func() error {
+		lock, err = storageabstraction.LockContext(ctx, storage.storage, fileName)
+		return err
+	})
+	return lock, err
+}
@@ -0,0 +1,611 @@
+package s3storage
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"github.com/2flow/gokies/storageabstraction"
+	"github.com/2flow/gokies/storageabstraction/common"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+const (
+	defaultConcurrency = 4
+	// defaultStreamPartSize is used by OpenWriter, otherwise the part size is derived from the maximal object size
+	defaultStreamPartSize = 16 * 1024 * 1024
+	// maxCopyObjectSize is the largest object which can be copied with a single request
+	maxCopyObjectSize = 5 * 1024 * 1024 * 1024
+)
+
+// storageClasses are the S3 storage classes of the access tiers, the default tier uses the default class of the bucket
+var storageClasses = map[storageabstraction.AccessTier]string{
+	storageabstraction.AccessTierHot:     "STANDARD",
+	storageabstraction.AccessTierCool:    "STANDARD_IA",
+	storageabstraction.AccessTierCold:    "GLACIER_IR",
+	storageabstraction.AccessTierArchive: "DEEP_ARCHIVE",
+}
+
+// tS3FileStorage stores the files as objects of a bucket of an S3 compatible object store (AWS S3, MinIO, ...)
+type tS3FileStorage struct {
+	client      *minio.Client
+	bucketName  string
+	partSize    uint64
+	concurrency uint
+	logger      log.Logger
+}
+
+// NewS3Storage creates a storage for the bucket of the configuration
+func NewS3Storage(config S3StorageConfig) (storageabstraction.IFileStorage, error) {
+	client, err := minio.New(config.Endpoint, &minio.Options{
+		Creds:     credentials.NewStaticV4(config.AccessKeyID, config.SecretAccessKey, config.SessionToken),
+		Secure:    config.UseSSL,
+		Region:    config.Region,
+		Transport: config.Transport,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	concurrency := config.Concurrency
+	if concurrency == 0 {
+		concurrency = defaultConcurrency
+	}
+
+	logger := config.Logger
+	if logger == nil {
+		logger = log.NewNopLogger()
+	}
+
+	return &tS3FileStorage{
+		client:      client,
+		bucketName:  config.Bucket,
+		partSize:    config.PartSize,
+		concurrency: concurrency,
+		logger:      logger,
+	}, nil
+}
+
+// objectName converts the file name to the object key, keys never start with a "/"
+func objectName(fileName string) string {
+	return strings.TrimPrefix(fileName, "/")
+}
+
+// convertError wraps the errors of the object store with the matching storageabstraction error
+func convertError(op string, fileName string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	response := minio.ToErrorResponse(err)
+	switch {
+	case response.Code == "NoSuchKey" || response.Code == "NoSuchBucket" || response.StatusCode == http.StatusNotFound:
+		return storageabstraction.NewPathError(op, fileName, storageabstraction.ErrNotExist, err)
+	case response.Code == "AccessDenied" || response.StatusCode == http.StatusForbidden:
+		return storageabstraction.NewPathError(op, fileName, storageabstraction.ErrPermission, err)
+	case response.Code == "BucketAlreadyExists" || response.Code == "BucketAlreadyOwnedByYou":
+		return storageabstraction.NewPathError(op, fileName, storageabstraction.ErrAlreadyExists, err)
+	case response.StatusCode == http.StatusPreconditionFailed:
+		return storageabstraction.NewPathError(op, fileName, storageabstraction.ErrPreconditionFailed, err)
+	case response.StatusCode == http.StatusConflict:
+		return storageabstraction.NewPathError(op, fileName, storageabstraction.ErrConflict, err)
+	case response.Code == "SlowDown" || response.StatusCode == http.StatusTooManyRequests || response.StatusCode >= http.StatusInternalServerError:
+		return storageabstraction.NewPathError(op, fileName, storageabstraction.ErrUnavailable, err)
+	}
+	return err
+}
+
+func (s3Storage *tS3FileStorage) Write(fileName string, fileSize int64, reader io.ReadSeeker) error {
+	return s3Storage.WriteContext(context.Background(), fileName, fileSize, reader)
+}
+
+func (s3Storage *tS3FileStorage) WriteContext(ctx context.Context, fileName string, fileSize int64, reader io.ReadSeeker) error {
+	return s3Storage.WriteWithOptionsContext(ctx, fileName, fileSize, reader, storageabstraction.WriteOptions{})
+}
+
+func (s3Storage *tS3FileStorage) WriteWithOptions(fileName string, fileSize int64, reader io.ReadSeeker, options storageabstraction.WriteOptions) error {
+	return s3Storage.WriteWithOptionsContext(context.Background(), fileName, fileSize, reader, options)
+}
+
+// WriteWithOptionsContext uploads the file, files larger than the part size are uploaded as multipart upload.
+// The size is taken from the reader, the fileSize is ignored like for the other storages
+func (s3Storage *tS3FileStorage) WriteWithOptionsContext(ctx context.Context, fileName string, _ int64, reader io.ReadSeeker, options storageabstraction.WriteOptions) (err error) {
+	size := int64(-1)
+	defer func(start time.Time) {
+		common.LogOperation(s3Storage.logger, "write", fileName, size, start, err)
+	}(time.Now())
+
+	if err = common.ValidatePath(fileName); err != nil {
+		return err
+	}
+
+	size, err = common.ReaderSize(reader)
+	if err != nil {
+		return err
+	}
+	if options.ContentType == "" {
+		if options.ContentType, err = storageabstraction.DetectContentType(fileName, reader); err != nil {
+			return err
+		}
+	}
+
+	putOptions := putObjectOptions(options)
+	putOptions.PartSize = s3Storage.partSize
+	putOptions.NumThreads = s3Storage.concurrency
+
+	_, err = s3Storage.client.PutObject(ctx, s3Storage.bucketName, objectName(fileName), reader, size, putOptions)
+	return convertError("write", fileName, err)
+}
+
+func (s3Storage *tS3FileStorage) OpenWriter(fileName string) (io.WriteCloser, error) {
+	return s3Storage.OpenWriterContext(context.Background(), fileName)
+}
+
+func (s3Storage *tS3FileStorage) OpenWriterContext(ctx context.Context, fileName string) (io.WriteCloser, error) {
+	return s3Storage.OpenWriterWithOptionsContext(ctx, fileName, storageabstraction.WriteOptions{})
+}
+
+func (s3Storage *tS3FileStorage) OpenWriterWithOptions(fileName string, options storageabstraction.WriteOptions) (io.WriteCloser, error) {
+	return s3Storage.OpenWriterWithOptionsContext(context.Background(), fileName, options)
+}
+
+// OpenWriterWithOptionsContext streams the content as multipart upload, which is completed on Close.
+// Without content type in the options, it is detected from the file extension
+func (s3Storage *tS3FileStorage) OpenWriterWithOptionsContext(ctx context.Context, fileName string, options storageabstraction.WriteOptions) (io.WriteCloser, error) {
+	if err := common.ValidatePath(fileName); err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	partSize := s3Storage.partSize
+	if partSize == 0 {
+		partSize = defaultStreamPartSize
+	}
+
+	pipeReader, pipeWriter := io.Pipe()
+	writer := &s3ObjectWriter{ctx: ctx, fileName: fileName, pipeWriter: pipeWriter, done: make(chan error, 1)}
+
+	if options.ContentType == "" {
+		options.ContentType = storageabstraction.ContentTypeByName(fileName)
+	}
+	putOptions := putObjectOptions(options)
+	putOptions.PartSize = partSize
+
+	go func() {
+		_, err := s3Storage.client.PutObject(ctx, s3Storage.bucketName, objectName(fileName), pipeReader, -1, putOptions)
+		// unblocks the writer if the upload failed
+		_ = pipeReader.CloseWithError(err)
+		writer.done <- err
+	}()
+
+	return common.LogWriteCloser(s3Storage.logger, "write", fileName, writer), nil
+}
+
+// putObjectOptions converts the options, the access tiers are mapped to the S3 storage classes
+func putObjectOptions(options storageabstraction.WriteOptions) minio.PutObjectOptions {
+	putOptions := minio.PutObjectOptions{
+		ContentType:        options.ContentType,
+		ContentEncoding:    options.ContentEncoding,
+		ContentDisposition: options.ContentDisposition,
+		CacheControl:       options.CacheControl,
+		UserMetadata:       options.Metadata,
+		UserTags:           options.Tags,
+		StorageClass:       storageClasses[options.AccessTier],
+	}
+
+	// the ETags of Stat are quoted, minio quotes them again. The object store checks the conditions
+	// of single part uploads, multipart uploads only if it supports conditions for them
+	if options.Conditions.IfMatch != "" {
+		putOptions.SetMatchETag(strings.Trim(options.Conditions.IfMatch, "\""))
+	}
+	if options.Conditions.IfNoneMatch != "" {
+		putOptions.SetMatchETagExcept(strings.Trim(options.Conditions.IfNoneMatch, "\""))
+	}
+	return putOptions
+}
+
+type s3ObjectWriter struct {
+	ctx        context.Context
+	fileName   string
+	pipeWriter *io.PipeWriter
+	done       chan error
+}
+
+func (writer *s3ObjectWriter) Write(p []byte) (n int, err error) {
+	return writer.pipeWriter.Write(p)
+}
+
+func (writer *s3ObjectWriter) Close() error {
+	if err := writer.ctx.Err(); err != nil {
+		// the upload fails with the error and is not completed
+		_ = writer.pipeWriter.CloseWithError(err)
+		<-writer.done
+		return err
+	}
+
+	_ = writer.pipeWriter.Close()
+	return convertError("write", writer.fileName, <-writer.done)
+}
+
+func (s3Storage *tS3FileStorage) Read(fileName string) (io.ReadCloser, error) {
+	return s3Storage.ReadContext(context.Background(), fileName)
+}
+
+func (s3Storage *tS3FileStorage) ReadContext(ctx context.Context, fileName string) (io.ReadCloser, error) {
+	if err := common.ValidatePath(fileName); err != nil {
+		return nil, err
+	}
+	return s3Storage.getObject(ctx, "read", fileName, minio.GetObjectOptions{})
+}
+
+// getObject downloads the object with a single request, so a missing file is reported immediately
+func (s3Storage *tS3FileStorage) getObject(ctx context.Context, operation string, fileName string, options minio.GetObjectOptions) (io.ReadCloser, error) {
+	core := minio.Core{Client: s3Storage.client}
+
+	reader, _, _, err := core.GetObject(ctx, s3Storage.bucketName, objectName(fileName), options)
+	if err != nil {
+		err = convertError("read", fileName, err)
+		common.LogOperation(s3Storage.logger, operation, fileName, -1, time.Now(), err)
+		return nil, err
+	}
+
+	return common.LogReadCloser(s3Storage.logger, operation, fileName, reader), nil
+}
+
+func (s3Storage *tS3FileStorage) ReadRange(fileName string, offset int64, length int64) (io.ReadCloser, error) {
+	return s3Storage.ReadRangeContext(context.Background(), fileName, offset, length)
+}
+
+func (s3Storage *tS3FileStorage) ReadRangeContext(ctx context.Context, fileName string, offset int64, length int64) (io.ReadCloser, error) {
+	if err := common.ValidatePath(fileName); err != nil {
+		return nil, err
+	}
+	options := minio.GetObjectOptions{}
+	var err error
+	if length != storageabstraction.CountToEnd {
+		err = options.SetRange(offset, offset+length-1)
+	} else if offset > 0 {
+		err = options.SetRange(offset, 0)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return s3Storage.getObject(ctx, "readRange", fileName, options)
+}
+
+func (s3Storage *tS3FileStorage) FileSize(fileName string) (int64, error) {
+	return s3Storage.FileSizeContext(context.Background(), fileName)
+}
+
+func (s3Storage *tS3FileStorage) FileSizeContext(ctx context.Context, fileName string) (int64, error) {
+	if err := common.ValidatePath(fileName); err != nil {
+		return 0, err
+	}
+	info, err := s3Storage.client.StatObject(ctx, s3Storage.bucketName, objectName(fileName), minio.StatObjectOptions{})
+	if err != nil {
+		return 0, convertError("stat", fileName, err)
+	}
+
+	return info.Size, nil
+}
+
+func (s3Storage *tS3FileStorage) Stat(fileName string) (*storageabstraction.FileInfo, error) {
+	return s3Storage.StatContext(context.Background(), fileName)
+}
+
+// StatContext returns the properties of the object. If there is no object with this name,
+// but objects with the name as prefix, it is reported as directory
+func (s3Storage *tS3FileStorage) StatContext(ctx context.Context, fileName string) (*storageabstraction.FileInfo, error) {
+	if err := common.ValidatePath(fileName); err != nil {
+		return nil, err
+	}
+	info, err := s3Storage.client.StatObject(ctx, s3Storage.bucketName, objectName(fileName), minio.StatObjectOptions{})
+	if err == nil {
+		return storageabstraction.NewFileInfoFromDetails(storageabstraction.FileDetails{
+			Name:            path.Base(info.Key),
+			Size:            info.Size,
+			ModTime:         info.LastModified,
+			ContentType:     info.ContentType,
+			ContentEncoding: info.Metadata.Get("Content-Encoding"),
+			ETag:            "\"" + info.ETag + "\"",
+			ContentMD5:      etagMD5(info.ETag),
+		}), nil
+	}
+
+	err = convertError("stat", fileName, err)
+	if !errors.Is(err, storageabstraction.ErrNotExist) {
+		return nil, err
+	}
+
+	// virtual directory
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	objects := s3Storage.client.ListObjects(ctx, s3Storage.bucketName, minio.ListObjectsOptions{
+		Prefix:    strings.TrimSuffix(objectName(fileName), "/") + "/",
+		Recursive: true,
+		MaxKeys:   1,
+	})
+	for object := range objects {
+		if object.Err != nil {
+			return nil, convertError("stat", fileName, object.Err)
+		}
+		return storageabstraction.NewFileInfoFromDetails(storageabstraction.FileDetails{
+			Name:  path.Base(fileName),
+			IsDir: true,
+		}), nil
+	}
+
+	return nil, err
+}
+
+// etagMD5 returns the MD5 hash of the content if the ETag is one, which is not the case for multipart uploads
+func etagMD5(etag string) []byte {
+	if len(etag) != hex.EncodedLen(md5.Size) {
+		return nil
+	}
+
+	contentMD5, err := hex.DecodeString(etag)
+	if err != nil {
+		return nil
+	}
+	return contentMD5
+}
+
+func (s3Storage *tS3FileStorage) DeleteDirectory(directory string) error {
+	return s3Storage.DeleteDirectoryContext(context.Background(), directory)
+}
+
+// DeleteDirectoryContext deletes all objects with the directory as prefix, using batched delete requests
+func (s3Storage *tS3FileStorage) DeleteDirectoryContext(ctx context.Context, directory string) (err error) {
+	defer func(start time.Time) {
+		common.LogOperation(s3Storage.logger, "deleteDirectory", directory, -1, start, err)
+	}(time.Now())
+
+	if err = common.ValidatePath(directory); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	objects := s3Storage.client.ListObjects(ctx, s3Storage.bucketName, minio.ListObjectsOptions{
+		Prefix:    directoryPrefix(directory),
+		Recursive: true,
+	})
+
+	for removeErr := range s3Storage.client.RemoveObjects(ctx, s3Storage.bucketName, objects, minio.RemoveObjectsOptions{}) {
+		return convertError("remove", removeErr.ObjectName, removeErr.Err)
+	}
+
+	return ctx.Err()
+}
+
+func (s3Storage *tS3FileStorage) DeleteFile(fileName string) error {
+	return s3Storage.DeleteFileContext(context.Background(), fileName)
+}
+
+func (s3Storage *tS3FileStorage) DeleteFileContext(ctx context.Context, fileName string) error {
+	if err := common.ValidatePath(fileName); err != nil {
+		return err
+	}
+	start := time.Now()
+	err := s3Storage.client.RemoveObject(ctx, s3Storage.bucketName, objectName(fileName), minio.RemoveObjectOptions{})
+	err = convertError("remove", fileName, err)
+	common.LogOperation(s3Storage.logger, "delete", fileName, -1, start, err)
+	return err
+}
+
+func (s3Storage *tS3FileStorage) Walk(directory string, walk storageabstraction.WalkFunc) error {
+	return s3Storage.WalkContext(context.Background(), directory, walk)
+}
+
+// WalkContext calls walk for every object with the directory as prefix, the path is the key without the prefix.
+// Like for the azure storage there are no directory entries.
+func (s3Storage *tS3FileStorage) WalkContext(ctx context.Context, directory string, walk storageabstraction.WalkFunc) error {
+	if err := common.ValidatePath(directory); err != nil {
+		_ = walk("", nil, err)
+		return err
+	}
+	// stops the listing if the walk is aborted
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	prefix := directoryPrefix(directory)
+	objects := s3Storage.client.ListObjects(ctx, s3Storage.bucketName, minio.ListObjectsOptions{
+		Prefix:    prefix,
+		Recursive: true,
+	})
+
+	for object := range objects {
+		if object.Err != nil {
+			err := convertError("walk", directory, object.Err)
+			_ = level.Error(s3Storage.logger).Log("msg", "Unable to list objects", "op", "walk", "path", directory, "err", err)
+			_ = walk("", &S3FileInfo{}, err)
+			return err
+		}
+
+		fileInfo := S3FileInfo{objectInfo: object}
+		if err := walk(strings.TrimPrefix(object.Key, prefix), &fileInfo, nil); err != nil {
+			return err
+		}
+	}
+
+	return ctx.Err()
+}
+
+func (s3Storage *tS3FileStorage) CopyFile(source string, destination string) error {
+	return s3Storage.CopyFileContext(context.Background(), source, destination)
+}
+
+// CopyFileContext copies the object on the server, objects larger than 5 GiB are copied with a multipart copy
+func (s3Storage *tS3FileStorage) CopyFileContext(ctx context.Context, source string, destination string) (err error) {
+	defer func(start time.Time) {
+		common.LogOperation(s3Storage.logger, "copy", destination, -1, start, err, "source", source)
+	}(time.Now())
+
+	if err = common.ValidatePath(source); err != nil {
+		return err
+	}
+	if err = common.ValidatePath(destination); err != nil {
+		return err
+	}
+
+	if objectName(source) == objectName(destination) {
+		return nil
+	}
+
+	info, err := s3Storage.client.StatObject(ctx, s3Storage.bucketName, objectName(source), minio.StatObjectOptions{})
+	if err != nil {
+		return convertError("copy", source, err)
+	}
+
+	destinationOptions := minio.CopyDestOptions{Bucket: s3Storage.bucketName, Object: objectName(destination)}
+	sourceOptions := minio.CopySrcOptions{Bucket: s3Storage.bucketName, Object: objectName(source), MatchETag: info.ETag}
+	if info.Size > maxCopyObjectSize {
+		_, err = s3Storage.client.ComposeObject(ctx, destinationOptions, sourceOptions)
+	} else {
+		_, err = s3Storage.client.CopyObject(ctx, destinationOptions, sourceOptions)
+	}
+	return convertError("copy", source, err)
+}
+
+func (s3Storage *tS3FileStorage) MoveFile(source string, destination string) error {
+	return s3Storage.MoveFileContext(context.Background(), source, destination)
+}
+
+// MoveFileContext copies the object on the server and deletes the source object
+func (s3Storage *tS3FileStorage) MoveFileContext(ctx context.Context, source string, destination string) (err error) {
+	defer func(start time.Time) {
+		common.LogOperation(s3Storage.logger, "move", destination, -1, start, err, "source", source)
+	}(time.Now())
+
+	if err = common.ValidatePath(source); err != nil {
+		return err
+	}
+	if err = common.ValidatePath(destination); err != nil {
+		return err
+	}
+
+	if objectName(source) == objectName(destination) {
+		return nil
+	}
+
+	if err := s3Storage.CopyFileContext(ctx, source, destination); err != nil {
+		return err
+	}
+	return s3Storage.DeleteFileContext(ctx, source)
+}
+
+func (s3Storage *tS3FileStorage) CopyDirectory(source string, destination string) error {
+	return s3Storage.CopyDirectoryContext(context.Background(), source, destination)
+}
+
+func (s3Storage *tS3FileStorage) CopyDirectoryContext(ctx context.Context, source string, destination string) (err error) {
+	defer func(start time.Time) {
+		common.LogOperation(s3Storage.logger, "copyDirectory", destination, -1, start, err, "source", source)
+	}(time.Now())
+
+	if err = common.ValidatePath(source); err != nil {
+		return err
+	}
+	if err = common.ValidatePath(destination); err != nil {
+		return err
+	}
+
+	_, err = s3Storage.copyDirectory(ctx, source, destination)
+	return err
+}
+
+func (s3Storage *tS3FileStorage) MoveDirectory(source string, destination string) error {
+	return s3Storage.MoveDirectoryContext(context.Background(), source, destination)
+}
+
+// MoveDirectoryContext copies all objects of the directory and deletes the copied objects afterwards
+func (s3Storage *tS3FileStorage) MoveDirectoryContext(ctx context.Context, source string, destination string) (err error) {
+	defer func(start time.Time) {
+		common.LogOperation(s3Storage.logger, "moveDirectory", destination, -1, start, err, "source", source)
+	}(time.Now())
+
+	if err = common.ValidatePath(source); err != nil {
+		return err
+	}
+	if err = common.ValidatePath(destination); err != nil {
+		return err
+	}
+
+	copied, err := s3Storage.copyDirectory(ctx, source, destination)
+	if err != nil {
+		return err
+	}
+
+	objects := make(chan minio.ObjectInfo, len(copied))
+	for _, key := range copied {
+		objects <- minio.ObjectInfo{Key: key}
+	}
+	close(objects)
+
+	for removeErr := range s3Storage.client.RemoveObjects(ctx, s3Storage.bucketName, objects, minio.RemoveObjectsOptions{}) {
+		return convertError("remove", removeErr.ObjectName, removeErr.Err)
+	}
+	return ctx.Err()
+}
+
+// copyDirectory copies all objects of the source directory into the destination directory,
+// the keys of the copied objects are returned
+func (s3Storage *tS3FileStorage) copyDirectory(ctx context.Context, source string, destination string) ([]string, error) {
+	sourcePrefix := directoryPrefix(source)
+	destinationPrefix := directoryPrefix(destination)
+	if sourcePrefix == destinationPrefix {
+		return nil, nil
+	}
+
+	// the keys are collected first, so the copies do not show up in the listing
+	var keys []string
+	for object := range s3Storage.client.ListObjects(ctx, s3Storage.bucketName, minio.ListObjectsOptions{
+		Prefix:    sourcePrefix,
+		Recursive: true,
+	}) {
+		if object.Err != nil {
+			return nil, convertError("copy", source, object.Err)
+		}
+		keys = append(keys, object.Key)
+	}
+	if len(keys) == 0 {
+		return nil, storageabstraction.NewPathError("copy", source, storageabstraction.ErrNotExist, nil)
+	}
+
+	for _, key := range keys {
+		err := s3Storage.CopyFileContext(ctx, key, destinationPrefix+strings.TrimPrefix(key, sourcePrefix))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return keys, nil
+}
+
+// directoryPrefix returns the key prefix of all objects in the directory
+func directoryPrefix(directory string) string {
+	prefix := strings.TrimSuffix(objectName(directory), "/")
+	if prefix == "" {
+		return ""
+	}
+	return prefix + "/"
+}
+
+func (s3Storage *tS3FileStorage) Join(paths ...string) string {
+	return common.LinuxPathJoin(paths...)
+}
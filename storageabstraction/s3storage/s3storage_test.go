@@ -0,0 +1,454 @@
+package s3storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"github.com/2flow/gokies/storageabstraction"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testBucket = "test-bucket"
+)
+
+// fakeS3Server is a minimal in memory stand-in for an S3 compatible object store,
+// it supports just the requests used by the storage and does not verify signatures
+type fakeS3Server struct {
+	objects map[string][]byte
+	uploads map[string]map[int][]byte
+	// headers are the request headers of the last upload of the objects
+	headers map[string]http.Header
+	lock    sync.Mutex
+}
+
+func newTestStorage(t *testing.T, partSize uint64) (storageabstraction.IFileStorage, *fakeS3Server) {
+	fake := &fakeS3Server{objects: map[string][]byte{}, uploads: map[string]map[int][]byte{}, headers: map[string]http.Header{}}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	storage, err := NewS3Storage(S3StorageConfig{
+		Endpoint:        strings.TrimPrefix(server.URL, "http://"),
+		Region:          "us-east-1",
+		Bucket:          testBucket,
+		AccessKeyID:     "access",
+		SecretAccessKey: "secret",
+		PartSize:        partSize,
+	})
+	if err != nil {
+		t.Fatalf("[TestError] Unable to create storage: %v", err)
+	}
+
+	return storage, fake
+}
+
+func (fake *fakeS3Server) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	fake.lock.Lock()
+	defer fake.lock.Unlock()
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(request.URL.Path, "/"), "/")
+	if bucket != testBucket {
+		writeError(writer, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+	query := request.URL.Query()
+
+	switch {
+	case key == "" && request.Method == http.MethodGet:
+		fake.listObjects(writer, query.Get("prefix"))
+	case key == "" && request.Method == http.MethodPost && query.Has("delete"):
+		fake.deleteObjects(writer, request)
+	case request.Method == http.MethodPost && query.Has("uploads"):
+		uploadID := strconv.Itoa(len(fake.uploads) + 1)
+		fake.uploads[uploadID] = map[int][]byte{}
+		fake.headers[key] = request.Header.Clone()
+		writeXML(writer, struct {
+			XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+			Bucket   string
+			Key      string
+			UploadId string
+		}{Bucket: bucket, Key: key, UploadId: uploadID})
+	case request.Method == http.MethodPut && query.Has("uploadId"):
+		partNumber, _ := strconv.Atoi(query.Get("partNumber"))
+		fake.uploads[query.Get("uploadId")][partNumber] = readBody(request)
+		writer.Header().Set("ETag", fmt.Sprintf("\"part%d\"", partNumber))
+	case request.Method == http.MethodPost && query.Has("uploadId"):
+		parts := fake.uploads[query.Get("uploadId")]
+		var partNumbers []int
+		for partNumber := range parts {
+			partNumbers = append(partNumbers, partNumber)
+		}
+		sort.Ints(partNumbers)
+
+		content := bytes.Buffer{}
+		for _, partNumber := range partNumbers {
+			content.Write(parts[partNumber])
+		}
+		fake.objects[key] = content.Bytes()
+		writeXML(writer, struct {
+			XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+			Bucket  string
+			Key     string
+			ETag    string
+		}{Bucket: bucket, Key: key, ETag: "\"multipart\""})
+	case request.Method == http.MethodPut && request.Header.Get("X-Amz-Copy-Source") != "":
+		copySource, _ := url.PathUnescape(request.Header.Get("X-Amz-Copy-Source"))
+		content, ok := fake.objects[strings.TrimPrefix(strings.TrimPrefix(copySource, "/"), testBucket+"/")]
+		if !ok {
+			writeError(writer, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		fake.objects[key] = content
+		writeXML(writer, struct {
+			XMLName      xml.Name `xml:"CopyObjectResult"`
+			ETag         string
+			LastModified string
+		}{ETag: "\"etag\"", LastModified: time.Now().UTC().Format(time.RFC3339)})
+	case request.Method == http.MethodPut:
+		fake.objects[key] = readBody(request)
+		fake.headers[key] = request.Header.Clone()
+		writer.Header().Set("ETag", "\"etag\"")
+	case request.Method == http.MethodGet || request.Method == http.MethodHead:
+		content, ok := fake.objects[key]
+		if !ok {
+			writeError(writer, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		statusCode := http.StatusOK
+		if byteRange := request.Header.Get("Range"); byteRange != "" {
+			startText, endText, _ := strings.Cut(strings.TrimPrefix(byteRange, "bytes="), "-")
+			start, _ := strconv.Atoi(startText)
+			end, err := strconv.Atoi(endText)
+			if err != nil || end >= len(content) {
+				end = len(content) - 1
+			}
+			writer.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(content)))
+			content = content[start : end+1]
+			statusCode = http.StatusPartialContent
+		}
+		writer.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		writer.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+		writer.Header().Set("ETag", "\"etag\"")
+		writer.WriteHeader(statusCode)
+		if request.Method == http.MethodGet {
+			_, _ = writer.Write(content)
+		}
+	case request.Method == http.MethodDelete:
+		delete(fake.objects, key)
+		writer.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(writer, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+func (fake *fakeS3Server) listObjects(writer http.ResponseWriter, prefix string) {
+	type content struct {
+		Key          string
+		Size         int
+		LastModified string
+		ETag         string
+	}
+	result := struct {
+		XMLName     xml.Name `xml:"ListBucketResult"`
+		Name        string
+		Prefix      string
+		KeyCount    int
+		IsTruncated bool
+		Contents    []content
+	}{Name: testBucket, Prefix: prefix}
+
+	for key, data := range fake.objects {
+		if strings.HasPrefix(key, prefix) {
+			result.Contents = append(result.Contents, content{Key: key, Size: len(data),
+				LastModified: time.Now().UTC().Format(time.RFC3339), ETag: "\"etag\""})
+		}
+	}
+	sort.Slice(result.Contents, func(i, j int) bool { return result.Contents[i].Key < result.Contents[j].Key })
+	result.KeyCount = len(result.Contents)
+
+	writeXML(writer, result)
+}
+
+func (fake *fakeS3Server) deleteObjects(writer http.ResponseWriter, request *http.Request) {
+	deleteRequest := struct {
+		Objects []struct{ Key string } `xml:"Object"`
+	}{}
+	if err := xml.Unmarshal(readBody(request), &deleteRequest); err != nil {
+		writeError(writer, http.StatusBadRequest, "MalformedXML")
+		return
+	}
+
+	type deleted struct{ Key string }
+	result := struct {
+		XMLName xml.Name  `xml:"DeleteResult"`
+		Deleted []deleted `xml:"Deleted"`
+	}{}
+	for _, object := range deleteRequest.Objects {
+		delete(fake.objects, object.Key)
+		result.Deleted = append(result.Deleted, deleted{Key: object.Key})
+	}
+
+	writeXML(writer, result)
+}
+
+// readBody returns the request body, aws-chunked bodies of streaming uploads are decoded
+func readBody(request *http.Request) []byte {
+	if !strings.HasPrefix(request.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+		body, _ := io.ReadAll(request.Body)
+		return body
+	}
+
+	content := bytes.Buffer{}
+	reader := bufio.NewReader(request.Body)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return content.Bytes()
+		}
+		sizeText, _, _ := strings.Cut(strings.TrimSpace(line), ";")
+		size, err := strconv.ParseInt(sizeText, 16, 64)
+		if err != nil || size == 0 {
+			return content.Bytes()
+		}
+		_, _ = io.CopyN(&content, reader, size)
+		_, _ = reader.ReadString('\n')
+	}
+}
+
+func writeXML(writer http.ResponseWriter, value any) {
+	writer.Header().Set("Content-Type", "application/xml")
+	_ = xml.NewEncoder(writer).Encode(value)
+}
+
+func writeError(writer http.ResponseWriter, statusCode int, code string) {
+	writer.Header().Set("Content-Type", "application/xml")
+	writer.WriteHeader(statusCode)
+	_ = xml.NewEncoder(writer).Encode(struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string
+		Message string
+	}{Code: code, Message: code})
+}
+
+func TestS3StorageReadWrite(t *testing.T) {
+	storage, _ := newTestStorage(t, 0)
+
+	testText := "Some test Text"
+	if err := storage.Write("/dir/test.txt", int64(len(testText)), strings.NewReader(testText)); err != nil {
+		t.Errorf("Error writing test file: %v", err)
+		return
+	}
+
+	size, err := storage.FileSize("dir/test.txt")
+	if err != nil || size != int64(len(testText)) {
+		t.Errorf("Unexpected file size %d: %v", size, err)
+	}
+
+	reader, err := storage.Read("dir/test.txt")
+	if err != nil {
+		t.Errorf("Error reading test file: %v", err)
+		return
+	}
+	defer reader.Close()
+
+	content, err := io.ReadAll(reader)
+	if err != nil || string(content) != testText {
+		t.Errorf("Values are not equal, expected: %v, actual: %v (%v)", testText, string(content), err)
+	}
+
+	if _, err = storage.Read("dir/missing.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected not exist error, actual: %v", err)
+	}
+}
+
+func TestS3StorageWriteOptions(t *testing.T) {
+	storage, fake := newTestStorage(t, 0)
+
+	options := storageabstraction.WriteOptions{
+		CacheControl: "max-age=3600",
+		Metadata:     map[string]string{"Author": "test"},
+		Tags:         map[string]string{"project": "gokies"},
+		AccessTier:   storageabstraction.AccessTierCool,
+	}
+	if err := storageabstraction.WriteWithOptions(storage, "site/app.css", 4, strings.NewReader("body"), options); err != nil {
+		t.Errorf("Error writing test file: %v", err)
+		return
+	}
+	if err := storage.Write("site/page", 15, strings.NewReader("<html></html>\n\n")); err != nil {
+		t.Errorf("Error writing test file: %v", err)
+		return
+	}
+	if err := storageabstraction.WriteFrom(storage, "site/app.js", strings.NewReader("code")); err != nil {
+		t.Errorf("Error writing test file: %v", err)
+		return
+	}
+	conditions := storageabstraction.WriteOptions{Conditions: storageabstraction.Conditions{IfMatch: "\"abc\"", IfNoneMatch: storageabstraction.ETagAny}}
+	if err := storageabstraction.WriteWithOptions(storage, "site/new.txt", 3, strings.NewReader("new"), conditions); err != nil {
+		t.Errorf("Error writing test file: %v", err)
+		return
+	}
+
+	expected := map[string]map[string]string{
+		"site/app.css": {"Content-Type": storageabstraction.ContentTypeByName("app.css"), "Cache-Control": "max-age=3600",
+			"X-Amz-Meta-Author": "test", "X-Amz-Tagging": "project=gokies", "X-Amz-Storage-Class": "STANDARD_IA"},
+		"site/page":    {"Content-Type": "text/html; charset=utf-8", "Cache-Control": "", "X-Amz-Storage-Class": ""},
+		"site/app.js":  {"Content-Type": storageabstraction.ContentTypeByName("app.js")},
+		"site/new.txt": {"If-Match": "\"abc\"", "If-None-Match": "*"},
+	}
+	for key, headers := range expected {
+		for name, value := range headers {
+			if actual := fake.headers[key].Get(name); actual != value {
+				t.Errorf("Unexpected header %s of %s, expected: %q, actual: %q", name, key, value, actual)
+			}
+		}
+	}
+}
+
+func TestS3StorageMultipartWrite(t *testing.T) {
+	const partSize = 5 * 1024 * 1024
+	storage, fake := newTestStorage(t, partSize)
+
+	content := bytes.Repeat([]byte("0123456789"), partSize/5)
+	if err := storage.Write("large.bin", int64(len(content)), bytes.NewReader(content)); err != nil {
+		t.Errorf("Error writing large file: %v", err)
+		return
+	}
+
+	if len(fake.uploads) != 1 {
+		t.Errorf("Expected a multipart upload, actual uploads: %d", len(fake.uploads))
+	}
+	if !bytes.Equal(fake.objects["large.bin"], content) {
+		t.Errorf("Uploaded content differs, length %d, expected %d", len(fake.objects["large.bin"]), len(content))
+	}
+}
+
+func TestS3StorageWalkAndDelete(t *testing.T) {
+	storage, fake := newTestStorage(t, 0)
+	// the objects with the directory name as prefix are no entries of the directory
+	for _, fileName := range []string{"compressDir/test.txt", "compressDir/subDir/test3.txt", "other/test4.txt",
+		"compressDir2/test.txt", "compressDir.txt"} {
+		if err := storage.Write(fileName, int64(len(fileName)), strings.NewReader(fileName)); err != nil {
+			t.Errorf("[TestError] Error writing %s: %v", fileName, err)
+			return
+		}
+	}
+
+	var walked []string
+	err := storage.Walk("compressDir/", func(path string, info os.FileInfo, err error) error {
+		walked = append(walked, path)
+		return err
+	})
+	if err != nil {
+		t.Errorf("Error walking directory: %v", err)
+	}
+	if strings.Join(walked, ",") != "subDir/test3.txt,test.txt" {
+		t.Errorf("Walk failed, actual: %v", walked)
+	}
+	walked = nil
+	_ = storage.Walk("compressDir", func(path string, info os.FileInfo, err error) error {
+		walked = append(walked, path)
+		return err
+	})
+	if strings.Join(walked, ",") != "subDir/test3.txt,test.txt" {
+		t.Errorf("Walk without trailing slash failed, actual: %v", walked)
+	}
+
+	if err = storage.DeleteDirectory("compressDir"); err != nil {
+		t.Errorf("Error deleting directory: %v", err)
+	}
+	if len(fake.objects) != 3 || fake.objects["compressDir2/test.txt"] == nil || fake.objects["compressDir.txt"] == nil {
+		t.Errorf("Expected other/test4.txt and the objects with the same prefix to remain, actual: %v", len(fake.objects))
+	}
+}
+
+func TestS3StorageOpenWriter(t *testing.T) {
+	storage, fake := newTestStorage(t, 0)
+
+	writer, err := storageabstraction.OpenWriter(storage, "stream/test.txt")
+	if err != nil {
+		t.Errorf("Error opening writer: %v", err)
+		return
+	}
+	_, _ = writer.Write([]byte("streamed "))
+	_, _ = writer.Write([]byte("content"))
+	if err = writer.Close(); err != nil {
+		t.Errorf("Error closing writer: %v", err)
+		return
+	}
+
+	if string(fake.objects["stream/test.txt"]) != "streamed content" {
+		t.Errorf("Unexpected content %q", fake.objects["stream/test.txt"])
+	}
+}
+
+func TestS3StorageReadRange(t *testing.T) {
+	storage, _ := newTestStorage(t, 0)
+	if err := storage.Write("range.txt", 10, strings.NewReader("0123456789")); err != nil {
+		t.Errorf("[TestError] Error writing test file: %v", err)
+		return
+	}
+
+	for _, testCase := range []struct {
+		offset   int64
+		length   int64
+		expected string
+	}{{0, 1, "0"}, {2, 4, "2345"}, {7, storageabstraction.CountToEnd, "789"}} {
+		reader, err := storageabstraction.ReadRange(storage, "range.txt", testCase.offset, testCase.length)
+		if err != nil {
+			t.Errorf("Error reading range %d+%d: %v", testCase.offset, testCase.length, err)
+			continue
+		}
+		content, _ := io.ReadAll(reader)
+		_ = reader.Close()
+
+		if string(content) != testCase.expected {
+			t.Errorf("Unexpected range %d+%d: %q", testCase.offset, testCase.length, content)
+		}
+	}
+}
+
+func TestS3StorageCopyAndMove(t *testing.T) {
+	storage, fake := newTestStorage(t, 0)
+	for _, fileName := range []string{"dir/test.txt", "dir/subDir/test3.txt", "dir2/test4.txt"} {
+		if err := storage.Write(fileName, int64(len(fileName)), strings.NewReader(fileName)); err != nil {
+			t.Errorf("[TestError] Error writing %s: %v", fileName, err)
+			return
+		}
+	}
+
+	if err := storageabstraction.CopyFile(storage, "dir/test.txt", "copy.txt"); err != nil {
+		t.Errorf("Error copying file: %v", err)
+	}
+	if err := storageabstraction.MoveDirectory(storage, "dir", "moved"); err != nil {
+		t.Errorf("Error moving directory: %v", err)
+	}
+
+	var keys []string
+	for key := range fake.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	if strings.Join(keys, ",") != "copy.txt,dir2/test4.txt,moved/subDir/test3.txt,moved/test.txt" {
+		t.Errorf("Unexpected objects after copy and move: %v", keys)
+	}
+	if string(fake.objects["moved/subDir/test3.txt"]) != "dir/subDir/test3.txt" {
+		t.Errorf("Unexpected content of moved file: %q", fake.objects["moved/subDir/test3.txt"])
+	}
+
+	if err := storageabstraction.MoveFile(storage, "missing.txt", "other.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected not exist error, actual: %v", err)
+	}
+}
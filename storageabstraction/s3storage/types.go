@@ -0,0 +1,62 @@
+package s3storage
+
+import (
+	"io/fs"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/minio/minio-go/v7"
+)
+
+// S3StorageConfig configures the connection to an S3 compatible object store
+type S3StorageConfig struct {
+	// Endpoint is the host (and port) of the service, e.g. "s3.amazonaws.com" or "localhost:9000" for MinIO
+	Endpoint        string
+	UseSSL          bool
+	Region          string
+	Bucket          string
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+
+	// PartSize is the size of the parts of a multipart upload, larger files are uploaded in multiple parts.
+	// 0 uses the default of 16 MiB, the minimum is 5 MiB
+	PartSize uint64
+	// Concurrency is the number of parts uploaded in parallel, 0 uses the default of 4
+	Concurrency uint
+	// Transport is used for all requests, nil uses the default transport
+	Transport http.RoundTripper
+	// Logger logs the operations of the storage, nil logs nothing
+	Logger log.Logger
+}
+
+type S3FileInfo struct {
+	fs.FileInfo
+	objectInfo minio.ObjectInfo
+}
+
+func (fileInfo *S3FileInfo) Name() string {
+	return path.Base(fileInfo.objectInfo.Key)
+}
+
+func (fileInfo *S3FileInfo) Size() int64 {
+	return fileInfo.objectInfo.Size
+}
+
+func (fileInfo *S3FileInfo) Mode() fs.FileMode {
+	return 0644
+}
+
+func (fileInfo *S3FileInfo) ModTime() time.Time {
+	return fileInfo.objectInfo.LastModified
+}
+
+func (fileInfo *S3FileInfo) IsDir() bool {
+	return false
+}
+
+func (fileInfo *S3FileInfo) Sys() any {
+	return fileInfo.objectInfo
+}
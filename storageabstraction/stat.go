@@ -0,0 +1,61 @@
+package storageabstraction
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"mime"
+	"path"
+)
+
+// IStatFileStorage is implemented by storages which provide the details of a file or directory
+type IStatFileStorage interface {
+	Stat(fileName string) (*FileInfo, error)
+	StatContext(ctx context.Context, fileName string) (*FileInfo, error)
+}
+
+// Stat returns the details of the file or directory.
+// Storages which do not implement IStatFileStorage only provide the size of files
+func Stat(storage IFileStorage, fileName string) (*FileInfo, error) {
+	return StatContext(context.Background(), storage, fileName)
+}
+
+// StatContext is Stat with a context
+func StatContext(ctx context.Context, storage IFileStorage, fileName string) (*FileInfo, error) {
+	if statStorage, ok := storage.(IStatFileStorage); ok {
+		return statStorage.StatContext(ctx, fileName)
+	}
+
+	size, err := WithContext(storage).FileSizeContext(ctx, fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewFileInfoFromDetails(FileDetails{
+		Name:        path.Base(fileName),
+		Size:        size,
+		ContentType: ContentTypeByName(fileName),
+	}), nil
+}
+
+// Exists checks if the file or directory exists, errors other than a missing file are returned
+func Exists(storage IFileStorage, fileName string) (bool, error) {
+	return ExistsContext(context.Background(), storage, fileName)
+}
+
+// ExistsContext is Exists with a context
+func ExistsContext(ctx context.Context, storage IFileStorage, fileName string) (bool, error) {
+	_, err := StatContext(ctx, storage, fileName)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// ContentTypeByName returns the content type of the file extension, or "" if it is unknown
+func ContentTypeByName(fileName string) string {
+	return mime.TypeByExtension(path.Ext(fileName))
+}
@@ -0,0 +1,380 @@
+package storageabstraction
+
+import (
+	"context"
+	"github.com/2flow/gokies/storageabstraction/common"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// Sub returns a view of the directory of the storage, like fs.Sub does for file systems. All paths of the view are
+// relative to the directory, paths which escape from it with ".." are rejected with ErrPathEscape.
+// The directory does not have to exist, e.g. the view of a tenant is empty until the first file is written
+func Sub(storage IFileStorage, directory string) (IContextFileStorage, error) {
+	prefix, err := common.CleanPath(directory)
+	if err != nil {
+		return nil, err
+	}
+	if prefix == "" {
+		return WithContext(storage), nil
+	}
+
+	if subStorage, isSub := storage.(*subFileStorage); isSub {
+		return &subFileStorage{storage: subStorage.storage, prefix: path.Join(subStorage.prefix, prefix)}, nil
+	}
+	return &subFileStorage{storage: storage, prefix: prefix}, nil
+}
+
+// subFileStorage is the view of a directory of the wrapped storage
+type subFileStorage struct {
+	storage IFileStorage
+	prefix  string
+}
+
+func (storage *subFileStorage) contextStorage() IContextFileStorage {
+	return WithContext(storage.storage)
+}
+
+// resolve returns the path of the file in the wrapped storage, paths escaping from the directory return a
+// common.InvalidPathError
+func (storage *subFileStorage) resolve(fileName string) (string, error) {
+	relative, err := common.CleanPath(fileName)
+	if err != nil {
+		return "", err
+	}
+	return path.Join(storage.prefix, relative), nil
+}
+
+// resolveFile is resolve for operations on files, the directory of the view itself is not a file
+func (storage *subFileStorage) resolveFile(fileName string) (string, error) {
+	relative, err := common.CleanPath(fileName)
+	if err != nil {
+		return "", err
+	}
+	if relative == "" {
+		return "", &common.InvalidPathError{Path: fileName, Err: fs.ErrInvalid}
+	}
+	return path.Join(storage.prefix, relative), nil
+}
+
+func (storage *subFileStorage) Write(fileName string, fileSize int64, reader io.ReadSeeker) error {
+	return storage.WriteContext(context.Background(), fileName, fileSize, reader)
+}
+
+func (storage *subFileStorage) WriteContext(ctx context.Context, fileName string, fileSize int64, reader io.ReadSeeker) error {
+	resolved, err := storage.resolveFile(fileName)
+	if err != nil {
+		return err
+	}
+	return storage.contextStorage().WriteContext(ctx, resolved, fileSize, reader)
+}
+
+func (storage *subFileStorage) WriteWithOptions(fileName string, fileSize int64, reader io.ReadSeeker, options WriteOptions) error {
+	return storage.WriteWithOptionsContext(context.Background(), fileName, fileSize, reader, options)
+}
+
+func (storage *subFileStorage) WriteWithOptionsContext(ctx context.Context, fileName string, fileSize int64, reader io.ReadSeeker, options WriteOptions) error {
+	resolved, err := storage.resolveFile(fileName)
+	if err != nil {
+		return err
+	}
+	return WriteWithOptionsContext(ctx, storage.storage, resolved, fileSize, reader, options)
+}
+
+func (storage *subFileStorage) OpenWriter(fileName string) (io.WriteCloser, error) {
+	return storage.OpenWriterContext(context.Background(), fileName)
+}
+
+func (storage *subFileStorage) OpenWriterContext(ctx context.Context, fileName string) (io.WriteCloser, error) {
+	resolved, err := storage.resolveFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	return OpenWriterContext(ctx, storage.storage, resolved)
+}
+
+func (storage *subFileStorage) OpenWriterWithOptions(fileName string, options WriteOptions) (io.WriteCloser, error) {
+	return storage.OpenWriterWithOptionsContext(context.Background(), fileName, options)
+}
+
+func (storage *subFileStorage) OpenWriterWithOptionsContext(ctx context.Context, fileName string, options WriteOptions) (io.WriteCloser, error) {
+	resolved, err := storage.resolveFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	return OpenWriterWithOptionsContext(ctx, storage.storage, resolved, options)
+}
+
+func (storage *subFileStorage) Read(fileName string) (io.ReadCloser, error) {
+	return storage.ReadContext(context.Background(), fileName)
+}
+
+func (storage *subFileStorage) ReadContext(ctx context.Context, fileName string) (io.ReadCloser, error) {
+	resolved, err := storage.resolve(fileName)
+	if err != nil {
+		return nil, err
+	}
+	return storage.contextStorage().ReadContext(ctx, resolved)
+}
+
+func (storage *subFileStorage) ReadRange(fileName string, offset int64, length int64) (io.ReadCloser, error) {
+	return storage.ReadRangeContext(context.Background(), fileName, offset, length)
+}
+
+func (storage *subFileStorage) ReadRangeContext(ctx context.Context, fileName string, offset int64, length int64) (io.ReadCloser, error) {
+	resolved, err := storage.resolve(fileName)
+	if err != nil {
+		return nil, err
+	}
+	return ReadRangeContext(ctx, storage.storage, resolved, offset, length)
+}
+
+func (storage *subFileStorage) ReadEncodedRange(fileName string, offset int64, length int64) (io.ReadCloser, error) {
+	return storage.ReadEncodedRangeContext(context.Background(), fileName, offset, length)
+}
+
+func (storage *subFileStorage) ReadEncodedRangeContext(ctx context.Context, fileName string, offset int64, length int64) (io.ReadCloser, error) {
+	resolved, err := storage.resolve(fileName)
+	if err != nil {
+		return nil, err
+	}
+	return ReadEncodedRangeContext(ctx, storage.storage, resolved, offset, length)
+}
+
+func (storage *subFileStorage) FileSize(fileName string) (int64, error) {
+	return storage.FileSizeContext(context.Background(), fileName)
+}
+
+func (storage *subFileStorage) FileSizeContext(ctx context.Context, fileName string) (int64, error) {
+	resolved, err := storage.resolve(fileName)
+	if err != nil {
+		return 0, err
+	}
+	return storage.contextStorage().FileSizeContext(ctx, resolved)
+}
+
+func (storage *subFileStorage) Stat(fileName string) (*FileInfo, error) {
+	return storage.StatContext(context.Background(), fileName)
+}
+
+func (storage *subFileStorage) StatContext(ctx context.Context, fileName string) (*FileInfo, error) {
+	resolved, err := storage.resolve(fileName)
+	if err != nil {
+		return nil, err
+	}
+	return StatContext(ctx, storage.storage, resolved)
+}
+
+func (storage *subFileStorage) StatEncoded(fileName string) (*FileInfo, error) {
+	return storage.StatEncodedContext(context.Background(), fileName)
+}
+
+func (storage *subFileStorage) StatEncodedContext(ctx context.Context, fileName string) (*FileInfo, error) {
+	resolved, err := storage.resolve(fileName)
+	if err != nil {
+		return nil, err
+	}
+	return StatEncodedContext(ctx, storage.storage, resolved)
+}
+
+func (storage *subFileStorage) DeleteDirectory(directory string) error {
+	return storage.DeleteDirectoryContext(context.Background(), directory)
+}
+
+func (storage *subFileStorage) DeleteDirectoryContext(ctx context.Context, directory string) error {
+	resolved, err := storage.resolve(directory)
+	if err != nil {
+		return err
+	}
+	return storage.contextStorage().DeleteDirectoryContext(ctx, resolved)
+}
+
+func (storage *subFileStorage) DeleteFile(fileName string) error {
+	return storage.DeleteFileContext(context.Background(), fileName)
+}
+
+func (storage *subFileStorage) DeleteFileContext(ctx context.Context, fileName string) error {
+	resolved, err := storage.resolveFile(fileName)
+	if err != nil {
+		return err
+	}
+	return storage.contextStorage().DeleteFileContext(ctx, resolved)
+}
+
+func (storage *subFileStorage) DeleteFileWithConditions(fileName string, conditions Conditions) error {
+	return storage.DeleteFileWithConditionsContext(context.Background(), fileName, conditions)
+}
+
+func (storage *subFileStorage) DeleteFileWithConditionsContext(ctx context.Context, fileName string, conditions Conditions) error {
+	resolved, err := storage.resolveFile(fileName)
+	if err != nil {
+		return err
+	}
+	return DeleteFileWithConditionsContext(ctx, storage.storage, resolved, conditions)
+}
+
+func (storage *subFileStorage) Walk(directory string, walk WalkFunc) error {
+	return storage.WalkContext(context.Background(), directory, walk)
+}
+
+// WalkContext walks the directory of the wrapped storage, the walked paths are relative to the directory already
+func (storage *subFileStorage) WalkContext(ctx context.Context, directory string, walk WalkFunc) error {
+	resolved, err := storage.resolve(directory)
+	if err != nil {
+		_ = walk("", nil, err)
+		return err
+	}
+	return storage.contextStorage().WalkContext(ctx, resolved, walk)
+}
+
+func (storage *subFileStorage) List(directory string) ([]fs.FileInfo, error) {
+	return storage.ListContext(context.Background(), directory)
+}
+
+func (storage *subFileStorage) ListContext(ctx context.Context, directory string) ([]fs.FileInfo, error) {
+	resolved, err := storage.resolve(directory)
+	if err != nil {
+		return nil, err
+	}
+	return ListContext(ctx, storage.storage, resolved)
+}
+
+func (storage *subFileStorage) Join(paths ...string) string {
+	return storage.storage.Join(paths...)
+}
+
+func (storage *subFileStorage) CopyFile(source string, destination string) error {
+	return storage.CopyFileContext(context.Background(), source, destination)
+}
+
+func (storage *subFileStorage) CopyFileContext(ctx context.Context, source string, destination string) error {
+	return storage.relocate(ctx, source, destination, storage.resolveFile, CopyFileContext)
+}
+
+func (storage *subFileStorage) MoveFile(source string, destination string) error {
+	return storage.MoveFileContext(context.Background(), source, destination)
+}
+
+func (storage *subFileStorage) MoveFileContext(ctx context.Context, source string, destination string) error {
+	return storage.relocate(ctx, source, destination, storage.resolveFile, MoveFileContext)
+}
+
+func (storage *subFileStorage) CopyDirectory(source string, destination string) error {
+	return storage.CopyDirectoryContext(context.Background(), source, destination)
+}
+
+func (storage *subFileStorage) CopyDirectoryContext(ctx context.Context, source string, destination string) error {
+	return storage.relocate(ctx, source, destination, storage.resolve, CopyDirectoryContext)
+}
+
+func (storage *subFileStorage) MoveDirectory(source string, destination string) error {
+	return storage.MoveDirectoryContext(context.Background(), source, destination)
+}
+
+func (storage *subFileStorage) MoveDirectoryContext(ctx context.Context, source string, destination string) error {
+	return storage.relocate(ctx, source, destination, storage.resolve, MoveDirectoryContext)
+}
+
+// relocate resolves both paths and copies or moves with the helper within the wrapped storage
+func (storage *subFileStorage) relocate(ctx context.Context, source string, destination string,
+	resolve func(fileName string) (string, error),
+	relocate func(ctx context.Context, storage IFileStorage, source string, destination string) error) error {
+	resolvedSource, err := resolve(source)
+	if err != nil {
+		return err
+	}
+	resolvedDestination, err := resolve(destination)
+	if err != nil {
+		return err
+	}
+	return relocate(ctx, storage.storage, resolvedSource, resolvedDestination)
+}
+
+func (storage *subFileStorage) Flush() error {
+	return storage.FlushContext(context.Background())
+}
+
+// FlushContext flushes the whole wrapped storage
+func (storage *subFileStorage) FlushContext(ctx context.Context) error {
+	return FlushContext(ctx, storage.storage)
+}
+
+func (storage *subFileStorage) Snapshot(fileName string) (string, error) {
+	return storage.SnapshotContext(context.Background(), fileName)
+}
+
+func (storage *subFileStorage) SnapshotContext(ctx context.Context, fileName string) (string, error) {
+	resolved, err := storage.resolveFile(fileName)
+	if err != nil {
+		return "", err
+	}
+	return SnapshotContext(ctx, storage.storage, resolved)
+}
+
+func (storage *subFileStorage) Versions(filePath string) ([]FileVersion, error) {
+	return storage.VersionsContext(context.Background(), filePath)
+}
+
+// VersionsContext returns the versions of the wrapped storage with their paths relative to the directory
+func (storage *subFileStorage) VersionsContext(ctx context.Context, filePath string) ([]FileVersion, error) {
+	resolved, err := storage.resolve(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	versions, err := VersionsContext(ctx, storage.storage, resolved)
+	for i := range versions {
+		versions[i].Path = strings.TrimPrefix(versions[i].Path, storage.prefix+"/")
+	}
+	return versions, err
+}
+
+func (storage *subFileStorage) RestoreVersion(fileName string, versionID string) error {
+	return storage.RestoreVersionContext(context.Background(), fileName, versionID)
+}
+
+func (storage *subFileStorage) RestoreVersionContext(ctx context.Context, fileName string, versionID string) error {
+	resolved, err := storage.resolveFile(fileName)
+	if err != nil {
+		return err
+	}
+	return RestoreVersionContext(ctx, storage.storage, resolved, versionID)
+}
+
+func (storage *subFileStorage) Undelete(fileName string) error {
+	return storage.UndeleteContext(context.Background(), fileName)
+}
+
+func (storage *subFileStorage) UndeleteContext(ctx context.Context, fileName string) error {
+	resolved, err := storage.resolveFile(fileName)
+	if err != nil {
+		return err
+	}
+	return UndeleteContext(ctx, storage.storage, resolved)
+}
+
+func (storage *subFileStorage) TryLock(fileName string) (ILock, error) {
+	return storage.TryLockContext(context.Background(), fileName)
+}
+
+func (storage *subFileStorage) TryLockContext(ctx context.Context, fileName string) (ILock, error) {
+	resolved, err := storage.resolveFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	return TryLockContext(ctx, storage.storage, resolved)
+}
+
+func (storage *subFileStorage) Lock(fileName string) (ILock, error) {
+	return storage.LockContext(context.Background(), fileName)
+}
+
+func (storage *subFileStorage) LockContext(ctx context.Context, fileName string) (ILock, error) {
+	resolved, err := storage.resolveFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	return LockContext(ctx, storage.storage, resolved)
+}
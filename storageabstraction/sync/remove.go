@@ -0,0 +1,139 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"github.com/2flow/gokies/storageabstraction"
+	"io/fs"
+	"os"
+	"sort"
+	"strings"
+	gosync "sync"
+)
+
+// RemoveOtherFiles deletes all files of the directory which are not in keep, the paths are relative to the directory.
+// The deleted paths are returned
+func RemoveOtherFiles(storage storageabstraction.IFileStorage, directory string, keep []string) ([]string, error) {
+	return RemoveOtherFilesContext(context.Background(), storage, directory, keep)
+}
+
+// RemoveOtherFilesContext is RemoveOtherFiles with a context
+func RemoveOtherFilesContext(ctx context.Context, storage storageabstraction.IFileStorage, directory string, keep []string) ([]string, error) {
+	files, err := listFiles(ctx, storage, directory)
+	if err != nil {
+		return nil, err
+	}
+
+	keepFiles := make(map[string]bool, len(keep))
+	for _, fileName := range keep {
+		keepFiles[fileName] = true
+	}
+
+	return removeFiles(ctx, storage, directory, otherFiles(files, keepFiles), 1)
+}
+
+// listFiles returns all files of the directory by their path relative to the directory.
+// A directory which does not exist has no files
+func listFiles(ctx context.Context, storage storageabstraction.IFileStorage, directory string) (map[string]os.FileInfo, error) {
+	files := map[string]os.FileInfo{}
+
+	err := storageabstraction.WithContext(storage).WalkContext(ctx, directory, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		// storages without directory entries return the paths with a leading "/", if the directory has no trailing one
+		filePath = strings.TrimPrefix(filePath, "/")
+		if filePath != "" && info != nil && !info.IsDir() {
+			files[filePath] = info
+		}
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return files, nil
+	}
+
+	return files, err
+}
+
+// otherFiles returns the sorted paths of the files which are not in keep
+func otherFiles(files map[string]os.FileInfo, keep map[string]bool) []string {
+	var other []string
+	for filePath := range files {
+		if !keep[filePath] {
+			other = append(other, filePath)
+		}
+	}
+
+	sort.Strings(other)
+	return other
+}
+
+// removeFiles deletes the files of the directory with up to parallelism concurrent requests,
+// files which do not exist anymore are ignored. The deleted paths are returned
+func removeFiles(ctx context.Context, storage storageabstraction.IFileStorage, directory string, files []string, parallelism int) ([]string, error) {
+	contextStorage := storageabstraction.WithContext(storage)
+	var removed []string
+	lock := gosync.Mutex{}
+
+	err := forEach(ctx, files, parallelism, func(ctx context.Context, filePath string) error {
+		err := contextStorage.DeleteFileContext(ctx, storage.Join(directory, filePath))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+
+		lock.Lock()
+		defer lock.Unlock()
+		removed = append(removed, filePath)
+		return nil
+	})
+
+	sort.Strings(removed)
+	return removed, err
+}
+
+// forEach calls action for every path with up to parallelism concurrent calls.
+// After the first error no further actions are started and the error is returned
+func forEach(ctx context.Context, paths []string, parallelism int, action func(ctx context.Context, filePath string) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	if parallelism < 1 {
+		parallelism = 1
+	}
+
+	pathChannel := make(chan string)
+	waitGroup := gosync.WaitGroup{}
+	var firstErr error
+	errOnce := gosync.Once{}
+
+	for i := 0; i < parallelism; i++ {
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			for filePath := range pathChannel {
+				if err := action(ctx, filePath); err != nil {
+					errOnce.Do(func() {
+						firstErr = err
+						cancel()
+					})
+				}
+			}
+		}()
+	}
+
+sendLoop:
+	for _, filePath := range paths {
+		select {
+		case pathChannel <- filePath:
+		case <-ctx.Done():
+			break sendLoop
+		}
+	}
+	close(pathChannel)
+	waitGroup.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
@@ -0,0 +1,198 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"github.com/2flow/gokies/storageabstraction"
+	"io"
+	"os"
+	"sort"
+	gosync "sync"
+)
+
+// CompareMode selects how the files of the source and the destination are compared
+type CompareMode int
+
+const (
+	// CompareSize copies the file if the sizes differ
+	CompareSize CompareMode = 1 << iota
+	// CompareModTime copies the file if the source was modified after the destination
+	CompareModTime
+	// CompareHash copies the file if the MD5 hashes differ, the content is read if a storage does not know the hash
+	CompareHash
+)
+
+const (
+	defaultParallelism = 4
+)
+
+// Report lists the paths, relative to the directories, of the files which were copied, deleted or skipped.
+// For a dry run it lists what would have been done
+type Report struct {
+	Copied  []string
+	Deleted []string
+	Skipped []string
+}
+
+// Syncer mirrors a directory of the source storage into a directory of the destination storage,
+// the storages can be of different types
+type Syncer struct {
+	source      storageabstraction.IFileStorage
+	destination storageabstraction.IFileStorage
+
+	// Compare is a combination of the compare modes, a file is copied if any of them detects a difference
+	Compare CompareMode
+	// DryRun does not modify the destination, the report shows what would be done
+	DryRun bool
+	// KeepOtherFiles does not delete files from the destination which do not exist in the source
+	KeepOtherFiles bool
+	// Parallelism is the maximal count of files copied or deleted at the same time
+	Parallelism int
+}
+
+// NewSyncer creates a new Syncer which compares the size and the modification time
+func NewSyncer(source storageabstraction.IFileStorage, destination storageabstraction.IFileStorage) *Syncer {
+	return &Syncer{
+		source:      source,
+		destination: destination,
+		Compare:     CompareSize | CompareModTime,
+		Parallelism: defaultParallelism,
+	}
+}
+
+// Sync copies all new and changed files of the source directory into the destination directory,
+// and deletes the files which do not exist in the source directory
+func (syncer *Syncer) Sync(sourceDir string, destinationDir string) (*Report, error) {
+	return syncer.SyncContext(context.Background(), sourceDir, destinationDir)
+}
+
+// SyncContext is Sync with a context, the report contains the changes done before an error occurred
+func (syncer *Syncer) SyncContext(ctx context.Context, sourceDir string, destinationDir string) (*Report, error) {
+	report := &Report{}
+
+	sourceFiles, err := listFiles(ctx, syncer.source, sourceDir)
+	if err != nil {
+		return report, err
+	}
+	destinationFiles, err := listFiles(ctx, syncer.destination, destinationDir)
+	if err != nil {
+		return report, err
+	}
+
+	var changed []string
+	for filePath, sourceInfo := range sourceFiles {
+		destinationInfo, exists := destinationFiles[filePath]
+		if !exists {
+			changed = append(changed, filePath)
+			continue
+		}
+
+		differs, err := syncer.differs(ctx, syncer.source.Join(sourceDir, filePath), sourceInfo,
+			syncer.destination.Join(destinationDir, filePath), destinationInfo)
+		if err != nil {
+			return report, err
+		}
+		if differs {
+			changed = append(changed, filePath)
+		} else {
+			report.Skipped = append(report.Skipped, filePath)
+		}
+	}
+	sort.Strings(changed)
+	sort.Strings(report.Skipped)
+
+	var other []string
+	if !syncer.KeepOtherFiles {
+		sourcePaths := make(map[string]bool, len(sourceFiles))
+		for filePath := range sourceFiles {
+			sourcePaths[filePath] = true
+		}
+		other = otherFiles(destinationFiles, sourcePaths)
+	}
+
+	if syncer.DryRun {
+		report.Copied = changed
+		report.Deleted = other
+		return report, nil
+	}
+
+	report.Copied, err = syncer.copyFiles(ctx, sourceDir, destinationDir, changed)
+	if err != nil {
+		return report, err
+	}
+
+	report.Deleted, err = removeFiles(ctx, syncer.destination, destinationDir, other, syncer.Parallelism)
+	return report, err
+}
+
+// differs compares the files with the compare modes of the syncer
+func (syncer *Syncer) differs(ctx context.Context, sourcePath string, sourceInfo os.FileInfo,
+	destinationPath string, destinationInfo os.FileInfo) (bool, error) {
+	if syncer.Compare&CompareSize != 0 && sourceInfo.Size() != destinationInfo.Size() {
+		return true, nil
+	}
+	if syncer.Compare&CompareModTime != 0 && sourceInfo.ModTime().After(destinationInfo.ModTime()) {
+		return true, nil
+	}
+	if syncer.Compare&CompareHash != 0 {
+		sourceMD5, err := contentMD5(ctx, syncer.source, sourcePath)
+		if err != nil {
+			return false, err
+		}
+		destinationMD5, err := contentMD5(ctx, syncer.destination, destinationPath)
+		if err != nil {
+			return false, err
+		}
+		return !bytes.Equal(sourceMD5, destinationMD5), nil
+	}
+
+	return false, nil
+}
+
+// contentMD5 returns the MD5 hash known by the storage, or hashes the content of the file
+func contentMD5(ctx context.Context, storage storageabstraction.IFileStorage, fileName string) ([]byte, error) {
+	info, err := storageabstraction.StatContext(ctx, storage, fileName)
+	if err != nil {
+		return nil, err
+	}
+	if len(info.ContentMD5()) != 0 {
+		return info.ContentMD5(), nil
+	}
+
+	reader, err := storageabstraction.WithContext(storage).ReadContext(ctx, fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	hash := md5.New()
+	if _, err = io.Copy(hash, reader); err != nil {
+		return nil, err
+	}
+	return hash.Sum(nil), nil
+}
+
+// copyFiles copies the files with up to Parallelism concurrent copies, the copied paths are returned
+func (syncer *Syncer) copyFiles(ctx context.Context, sourceDir string, destinationDir string, files []string) ([]string, error) {
+	var copied []string
+	lock := gosync.Mutex{}
+
+	err := forEach(ctx, files, syncer.Parallelism, func(ctx context.Context, filePath string) error {
+		sourcePath := syncer.source.Join(sourceDir, filePath)
+		destinationPath := syncer.destination.Join(destinationDir, filePath)
+
+		err := storageabstraction.CopyFileBetweenContext(ctx, syncer.source, sourcePath, syncer.destination, destinationPath)
+		if err != nil {
+			return err
+		}
+
+		lock.Lock()
+		defer lock.Unlock()
+		copied = append(copied, filePath)
+		return nil
+	})
+
+	sort.Strings(copied)
+	return copied, err
+}
@@ -0,0 +1,145 @@
+package sync
+
+import (
+	"errors"
+	"github.com/2flow/gokies/storageabstraction"
+	"github.com/2flow/gokies/storageabstraction/localstorage"
+	"github.com/2flow/gokies/storageabstraction/memorystorage"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+	"testing"
+)
+
+const (
+	testTempDir = "testingDir"
+)
+
+func TestSyncMirrorsDirectory(t *testing.T) {
+	os.RemoveAll(testTempDir)
+	defer os.RemoveAll(testTempDir)
+
+	source := localstorage.NewLocalStorage(testTempDir)
+	writeTestFiles(t, source, "site/index.html", "site/js/app.js")
+	destination := memorystorage.NewMemoryStorage()
+	writeTestFiles(t, destination, "mirror/old.txt")
+	if err := destination.Write("mirror/js/app.js", 3, strings.NewReader("old")); err != nil {
+		t.Errorf("[TestError] Error writing file: %v", err)
+		return
+	}
+	// same size and the destination is newer
+	if err := source.Write("site/unchanged.txt", 4, strings.NewReader("same")); err != nil {
+		t.Errorf("[TestError] Error writing file: %v", err)
+		return
+	}
+	if err := destination.Write("mirror/unchanged.txt", 4, strings.NewReader("SAME")); err != nil {
+		t.Errorf("[TestError] Error writing file: %v", err)
+		return
+	}
+
+	syncer := NewSyncer(source, destination)
+	syncer.DryRun = true
+	report, err := syncer.Sync("site", "mirror")
+	if err != nil {
+		t.Errorf("Error during dry run: %v", err)
+		return
+	}
+	expectReport(t, report, "index.html,js/app.js", "old.txt", "unchanged.txt")
+	if exists, _ := storageabstraction.Exists(destination, "mirror/old.txt"); !exists {
+		t.Errorf("Dry run deleted a file")
+	}
+
+	syncer.DryRun = false
+	report, err = syncer.Sync("site", "mirror")
+	if err != nil {
+		t.Errorf("Error during sync: %v", err)
+		return
+	}
+	expectReport(t, report, "index.html,js/app.js", "old.txt", "unchanged.txt")
+
+	if content := readFile(t, destination, "mirror/js/app.js"); content != "site/js/app.js" {
+		t.Errorf("Unexpected content of copied file: %q", content)
+	}
+	if _, err = destination.FileSize("mirror/old.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Other file was not deleted: %v", err)
+	}
+
+	// a second sync has nothing to do
+	report, err = syncer.Sync("site", "mirror")
+	if err != nil {
+		t.Errorf("Error during sync: %v", err)
+		return
+	}
+	expectReport(t, report, "", "", "index.html,js/app.js,unchanged.txt")
+}
+
+func TestSyncComparesHashes(t *testing.T) {
+	source := memorystorage.NewMemoryStorage()
+	destination := memorystorage.NewMemoryStorage()
+	writeTestFiles(t, destination, "changed.txt", "same.txt")
+	writeTestFiles(t, source, "same.txt")
+	if err := source.Write("changed.txt", 11, strings.NewReader("CHANGED.TXT")); err != nil {
+		t.Errorf("[TestError] Error writing file: %v", err)
+		return
+	}
+
+	syncer := NewSyncer(source, destination)
+	syncer.Compare = CompareHash
+	syncer.KeepOtherFiles = true
+	report, err := syncer.Sync("", "")
+	if err != nil {
+		t.Errorf("Error during sync: %v", err)
+		return
+	}
+	expectReport(t, report, "changed.txt", "", "same.txt")
+}
+
+func TestRemoveOtherFiles(t *testing.T) {
+	storage := memorystorage.NewMemoryStorage()
+	writeTestFiles(t, storage, "dir/a.txt", "dir/sub/b.txt", "dir/sub/c.txt", "dir2/d.txt")
+
+	removed, err := RemoveOtherFiles(storage, "dir", []string{"a.txt", "sub/c.txt"})
+	if err != nil {
+		t.Errorf("Error removing files: %v", err)
+	}
+	if strings.Join(removed, ",") != "sub/b.txt" {
+		t.Errorf("Unexpected removed files: %v", removed)
+	}
+
+	if removed, err = RemoveOtherFiles(storage, "missing", nil); err != nil || len(removed) != 0 {
+		t.Errorf("Unexpected result for a missing directory: %v, %v", removed, err)
+	}
+}
+
+func expectReport(t *testing.T, report *Report, copied string, deleted string, skipped string) {
+	if strings.Join(report.Copied, ",") != copied {
+		t.Errorf("Unexpected copied files, expected: %v, actual: %v", copied, report.Copied)
+	}
+	if strings.Join(report.Deleted, ",") != deleted {
+		t.Errorf("Unexpected deleted files, expected: %v, actual: %v", deleted, report.Deleted)
+	}
+	if strings.Join(report.Skipped, ",") != skipped {
+		t.Errorf("Unexpected skipped files, expected: %v, actual: %v", skipped, report.Skipped)
+	}
+}
+
+func readFile(t *testing.T, storage storageabstraction.IFileStorage, fileName string) string {
+	reader, err := storage.Read(fileName)
+	if err != nil {
+		t.Errorf("Error reading %s: %v", fileName, err)
+		return ""
+	}
+	defer reader.Close()
+
+	content, _ := io.ReadAll(reader)
+	return string(content)
+}
+
+func writeTestFiles(t *testing.T, storage storageabstraction.IFileStorage, fileNames ...string) {
+	for _, fileName := range fileNames {
+		if err := storage.Write(fileName, int64(len(fileName)), strings.NewReader(fileName)); err != nil {
+			t.Errorf("[TestError] Error writing %s: %v", fileName, err)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package storageabstraction
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// FileVersion is a previous state of a file, which can be restored
+type FileVersion struct {
+	// Path is the path of the file
+	Path string
+	// ID identifies the version for RestoreVersion, it is empty for a deleted file itself
+	ID string
+	// Created is the time the version was taken or the time the file was deleted
+	Created time.Time
+	// ModTime is the time the content of the version was written
+	ModTime time.Time
+	Size    int64
+	// Deleted is true if the file is deleted, it can be restored with Undelete
+	Deleted bool
+}
+
+// IVersionFileStorage is implemented by storages which keep previous versions of the files,
+// e.g. the snapshots and the soft delete of the azure blobs
+type IVersionFileStorage interface {
+	// Snapshot keeps the current content of the file as version, the id of the version is returned
+	Snapshot(fileName string) (string, error)
+	SnapshotContext(ctx context.Context, fileName string) (string, error)
+	// Versions returns the versions and the deleted files of the file or of all files in the directory,
+	// sorted by path and oldest first. The current content of the files is not included
+	Versions(path string) ([]FileVersion, error)
+	VersionsContext(ctx context.Context, path string) ([]FileVersion, error)
+	// RestoreVersion replaces the current content of the file with the version
+	RestoreVersion(fileName string, versionID string) error
+	RestoreVersionContext(ctx context.Context, fileName string, versionID string) error
+	// Undelete restores the deleted file together with its versions
+	Undelete(fileName string) error
+	UndeleteContext(ctx context.Context, fileName string) error
+}
+
+// Snapshot keeps the current content of the file as version. Storages which do not implement IVersionFileStorage
+// return errors.ErrUnsupported, as all the version helpers do
+func Snapshot(storage IFileStorage, fileName string) (string, error) {
+	return SnapshotContext(context.Background(), storage, fileName)
+}
+
+// SnapshotContext is Snapshot with a context
+func SnapshotContext(ctx context.Context, storage IFileStorage, fileName string) (string, error) {
+	if versionStorage, ok := storage.(IVersionFileStorage); ok {
+		return versionStorage.SnapshotContext(ctx, fileName)
+	}
+	return "", NewPathError("snapshot", fileName, errors.ErrUnsupported, nil)
+}
+
+// Versions returns the versions and the deleted files of the file or of all files in the directory
+func Versions(storage IFileStorage, path string) ([]FileVersion, error) {
+	return VersionsContext(context.Background(), storage, path)
+}
+
+// VersionsContext is Versions with a context
+func VersionsContext(ctx context.Context, storage IFileStorage, path string) ([]FileVersion, error) {
+	if versionStorage, ok := storage.(IVersionFileStorage); ok {
+		return versionStorage.VersionsContext(ctx, path)
+	}
+	return nil, NewPathError("versions", path, errors.ErrUnsupported, nil)
+}
+
+// RestoreVersion replaces the current content of the file with the version
+func RestoreVersion(storage IFileStorage, fileName string, versionID string) error {
+	return RestoreVersionContext(context.Background(), storage, fileName, versionID)
+}
+
+// RestoreVersionContext is RestoreVersion with a context
+func RestoreVersionContext(ctx context.Context, storage IFileStorage, fileName string, versionID string) error {
+	if versionStorage, ok := storage.(IVersionFileStorage); ok {
+		return versionStorage.RestoreVersionContext(ctx, fileName, versionID)
+	}
+	return NewPathError("restore", fileName, errors.ErrUnsupported, nil)
+}
+
+// Undelete restores the deleted file together with its versions
+func Undelete(storage IFileStorage, fileName string) error {
+	return UndeleteContext(context.Background(), storage, fileName)
+}
+
+// UndeleteContext is Undelete with a context
+func UndeleteContext(ctx context.Context, storage IFileStorage, fileName string) error {
+	if versionStorage, ok := storage.(IVersionFileStorage); ok {
+		return versionStorage.UndeleteContext(ctx, fileName)
+	}
+	return NewPathError("undelete", fileName, errors.ErrUnsupported, nil)
+}
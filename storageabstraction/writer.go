@@ -0,0 +1,97 @@
+package storageabstraction
+
+import (
+	"context"
+	"io"
+	"os"
+)
+
+// IStreamFileStorage is implemented by storages which can write a file of unknown size from a stream.
+//
+// The file is committed when the writer is closed. If the context is done before, Close does not
+// commit the file and returns the context error, so a failed write is aborted by cancelling the context.
+type IStreamFileStorage interface {
+	OpenWriter(fileName string) (io.WriteCloser, error)
+	OpenWriterContext(ctx context.Context, fileName string) (io.WriteCloser, error)
+}
+
+// OpenWriter returns a writer for the file. Storages which do not implement IStreamFileStorage
+// get the content spooled to a temp file, which is written to the storage on Close
+func OpenWriter(storage IFileStorage, fileName string) (io.WriteCloser, error) {
+	return OpenWriterContext(context.Background(), storage, fileName)
+}
+
+// OpenWriterContext is OpenWriter with a context
+func OpenWriterContext(ctx context.Context, storage IFileStorage, fileName string) (io.WriteCloser, error) {
+	if streamStorage, ok := storage.(IStreamFileStorage); ok {
+		return streamStorage.OpenWriterContext(ctx, fileName)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	file, err := os.CreateTemp("", "storageWriter")
+	if err != nil {
+		return nil, err
+	}
+
+	return &spoolWriter{ctx: ctx, storage: WithContext(storage), fileName: fileName, file: file}, nil
+}
+
+// WriteFrom streams the content of the reader into the file, the file is not modified if the content can not be read
+func WriteFrom(storage IFileStorage, fileName string, reader io.Reader) error {
+	return WriteFromContext(context.Background(), storage, fileName, reader)
+}
+
+// WriteFromContext is WriteFrom with a context
+func WriteFromContext(ctx context.Context, storage IFileStorage, fileName string, reader io.Reader) error {
+	writerCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	writer, err := OpenWriterContext(writerCtx, storage, fileName)
+	if err != nil {
+		return err
+	}
+
+	if _, err = io.Copy(writer, reader); err != nil {
+		// aborts the write
+		cancel()
+		_ = writer.Close()
+		return err
+	}
+
+	return writer.Close()
+}
+
+type spoolWriter struct {
+	ctx      context.Context
+	storage  IContextFileStorage
+	fileName string
+	file     *os.File
+	size     int64
+}
+
+func (writer *spoolWriter) Write(p []byte) (n int, err error) {
+	if err := writer.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	n, err = writer.file.Write(p)
+	writer.size += int64(n)
+	return n, err
+}
+
+func (writer *spoolWriter) Close() error {
+	defer os.Remove(writer.file.Name())
+	defer writer.file.Close()
+
+	if err := writer.ctx.Err(); err != nil {
+		return err
+	}
+	if _, err := writer.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	return writer.storage.WriteContext(writer.ctx, writer.fileName, writer.size, writer.file)
+}